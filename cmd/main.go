@@ -2,29 +2,50 @@ package main
 
 import (
 	"context"
+	"crypto"
+	"crypto/rand"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
-
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/jwtauth/v5"
 	"github.com/go-playground/validator/v10"
+	"github.com/google/uuid"
+	"github.com/tomdoestech/goth/internal/apikey"
 	"github.com/tomdoestech/goth/internal/auth"
+	orgs "github.com/tomdoestech/goth/internal/org"
+	"github.com/tomdoestech/goth/internal/pkg/assets"
+	"github.com/tomdoestech/goth/internal/pkg/audit"
+	"github.com/tomdoestech/goth/internal/pkg/compress"
 	"github.com/tomdoestech/goth/internal/pkg/config"
+	"github.com/tomdoestech/goth/internal/pkg/errreport"
+	"github.com/tomdoestech/goth/internal/pkg/geoip"
+	"github.com/tomdoestech/goth/internal/pkg/i18n"
+	"github.com/tomdoestech/goth/internal/pkg/identity"
+	"github.com/tomdoestech/goth/internal/pkg/jobs"
+	"github.com/tomdoestech/goth/internal/pkg/keyring"
+	"github.com/tomdoestech/goth/internal/pkg/mail"
 	"github.com/tomdoestech/goth/internal/pkg/metrics"
+	"github.com/tomdoestech/goth/internal/pkg/openapi"
+	"github.com/tomdoestech/goth/internal/pkg/password"
+	"github.com/tomdoestech/goth/internal/pkg/realtime"
+	"github.com/tomdoestech/goth/internal/pkg/secure"
+	"github.com/tomdoestech/goth/internal/pkg/session"
+	"github.com/tomdoestech/goth/internal/pkg/storage"
 	users "github.com/tomdoestech/goth/internal/user"
 	"github.com/tomdoestech/goth/internal/web"
+	"github.com/tomdoestech/goth/static"
 	"go.uber.org/zap"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 )
 
-var tokenAuth *jwtauth.JWTAuth
-
 // use a single instance of Validate, it caches struct info
 var validate *validator.Validate
 
@@ -36,6 +57,27 @@ func TokenFromCookie(r *http.Request) string {
 	return cookie.Value
 }
 
+// override replaces a default security header from the config, "off" leaves
+// the header out.
+func override(value *string, setting string) {
+	switch setting {
+	case "":
+	case "off":
+		*value = ""
+	default:
+		*value = setting
+	}
+}
+
+// TokenFromHeader reads a bearer JWT, API keys are left to the identity middleware
+func TokenFromHeader(r *http.Request) string {
+	token := jwtauth.TokenFromHeader(r)
+	if strings.HasPrefix(token, apikey.KeyPrefix) {
+		return ""
+	}
+	return token
+}
+
 func main() {
 
 	validate = validator.New()
@@ -54,48 +96,518 @@ func main() {
 
 	sugar := logger.Sugar()
 
+	bundle, err := i18n.NewBundle(i18n.BundleParams{
+		Validate: validate,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var reporter errreport.Reporter = errreport.Nop{}
+	if conf.SentryDSN != "" {
+		reporter, err = errreport.NewSentry(errreport.SentryParams{
+			DSN:         conf.SentryDSN,
+			Environment: conf.Environment,
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	errorHandler := web.NewErrorHandler(
+		web.ErrorHandlerParams{
+			I18n:   bundle,
+			Logger: logger,
+		},
+	)
+
 	r := chi.NewRouter()
 
+	r.Use(middleware.RequestID)
+
+	// the recoverer is outermost, after the request id, so panics in any later
+	// middleware are rendered and reported
+	r.Use(web.NewRecoverer(web.RecovererParams{
+		ErrorHandler: errorHandler,
+		Reporter:     reporter,
+		Logger:       logger,
+	}))
+
+	secureConf := secure.DefaultConfig()
+	secureConf.ReportOnly = conf.CSPReportOnly
+	secureConf.HSTSMaxAge = conf.HSTSMaxAge
+	secureConf.HSTSIncludeSubdomains = conf.HSTSIncludeSubdomains
+	secureConf.HSTSPreload = conf.HSTSPreload
+	override(&secureConf.ReportURI, conf.CSPReportURI)
+	override(&secureConf.ReferrerPolicy, conf.ReferrerPolicy)
+	override(&secureConf.PermissionsPolicy, conf.PermissionsPolicy)
+	override(&secureConf.CrossOriginOpenerPolicy, conf.CrossOriginOpenerPolicy)
+	override(&secureConf.CrossOriginEmbedderPolicy, conf.CrossOriginEmbedderPolicy)
+
+	directives, err := secure.ParseDirectives(conf.CSPDirectives)
+	if err != nil {
+		log.Fatal("Error parsing CSP_DIRECTIVES: ", err)
+	}
+	for _, d := range directives {
+		secureConf.Set(d)
+	}
+
+	r.Use(secure.Middleware(secureConf))
+
 	r.Use(metrics.NewPatternMiddleware(conf.ServiceName))
 
-	tokenAuth = jwtauth.New("RS256", conf.JWTPrivateKey, conf.JWTPublicKey)
+	// inside the metrics middleware so it records the status compress writes
+	r.Use(compress.Middleware(compress.Params{}))
+
+	var envKey crypto.Signer
+	if conf.JWTPrivateKey != nil {
+		envKey = conf.JWTPrivateKey
+	}
+
+	keys, err := keyring.New(keyring.Params{
+		Logger:           logger,
+		Key:              envKey,
+		Dir:              conf.JWTKeyDir,
+		Algorithm:        conf.JWTAlgorithm,
+		RotationInterval: conf.JWTRotationInterval,
+		Retain:           auth.TokenTTL,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	r.Use(jwtauth.Verify(tokenAuth, TokenFromCookie))
+	keysCtx, stopKeys := context.WithCancel(context.Background())
+	defer stopKeys()
+
+	go keys.Run(keysCtx)
+
+	r.Use(keys.Verify(TokenFromHeader, TokenFromCookie))
+
+	sessionMode := auth.SessionMode(conf.SessionMode)
+
+	var sessionPrincipal func(r *http.Request) *identity.Principal
+
+	if sessionMode == auth.SessionServer {
+		var store session.Store
+
+		switch conf.SessionStore {
+		case "memory":
+			store = session.NewMemoryStore()
+		case "file":
+			store, err = session.NewFileStore(conf.SessionDir)
+		default:
+			store, err = session.NewGormStore(db)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		sessions := session.New(session.Params{
+			Store:           store,
+			IdleTimeout:     conf.SessionIdleTimeout,
+			AbsoluteTimeout: conf.SessionAbsoluteTimeout,
+			Logger:          logger,
+		})
+
+		sessionsCtx, stopSessions := context.WithCancel(context.Background())
+		defer stopSessions()
+
+		go sessions.Run(sessionsCtx)
+
+		r.Use(sessions.Middleware)
+
+		sessionPrincipal = auth.SessionPrincipal
+	}
+
+	argon2id := password.NewArgon2id(password.Argon2idParams{
+		Memory:      uint32(conf.Argon2Memory),
+		Time:        uint32(conf.Argon2Time),
+		Parallelism: uint8(conf.Argon2Parallelism),
+	})
+	bcrypt := password.NewBcrypt(conf.BcryptCost)
+
+	// new passwords use the configured algorithm, the other is still verified
+	hasher := password.NewChain(argon2id, bcrypt)
+	if conf.PasswordAlgorithm == "bcrypt" {
+		hasher = password.NewChain(bcrypt, argon2id)
+	}
+
+	// the services register their jobs, the queue runs once they all have
+	queue, err := jobs.New(jobs.Params{
+		DB:          db,
+		Concurrency: conf.JobsConcurrency,
+		Logger:      logger,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	storageSecret := []byte(conf.StorageSecret)
+	if len(storageSecret) == 0 {
+		storageSecret = make([]byte, 32)
+		if _, err := rand.Read(storageSecret); err != nil {
+			log.Fatal(err)
+		}
+		logger.Warn("STORAGE_SECRET is not set, links to uploads stop working on restart")
+	}
+
+	signer := storage.NewSigner(storageSecret, "/files/")
+
+	var blobs storage.BlobStore
+	if conf.Storage == "s3" {
+		blobs, err = storage.NewS3Store(storage.S3Params{
+			Endpoint:        conf.S3Endpoint,
+			Region:          conf.S3Region,
+			Bucket:          conf.S3Bucket,
+			AccessKeyID:     conf.S3AccessKeyID,
+			SecretAccessKey: conf.S3SecretAccessKey,
+			// the bucket's links would be blocked by the CSP and COEP
+			Links: signer,
+		})
+	} else {
+		blobs, err = storage.NewLocalStore(conf.StorageDir, signer)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	orgService := orgs.NewOrgService(orgs.OrgServiceParams{
+		DB:     db,
+		Logger: logger,
+	})
 
 	usersService := users.NewUserService(users.UserServiceParams{
-		Logger:   logger,
-		Validate: validate,
-		DB:       db,
+		Logger:          logger,
+		Validate:        validate,
+		DB:              db,
+		Hasher:          hasher,
+		PasswordHistory: conf.PasswordHistory,
+		AdminEmails:     conf.AdminEmails,
+		VerifyEmail:     conf.VerifyEmail,
+		Queue:           queue,
+		Store:           blobs,
+		Dependents: []users.Dependent{
+			{Table: auth.SessionModel{}.TableName()},
+			{Table: auth.RememberTokenModel{}.TableName()},
+			{Table: auth.MagicLinkModel{}.TableName()},
+			{Table: auth.InvitationModel{}.TableName(), Column: "inviter_id"},
+			{Table: auth.OIDCConsentModel{}.TableName()},
+			{Table: auth.OIDCCodeModel{}.TableName()},
+			{Table: auth.OIDCRefreshTokenModel{}.TableName()},
+			{Table: apikey.APIKeyModel{}.TableName()},
+			{Table: orgs.MembershipModel{}.TableName()},
+		},
+		OnDelete: []func(tx *gorm.DB, userIDs []uuid.UUID) error{orgService.HandOver},
 	})
+
 	authService := auth.NewAuthService(auth.AuthServiceParams{
 		Logger:    logger,
 		SecretKey: []byte("secret"),
-		TokenAuth: tokenAuth,
+		TokenAuth: keys,
+		Hasher:    hasher,
+	})
+
+	rememberService := auth.NewRememberService(auth.RememberServiceParams{
+		DB:     db,
+		Queue:  queue,
+		Logger: logger,
+	})
+
+	var geoIP *geoip.DB
+	if conf.GeoIPDatabase != "" {
+		geoIP, err = geoip.Open(conf.GeoIPDatabase)
+		if err != nil {
+			log.Fatal(err)
+		}
+		logger.Info("Loaded GeoIP database", zap.Int("ranges", geoIP.Len()))
+	}
+
+	sessionService := auth.NewSessionService(auth.SessionServiceParams{
+		DB:              db,
+		GeoIP:           geoIP,
+		RememberService: rememberService,
+		Logger:          logger,
+	})
+
+	sessionHandler := auth.NewSessionHandler(auth.SessionHandlerParams{
+		SessionService: sessionService,
+		I18n:           bundle,
+		Logger:         logger,
+	})
+
+	// rejects tokens of revoked sessions, before remember me may start a new one
+	r.Use(sessionHandler.Verify)
+
+	rememberHandler := auth.NewRememberHandler(auth.RememberHandlerParams{
+		RememberService: rememberService,
+		SessionService:  sessionService,
+		AuthService:     authService,
+		UserService:     usersService,
+		I18n:            bundle,
+		SessionMode:     sessionMode,
+		Logger:          logger,
+	})
+
+	// before the identity middleware so it sees the session started for a
+	// remembered browser
+	r.Use(rememberHandler.Middleware)
+
+	apiKeyService := apikey.NewAPIKeyService(apikey.APIKeyServiceParams{
+		DB:          db,
+		UserService: usersService,
+		Logger:      logger,
+	})
+
+	r.Use(identity.Middleware(identity.MiddlewareParams{
+		APIKeys:      apiKeyService,
+		APIKeyPrefix: apikey.KeyPrefix,
+		Sessions:     sessionPrincipal,
+		Status:       usersService.StatusError,
+		Impersonator: usersService.ImpersonatorError,
+		Unauthorized: errorHandler.Render,
+		Logger:       logger,
+	}))
+
+	r.Use(bundle.Middleware)
+
+	// after the identity middleware, the organizations are the user's
+	r.Use(orgs.Middleware(orgs.MiddlewareParams{
+		OrgService: orgService,
+		I18n:       bundle,
+		Forbidden:  errorHandler.Render,
+		Logger:     logger,
+	}))
+
+	// reports carry the user and the 500 page is in their locale
+	r.Use(web.CaptureRequest)
+
+	passwordPolicy := &password.Policy{
+		MinLength:     conf.PasswordMinLength,
+		MaxLength:     conf.PasswordMaxLength,
+		MinScore:      conf.PasswordMinScore,
+		DisallowEmail: conf.PasswordDisallowEmail,
+	}
+	if conf.PasswordBreachDir != "" {
+		breaches, err := password.NewRangeDir(conf.PasswordBreachDir)
+		if err != nil {
+			log.Fatal(err)
+		}
+		passwordPolicy.Breaches = breaches
+	}
+
+	var mailer mail.Sender
+	switch {
+	case conf.SMTPHost != "":
+		mailer = mail.NewSMTP(mail.SMTPParams{
+			Host:     conf.SMTPHost,
+			Port:     conf.SMTPPort,
+			Username: conf.SMTPUsername,
+			Password: conf.SMTPPassword,
+			From:     conf.MailFrom,
+		})
+	case conf.Environment == "development":
+		mailer = mail.NewLogSender(logger)
+	}
+
+	// send from the queue, requests don't wait for the mail server
+	if mailer != nil {
+		mailer = mail.NewQueueSender(mail.QueueSenderParams{Queue: queue, Sender: mailer})
+	}
+
+	// magic links need a way to reach the user
+	var magicLinkService *auth.MagicLinkService
+	if conf.MagicLinks && mailer != nil {
+		magicLinkService = auth.NewMagicLinkService(auth.MagicLinkServiceParams{
+			DB:            db,
+			Keys:          keys,
+			TTL:           conf.MagicLinkTTL,
+			DeviceBinding: conf.MagicLinkDeviceBinding,
+			Queue:         queue,
+			Logger:        logger,
+		})
+	} else if conf.MagicLinks {
+		logger.Warn("Magic links are off, set SMTP_HOST to send them")
+	}
+
+	// every kind of job is registered by now
+	jobsCtx, stopJobs := context.WithCancel(context.Background())
+	defer stopJobs()
+
+	go queue.Run(jobsCtx)
+
+	// new users verify their address with a magic link
+	if conf.VerifyEmail && magicLinkService == nil {
+		log.Fatal("VERIFY_EMAIL needs magic links, set MAGIC_LINKS and SMTP_HOST")
+	}
+
+	invitationService := auth.NewInvitationService(auth.InvitationServiceParams{
+		DB:     db,
+		TTL:    conf.InvitationTTL,
+		Logger: logger,
 	})
 
 	authHandler := auth.NewAuthHandler(
 		auth.AuthHandlerParams{
-			AuthService: authService,
+			AuthService:         authService,
+			RememberService:     rememberService,
+			SessionService:      sessionService,
+			MagicLinkService:    magicLinkService,
+			Mailer:              mailer,
+			BaseURL:             conf.BaseURL,
+			InvitationService:   invitationService,
+			RegistrationMode:    auth.RegistrationMode(conf.RegistrationMode),
+			RegistrationDomains: conf.RegistrationDomains,
+			PasswordPolicy:      passwordPolicy,
+			UserService:         usersService,
+			Validate:            validate,
+			I18n:                bundle,
+			TokenDelivery:       auth.TokenDelivery(conf.APITokenDelivery),
+			SessionMode:         sessionMode,
+			Logger:              logger,
+		},
+	)
+
+	oidcHandler := auth.NewOIDCHandler(
+		auth.OIDCHandlerParams{
+			OIDCService: auth.NewOIDCService(auth.OIDCServiceParams{
+				DB:          db,
+				Keys:        keys,
+				UserService: usersService,
+				Issuer:      conf.OIDCIssuer,
+				Logger:      logger,
+			}),
+			I18n:   bundle,
+			Logger: logger,
+		},
+	)
+
+	passwordHandler := auth.NewPasswordHandler(auth.PasswordHandlerParams{
+		AuthService: authService,
+		UserService: usersService,
+		Policy:      passwordPolicy,
+		Validate:    validate,
+		I18n:        bundle,
+		Logger:      logger,
+	})
+
+	inviteHandler := auth.NewInviteHandler(auth.InviteHandlerParams{
+		InvitationService: invitationService,
+		UserService:       usersService,
+		Mailer:            mailer,
+		BaseURL:           conf.BaseURL,
+		RegistrationMode:  auth.RegistrationMode(conf.RegistrationMode),
+		Validate:          validate,
+		I18n:              bundle,
+		Logger:            logger,
+	})
+
+	impersonateHandler := auth.NewImpersonateHandler(auth.ImpersonateHandlerParams{
+		AuthService: authService,
+		UserService: usersService,
+		Audit: audit.New(audit.Params{
+			DB:     db,
+			Logger: logger,
+		}),
+		SessionMode: sessionMode,
+		Validate:    validate,
+		I18n:        bundle,
+		Logger:      logger,
+	})
+
+	orgHandler := orgs.NewOrgHandler(orgs.OrgHandlerParams{
+		OrgService:  orgService,
+		UserService: usersService,
+		Switcher:    authHandler,
+		Validate:    validate,
+		I18n:        bundle,
+		Logger:      logger,
+	})
+
+	userHandler := users.NewUserHandler(
+		users.UserHandlerParams{
 			UserService: usersService,
 			Validate:    validate,
+			I18n:        bundle,
 			Logger:      logger,
 		},
 	)
 
+	avatarHandler := users.NewAvatarHandler(users.AvatarHandlerParams{
+		UserService: usersService,
+		Store:       blobs,
+		MaxSize:     conf.UploadMaxSize,
+		I18n:        bundle,
+		Logger:      logger,
+	})
+
+	apiKeyHandler := apikey.NewAPIKeyHandler(
+		apikey.APIKeyHandlerParams{
+			APIKeyService: apiKeyService,
+			Validate:      validate,
+			I18n:          bundle,
+			Logger:        logger,
+		},
+	)
+
+	r.NotFound(errorHandler.NotFound)
+	r.MethodNotAllowed(errorHandler.MethodNotAllowed)
+
+	staticAssets, err := assets.New(assets.Params{
+		FS:     static.FS,
+		Prefix: "/static/",
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	webHandler := web.NewWebHandler(
 		web.WebHandlerParams{
 			Logger: logger,
 		},
 	)
 
-	auth.NewAuthHTTP(auth.AuthHTTPParams{
-		AuthHandler: authHandler,
-		Mux:         r,
+	broker := realtime.NewBroker(realtime.BrokerParams{
+		Logger: logger,
+	})
+
+	hub := realtime.NewHub(realtime.HubParams{
+		Logger:         logger,
+		AllowedOrigins: conf.AllowedOrigins,
 	})
 
-	web.NewWebHTTP(web.WebHTTPParams{
-		WebHandler: webHandler,
-		Mux:        r,
+	routes(routesParams{
+		Mux: r,
+		API: openapi.New(openapi.Params{
+			Title:   conf.ServiceName,
+			Version: "1.0.0",
+			Problem: web.Problem{},
+		}),
+		ErrorHandler:       errorHandler,
+		AuthHandler:        authHandler,
+		OIDCHandler:        oidcHandler,
+		RememberHandler:    rememberHandler,
+		SessionHandler:     sessionHandler,
+		PasswordHandler:    passwordHandler,
+		InviteHandler:      inviteHandler,
+		OrgHandler:         orgHandler,
+		ImpersonateHandler: impersonateHandler,
+		UserHandler:        userHandler,
+		AvatarHandler:      avatarHandler,
+		APIKeyHandler:      apiKeyHandler,
+		WebHandler:         webHandler,
+		Assets:             staticAssets,
+		Broker:             broker,
+		Hub:                hub,
+		Keyring:            keys,
+		Files: storage.NewHandler(storage.HandlerParams{
+			Store:  blobs,
+			Signer: signer,
+			Error:  errorHandler.Render,
+			Logger: logger,
+		}),
+		MagicLinks: magicLinkService != nil,
+		ReportURI:  secureConf.ReportURI,
+		Logger:     logger,
 	})
 
 	go metrics.StartMetricsServer(logger)
@@ -125,9 +637,27 @@ func main() {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
+	// Close the event streams first, Shutdown waits for open connections
+	if err := broker.Shutdown(ctx); err != nil {
+		sugar.Errorln("Error closing event streams", zap.Error(err))
+	}
+
+	if err := hub.Shutdown(ctx); err != nil {
+		sugar.Errorln("Error closing WebSockets", zap.Error(err))
+	}
+
 	// Attempt to gracefully shut down the server
 	if err := srv.Shutdown(ctx); err != nil {
-		sugar.Fatalln("Error shutting down server", zap.Error(err))
+		sugar.Errorln("Error shutting down server", zap.Error(err))
+	}
+
+	// requests are done enqueuing, let the running jobs finish, with their
+	// own time whatever the requests took
+	drainCtx, cancelDrain := context.WithTimeout(context.Background(), conf.JobsShutdownTimeout)
+	defer cancelDrain()
+
+	if err := queue.Shutdown(drainCtx); err != nil {
+		sugar.Errorln("Error draining jobs", zap.Error(err))
 	}
 
 	log.Println("Server gracefully stopped")
@@ -0,0 +1,57 @@
+// Command oidc-client registers an app that signs its users in with this one.
+//
+//	go run ./cmd/oidc-client -name "Wiki" -redirect-uri https://wiki.example.com/callback
+//
+// The client id, and the secret of confidential clients, are printed once.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/tomdoestech/goth/internal/auth"
+	"go.uber.org/zap"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func main() {
+	name := flag.String("name", "", "name shown on the consent screen")
+	redirectURIs := flag.String("redirect-uri", "", "comma separated redirect URIs")
+	public := flag.Bool("public", false, "register a public client, such as a single page app, without a secret")
+	flag.Parse()
+
+	if *name == "" || *redirectURIs == "" {
+		flag.Usage()
+		log.Fatal("-name and -redirect-uri are required")
+	}
+
+	var uris []string
+	for _, uri := range strings.Split(*redirectURIs, ",") {
+		if uri = strings.TrimSpace(uri); uri != "" {
+			uris = append(uris, uri)
+		}
+	}
+
+	db, err := gorm.Open(sqlite.Open("test.db"), &gorm.Config{})
+	if err != nil {
+		log.Fatal("failed to connect database: ", err)
+	}
+
+	service := auth.NewOIDCService(auth.OIDCServiceParams{
+		DB:     db,
+		Logger: zap.NewNop(),
+	})
+
+	client, secret, err := service.RegisterClient(*name, uris, !*public)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Println("client_id:", client.ID)
+	if secret != "" {
+		fmt.Println("client_secret:", secret)
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/tomdoestech/goth/internal/apikey"
+	"github.com/tomdoestech/goth/internal/auth"
+	orgs "github.com/tomdoestech/goth/internal/org"
+	"github.com/tomdoestech/goth/internal/pkg/assets"
+	"github.com/tomdoestech/goth/internal/pkg/keyring"
+	"github.com/tomdoestech/goth/internal/pkg/openapi"
+	"github.com/tomdoestech/goth/internal/pkg/realtime"
+	"github.com/tomdoestech/goth/internal/pkg/secure"
+	users "github.com/tomdoestech/goth/internal/user"
+	"github.com/tomdoestech/goth/internal/web"
+	"go.uber.org/zap"
+)
+
+type routesParams struct {
+	Mux                *chi.Mux
+	API                *openapi.Document
+	ErrorHandler       *web.ErrorHandler
+	AuthHandler        *auth.AuthHandler
+	OIDCHandler        *auth.OIDCHandler
+	RememberHandler    *auth.RememberHandler
+	SessionHandler     *auth.SessionHandler
+	PasswordHandler    *auth.PasswordHandler
+	InviteHandler      *auth.InviteHandler
+	OrgHandler         *orgs.OrgHandler
+	ImpersonateHandler *auth.ImpersonateHandler
+	UserHandler        *users.UserHandler
+	AvatarHandler      *users.AvatarHandler
+	APIKeyHandler      *apikey.APIKeyHandler
+	WebHandler         *web.WebHandler
+	Assets             *assets.Assets
+	Broker             *realtime.Broker
+	Hub                *realtime.Hub
+	Keyring            *keyring.Keyring
+	Files              http.Handler
+	MagicLinks         bool
+	ReportURI          string
+	Logger             *zap.Logger
+}
+
+// routes registers every route of the server, documenting each in p.API.
+func routes(p routesParams) {
+
+	auth.NewAuthHTTP(auth.AuthHTTPParams{
+		AuthHandler:  p.AuthHandler,
+		ErrorHandler: p.ErrorHandler,
+		API:          p.API,
+		Mux:          p.Mux,
+	})
+
+	auth.NewMagicLinkHTTP(auth.MagicLinkHTTPParams{
+		AuthHandler:  p.AuthHandler,
+		ErrorHandler: p.ErrorHandler,
+		API:          p.API,
+		Mux:          p.Mux,
+	})
+
+	auth.NewOIDCHTTP(auth.OIDCHTTPParams{
+		OIDCHandler:  p.OIDCHandler,
+		ErrorHandler: p.ErrorHandler,
+		API:          p.API,
+		Mux:          p.Mux,
+	})
+
+	auth.NewRememberHTTP(auth.RememberHTTPParams{
+		RememberHandler: p.RememberHandler,
+		ErrorHandler:    p.ErrorHandler,
+		API:             p.API,
+		Mux:             p.Mux,
+	})
+
+	auth.NewSessionHTTP(auth.SessionHTTPParams{
+		SessionHandler: p.SessionHandler,
+		ErrorHandler:   p.ErrorHandler,
+		API:            p.API,
+		Mux:            p.Mux,
+	})
+
+	auth.NewPasswordHTTP(auth.PasswordHTTPParams{
+		PasswordHandler: p.PasswordHandler,
+		ErrorHandler:    p.ErrorHandler,
+		API:             p.API,
+		Mux:             p.Mux,
+	})
+
+	auth.NewInviteHTTP(auth.InviteHTTPParams{
+		InviteHandler: p.InviteHandler,
+		ErrorHandler:  p.ErrorHandler,
+		API:           p.API,
+		Mux:           p.Mux,
+	})
+
+	auth.NewImpersonateHTTP(auth.ImpersonateHTTPParams{
+		ImpersonateHandler: p.ImpersonateHandler,
+		ErrorHandler:       p.ErrorHandler,
+		API:                p.API,
+		Mux:                p.Mux,
+	})
+
+	orgs.NewOrgHTTP(orgs.OrgHTTPParams{
+		OrgHandler:   p.OrgHandler,
+		ErrorHandler: p.ErrorHandler,
+		API:          p.API,
+		Mux:          p.Mux,
+	})
+
+	users.NewUserHTTP(users.UserHTTPParams{
+		UserHandler:  p.UserHandler,
+		ErrorHandler: p.ErrorHandler,
+		API:          p.API,
+		Mux:          p.Mux,
+	})
+
+	users.NewAvatarHTTP(users.AvatarHTTPParams{
+		AvatarHandler: p.AvatarHandler,
+		ErrorHandler:  p.ErrorHandler,
+		API:           p.API,
+		Mux:           p.Mux,
+	})
+
+	apikey.NewAPIKeyHTTP(apikey.APIKeyHTTPParams{
+		APIKeyHandler: p.APIKeyHandler,
+		ErrorHandler:  p.ErrorHandler,
+		API:           p.API,
+		Mux:           p.Mux,
+	})
+
+	web.NewWebHTTP(web.WebHTTPParams{
+		WebHandler: p.WebHandler,
+		Assets:     p.Assets,
+		API:        p.API,
+		Mux:        p.Mux,
+		MagicLinks: p.MagicLinks,
+	})
+
+	r := openapi.NewRouter(p.Mux, p.API)
+
+	// reports may go to another service, or nowhere
+	if strings.HasPrefix(p.ReportURI, "/") {
+		r.Post(p.ReportURI, openapi.Operation{
+			Summary:      "Content Security Policy violation reports",
+			Description:  "Browsers post CSP violations here, they are logged.",
+			Tags:         []string{"operations"},
+			RequestTypes: []string{"application/csp-report", "application/reports+json"},
+			Responses: map[int]openapi.Response{
+				http.StatusNoContent:  {Description: "Report logged"},
+				http.StatusBadRequest: {Description: "Not a violation report"},
+			},
+		}, secure.ReportHandler(p.Logger))
+	}
+
+	r.Get("/.well-known/jwks.json", openapi.Operation{
+		Summary:     "Token signing keys",
+		Description: "The public keys that verify access tokens, as a JSON Web Key Set. Tokens name their key in the kid header.",
+		Tags:        []string{"auth"},
+		Responses: map[int]openapi.Response{
+			http.StatusOK: {Description: "The key set", Body: keyring.JWKS{}, ContentType: "application/jwk-set+json"},
+		},
+	}, p.Keyring)
+
+	r.Get("/files/*", openapi.Operation{
+		Summary:     "Uploads",
+		Description: "Serves uploaded files, such as avatars, through the signed links the app hands out. Links stop working when they expire.",
+		Tags:        []string{"users"},
+		Query: []openapi.Parameter{
+			{Name: "expires", Description: "When the link expires, in seconds since the epoch", Required: true},
+			{Name: "signature", Description: "The signature of the link", Required: true},
+		},
+		Responses: map[int]openapi.Response{
+			http.StatusOK: {Description: "The file", ContentType: "application/octet-stream"},
+		},
+		Errors: []int{http.StatusForbidden, http.StatusNotFound},
+	}, p.Files)
+
+	r.Get("/events", openapi.Operation{
+		Summary:     "Server-sent events",
+		Description: "A text/event-stream of the requested topics. Logged in users also get their own notifications. Send Last-Event-ID to resume.",
+		Tags:        []string{"realtime"},
+		Query: []openapi.Parameter{
+			{Name: "topic", Description: "A public topic to subscribe to, may be repeated"},
+		},
+		Responses: map[int]openapi.Response{
+			http.StatusOK: {Description: "The event stream", ContentType: "text/event-stream"},
+		},
+		Errors: []int{http.StatusBadRequest, http.StatusServiceUnavailable},
+	}, p.Broker)
+
+	r.Get("/ws", openapi.Operation{
+		Summary:     "WebSocket",
+		Description: "Upgrades to a WebSocket that receives HTML fragments for htmx. Send {\"action\": \"join\", \"room\": \"...\"} or leave to change rooms.",
+		Tags:        []string{"realtime"},
+		Query: []openapi.Parameter{
+			{Name: "room", Description: "A room to join straight away, may be repeated"},
+		},
+		Responses: map[int]openapi.Response{
+			http.StatusSwitchingProtocols: {Description: "Upgraded to a WebSocket"},
+		},
+		Errors: []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden, http.StatusServiceUnavailable},
+		Auth:   true,
+	}, p.Hub)
+}
@@ -0,0 +1,57 @@
+//go:build unit
+// +build unit
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/tomdoestech/goth/internal/pkg/assets"
+	"github.com/tomdoestech/goth/internal/pkg/openapi"
+	"github.com/tomdoestech/goth/internal/web"
+)
+
+func TestRoutesDocumented(t *testing.T) {
+	r := chi.NewMux()
+	api := openapi.New(openapi.Params{
+		Title:   "goth",
+		Version: "1.0.0",
+		Problem: web.Problem{},
+	})
+
+	staticAssets, err := assets.New(assets.Params{
+		FS:     fstest.MapFS{},
+		Prefix: "/static/",
+	})
+	assert.NoError(t, err)
+
+	routes(routesParams{
+		Mux:       r,
+		API:       api,
+		Assets:    staticAssets,
+		ReportURI: "/csp-report",
+	})
+
+	missing, err := openapi.Undocumented(r, api)
+	assert.NoError(t, err)
+	assert.Empty(t, missing, "every route must be registered through openapi.Router")
+
+	for _, path := range []string{"/openapi.json", "/docs"} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		assert.Equal(t, http.StatusOK, w.Code, path)
+	}
+
+	var spec map[string]interface{}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &spec))
+	assert.Equal(t, "3.1.0", spec["openapi"])
+	assert.Contains(t, spec["paths"], "/api/api-keys/{id}")
+}
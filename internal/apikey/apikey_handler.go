@@ -0,0 +1,225 @@
+package apikey
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-playground/validator/v10"
+	"github.com/google/uuid"
+	"github.com/tomdoestech/goth/internal/pkg/apperror"
+	"github.com/tomdoestech/goth/internal/pkg/i18n"
+	"github.com/tomdoestech/goth/internal/pkg/identity"
+	"github.com/tomdoestech/goth/internal/web"
+	"go.uber.org/zap"
+)
+
+type APIKeyHandler struct {
+	apiKeyService *APIKeyService
+	validate      *validator.Validate
+	i18n          *i18n.Bundle
+	logger        *zap.Logger
+}
+
+type APIKeyHandlerParams struct {
+	APIKeyService *APIKeyService
+	Validate      *validator.Validate
+	I18n          *i18n.Bundle
+	Logger        *zap.Logger
+}
+
+type createData struct {
+	Name   string   `json:"name" validate:"required,max=64"`
+	Scopes []string `json:"scopes" validate:"dive,required"`
+	// ExpiresInDays is 0 for keys that don't expire
+	ExpiresInDays int `json:"expires_in_days" validate:"min=0,max=365"`
+}
+
+type createResponse struct {
+	// Key is only ever returned here
+	Key    string         `json:"key"`
+	APIKey APIKeyResponse `json:"api_key"`
+}
+
+func NewAPIKeyHandler(p APIKeyHandlerParams) *APIKeyHandler {
+	return &APIKeyHandler{
+		apiKeyService: p.APIKeyService,
+		validate:      p.Validate,
+		i18n:          p.I18n,
+		logger:        p.Logger,
+	}
+}
+
+// principal returns the logged in user. Keys are managed with a session
+// only, an API key can't be used to create or revoke keys.
+func (a *APIKeyHandler) principal(r *http.Request) (*identity.Principal, error) {
+	loc := a.i18n.FromRequest(r)
+
+	p, ok := identity.FromContext(r.Context())
+	if !ok {
+		return nil, apperror.Unauthorized(loc.T("error.unauthorized.message"), nil)
+	}
+	if p.Method == identity.MethodAPIKey {
+		return nil, apperror.Forbidden(loc.T("apikey.session_required"))
+	}
+	return p, nil
+}
+
+// Page renders the API keys section of the account settings.
+func (a *APIKeyHandler) Page(w http.ResponseWriter, r *http.Request) error {
+	p, err := a.principal(r)
+	if err != nil {
+		return err
+	}
+
+	keys, err := a.apiKeyService.List(p.UserID)
+	if err != nil {
+		return apperror.Internal(fmt.Errorf("listing api keys: %w", err))
+	}
+
+	web.RenderTemplate(w, "api_keys.html", a.pageData(keys, ""), r)
+
+	return nil
+}
+
+func (a *APIKeyHandler) List(w http.ResponseWriter, r *http.Request) error {
+	p, err := a.principal(r)
+	if err != nil {
+		return err
+	}
+
+	keys, err := a.apiKeyService.List(p.UserID)
+	if err != nil {
+		return apperror.Internal(fmt.Errorf("listing api keys: %w", err))
+	}
+
+	res := make([]APIKeyResponse, 0, len(keys))
+	for i := range keys {
+		res = append(res, NewAPIKeyResponse(&keys[i]))
+	}
+
+	return web.WriteJSON(w, http.StatusOK, res)
+}
+
+func (a *APIKeyHandler) Create(w http.ResponseWriter, r *http.Request) error {
+	p, err := a.principal(r)
+	if err != nil {
+		return err
+	}
+
+	loc := a.i18n.FromRequest(r)
+
+	// a key would let an impersonating admin act as the user after they stop
+	if p.Impersonated() {
+		return apperror.Forbidden(loc.T("impersonate.blocked"))
+	}
+
+	var data createData
+	if err := web.Decode(r, &data); err != nil {
+		return err
+	}
+
+	if err := a.validate.Struct(&data); err != nil {
+		return web.ValidationError(loc, &data, err)
+	}
+
+	for _, scope := range data.Scopes {
+		if !validScope(scope) {
+			return apperror.Validation(loc.T("error.validation.title"), []string{loc.T("apikey.invalid_scope", scope)})
+		}
+	}
+
+	var expiresAt *time.Time
+	if data.ExpiresInDays > 0 {
+		t := time.Now().AddDate(0, 0, data.ExpiresInDays)
+		expiresAt = &t
+	}
+
+	key, model, err := a.apiKeyService.Create(p.UserID, data.Name, data.Scopes, expiresAt)
+	if err != nil {
+		return apperror.Internal(err)
+	}
+
+	if web.WantsJSON(r) {
+		return web.WriteJSON(w, http.StatusCreated, createResponse{
+			Key:    key,
+			APIKey: NewAPIKeyResponse(model),
+		})
+	}
+
+	return a.renderList(w, r, p, key, http.StatusCreated)
+}
+
+func (a *APIKeyHandler) Revoke(w http.ResponseWriter, r *http.Request) error {
+	p, err := a.principal(r)
+	if err != nil {
+		return err
+	}
+
+	loc := a.i18n.FromRequest(r)
+
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		return apperror.NotFound(loc.T("apikey.not_found"))
+	}
+
+	err = a.apiKeyService.Revoke(p.UserID, id)
+	if errors.Is(err, ErrNotFound) {
+		return apperror.NotFound(loc.T("apikey.not_found"))
+	}
+	if err != nil {
+		return apperror.Internal(fmt.Errorf("revoking api key: %w", err))
+	}
+
+	if web.WantsJSON(r) {
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+
+	return a.renderList(w, r, p, "", http.StatusOK)
+}
+
+// renderList renders the key list for htmx, with the new key when one was
+// just created.
+func (a *APIKeyHandler) renderList(w http.ResponseWriter, r *http.Request, p *identity.Principal, newKey string, status int) error {
+	keys, err := a.apiKeyService.List(p.UserID)
+	if err != nil {
+		return apperror.Internal(fmt.Errorf("listing api keys: %w", err))
+	}
+
+	web.RenderBlock(w, r, "api_keys.html", "api_keys", status, a.pageData(keys, newKey))
+
+	return nil
+}
+
+func (a *APIKeyHandler) pageData(keys []APIKeyModel, newKey string) map[string]interface{} {
+	now := time.Now()
+
+	type row struct {
+		APIKeyModel
+		Active bool
+	}
+
+	rows := make([]row, 0, len(keys))
+	for _, k := range keys {
+		rows = append(rows, row{APIKeyModel: k, Active: k.Active(now)})
+	}
+
+	return map[string]interface{}{
+		"Title":  "apikey.title",
+		"Keys":   rows,
+		"NewKey": newKey,
+		"Scopes": identity.Scopes,
+	}
+}
+
+func validScope(scope string) bool {
+	for _, s := range identity.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,67 @@
+package apikey
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/tomdoestech/goth/internal/pkg/openapi"
+	"github.com/tomdoestech/goth/internal/web"
+)
+
+type APIKeyHTTPParams struct {
+	APIKeyHandler *APIKeyHandler
+	ErrorHandler  *web.ErrorHandler
+	API           *openapi.Document
+	Mux           *chi.Mux
+}
+
+func NewAPIKeyHTTP(p APIKeyHTTPParams) {
+
+	r := openapi.NewRouter(p.Mux, p.API)
+	e := p.ErrorHandler
+
+	r.Get("/account/api-keys", openapi.Operation{
+		Summary: "API keys page",
+		Tags:    []string{"api keys"},
+		Responses: map[int]openapi.Response{
+			http.StatusOK: {Description: "The API keys section of the account settings", ContentType: "text/html"},
+		},
+		Errors: []int{http.StatusUnauthorized, http.StatusForbidden},
+		Auth:   true,
+	}, e.Handle(p.APIKeyHandler.Page))
+
+	r.Get("/api/api-keys", openapi.Operation{
+		Summary:     "List API keys",
+		Description: "Lists the user's keys, including revoked and expired ones. Only available to logged in users, not to API keys.",
+		Tags:        []string{"api keys"},
+		Responses: map[int]openapi.Response{
+			http.StatusOK: {Description: "The keys, newest first", Body: []APIKeyResponse{}},
+		},
+		Errors: []int{http.StatusUnauthorized, http.StatusForbidden},
+		Auth:   true,
+	}, e.Handle(p.APIKeyHandler.List))
+
+	r.Post("/api/api-keys", openapi.Operation{
+		Summary:      "Create an API key",
+		Description:  "The key is only returned in this response, store it somewhere safe.",
+		Tags:         []string{"api keys"},
+		Request:      createData{},
+		RequestTypes: []string{"application/json", "application/x-www-form-urlencoded"},
+		Responses: map[int]openapi.Response{
+			http.StatusCreated: {Description: "Key created", Body: createResponse{}},
+		},
+		Errors: []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden},
+		Auth:   true,
+	}, e.Handle(p.APIKeyHandler.Create))
+
+	r.Delete("/api/api-keys/{id}", openapi.Operation{
+		Summary: "Revoke an API key",
+		Tags:    []string{"api keys"},
+		Responses: map[int]openapi.Response{
+			http.StatusOK:        {Description: "Key revoked, htmx gets the updated list", ContentType: "text/html"},
+			http.StatusNoContent: {Description: "Key revoked, for JSON clients"},
+		},
+		Errors: []int{http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound},
+		Auth:   true,
+	}, e.Handle(p.APIKeyHandler.Revoke))
+}
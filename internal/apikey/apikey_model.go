@@ -0,0 +1,67 @@
+package apikey
+
+import (
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type APIKeyModel struct {
+	ID        uuid.UUID `gorm:"primaryKey;type:uuid" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+
+	UserID uuid.UUID `gorm:"type:uuid;index;not null" json:"-"`
+	Name   string    `gorm:"not null" json:"name"`
+	// Prefix identifies the key in lists and logs, e.g. goth_k3j9x2mq
+	Prefix string `gorm:"uniqueIndex;not null" json:"prefix"`
+	// Hash is the SHA-256 of the whole key, the key itself is never stored
+	Hash string `gorm:"not null" json:"-"`
+	// Scopes is space separated
+	Scopes string `json:"-"`
+
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+}
+
+func (APIKeyModel) TableName() string {
+	return "api_keys"
+}
+
+func (k *APIKeyModel) ScopeList() []string {
+	return strings.Fields(k.Scopes)
+}
+
+// Active reports whether the key can still be used at now.
+func (k *APIKeyModel) Active(now time.Time) bool {
+	if k.RevokedAt != nil {
+		return false
+	}
+	return k.ExpiresAt == nil || now.Before(*k.ExpiresAt)
+}
+
+// APIKeyResponse is the representation of a key returned to API clients.
+type APIKeyResponse struct {
+	ID         uuid.UUID  `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+}
+
+func NewAPIKeyResponse(k *APIKeyModel) APIKeyResponse {
+	return APIKeyResponse{
+		ID:         k.ID,
+		Name:       k.Name,
+		Prefix:     k.Prefix,
+		Scopes:     k.ScopeList(),
+		CreatedAt:  k.CreatedAt,
+		ExpiresAt:  k.ExpiresAt,
+		LastUsedAt: k.LastUsedAt,
+		RevokedAt:  k.RevokedAt,
+	}
+}
@@ -0,0 +1,184 @@
+package apikey
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/tomdoestech/goth/internal/pkg/identity"
+	users "github.com/tomdoestech/goth/internal/user"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// KeyPrefix starts every API key so they are easy to tell apart from JWTs
+// and to spot by secret scanners.
+const KeyPrefix = "goth_"
+
+// lastUsedResolution limits how often using a key writes to the database.
+const lastUsedResolution = time.Minute
+
+var (
+	ErrNotFound   = errors.New("api key not found")
+	ErrInvalidKey = errors.New("invalid api key")
+)
+
+var encoding = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+
+type APIKeyService struct {
+	db          *gorm.DB
+	userService *users.UserService
+	logger      *zap.Logger
+	now         func() time.Time
+}
+
+type APIKeyServiceParams struct {
+	DB          *gorm.DB
+	UserService *users.UserService
+	Logger      *zap.Logger
+}
+
+func NewAPIKeyService(p APIKeyServiceParams) *APIKeyService {
+
+	p.DB.AutoMigrate(&APIKeyModel{})
+
+	return &APIKeyService{
+		db:          p.DB,
+		userService: p.UserService,
+		logger:      p.Logger,
+		now:         time.Now,
+	}
+}
+
+// Create generates a key for the user. The returned key is shown once, only
+// its hash is stored.
+func (s *APIKeyService) Create(userID uuid.UUID, name string, scopes []string, expiresAt *time.Time) (string, *APIKeyModel, error) {
+	id, err := randomString(5)
+	if err != nil {
+		return "", nil, err
+	}
+	secret, err := randomString(20)
+	if err != nil {
+		return "", nil, err
+	}
+
+	prefix := KeyPrefix + id
+	key := prefix + "_" + secret
+
+	model := &APIKeyModel{
+		ID:        uuid.New(),
+		UserID:    userID,
+		Name:      name,
+		Prefix:    prefix,
+		Hash:      hashKey(key),
+		Scopes:    strings.Join(scopes, " "),
+		ExpiresAt: expiresAt,
+	}
+
+	if err := s.db.Create(model).Error; err != nil {
+		return "", nil, fmt.Errorf("creating api key: %w", err)
+	}
+
+	return key, model, nil
+}
+
+// List returns the user's keys, newest first, including revoked and expired ones.
+func (s *APIKeyService) List(userID uuid.UUID) ([]APIKeyModel, error) {
+	var keys []APIKeyModel
+	err := s.db.Where("user_id = ?", userID).Order("created_at desc").Find(&keys).Error
+	return keys, err
+}
+
+// Revoke stops the key from working. Keys of other users are not found.
+func (s *APIKeyService) Revoke(userID, id uuid.UUID) error {
+	result := s.db.Model(&APIKeyModel{}).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", id, userID).
+		Update("revoked_at", s.now())
+
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Authenticate returns the active key matching key and records that it was used.
+func (s *APIKeyService) Authenticate(key string) (*APIKeyModel, error) {
+	i := strings.LastIndexByte(key, '_')
+	if !strings.HasPrefix(key, KeyPrefix) || i < len(KeyPrefix) {
+		return nil, ErrInvalidKey
+	}
+
+	var model APIKeyModel
+	err := s.db.Where("prefix = ?", key[:i]).First(&model).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrInvalidKey
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if subtle.ConstantTimeCompare([]byte(model.Hash), []byte(hashKey(key))) != 1 {
+		return nil, ErrInvalidKey
+	}
+
+	now := s.now()
+	if !model.Active(now) {
+		return nil, ErrInvalidKey
+	}
+
+	if model.LastUsedAt == nil || now.Sub(*model.LastUsedAt) > lastUsedResolution {
+		if err := s.db.Model(&model).UpdateColumn("last_used_at", now).Error; err != nil {
+			s.logger.Warn("Error recording api key use", zap.Error(err))
+		}
+		model.LastUsedAt = &now
+	}
+
+	return &model, nil
+}
+
+// ResolveAPIKey implements identity.APIKeyResolver.
+func (s *APIKeyService) ResolveAPIKey(ctx context.Context, key string) (*identity.Principal, error) {
+	model, err := s.Authenticate(key)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := s.userService.FindUserByID(model.UserID)
+	if err != nil {
+		return nil, fmt.Errorf("finding owner of api key %s: %w", model.Prefix, err)
+	}
+
+	return &identity.Principal{
+		UserID:   user.ID,
+		Email:    user.Email,
+		Locale:   user.Locale,
+		Method:   identity.MethodAPIKey,
+		Scopes:   model.ScopeList(),
+		APIKeyID: model.ID,
+	}, nil
+}
+
+func hashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// randomString returns n random bytes as lower case base32.
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return encoding.EncodeToString(b), nil
+}
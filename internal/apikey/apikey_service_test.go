@@ -0,0 +1,110 @@
+//go:build unit
+// +build unit
+
+package apikey
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/tomdoestech/goth/internal/pkg/identity"
+	users "github.com/tomdoestech/goth/internal/user"
+	"go.uber.org/zap"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func newTestService(t *testing.T) (*APIKeyService, *users.UserModel) {
+	t.Helper()
+
+	f, err := os.CreateTemp("", "test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	filename := f.Name() + ".db"
+	t.Cleanup(func() { os.Remove(filename) })
+
+	db, err := gorm.Open(sqlite.Open(filename), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	userService := users.NewUserService(users.UserServiceParams{
+		Logger:   zap.NewNop(),
+		Validate: validator.New(),
+		DB:       db,
+	})
+
+	user, err := userService.CreateUser("test@example.com", "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return NewAPIKeyService(APIKeyServiceParams{
+		DB:          db,
+		UserService: userService,
+		Logger:      zap.NewNop(),
+	}), user
+}
+
+func TestAPIKeyLifecycle(t *testing.T) {
+
+	assert := assert.New(t)
+
+	service, user := newTestService(t)
+
+	key, model, err := service.Create(user.ID, "ci", []string{identity.ScopeProfileRead}, nil)
+	assert.NoError(err)
+	assert.True(strings.HasPrefix(key, model.Prefix+"_"))
+	assert.NotContains(model.Hash, key)
+
+	principal, err := service.ResolveAPIKey(context.Background(), key)
+	if assert.NoError(err) {
+		assert.Equal(user.ID, principal.UserID)
+		assert.Equal(identity.MethodAPIKey, principal.Method)
+		assert.True(principal.Can(identity.ScopeProfileRead))
+		assert.False(principal.Can(identity.ScopeProfileWrite))
+	}
+
+	keys, err := service.List(user.ID)
+	assert.NoError(err)
+	if assert.Len(keys, 1) {
+		assert.NotNil(keys[0].LastUsedAt)
+	}
+
+	_, err = service.Authenticate(key[:len(key)-1] + "x")
+	assert.ErrorIs(err, ErrInvalidKey)
+
+	assert.ErrorIs(service.Revoke(uuid.New(), model.ID), ErrNotFound, "other users can't revoke the key")
+	assert.NoError(service.Revoke(user.ID, model.ID))
+	assert.ErrorIs(service.Revoke(user.ID, model.ID), ErrNotFound)
+
+	_, err = service.Authenticate(key)
+	assert.ErrorIs(err, ErrInvalidKey)
+}
+
+func TestAPIKeyExpiry(t *testing.T) {
+
+	assert := assert.New(t)
+
+	service, user := newTestService(t)
+
+	expiresAt := time.Now().Add(time.Hour)
+	key, _, err := service.Create(user.ID, "temporary", nil, &expiresAt)
+	assert.NoError(err)
+
+	_, err = service.Authenticate(key)
+	assert.NoError(err)
+
+	service.now = func() time.Time { return expiresAt.Add(time.Second) }
+
+	_, err = service.Authenticate(key)
+	assert.ErrorIs(err, ErrInvalidKey)
+}
@@ -1,170 +1,586 @@
 package auth
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/go-playground/validator/v10"
+	"github.com/google/uuid"
+	"github.com/tomdoestech/goth/internal/pkg/apperror"
+	"github.com/tomdoestech/goth/internal/pkg/i18n"
+	"github.com/tomdoestech/goth/internal/pkg/identity"
+	"github.com/tomdoestech/goth/internal/pkg/mail"
+	"github.com/tomdoestech/goth/internal/pkg/password"
+	"github.com/tomdoestech/goth/internal/pkg/session"
 	users "github.com/tomdoestech/goth/internal/user"
+	"github.com/tomdoestech/goth/internal/web"
 	"go.uber.org/zap"
 )
 
+// TokenDelivery decides how JSON clients receive their token when they log
+// in. Browsers always get a cookie.
+type TokenDelivery string
+
+const (
+	TokenInCookie TokenDelivery = "cookie"
+	TokenInBody   TokenDelivery = "body"
+	TokenInBoth   TokenDelivery = "both"
+)
+
 type AuthHandler struct {
-	authService *AuthService
-	userService *users.UserService
-	validate    *validator.Validate
-	logger      *zap.Logger
+	authService     *AuthService
+	userService     *users.UserService
+	rememberService *RememberService
+	sessionService  *SessionService
+	magicLinks      *MagicLinkService
+	mailer          mail.Sender
+	baseURL         string
+	invitations     *InvitationService
+	registration    RegistrationMode
+	domains         []string
+	passwordPolicy  *password.Policy
+	validate        *validator.Validate
+	i18n            *i18n.Bundle
+	tokenDelivery   TokenDelivery
+	sessionMode     SessionMode
+	logger          *zap.Logger
 }
 
 type AuthHandlerParams struct {
 	AuthService *AuthService
 	UserService *users.UserService
-	Validate    *validator.Validate
+	// RememberService keeps browsers logged in that tick "Remember me", nil
+	// ignores the checkbox
+	RememberService *RememberService
+	// SessionService records each login so users can see and revoke them,
+	// nil issues tokens without a session
+	SessionService *SessionService
+	// MagicLinkService emails login links, nil turns them off
+	MagicLinkService *MagicLinkService
+	// Mailer sends the magic links
+	Mailer mail.Sender
+	// BaseURL is the public URL of the app, the start of magic links
+	BaseURL string
+	// InvitationService lets invited addresses register, nil turns
+	// invitations off
+	InvitationService *InvitationService
+	// RegistrationMode decides who may register without an invitation,
+	// defaults to RegistrationOpen
+	RegistrationMode RegistrationMode
+	// RegistrationDomains are the domains RegistrationDomain lets register
+	RegistrationDomains []string
+	// PasswordPolicy decides which passwords new accounts may have, defaults
+	// to password.DefaultPolicy()
+	PasswordPolicy *password.Policy
+	Validate       *validator.Validate
+	I18n           *i18n.Bundle
+	// TokenDelivery defaults to TokenInCookie
+	TokenDelivery TokenDelivery
+	// SessionMode is how the cookie keeps the browser logged in, defaults to
+	// SessionJWT
+	SessionMode SessionMode
 	Logger      *zap.Logger
 }
 
 type loginData struct {
-	Email    string `json:"email" validate:"required,email"`
-	Password string `json:"password" validate:"required,min=6,max=32"`
+	Email string `json:"email" validate:"required,email"`
+	// Password has no minimum, older accounts may have shorter ones than the
+	// policy allows now
+	Password string `json:"password" validate:"required,max=1024"`
+	// Remember keeps the browser logged in after it is closed
+	Remember bool `json:"remember,omitempty"`
+	// Next is the page browsers are sent to after logging in
+	Next string `json:"next,omitempty"`
 }
 
 type registrationData struct {
-	Email    string `json:"email" validate:"required,email"`
-	Password string `json:"password" validate:"required,min=6,max=32"`
+	Email string `json:"email" validate:"required,email"`
+	// Password must also satisfy the password policy
+	Password string `json:"password" validate:"required"`
+	// Invite is the token of an invitation, Email must be the invited address
+	Invite string `json:"invite,omitempty"`
+}
+
+type loginResponse struct {
+	User      users.UserResponse `json:"user"`
+	Token     string             `json:"token,omitempty"`
+	TokenType string             `json:"token_type,omitempty"`
+	ExpiresAt time.Time          `json:"expires_at"`
+}
+
+type registrationResponse struct {
+	User users.UserResponse `json:"user"`
 }
 
 func NewAuthHandler(p AuthHandlerParams) *AuthHandler {
+	if p.TokenDelivery == "" {
+		p.TokenDelivery = TokenInCookie
+	}
+	if p.SessionMode == "" {
+		p.SessionMode = SessionJWT
+	}
+	if p.PasswordPolicy == nil {
+		p.PasswordPolicy = password.DefaultPolicy()
+	}
+	if p.RegistrationMode == "" {
+		p.RegistrationMode = RegistrationOpen
+	}
+
 	return &AuthHandler{
-		authService: p.AuthService,
-		userService: p.UserService,
-		validate:    p.Validate,
-		logger:      p.Logger,
+		authService:     p.AuthService,
+		userService:     p.UserService,
+		rememberService: p.RememberService,
+		sessionService:  p.SessionService,
+		magicLinks:      p.MagicLinkService,
+		mailer:          p.Mailer,
+		baseURL:         strings.TrimSuffix(p.BaseURL, "/"),
+		invitations:     p.InvitationService,
+		registration:    p.RegistrationMode,
+		domains:         p.RegistrationDomains,
+		passwordPolicy:  p.PasswordPolicy,
+		validate:        p.Validate,
+		i18n:            p.I18n,
+		tokenDelivery:   p.TokenDelivery,
+		sessionMode:     p.SessionMode,
+		logger:          p.Logger,
 	}
 }
 
-func (a *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
+func (a *AuthHandler) Login(w http.ResponseWriter, r *http.Request) error {
 
-	email := r.FormValue("email")
-	password := r.FormValue("password")
-	data := loginData{
-		Email:    email,
-		Password: password,
+	loc := a.i18n.FromRequest(r)
+
+	var data loginData
+	if err := web.Decode(r, &data); err != nil {
+		return err
 	}
 
 	err := a.validate.Struct(&data)
 	if err != nil {
-		// Handle validation errors
-		handleValidationErrors(w, err)
-		return
+		return web.ValidationError(loc, &data, err)
 	}
 
 	user, err := a.userService.FindUserByEmail(data.Email)
 
 	if err != nil {
-		a.logger.Error("Error finding user", zap.Error(err))
-		http.Error(w, "Authentication failed", http.StatusUnauthorized)
-		return
+		return apperror.Unauthorized(loc.T("auth.failed"), fmt.Errorf("finding user: %w", err))
 	}
 
 	// Authenticate user
 	err = a.authService.VerifyPassword(user.Password, data.Password)
 
 	if err != nil {
-		a.logger.Error("Error authenticating user", zap.Error(err))
-		http.Error(w, "Authentication failed", http.StatusUnauthorized)
-		return
+		return apperror.Unauthorized(loc.T("auth.failed"), fmt.Errorf("verifying password: %w", err))
+	}
+
+	// the password is known now, upgrade its hash to the current algorithm
+	if a.authService.NeedsRehash(user.Password) {
+		if err := a.userService.UpdatePassword(user.ID, data.Password); err != nil {
+			a.logger.Warn("Error rehashing password", zap.Error(err))
+		}
+	}
+
+	return a.logIn(w, r, user, data.Remember, data.Next)
+}
+
+// logIn starts a session for a user who has proven who they are, remembering
+// the browser when asked, and sends browsers on to next.
+func (a *AuthHandler) logIn(w http.ResponseWriter, r *http.Request, user *users.UserModel, remember bool, next string) error {
+
+	if err := a.checkStatus(a.i18n.FromRequest(r), user); err != nil {
+		return err
+	}
+
+	// remember me only applies when the token goes in a cookie
+	var rememberID *uuid.UUID
+	if !web.WantsJSON(r) || a.tokenDelivery == TokenInCookie || a.tokenDelivery == TokenInBoth {
+		var err error
+		rememberID, err = a.remember(w, r, user, remember)
+		if err != nil {
+			return err
+		}
+	}
+
+	sessionID, err := a.startSession(r, user, rememberID)
+	if err != nil {
+		return err
 	}
 
 	// Generate JWT token
-	token, err := a.authService.GenerateToken(user)
+	token, err := a.authService.GenerateToken(user, sessionID)
 
 	if err != nil {
-		a.logger.Error("Error generating token", zap.Error(err))
-		http.Error(w, "Authentication failed", http.StatusUnauthorized)
-		return
+		return apperror.Internal(fmt.Errorf("generating token: %w", err))
 	}
 
-	// set the cookie
-	expiration := time.Now().Add(365 * 24 * time.Hour)
-	cookie := http.Cookie{Name: "token", Value: token, Expires: expiration, Path: "/"}
+	if !web.WantsJSON(r) {
+		if err := a.setSessionCookie(w, r, user, token, sessionID); err != nil {
+			return err
+		}
 
-	http.SetCookie(w, &cookie)
+		w.Header().Set("HX-Redirect", localPath(next))
+		w.WriteHeader(http.StatusOK)
 
-	w.Header().Set("HX-Redirect", "/")
-	w.WriteHeader(http.StatusOK)
+		return nil
+	}
+
+	res := loginResponse{
+		User:      users.NewUserResponse(user),
+		ExpiresAt: time.Now().Add(TokenTTL).UTC(),
+	}
+
+	if a.tokenDelivery == TokenInCookie || a.tokenDelivery == TokenInBoth {
+		if err := a.setSessionCookie(w, r, user, token, sessionID); err != nil {
+			return err
+		}
+	}
+
+	if a.tokenDelivery == TokenInBody || a.tokenDelivery == TokenInBoth {
+		res.Token = token
+		res.TokenType = "Bearer"
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+
+	return web.WriteJSON(w, http.StatusOK, res)
+}
+
+// checkStatus refuses users whose account status keeps them from logging in,
+// telling them why.
+func (a *AuthHandler) checkStatus(loc *i18n.Localizer, user *users.UserModel) error {
+	err := user.CheckStatus(time.Now())
+	if err == nil {
+		return nil
+	}
+
+	var message string
+	switch {
+	case errors.Is(err, users.ErrAccountPending):
+		message = loc.T("account.pending")
+	case errors.Is(err, users.ErrAccountSuspended):
+		message = loc.T("account.suspended")
+		if user.SuspendedUntil != nil {
+			message = loc.T("account.suspended_until", loc.Translator().FmtDateLong(user.SuspendedUntil.UTC()))
+		}
+		if user.SuspendedReason != "" {
+			message += " " + loc.T("account.reason", user.SuspendedReason)
+		}
+	case errors.Is(err, users.ErrAccountDeletionScheduled):
+		message = loc.T("account.deletion_scheduled")
+		if user.DeleteAt != nil {
+			message = loc.T("account.deletion_scheduled_on", loc.Translator().FmtDateLong(user.DeleteAt.UTC()))
+		}
+	}
+
+	return apperror.New(apperror.KindForbidden, message, err)
+}
+
+// localPath returns next when it is a path on this site, so logging in can't
+// redirect anywhere else, and / otherwise.
+func localPath(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return "/"
+	}
+	return next
+}
+
+// remember sets the remember-me cookie when the user asked for it,
+// returning the id of the remember token.
+func (a *AuthHandler) remember(w http.ResponseWriter, r *http.Request, user *users.UserModel, remember bool) (*uuid.UUID, error) {
+	if !remember || a.rememberService == nil {
+		return nil, nil
+	}
+
+	value, model, err := a.rememberService.Create(user.ID, r.UserAgent(), web.ClientIP(r))
+	if err != nil {
+		return nil, apperror.Internal(fmt.Errorf("remembering browser: %w", err))
+	}
+
+	setRememberCookie(w, r, value, a.rememberService.TTL())
+
+	return &model.ID, nil
+}
+
+// startSession records the login, returning the id of the session, or
+// uuid.Nil when sessions aren't recorded.
+func (a *AuthHandler) startSession(r *http.Request, user *users.UserModel, rememberID *uuid.UUID) (uuid.UUID, error) {
+	if a.sessionService == nil {
+		return uuid.Nil, nil
+	}
+
+	session, err := a.sessionService.Start(user.ID, rememberID, r.UserAgent(), web.ClientIP(r))
+	if err != nil {
+		return uuid.Nil, apperror.Internal(fmt.Errorf("starting session: %w", err))
+	}
+
+	return session.ID, nil
 }
 
-func handleValidationErrors(w http.ResponseWriter, err error) {
-	if _, ok := err.(*validator.InvalidValidationError); ok {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
+// setSessionCookie logs the browser in, with the token cookie or the
+// server-side session depending on the session mode.
+func (a *AuthHandler) setSessionCookie(w http.ResponseWriter, r *http.Request, user *users.UserModel, token string, sessionID uuid.UUID) error {
+	if a.sessionMode == SessionServer {
+		if err := logInSession(r, user, sessionID); err != nil {
+			return apperror.Internal(fmt.Errorf("storing session: %w", err))
+		}
+		return nil
 	}
 
-	validationErrors, ok := err.(validator.ValidationErrors)
-	if !ok {
-		// Handle other error types
-		http.Error(w, "Validation error", http.StatusBadRequest)
-		return
+	setTokenCookie(w, r, token)
+	return nil
+}
+
+// SwitchOrg remembers orgID as the organization the browser's login acts
+// for: in the server-side session, or in a new token for the same login.
+// Bearer and API key clients send the organization with each request.
+func (a *AuthHandler) SwitchOrg(w http.ResponseWriter, r *http.Request, orgID uuid.UUID) error {
+	p, ok := identity.FromContext(r.Context())
+	if !ok || p.Method != identity.MethodCookie {
+		return nil
 	}
 
-	// Convert validationErrors to an array of error messages
-	errorMessages := make([]string, 0, len(validationErrors))
-	for _, err := range validationErrors {
-		errorMessages = append(errorMessages, fmt.Sprintf("%s is %s", err.Field(), err.Tag()))
+	if a.sessionMode == SessionServer {
+		s := session.FromContext(r.Context())
+		if s == nil {
+			return errNoSession
+		}
+		return s.Set(sessionOrgID, orgID.String())
 	}
 
-	// Return the array of error messages with status 401
-	w.Header().Set("Content-Type", "text/html charset=utf-8")
-	w.WriteHeader(http.StatusBadRequest)
+	user, err := a.userService.FindUserByID(p.UserID)
+	if err != nil {
+		return fmt.Errorf("finding user: %w", err)
+	}
 
-	errorMessagesHTML := ""
-	for _, errorMessage := range errorMessages {
-		errorMessagesHTML += fmt.Sprintf("<li>%s</li>", errorMessage)
+	_, token, err := a.authService.IssueTokenWith(user, TokenClaims{
+		SessionID:    p.SessionID,
+		OrgID:        orgID,
+		Impersonator: p.ImpersonatorID,
+	})
+	if err != nil {
+		return err
 	}
 
-	fmt.Fprintf(w, "<h1>Validation error</h1><ul>%s</ul>", errorMessagesHTML)
+	setTokenCookie(w, r, token)
+	return nil
+}
 
+// setTokenCookie sets the session cookie, which the browser drops when it
+// is closed. Remember me keeps it logged in beyond that.
+func setTokenCookie(w http.ResponseWriter, r *http.Request, token string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     "token",
+		Value:    token,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
 }
 
-func (a *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
+func setRememberCookie(w http.ResponseWriter, r *http.Request, value string, ttl time.Duration) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     RememberCookie,
+		Value:    value,
+		Path:     "/",
+		Expires:  time.Now().Add(ttl),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
 
-	email := r.FormValue("email")
-	password := r.FormValue("password")
-	data := registrationData{
-		Email:    email,
-		Password: password,
+func clearCookie(w http.ResponseWriter, name string) {
+	http.SetCookie(w, &http.Cookie{Name: name, Value: "", Path: "/", Expires: time.Unix(0, 0), MaxAge: -1})
+}
+
+func (a *AuthHandler) Register(w http.ResponseWriter, r *http.Request) error {
+
+	loc := a.i18n.FromRequest(r)
+
+	var data registrationData
+	if err := web.Decode(r, &data); err != nil {
+		return err
 	}
 
 	err := a.validate.Struct(&data)
 	if err != nil {
-		// Handle validation errors
-		handleValidationErrors(w, err)
-		return
+		err = web.ValidationError(loc, &data, err)
+	}
+
+	// report the password's problems along with the other fields
+	if data.Password != "" {
+		err = joinValidation(err, checkPassword(r.Context(), loc, a.passwordPolicy, a.userService, data.Password, data.Email, uuid.Nil))
+	}
+	if err != nil {
+		return err
 	}
 
-	_, err = a.userService.CreateUser(data.Email, data.Password)
+	invitation, err := a.checkRegistration(loc, data)
+	if err != nil {
+		return err
+	}
+
+	user, err := a.userService.CreateUser(data.Email, data.Password)
+
+	if errors.Is(err, users.ErrEmailTaken) {
+		return apperror.Conflict(loc.T("register.failed"), err)
+	}
+
+	if errors.Is(err, password.ErrTooLong) {
+		return apperror.Validation(loc.T("error.validation.title"), []string{loc.T(password.RuleMaxLength, 72)})
+	}
 
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
+		return apperror.Internal(fmt.Errorf("creating user: %w", err))
+	}
+
+	if invitation != nil {
+		// the invitation was emailed, so the address is verified
+		if user.Status == users.StatusPending {
+			if err := a.userService.Activate(user.ID); err != nil {
+				return apperror.Internal(fmt.Errorf("activating invited user: %w", err))
+			}
+			user.Status = users.StatusActive
+		}
+		// the address is locked to the invitation, so it can't create a
+		// second account even if it was accepted in the meantime
+		if err := a.invitations.Accept(invitation.ID, user.ID); err != nil {
+			a.logger.Warn("Error accepting invitation", zap.Error(err))
+		}
+		if roles := invitation.RoleList(); len(roles) > 0 {
+			if err := a.userService.SetRoles(user.ID, append(user.RoleList(), roles...)); err != nil {
+				return apperror.Internal(fmt.Errorf("giving invited user roles: %w", err))
+			}
+		}
+	}
+
+	if web.WantsJSON(r) {
+		return web.WriteJSON(w, http.StatusCreated, registrationResponse{
+			User: users.NewUserResponse(user),
+		})
 	}
 
 	// return html
-	w.Header().Set("Content-Type", "text/html charset=utf-8")
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 	w.WriteHeader(http.StatusCreated)
 
-	fmt.Fprintf(w, "<h1>Registration successful</h1><p>Go to <a href=\"/login\">login</a></p>")
+	body := loc.T("register.success.body")
+	if user.Status == users.StatusPending {
+		body = loc.T("register.success.verify")
+	}
+	fmt.Fprintf(w, "<h1>%s</h1><p>%s</p>", loc.T("register.success.title"), body)
+
+	return nil
+}
+
+// checkRegistration checks that the registration mode lets the address
+// register, returning the invitation it registers with if any.
+func (a *AuthHandler) checkRegistration(loc *i18n.Localizer, data registrationData) (*InvitationModel, error) {
+	if a.registration == RegistrationClosed {
+		return nil, apperror.Forbidden(loc.T("register.closed"))
+	}
+
+	if data.Invite == "" {
+		if a.registration.allowsEmail(data.Email, a.domains) {
+			return nil, nil
+		}
+		if a.registration == RegistrationDomain {
+			appErr := apperror.Validation(loc.T("error.validation.title"), []string{loc.T("register.domain_not_allowed")})
+			appErr.Fields = map[string]string{"email": loc.T("register.domain_not_allowed")}
+			return nil, appErr
+		}
+		return nil, apperror.Forbidden(loc.T("register.invite_only"))
+	}
+
+	if a.invitations == nil {
+		return nil, apperror.Forbidden(loc.T("invite.invalid"))
+	}
+
+	invitation, err := a.invitations.Find(data.Invite)
+	if errors.Is(err, ErrInvalidInvitation) {
+		return nil, apperror.Forbidden(loc.T("invite.invalid"))
+	}
+	if err != nil {
+		return nil, apperror.Internal(fmt.Errorf("finding invitation: %w", err))
+	}
+
+	if !strings.EqualFold(invitation.Email, data.Email) {
+		appErr := apperror.Validation(loc.T("error.validation.title"), []string{loc.T("invite.email_mismatch")})
+		appErr.Fields = map[string]string{"email": loc.T("invite.email_mismatch")}
+		return nil, appErr
+	}
+
+	return invitation, nil
+}
+
+// RegisterPage renders the registration form. With an invitation's token the
+// email is filled in and can't be changed.
+func (a *AuthHandler) RegisterPage(w http.ResponseWriter, r *http.Request) error {
+	loc := a.i18n.FromRequest(r)
+
+	data := map[string]interface{}{
+		"Title":  "register.title",
+		"Closed": a.registration == RegistrationClosed,
+	}
+
+	if token := r.URL.Query().Get("invite"); token != "" && a.invitations != nil && a.registration != RegistrationClosed {
+		invitation, err := a.invitations.Find(token)
+		if err != nil && !errors.Is(err, ErrInvalidInvitation) {
+			return apperror.Internal(fmt.Errorf("finding invitation: %w", err))
+		}
+		if invitation != nil {
+			data["Invite"] = token
+			data["Email"] = invitation.Email
+		} else {
+			data["Error"] = loc.T("invite.invalid")
+		}
+	}
+
+	if data["Invite"] == nil && a.registration == RegistrationInvite {
+		data["Closed"] = true
+	}
+	if a.registration == RegistrationDomain {
+		data["Domains"] = strings.Join(a.domains, ", ")
+	}
+
+	w.Header().Set("Referrer-Policy", "no-referrer")
+
+	web.RenderTemplate(w, "register.html", data, r)
+
+	return nil
 }
 
-func (a *AuthHandler) Logout(w http.ResponseWriter, r *http.Request) {
+func (a *AuthHandler) Logout(w http.ResponseWriter, r *http.Request) error {
+
+	clearCookie(w, "token")
+	logOutSession(r)
 
-	// set the cookie
-	expiration := time.Now().Add(-365 * 24 * time.Hour)
-	cookie := http.Cookie{Name: "token", Value: "", Expires: expiration, Path: "/"}
+	if p, ok := identity.FromContext(r.Context()); ok && p.SessionID != uuid.Nil && a.sessionService != nil {
+		if err := a.sessionService.End(p.SessionID); err != nil {
+			a.logger.Warn("Error ending session", zap.Error(err))
+		}
+	}
 
-	http.SetCookie(w, &cookie)
+	if cookie, err := r.Cookie(RememberCookie); err == nil {
+		if a.rememberService != nil {
+			if err := a.rememberService.Forget(cookie.Value); err != nil {
+				a.logger.Warn("Error forgetting browser", zap.Error(err))
+			}
+		}
+		clearCookie(w, RememberCookie)
+	}
+
+	if web.WantsJSON(r) {
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
 
 	w.Header().Set("HX-Redirect", "/")
 	w.WriteHeader(http.StatusOK)
+
+	return nil
 }
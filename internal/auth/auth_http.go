@@ -1,21 +1,69 @@
 package auth
 
 import (
+	"net/http"
+
 	"github.com/go-chi/chi/v5"
+	"github.com/tomdoestech/goth/internal/pkg/openapi"
+	"github.com/tomdoestech/goth/internal/web"
 )
 
 type AuthHTTPParams struct {
-	AuthHandler *AuthHandler
-	Mux         *chi.Mux
+	AuthHandler  *AuthHandler
+	ErrorHandler *web.ErrorHandler
+	API          *openapi.Document
+	Mux          *chi.Mux
 }
 
+// formOrJSON are the body types the auth endpoints accept.
+var formOrJSON = []string{"application/json", "application/x-www-form-urlencoded"}
+
 func NewAuthHTTP(p AuthHTTPParams) {
 
-	r := p.Mux
+	r := openapi.NewRouter(p.Mux, p.API)
+	e := p.ErrorHandler
+
+	r.Post("/api/login", openapi.Operation{
+		Summary:      "Log in",
+		Description:  "Browsers get the token as a cookie and an HX-Redirect header. JSON clients get the user and, depending on API_TOKEN_DELIVERY, the token in the body, a cookie or both.",
+		Tags:         []string{"auth"},
+		Request:      loginData{},
+		RequestTypes: formOrJSON,
+		Responses: map[int]openapi.Response{
+			http.StatusOK: {Description: "Logged in", Body: loginResponse{}},
+		},
+		Errors: []int{http.StatusBadRequest, http.StatusUnauthorized},
+	}, e.Handle(p.AuthHandler.Login))
 
-	r.Post("/api/login", p.AuthHandler.Login)
+	r.Get("/register", openapi.Operation{
+		Summary: "Registration page",
+		Tags:    []string{"pages"},
+		Query: []openapi.Parameter{
+			{Name: "invite", Description: "The token of an invitation, which fills in the email"},
+		},
+		Responses: map[int]openapi.Response{
+			http.StatusOK: {Description: "The registration form", ContentType: "text/html"},
+		},
+	}, e.Handle(p.AuthHandler.RegisterPage))
 
-	r.Post("/api/register", p.AuthHandler.Register)
+	r.Post("/api/register", openapi.Operation{
+		Summary:      "Create an account",
+		Description:  "Who may register depends on REGISTRATION_MODE. An invitation's token lets its address register in every mode but closed.",
+		Tags:         []string{"auth"},
+		Request:      registrationData{},
+		RequestTypes: formOrJSON,
+		Responses: map[int]openapi.Response{
+			http.StatusCreated: {Description: "Account created", Body: registrationResponse{}},
+		},
+		Errors: []int{http.StatusBadRequest, http.StatusForbidden, http.StatusConflict},
+	}, e.Handle(p.AuthHandler.Register))
 
-	r.Post("/api/logout", p.AuthHandler.Logout)
+	r.Post("/api/logout", openapi.Operation{
+		Summary: "Log out",
+		Tags:    []string{"auth"},
+		Responses: map[int]openapi.Response{
+			http.StatusOK:        {Description: "Logged out, browsers are redirected home"},
+			http.StatusNoContent: {Description: "Logged out, for JSON clients"},
+		},
+	}, e.Handle(p.AuthHandler.Logout))
 }
@@ -4,6 +4,7 @@
 package auth
 
 import (
+	"encoding/json"
 	"io"
 	"log"
 	"net/http/httptest"
@@ -15,7 +16,10 @@ import (
 	"github.com/go-chi/jwtauth/v5"
 	"github.com/go-playground/validator/v10"
 	"github.com/stretchr/testify/assert"
+	"github.com/tomdoestech/goth/internal/pkg/i18n"
+	"github.com/tomdoestech/goth/internal/pkg/password"
 	users "github.com/tomdoestech/goth/internal/user"
+	"github.com/tomdoestech/goth/internal/web"
 	"go.uber.org/zap"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
@@ -57,7 +61,7 @@ func TestRegister(t *testing.T) {
 			description: "register - create user",
 			formData: url.Values{
 				"email":    {"test@example.com"},
-				"password": {"password"},
+				"password": {"tangerine-otter-89-lamp"},
 			},
 			expectedStatusCode:   201,
 			expectedResponseBody: "<h1>Registration successful</h1><p>Go to <a href=\"/login\">login</a></p>",
@@ -69,7 +73,7 @@ func TestRegister(t *testing.T) {
 				"password": {"password"},
 			},
 			expectedStatusCode:   400,
-			expectedResponseBody: "<h1>Validation error</h1><ul><li>Email is email</li></ul>",
+			expectedResponseBody: "<li>Email must be a valid email address</li>",
 		},
 		{
 			description: "register - invalid password",
@@ -78,7 +82,34 @@ func TestRegister(t *testing.T) {
 				"password": {"1"},
 			},
 			expectedStatusCode:   400,
-			expectedResponseBody: "<h1>Validation error</h1><ul><li>Password is min</li></ul>",
+			expectedResponseBody: "<li>Password must be at least 8 characters long</li>",
+		},
+		{
+			description: "register - common password",
+			formData: url.Values{
+				"email":    {"test@example.com"},
+				"password": {"password"},
+			},
+			expectedStatusCode:   400,
+			expectedResponseBody: "<li>Password is too easy to guess</li>",
+		},
+		{
+			description: "register - password containing the email",
+			formData: url.Values{
+				"email":    {"kingfisher@example.com"},
+				"password": {"kingfisher-otter-89-lamp"},
+			},
+			expectedStatusCode:   400,
+			expectedResponseBody: "<li>Password must not contain your email address</li>",
+		},
+		{
+			description: "register - long passphrase",
+			formData: url.Values{
+				"email":    {"test@example.com"},
+				"password": {"a passphrase well beyond the old limit of thirty two characters"},
+			},
+			expectedStatusCode:   201,
+			expectedResponseBody: "<h1>Registration successful</h1>",
 		},
 	}
 
@@ -104,6 +135,11 @@ func TestRegister(t *testing.T) {
 
 		validate = validator.New()
 
+		bundle, err := i18n.NewBundle(i18n.BundleParams{Validate: validate})
+		if err != nil {
+			t.Fatal(err)
+		}
+
 		usersService := users.NewUserService(users.UserServiceParams{
 			Logger:   logger,
 			Validate: validate,
@@ -120,10 +156,16 @@ func TestRegister(t *testing.T) {
 				AuthService: authService,
 				UserService: usersService,
 				Validate:    validate,
+				I18n:        bundle,
 				Logger:      logger,
 			},
 		)
 
+		errorHandler := web.NewErrorHandler(web.ErrorHandlerParams{
+			I18n:   bundle,
+			Logger: logger,
+		})
+
 		t.Run(tc.description, func(t *testing.T) {
 
 			assert := assert.New(t)
@@ -133,7 +175,7 @@ func TestRegister(t *testing.T) {
 			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 			w := httptest.NewRecorder()
 
-			authHandler.Register(w, req)
+			errorHandler.Handle(authHandler.Register)(w, req)
 
 			assert.Equal(tc.expectedStatusCode, w.Code)
 
@@ -145,7 +187,7 @@ func TestRegister(t *testing.T) {
 				t.Errorf("expected error to be nil got %v", err)
 			}
 
-			assert.Equal(tc.expectedResponseBody, string(data))
+			assert.Contains(string(data), tc.expectedResponseBody)
 		})
 	}
 
@@ -179,7 +221,7 @@ func TestLogin(t *testing.T) {
 			password:             "password",
 			inputPassword:        "wrongpassword",
 			expectedStatusCode:   401,
-			expectedResponseBody: "Authentication failed\n",
+			expectedResponseBody: "Authentication failed",
 			setup:                CreateUser,
 		},
 	}
@@ -208,6 +250,11 @@ func TestLogin(t *testing.T) {
 
 		validate = validator.New()
 
+		bundle, err := i18n.NewBundle(i18n.BundleParams{Validate: validate})
+		if err != nil {
+			t.Fatal(err)
+		}
+
 		usersService := users.NewUserService(users.UserServiceParams{
 			Logger:   logger,
 			Validate: validate,
@@ -224,10 +271,16 @@ func TestLogin(t *testing.T) {
 				AuthService: authService,
 				UserService: usersService,
 				Validate:    validate,
+				I18n:        bundle,
 				Logger:      logger,
 			},
 		)
 
+		errorHandler := web.NewErrorHandler(web.ErrorHandlerParams{
+			I18n:   bundle,
+			Logger: logger,
+		})
+
 		tc.setup(usersService, t, tc.email, tc.password)
 
 		t.Run(tc.description, func(t *testing.T) {
@@ -250,7 +303,7 @@ func TestLogin(t *testing.T) {
 			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 			w := httptest.NewRecorder()
 
-			authHandler.Login(w, req)
+			errorHandler.Handle(authHandler.Login)(w, req)
 
 			assert.Equal(tc.expectedStatusCode, w.Code)
 
@@ -263,7 +316,7 @@ func TestLogin(t *testing.T) {
 				t.Errorf("expected error to be nil got %v", err)
 			}
 
-			assert.Equal(tc.expectedResponseBody, string(body))
+			assert.Contains(string(body), tc.expectedResponseBody)
 
 			for _, header := range tc.expectedHeaders {
 				assert.Contains(headers, header)
@@ -272,3 +325,214 @@ func TestLogin(t *testing.T) {
 	}
 
 }
+
+func TestAuthJSON(t *testing.T) {
+
+	filename := TempFilename(t)
+
+	defer os.Remove(filename)
+
+	db, err := gorm.Open(sqlite.Open(filename), &gorm.Config{})
+	if err != nil {
+		panic("failed to connect database")
+	}
+
+	logger := zap.NewNop()
+
+	validate := validator.New()
+
+	bundle, err := i18n.NewBundle(i18n.BundleParams{Validate: validate})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	usersService := users.NewUserService(users.UserServiceParams{
+		Logger:   logger,
+		Validate: validate,
+		DB:       db,
+	})
+	authService := NewAuthService(AuthServiceParams{
+		Logger:    logger,
+		SecretKey: []byte("secret"),
+		TokenAuth: jwtauth.New("HS256", []byte("secret"), nil),
+	})
+
+	authHandler := NewAuthHandler(
+		AuthHandlerParams{
+			AuthService:   authService,
+			UserService:   usersService,
+			Validate:      validate,
+			I18n:          bundle,
+			TokenDelivery: TokenInBody,
+			Logger:        logger,
+		},
+	)
+
+	errorHandler := web.NewErrorHandler(web.ErrorHandlerParams{
+		I18n:   bundle,
+		Logger: logger,
+	})
+
+	testCases := []struct {
+		description        string
+		handler            web.HandlerFunc
+		body               string
+		expectedStatusCode int
+		expectedFields     []string
+		expectedCookie     bool
+	}{
+		{
+			description:        "register",
+			handler:            authHandler.Register,
+			body:               `{"email": "test@example.com", "password": "tangerine-otter-89-lamp"}`,
+			expectedStatusCode: 201,
+			expectedFields:     []string{"user"},
+		},
+		{
+			description:        "register - email taken",
+			handler:            authHandler.Register,
+			body:               `{"email": "test@example.com", "password": "tangerine-otter-89-lamp"}`,
+			expectedStatusCode: 409,
+			expectedFields:     []string{"detail"},
+		},
+		{
+			description:        "register - invalid",
+			handler:            authHandler.Register,
+			body:               `{"email": "test@example", "password": "1"}`,
+			expectedStatusCode: 400,
+			expectedFields:     []string{"errors", "fields"},
+		},
+		{
+			description:        "register - malformed",
+			handler:            authHandler.Register,
+			body:               `{"email": `,
+			expectedStatusCode: 400,
+		},
+		{
+			description:        "login",
+			handler:            authHandler.Login,
+			body:               `{"email": "test@example.com", "password": "tangerine-otter-89-lamp"}`,
+			expectedStatusCode: 200,
+			expectedFields:     []string{"user", "token", "token_type", "expires_at"},
+		},
+		{
+			description:        "login - invalid password",
+			handler:            authHandler.Login,
+			body:               `{"email": "test@example.com", "password": "wrongpassword"}`,
+			expectedStatusCode: 401,
+		},
+		{
+			description:        "logout",
+			handler:            authHandler.Logout,
+			expectedStatusCode: 204,
+			expectedCookie:     true,
+		},
+	}
+
+	for _, tc := range testCases {
+
+		t.Run(tc.description, func(t *testing.T) {
+
+			assert := assert.New(t)
+
+			req := httptest.NewRequest("POST", "/", strings.NewReader(tc.body))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+
+			errorHandler.Handle(tc.handler)(w, req)
+
+			assert.Equal(tc.expectedStatusCode, w.Code)
+			assert.Equal(tc.expectedCookie, w.Header().Get("Set-Cookie") != "")
+
+			if tc.expectedStatusCode == 204 {
+				return
+			}
+
+			assert.Contains(w.Header().Get("Content-Type"), "json")
+
+			var body map[string]interface{}
+			assert.NoError(json.Unmarshal(w.Body.Bytes(), &body))
+
+			for _, field := range tc.expectedFields {
+				assert.Contains(body, field)
+			}
+
+			if fields, ok := body["fields"].(map[string]interface{}); ok {
+				assert.Equal("email must be a valid email address", strings.ToLower(fields["email"].(string)))
+				assert.Contains(fields, "password")
+			}
+		})
+	}
+
+}
+
+func TestLoginRehash(t *testing.T) {
+	assert := assert.New(t)
+
+	filename := TempFilename(t)
+	defer os.Remove(filename)
+
+	db, err := gorm.Open(sqlite.Open(filename), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	validate := validator.New()
+	bundle, err := i18n.NewBundle(i18n.BundleParams{Validate: validate})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a user from before argon2id
+	legacy := users.NewUserService(users.UserServiceParams{
+		Logger:   zap.NewNop(),
+		Validate: validate,
+		DB:       db,
+		Hasher:   password.NewBcrypt(0),
+	})
+	CreateUser(legacy, t, "test@example.com", "password")
+
+	hasher := password.NewChain(password.NewArgon2id(password.Argon2idParams{Memory: 64, Time: 1, Parallelism: 1}), password.NewBcrypt(0))
+	usersService := users.NewUserService(users.UserServiceParams{
+		Logger:   zap.NewNop(),
+		Validate: validate,
+		DB:       db,
+		Hasher:   hasher,
+	})
+	authHandler := NewAuthHandler(AuthHandlerParams{
+		AuthService: NewAuthService(AuthServiceParams{
+			Logger:    zap.NewNop(),
+			TokenAuth: jwtauth.New("HS256", []byte("secret"), nil),
+			Hasher:    hasher,
+		}),
+		UserService: usersService,
+		Validate:    validate,
+		I18n:        bundle,
+		Logger:      zap.NewNop(),
+	})
+	errorHandler := web.NewErrorHandler(web.ErrorHandlerParams{I18n: bundle, Logger: zap.NewNop()})
+
+	login := func(pass string) int {
+		form := url.Values{"email": {"test@example.com"}, "password": {pass}}
+		req := httptest.NewRequest("POST", "/login", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		errorHandler.Handle(authHandler.Login)(w, req)
+		return w.Code
+	}
+	stored := func() string {
+		user, err := usersService.FindUserByEmail("test@example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return user.Password
+	}
+
+	assert.Equal(401, login("wrongpassword"))
+	assert.True(strings.HasPrefix(stored(), "$2a$"), "failed logins don't rehash")
+
+	assert.Equal(200, login("password"))
+	assert.True(strings.HasPrefix(stored(), "$argon2id$"), "the bcrypt hash is replaced")
+
+	assert.Equal(200, login("password"), "the new hash verifies")
+}
@@ -0,0 +1,239 @@
+package auth
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/google/uuid"
+	"github.com/tomdoestech/goth/internal/pkg/apperror"
+	"github.com/tomdoestech/goth/internal/pkg/audit"
+	"github.com/tomdoestech/goth/internal/pkg/i18n"
+	"github.com/tomdoestech/goth/internal/pkg/identity"
+	"github.com/tomdoestech/goth/internal/pkg/session"
+	users "github.com/tomdoestech/goth/internal/user"
+	"github.com/tomdoestech/goth/internal/web"
+	"go.uber.org/zap"
+)
+
+// ImpersonateHandler lets admins act as another user to see what they see.
+// The login keeps both: the token's act claim, or the server-side session,
+// names the admin.
+type ImpersonateHandler struct {
+	authService *AuthService
+	userService *users.UserService
+	audit       *audit.Trail
+	sessionMode SessionMode
+	validate    *validator.Validate
+	i18n        *i18n.Bundle
+	logger      *zap.Logger
+}
+
+type ImpersonateHandlerParams struct {
+	AuthService *AuthService
+	UserService *users.UserService
+	// Audit records when impersonation starts and stops
+	Audit *audit.Trail
+	// SessionMode defaults to SessionJWT, use the same one as the auth
+	// handler
+	SessionMode SessionMode
+	Validate    *validator.Validate
+	I18n        *i18n.Bundle
+	Logger      *zap.Logger
+}
+
+type impersonateData struct {
+	Email string `json:"email" validate:"required,email"`
+}
+
+func NewImpersonateHandler(p ImpersonateHandlerParams) *ImpersonateHandler {
+	if p.SessionMode == "" {
+		p.SessionMode = SessionJWT
+	}
+
+	return &ImpersonateHandler{
+		authService: p.AuthService,
+		userService: p.UserService,
+		audit:       p.Audit,
+		sessionMode: p.SessionMode,
+		validate:    p.Validate,
+		i18n:        p.I18n,
+		logger:      p.Logger,
+	}
+}
+
+// principal returns the logged in user. Impersonation happens in a browser
+// only, it swaps the login in the cookie.
+func (h *ImpersonateHandler) principal(r *http.Request) (*identity.Principal, error) {
+	loc := h.i18n.FromRequest(r)
+
+	p, ok := identity.FromContext(r.Context())
+	if !ok {
+		return nil, apperror.Unauthorized(loc.T("error.unauthorized.message"), nil)
+	}
+	if p.Method != identity.MethodCookie {
+		return nil, apperror.Forbidden(loc.T("impersonate.browser_only"))
+	}
+	return p, nil
+}
+
+// admin returns the logged in admin, who isn't impersonating anyone already.
+func (h *ImpersonateHandler) admin(r *http.Request) (*identity.Principal, *users.UserModel, error) {
+	p, err := h.principal(r)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	loc := h.i18n.FromRequest(r)
+
+	if p.Impersonated() {
+		return nil, nil, apperror.Forbidden(loc.T("impersonate.blocked"))
+	}
+
+	user, err := h.userService.FindUserByID(p.UserID)
+	if err != nil {
+		return nil, nil, apperror.Unauthorized(loc.T("error.unauthorized.message"), fmt.Errorf("finding user: %w", err))
+	}
+	if !user.HasRole(users.RoleAdmin) {
+		return nil, nil, apperror.Forbidden(loc.T("impersonate.admin_only"))
+	}
+
+	return p, user, nil
+}
+
+func (h *ImpersonateHandler) Page(w http.ResponseWriter, r *http.Request) error {
+	if _, _, err := h.admin(r); err != nil {
+		return err
+	}
+
+	web.RenderTemplate(w, "impersonate.html", map[string]interface{}{
+		"Title": "impersonate.title",
+	}, r)
+
+	return nil
+}
+
+// Start logs the admin in as the user with the email, until they stop.
+// Other admins can't be impersonated.
+func (h *ImpersonateHandler) Start(w http.ResponseWriter, r *http.Request) error {
+	p, admin, err := h.admin(r)
+	if err != nil {
+		return err
+	}
+
+	loc := h.i18n.FromRequest(r)
+
+	var data impersonateData
+	if err := web.Decode(r, &data); err != nil {
+		return err
+	}
+
+	if err := h.validate.Struct(&data); err != nil {
+		return web.ValidationError(loc, &data, err)
+	}
+
+	target, err := h.userService.FindUserByEmail(data.Email)
+	if err != nil {
+		return apperror.NotFound(loc.T("impersonate.not_found"))
+	}
+	if target.ID == admin.ID || target.HasRole(users.RoleAdmin) {
+		return apperror.Forbidden(loc.T("impersonate.not_allowed"))
+	}
+	if err := h.userService.StatusError(r.Context(), target.ID); err != nil {
+		return apperror.Forbidden(loc.T("impersonate.target_blocked"))
+	}
+
+	if err := h.switchUser(w, r, target, p.SessionID, admin.ID); err != nil {
+		return apperror.Internal(fmt.Errorf("starting impersonation: %w", err))
+	}
+
+	h.record(r, audit.ActionImpersonationStart, admin.ID, target.ID)
+
+	if web.WantsJSON(r) {
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+
+	w.Header().Set("HX-Redirect", "/")
+	w.WriteHeader(http.StatusOK)
+
+	return nil
+}
+
+// Stop logs the admin back in as themselves.
+func (h *ImpersonateHandler) Stop(w http.ResponseWriter, r *http.Request) error {
+	p, err := h.principal(r)
+	if err != nil {
+		return err
+	}
+
+	loc := h.i18n.FromRequest(r)
+
+	if !p.Impersonated() {
+		return apperror.Validation(loc.T("impersonate.not_active"), nil)
+	}
+
+	admin, err := h.userService.FindUserByID(p.ImpersonatorID)
+	if err != nil {
+		return apperror.Unauthorized(loc.T("error.unauthorized.message"), fmt.Errorf("finding impersonator: %w", err))
+	}
+
+	if err := h.switchUser(w, r, admin, p.SessionID, uuid.Nil); err != nil {
+		return apperror.Internal(fmt.Errorf("stopping impersonation: %w", err))
+	}
+
+	h.record(r, audit.ActionImpersonationStop, admin.ID, p.UserID)
+
+	if web.WantsJSON(r) {
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+
+	w.Header().Set("HX-Redirect", "/admin/impersonate")
+	w.WriteHeader(http.StatusOK)
+
+	return nil
+}
+
+// switchUser logs the browser in as user, within the same login session so
+// revoking it ends the impersonation too.
+func (h *ImpersonateHandler) switchUser(w http.ResponseWriter, r *http.Request, user *users.UserModel, sessionID uuid.UUID, impersonator uuid.UUID) error {
+	if h.sessionMode == SessionServer {
+		s := session.FromContext(r.Context())
+		if s == nil {
+			return errNoSession
+		}
+		if err := logInSession(r, user, sessionID); err != nil {
+			return err
+		}
+		if impersonator == uuid.Nil {
+			return nil
+		}
+		return s.Set(sessionImpersonator, impersonator.String())
+	}
+
+	_, token, err := h.authService.IssueTokenWith(user, TokenClaims{SessionID: sessionID, Impersonator: impersonator})
+	if err != nil {
+		return err
+	}
+
+	setTokenCookie(w, r, token)
+	return nil
+}
+
+func (h *ImpersonateHandler) record(r *http.Request, action string, actorID, subjectID uuid.UUID) {
+	if h.audit == nil {
+		return
+	}
+
+	err := h.audit.Record(audit.Event{
+		Action:    action,
+		ActorID:   actorID,
+		SubjectID: subjectID,
+		IP:        web.ClientIP(r),
+		UserAgent: r.UserAgent(),
+	})
+	if err != nil {
+		h.logger.Error("Error recording audit event", zap.Error(err))
+	}
+}
@@ -0,0 +1,58 @@
+package auth
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/tomdoestech/goth/internal/pkg/openapi"
+	"github.com/tomdoestech/goth/internal/web"
+)
+
+type ImpersonateHTTPParams struct {
+	ImpersonateHandler *ImpersonateHandler
+	ErrorHandler       *web.ErrorHandler
+	API                *openapi.Document
+	Mux                *chi.Mux
+}
+
+func NewImpersonateHTTP(p ImpersonateHTTPParams) {
+
+	r := openapi.NewRouter(p.Mux, p.API)
+	e := p.ErrorHandler
+
+	r.Get("/admin/impersonate", openapi.Operation{
+		Summary: "Impersonation page",
+		Tags:    []string{"admin"},
+		Responses: map[int]openapi.Response{
+			http.StatusOK: {Description: "A form to act as another user", ContentType: "text/html"},
+		},
+		Errors: []int{http.StatusUnauthorized, http.StatusForbidden},
+		Auth:   true,
+	}, e.Handle(p.ImpersonateHandler.Page))
+
+	r.Post("/api/impersonate", openapi.Operation{
+		Summary:      "Start impersonating",
+		Description:  "Logs the admin in as the user, in the browser's cookie. The token names the admin in its act claim. Changing the password or creating API keys is refused until they stop. Recorded in the audit trail.",
+		Tags:         []string{"admin"},
+		Request:      impersonateData{},
+		RequestTypes: formOrJSON,
+		Responses: map[int]openapi.Response{
+			http.StatusOK:        {Description: "Impersonating, htmx is redirected home", ContentType: "text/html"},
+			http.StatusNoContent: {Description: "Impersonating, for JSON clients"},
+		},
+		Errors: []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound},
+		Auth:   true,
+	}, e.Handle(p.ImpersonateHandler.Start))
+
+	r.Post("/api/impersonate/stop", openapi.Operation{
+		Summary:     "Stop impersonating",
+		Description: "Logs the admin back in as themselves. Recorded in the audit trail.",
+		Tags:        []string{"admin"},
+		Responses: map[int]openapi.Response{
+			http.StatusOK:        {Description: "Stopped, htmx is redirected to the impersonation page", ContentType: "text/html"},
+			http.StatusNoContent: {Description: "Stopped, for JSON clients"},
+		},
+		Errors: []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden},
+		Auth:   true,
+	}, e.Handle(p.ImpersonateHandler.Stop))
+}
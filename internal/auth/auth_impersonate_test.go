@@ -0,0 +1,381 @@
+//go:build unit
+// +build unit
+
+package auth
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/jwtauth/v5"
+	"github.com/go-playground/validator/v10"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/tomdoestech/goth/internal/pkg/audit"
+	"github.com/tomdoestech/goth/internal/pkg/i18n"
+	"github.com/tomdoestech/goth/internal/pkg/identity"
+	"github.com/tomdoestech/goth/internal/pkg/openapi"
+	"github.com/tomdoestech/goth/internal/pkg/session"
+	users "github.com/tomdoestech/goth/internal/user"
+	"github.com/tomdoestech/goth/internal/web"
+	"go.uber.org/zap"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestImpersonation(t *testing.T) {
+	filename := TempFilename(t)
+	defer os.Remove(filename)
+
+	db, err := gorm.Open(sqlite.Open(filename), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	validate := validator.New()
+	bundle, err := i18n.NewBundle(i18n.BundleParams{Validate: validate})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	usersService := users.NewUserService(users.UserServiceParams{
+		Logger:      zap.NewNop(),
+		Validate:    validate,
+		DB:          db,
+		AdminEmails: []string{"admin@example.com", "other-admin@example.com"},
+	})
+	CreateUser(usersService, t, "admin@example.com", "tangerine-otter-89-lamp")
+	CreateUser(usersService, t, "other-admin@example.com", "tangerine-otter-89-lamp")
+	CreateUser(usersService, t, "member@example.com", "tangerine-otter-89-lamp")
+	admin, _ := usersService.FindUserByEmail("admin@example.com")
+	member, _ := usersService.FindUserByEmail("member@example.com")
+
+	tokenAuth := jwtauth.New("HS256", []byte("secret"), nil)
+	authService := NewAuthService(AuthServiceParams{Logger: zap.NewNop(), TokenAuth: tokenAuth})
+	trail := audit.New(audit.Params{DB: db, Logger: zap.NewNop()})
+	errorHandler := web.NewErrorHandler(web.ErrorHandlerParams{I18n: bundle, Logger: zap.NewNop()})
+
+	mux := chi.NewMux()
+	NewImpersonateHTTP(ImpersonateHTTPParams{
+		ImpersonateHandler: NewImpersonateHandler(ImpersonateHandlerParams{
+			AuthService: authService,
+			UserService: usersService,
+			Audit:       trail,
+			Validate:    validate,
+			I18n:        bundle,
+			Logger:      zap.NewNop(),
+		}),
+		ErrorHandler: errorHandler,
+		API:          openapi.New(openapi.Params{Title: "goth", Version: "1.0.0"}),
+		Mux:          mux,
+	})
+	api := openapi.New(openapi.Params{Title: "goth", Version: "1.0.0"})
+	NewSessionHTTP(SessionHTTPParams{
+		SessionHandler: NewSessionHandler(SessionHandlerParams{I18n: bundle, Logger: zap.NewNop()}),
+		ErrorHandler:   errorHandler,
+		API:            api,
+		Mux:            mux,
+	})
+	NewRememberHTTP(RememberHTTPParams{
+		RememberHandler: NewRememberHandler(RememberHandlerParams{I18n: bundle, Logger: zap.NewNop()}),
+		ErrorHandler:    errorHandler,
+		API:             api,
+		Mux:             mux,
+	})
+	mux.Post("/api/account/password", errorHandler.Handle(NewPasswordHandler(PasswordHandlerParams{
+		AuthService: authService,
+		UserService: usersService,
+		Validate:    validate,
+		I18n:        bundle,
+		Logger:      zap.NewNop(),
+	}).Change))
+	mux.Get("/whoami", func(w http.ResponseWriter, r *http.Request) {
+		p, ok := identity.FromContext(r.Context())
+		if !ok {
+			fmt.Fprint(w, "anonymous")
+			return
+		}
+		fmt.Fprintf(w, "%s %s", p.UserID, p.ImpersonatorID)
+	})
+	mux.Get("/", func(w http.ResponseWriter, r *http.Request) {
+		web.RenderTemplate(w, "about.html", map[string]interface{}{"Title": "about.title"}, r)
+	})
+
+	fromCookie := func(r *http.Request) string {
+		if cookie, err := r.Cookie("token"); err == nil {
+			return cookie.Value
+		}
+		return ""
+	}
+
+	handler := jwtauth.Verify(tokenAuth, fromCookie)(identity.Middleware(identity.MiddlewareParams{
+		Status:       usersService.StatusError,
+		Impersonator: usersService.ImpersonatorError,
+		Unauthorized: errorHandler.Render,
+		Logger:       zap.NewNop(),
+	})(bundle.Middleware(mux)))
+
+	login := func(user *users.UserModel) string {
+		token, err := authService.GenerateToken(user, uuid.Nil)
+		assert.NoError(t, err)
+		return token
+	}
+
+	do := func(token, method, path string, form url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.AddCookie(&http.Cookie{Name: "token", Value: token})
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	tokenCookie := func(w *httptest.ResponseRecorder) string {
+		for _, c := range w.Result().Cookies() {
+			if c.Name == "token" {
+				return c.Value
+			}
+		}
+		return ""
+	}
+
+	adminToken := login(admin)
+
+	w := do(login(member), "POST", "/api/impersonate", url.Values{"email": {"admin@example.com"}})
+	assert.Equal(t, http.StatusForbidden, w.Code, "only admins impersonate")
+	w = do(adminToken, "POST", "/api/impersonate", url.Values{"email": {"other-admin@example.com"}})
+	assert.Equal(t, http.StatusForbidden, w.Code, "admins can't be impersonated")
+	w = do(adminToken, "POST", "/api/impersonate", url.Values{"email": {"nobody@example.com"}})
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = do(adminToken, "POST", "/api/impersonate", url.Values{"email": {"member@example.com"}})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "/", w.Header().Get("HX-Redirect"))
+	impersonating := tokenCookie(w)
+
+	w = do(impersonating, "GET", "/whoami", nil)
+	assert.Equal(t, member.ID.String()+" "+admin.ID.String(), w.Body.String())
+
+	w = do(impersonating, "GET", "/", nil)
+	assert.Contains(t, w.Body.String(), "You are impersonating member@example.com.")
+	assert.Contains(t, w.Body.String(), `hx-post="/api/impersonate/stop"`)
+
+	w = do(impersonating, "POST", "/api/account/password", url.Values{"current_password": {"tangerine-otter-89-lamp"}, "password": {"velvet-harbor-42-moss"}})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Contains(t, w.Body.String(), "This isn’t possible while impersonating a user.")
+
+	for _, path := range []string{"/api/sessions/", "/api/devices/"} {
+		w = do(impersonating, "DELETE", path+uuid.NewString(), nil)
+		assert.Equal(t, http.StatusForbidden, w.Code, path)
+		assert.Contains(t, w.Body.String(), "This isn’t possible while impersonating a user.")
+	}
+
+	w = do(impersonating, "POST", "/api/impersonate", url.Values{"email": {"member@example.com"}})
+	assert.Equal(t, http.StatusForbidden, w.Code, "no impersonating from an impersonation")
+
+	w = do(impersonating, "POST", "/api/impersonate/stop", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	stopped := tokenCookie(w)
+
+	w = do(stopped, "GET", "/whoami", nil)
+	assert.Equal(t, admin.ID.String()+" 00000000-0000-0000-0000-000000000000", w.Body.String())
+
+	w = do(stopped, "GET", "/", nil)
+	assert.NotContains(t, w.Body.String(), "impersonate/stop")
+
+	w = do(stopped, "POST", "/api/impersonate/stop", nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	events, err := trail.List(member.ID, 10)
+	if assert.NoError(t, err) && assert.Len(t, events, 2) {
+		assert.Equal(t, audit.ActionImpersonationStop, events[0].Action)
+		assert.Equal(t, audit.ActionImpersonationStart, events[1].Action)
+		assert.Equal(t, admin.ID, events[1].ActorID)
+		assert.Equal(t, member.ID, events[1].SubjectID)
+	}
+
+	t.Run("blocked target", func(t *testing.T) {
+		CreateUser(usersService, t, "blocked@example.com", "tangerine-otter-89-lamp")
+		blocked, _ := usersService.FindUserByEmail("blocked@example.com")
+		assert.NoError(t, usersService.Suspend(blocked.ID, "spam", nil))
+
+		w := do(adminToken, "POST", "/api/impersonate", url.Values{"email": {"blocked@example.com"}})
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Empty(t, tokenCookie(w))
+	})
+
+	t.Run("demoted admin", func(t *testing.T) {
+		w := do(adminToken, "POST", "/api/impersonate", url.Values{"email": {"member@example.com"}})
+		assert.Equal(t, http.StatusOK, w.Code)
+		impersonating := tokenCookie(w)
+
+		assert.NoError(t, usersService.SetRoles(admin.ID, nil))
+		defer usersService.SetRoles(admin.ID, []string{users.RoleAdmin})
+
+		w = do(impersonating, "GET", "/whoami", nil)
+		assert.Equal(t, "anonymous", w.Body.String())
+		assert.Equal(t, "", tokenCookie(w), "the cookie is cleared")
+	})
+
+	t.Run("suspended admin", func(t *testing.T) {
+		w := do(adminToken, "POST", "/api/impersonate", url.Values{"email": {"member@example.com"}})
+		assert.Equal(t, http.StatusOK, w.Code)
+		impersonating := tokenCookie(w)
+
+		assert.NoError(t, usersService.Suspend(admin.ID, "", nil))
+		defer usersService.Activate(admin.ID)
+
+		w = do(impersonating, "GET", "/whoami", nil)
+		assert.Equal(t, "anonymous", w.Body.String())
+	})
+}
+
+func TestImpersonationOIDC(t *testing.T) {
+	s, user, _ := newTestOIDC(t)
+
+	client, _, err := s.RegisterClient("Wiki", []string{"https://wiki.example.com/callback"}, true)
+	assert.NoError(t, err)
+
+	bundle, err := i18n.NewBundle(i18n.BundleParams{Validate: validator.New()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := NewOIDCHandler(OIDCHandlerParams{OIDCService: s, I18n: bundle, Logger: zap.NewNop()})
+	errorHandler := web.NewErrorHandler(web.ErrorHandlerParams{I18n: bundle, Logger: zap.NewNop()})
+
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {client.ID},
+		"redirect_uri":          {"https://wiki.example.com/callback"},
+		"scope":                 {"openid email"},
+		"state":                 {"xyz"},
+		"code_challenge":        {codeChallenge("verifier")},
+		"code_challenge_method": {"S256"},
+		"decision":              {"allow"},
+	}
+	impersonated := &identity.Principal{UserID: user.ID, Method: identity.MethodCookie, ImpersonatorID: uuid.New()}
+
+	// even with the consent given by the user themselves
+	assert.NoError(t, s.SaveConsent(user.ID, client.ID, []string{"openid", "email"}))
+
+	req := httptest.NewRequest("GET", "/oauth/authorize?"+query.Encode(), nil)
+	req = req.WithContext(identity.NewContext(req.Context(), impersonated))
+	w := httptest.NewRecorder()
+	errorHandler.Handle(h.Authorize)(w, req)
+	assert.Equal(t, http.StatusFound, w.Code)
+	assert.Contains(t, w.Header().Get("Location"), "error=login_required")
+	assert.NotContains(t, w.Header().Get("Location"), "code=")
+
+	req = httptest.NewRequest("POST", "/oauth/authorize", strings.NewReader(query.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("HX-Request", "true")
+	req = req.WithContext(identity.NewContext(req.Context(), impersonated))
+	w = httptest.NewRecorder()
+	errorHandler.Handle(h.Consent)(w, req)
+	assert.Contains(t, w.Header().Get("HX-Redirect"), "error=access_denied")
+	assert.NotContains(t, w.Header().Get("HX-Redirect"), "code=")
+}
+
+func TestImpersonationServerSessions(t *testing.T) {
+	sessions, _ := newTestSessions(t)
+
+	validate := validator.New()
+	bundle, err := i18n.NewBundle(i18n.BundleParams{Validate: validate})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	usersService := users.NewUserService(users.UserServiceParams{
+		Logger:      zap.NewNop(),
+		Validate:    validate,
+		DB:          sessions.db,
+		AdminEmails: []string{"admin@example.com"},
+	})
+	CreateUser(usersService, t, "admin@example.com", "tangerine-otter-89-lamp")
+	CreateUser(usersService, t, "member@example.com", "tangerine-otter-89-lamp")
+	CreateUser(usersService, t, "other@example.com", "tangerine-otter-89-lamp")
+
+	authService := NewAuthService(AuthServiceParams{Logger: zap.NewNop(), TokenAuth: jwtauth.New("HS256", []byte("secret"), nil)})
+	authHandler := NewAuthHandler(AuthHandlerParams{
+		AuthService:    authService,
+		UserService:    usersService,
+		SessionService: sessions,
+		Validate:       validate,
+		I18n:           bundle,
+		SessionMode:    SessionServer,
+		Logger:         zap.NewNop(),
+	})
+	errorHandler := web.NewErrorHandler(web.ErrorHandlerParams{I18n: bundle, Logger: zap.NewNop()})
+
+	mux := chi.NewMux()
+	NewImpersonateHTTP(ImpersonateHTTPParams{
+		ImpersonateHandler: NewImpersonateHandler(ImpersonateHandlerParams{
+			AuthService: authService,
+			UserService: usersService,
+			Audit:       audit.New(audit.Params{DB: sessions.db, Logger: zap.NewNop()}),
+			SessionMode: SessionServer,
+			Validate:    validate,
+			I18n:        bundle,
+			Logger:      zap.NewNop(),
+		}),
+		ErrorHandler: errorHandler,
+		API:          openapi.New(openapi.Params{Title: "goth", Version: "1.0.0"}),
+		Mux:          mux,
+	})
+	mux.Post("/login", errorHandler.Handle(authHandler.Login))
+	mux.Get("/whoami", func(w http.ResponseWriter, r *http.Request) {
+		p, _ := identity.FromContext(r.Context())
+		fmt.Fprintf(w, "%s %s %s", p.Email, p.ImpersonatorID, p.OrgID)
+	})
+
+	handler := session.New(session.Params{Store: session.NewMemoryStore()}).Middleware(
+		identity.Middleware(identity.MiddlewareParams{
+			Sessions:     SessionPrincipal,
+			Unauthorized: errorHandler.Render,
+			Logger:       zap.NewNop(),
+		})(bundle.Middleware(mux)),
+	)
+
+	id := ""
+	do := func(method, path string, form url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("HX-Request", "true")
+		if id != "" {
+			req.AddCookie(&http.Cookie{Name: "session", Value: id})
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		for _, c := range w.Result().Cookies() {
+			if c.Name == "session" {
+				id = c.Value
+			}
+		}
+		return w
+	}
+	logIn := func(email string) {
+		w := do("POST", "/login", url.Values{"email": {email}, "password": {"tangerine-otter-89-lamp"}})
+		assert.Equal(t, http.StatusOK, w.Code)
+	}
+
+	logIn("admin@example.com")
+	w := do("POST", "/api/impersonate", url.Values{"email": {"member@example.com"}})
+	assert.Equal(t, http.StatusOK, w.Code)
+	admin, _ := usersService.FindUserByEmail("admin@example.com")
+	assert.Equal(t, "member@example.com "+admin.ID.String()+" "+uuid.Nil.String(), do("GET", "/whoami", nil).Body.String())
+
+	// logging in as someone else in the same browser ends the impersonation
+	logIn("other@example.com")
+	assert.Equal(t, "other@example.com "+uuid.Nil.String()+" "+uuid.Nil.String(), do("GET", "/whoami", nil).Body.String())
+
+	w = do("POST", "/api/impersonate/stop", nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code, "nothing to stop, the admin isn't logged back in")
+	assert.Contains(t, do("GET", "/whoami", nil).Body.String(), "other@example.com")
+}
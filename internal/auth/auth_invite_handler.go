@@ -0,0 +1,278 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-playground/validator/v10"
+	"github.com/google/uuid"
+	"github.com/tomdoestech/goth/internal/pkg/apperror"
+	"github.com/tomdoestech/goth/internal/pkg/i18n"
+	"github.com/tomdoestech/goth/internal/pkg/identity"
+	"github.com/tomdoestech/goth/internal/pkg/mail"
+	users "github.com/tomdoestech/goth/internal/user"
+	"github.com/tomdoestech/goth/internal/web"
+	"go.uber.org/zap"
+)
+
+// InviteHandler lets users invite others to register. Admins may give the
+// invited accounts roles, and see and revoke everyone's invitations.
+type InviteHandler struct {
+	invitations *InvitationService
+	userService *users.UserService
+	mailer      mail.Sender
+	baseURL     string
+	mode        RegistrationMode
+	validate    *validator.Validate
+	i18n        *i18n.Bundle
+	logger      *zap.Logger
+}
+
+type InviteHandlerParams struct {
+	InvitationService *InvitationService
+	UserService       *users.UserService
+	// Mailer sends the invitations, without one the link is only shown to
+	// the inviter
+	Mailer mail.Sender
+	// BaseURL is the public URL of the app, the start of invitation links
+	BaseURL string
+	// RegistrationMode defaults to RegistrationOpen, nobody can be invited
+	// when it is closed
+	RegistrationMode RegistrationMode
+	Validate         *validator.Validate
+	I18n             *i18n.Bundle
+	Logger           *zap.Logger
+}
+
+type inviteData struct {
+	Email string `json:"email" validate:"required,email"`
+	// Roles are given to the new account, only admins may set them
+	Roles []string `json:"roles" validate:"dive,required"`
+}
+
+type inviteResponse struct {
+	// Link is only ever returned here
+	Link       string             `json:"link"`
+	Invitation InvitationResponse `json:"invitation"`
+}
+
+func NewInviteHandler(p InviteHandlerParams) *InviteHandler {
+	if p.RegistrationMode == "" {
+		p.RegistrationMode = RegistrationOpen
+	}
+
+	return &InviteHandler{
+		invitations: p.InvitationService,
+		userService: p.UserService,
+		mailer:      p.Mailer,
+		baseURL:     strings.TrimSuffix(p.BaseURL, "/"),
+		mode:        p.RegistrationMode,
+		validate:    p.Validate,
+		i18n:        p.I18n,
+		logger:      p.Logger,
+	}
+}
+
+// inviter returns the logged in user and whether they are an admin.
+// Invitations are managed with a session only.
+func (h *InviteHandler) inviter(r *http.Request) (*users.UserModel, bool, error) {
+	loc := h.i18n.FromRequest(r)
+
+	p, ok := identity.FromContext(r.Context())
+	if !ok {
+		return nil, false, apperror.Unauthorized(loc.T("error.unauthorized.message"), nil)
+	}
+	if p.Method == identity.MethodAPIKey {
+		return nil, false, apperror.Forbidden(loc.T("invite.session_required"))
+	}
+
+	user, err := h.userService.FindUserByID(p.UserID)
+	if err != nil {
+		return nil, false, apperror.Unauthorized(loc.T("error.unauthorized.message"), fmt.Errorf("finding user: %w", err))
+	}
+
+	return user, user.HasRole(users.RoleAdmin), nil
+}
+
+// inviteScope is whose invitations the user manages, uuid.Nil for everyone's.
+func inviteScope(user *users.UserModel, admin bool) uuid.UUID {
+	if admin {
+		return uuid.Nil
+	}
+	return user.ID
+}
+
+// Page renders the invitations section of the account settings.
+func (h *InviteHandler) Page(w http.ResponseWriter, r *http.Request) error {
+	user, admin, err := h.inviter(r)
+	if err != nil {
+		return err
+	}
+
+	invitations, err := h.invitations.List(inviteScope(user, admin))
+	if err != nil {
+		return apperror.Internal(fmt.Errorf("listing invitations: %w", err))
+	}
+
+	web.RenderTemplate(w, "invites.html", h.pageData(invitations, admin, ""), r)
+
+	return nil
+}
+
+func (h *InviteHandler) List(w http.ResponseWriter, r *http.Request) error {
+	user, admin, err := h.inviter(r)
+	if err != nil {
+		return err
+	}
+
+	invitations, err := h.invitations.List(inviteScope(user, admin))
+	if err != nil {
+		return apperror.Internal(fmt.Errorf("listing invitations: %w", err))
+	}
+
+	now := time.Now()
+	res := make([]InvitationResponse, 0, len(invitations))
+	for i := range invitations {
+		res = append(res, NewInvitationResponse(&invitations[i], now))
+	}
+
+	return web.WriteJSON(w, http.StatusOK, res)
+}
+
+// Create invites an address and emails it the link.
+func (h *InviteHandler) Create(w http.ResponseWriter, r *http.Request) error {
+	user, admin, err := h.inviter(r)
+	if err != nil {
+		return err
+	}
+
+	loc := h.i18n.FromRequest(r)
+
+	if h.mode == RegistrationClosed {
+		return apperror.Forbidden(loc.T("register.closed"))
+	}
+
+	var data inviteData
+	if err := web.Decode(r, &data); err != nil {
+		return err
+	}
+
+	if err := h.validate.Struct(&data); err != nil {
+		return web.ValidationError(loc, &data, err)
+	}
+
+	if len(data.Roles) > 0 && !admin {
+		return apperror.Forbidden(loc.T("invite.roles_admin_only"))
+	}
+	for _, role := range data.Roles {
+		if !users.ValidRole(role) {
+			return apperror.Validation(loc.T("error.validation.title"), []string{loc.T("invite.invalid_role", role)})
+		}
+	}
+
+	if _, err := h.userService.FindUserByEmail(data.Email); err == nil {
+		return apperror.Conflict(loc.T("invite.registered"), nil)
+	}
+
+	token, model, err := h.invitations.Create(user.ID, data.Email, data.Roles)
+	if err != nil {
+		return apperror.Internal(err)
+	}
+
+	link := h.baseURL + "/register?invite=" + url.QueryEscape(token)
+
+	if h.mailer != nil {
+		err = h.mailer.Send(r.Context(), mail.Message{
+			To:      model.Email,
+			Subject: loc.T("invite.email.subject"),
+			Text: strings.Join([]string{
+				loc.T("invite.email.body", user.Email, link),
+				loc.T("invite.email.expires", int(h.invitations.TTL().Hours()/24)),
+			}, "\n\n"),
+		})
+		// the inviter can still pass the link on
+		if err != nil {
+			h.logger.Error("Error sending invitation", zap.Error(err))
+		}
+	}
+
+	if web.WantsJSON(r) {
+		return web.WriteJSON(w, http.StatusCreated, inviteResponse{
+			Link:       link,
+			Invitation: NewInvitationResponse(model, time.Now()),
+		})
+	}
+
+	return h.renderList(w, r, user, admin, link, http.StatusCreated)
+}
+
+// Revoke deletes an invitation that hasn't been accepted yet.
+func (h *InviteHandler) Revoke(w http.ResponseWriter, r *http.Request) error {
+	user, admin, err := h.inviter(r)
+	if err != nil {
+		return err
+	}
+
+	loc := h.i18n.FromRequest(r)
+
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		return apperror.NotFound(loc.T("invite.not_found"))
+	}
+
+	err = h.invitations.Revoke(inviteScope(user, admin), id)
+	if errors.Is(err, ErrInvitationNotFound) {
+		return apperror.NotFound(loc.T("invite.not_found"))
+	}
+	if err != nil {
+		return apperror.Internal(fmt.Errorf("revoking invitation: %w", err))
+	}
+
+	if web.WantsJSON(r) {
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+
+	return h.renderList(w, r, user, admin, "", http.StatusOK)
+}
+
+// renderList renders the invitation list for htmx, with the link of the
+// invitation that was just sent.
+func (h *InviteHandler) renderList(w http.ResponseWriter, r *http.Request, user *users.UserModel, admin bool, newLink string, status int) error {
+	invitations, err := h.invitations.List(inviteScope(user, admin))
+	if err != nil {
+		return apperror.Internal(fmt.Errorf("listing invitations: %w", err))
+	}
+
+	web.RenderBlock(w, r, "invites.html", "invites", status, h.pageData(invitations, admin, newLink))
+
+	return nil
+}
+
+func (h *InviteHandler) pageData(invitations []InvitationModel, admin bool, newLink string) map[string]interface{} {
+	now := time.Now()
+
+	type row struct {
+		InvitationModel
+		Status string
+	}
+
+	rows := make([]row, 0, len(invitations))
+	for _, inv := range invitations {
+		rows = append(rows, row{InvitationModel: inv, Status: inv.Status(now)})
+	}
+
+	return map[string]interface{}{
+		"Title":       "invite.title",
+		"Invitations": rows,
+		"NewLink":     newLink,
+		"Admin":       admin,
+		"Roles":       users.Roles,
+		"Closed":      h.mode == RegistrationClosed,
+	}
+}
@@ -0,0 +1,67 @@
+package auth
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/tomdoestech/goth/internal/pkg/openapi"
+	"github.com/tomdoestech/goth/internal/web"
+)
+
+type InviteHTTPParams struct {
+	InviteHandler *InviteHandler
+	ErrorHandler  *web.ErrorHandler
+	API           *openapi.Document
+	Mux           *chi.Mux
+}
+
+func NewInviteHTTP(p InviteHTTPParams) {
+
+	r := openapi.NewRouter(p.Mux, p.API)
+	e := p.ErrorHandler
+
+	r.Get("/account/invites", openapi.Operation{
+		Summary: "Invitations page",
+		Tags:    []string{"invites"},
+		Responses: map[int]openapi.Response{
+			http.StatusOK: {Description: "The invitations and a form to send one", ContentType: "text/html"},
+		},
+		Errors: []int{http.StatusUnauthorized, http.StatusForbidden},
+		Auth:   true,
+	}, e.Handle(p.InviteHandler.Page))
+
+	r.Get("/api/invites", openapi.Operation{
+		Summary:     "List invitations",
+		Description: "The invitations the user sent, admins get everyone's.",
+		Tags:        []string{"invites"},
+		Responses: map[int]openapi.Response{
+			http.StatusOK: {Description: "The invitations, newest first", Body: []InvitationResponse{}},
+		},
+		Errors: []int{http.StatusUnauthorized, http.StatusForbidden},
+		Auth:   true,
+	}, e.Handle(p.InviteHandler.List))
+
+	r.Post("/api/invites", openapi.Operation{
+		Summary:      "Invite someone",
+		Description:  "Emails a link to register with the address. The link is only returned here. Only admins may give the new account roles.",
+		Tags:         []string{"invites"},
+		Request:      inviteData{},
+		RequestTypes: formOrJSON,
+		Responses: map[int]openapi.Response{
+			http.StatusCreated: {Description: "Invitation sent, htmx gets the updated list with the link", Body: inviteResponse{}},
+		},
+		Errors: []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden, http.StatusConflict},
+		Auth:   true,
+	}, e.Handle(p.InviteHandler.Create))
+
+	r.Delete("/api/invites/{id}", openapi.Operation{
+		Summary: "Revoke an invitation",
+		Tags:    []string{"invites"},
+		Responses: map[int]openapi.Response{
+			http.StatusOK:        {Description: "Invitation revoked, htmx gets the updated list", ContentType: "text/html"},
+			http.StatusNoContent: {Description: "Invitation revoked, for JSON clients"},
+		},
+		Errors: []int{http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound},
+		Auth:   true,
+	}, e.Handle(p.InviteHandler.Revoke))
+}
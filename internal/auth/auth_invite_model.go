@@ -0,0 +1,72 @@
+package auth
+
+import (
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// InvitationModel lets someone register with the invited address, even when
+// registration is invite only. Only a hash of the token in the link is kept.
+type InvitationModel struct {
+	ID        uuid.UUID `gorm:"primaryKey;type:uuid"`
+	CreatedAt time.Time
+
+	Email     string    `gorm:"index;not null"`
+	TokenHash string    `gorm:"uniqueIndex;not null"`
+	InviterID uuid.UUID `gorm:"type:uuid;index;not null"`
+	// Roles are given to the account created with the invitation, space
+	// separated
+	Roles string
+
+	ExpiresAt  time.Time
+	AcceptedAt *time.Time
+	AcceptedBy *uuid.UUID `gorm:"type:uuid"`
+}
+
+func (InvitationModel) TableName() string {
+	return "invitations"
+}
+
+func (m *InvitationModel) RoleList() []string {
+	return strings.Fields(m.Roles)
+}
+
+// Status is accepted, expired or pending.
+func (m *InvitationModel) Status(now time.Time) string {
+	switch {
+	case m.AcceptedAt != nil:
+		return "accepted"
+	case !now.Before(m.ExpiresAt):
+		return "expired"
+	default:
+		return "pending"
+	}
+}
+
+// InvitationResponse is the representation of an invitation returned to API
+// clients.
+type InvitationResponse struct {
+	ID         uuid.UUID  `json:"id"`
+	Email      string     `json:"email"`
+	Roles      []string   `json:"roles"`
+	InviterID  uuid.UUID  `json:"inviter_id"`
+	Status     string     `json:"status"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	AcceptedAt *time.Time `json:"accepted_at,omitempty"`
+}
+
+func NewInvitationResponse(m *InvitationModel, now time.Time) InvitationResponse {
+	return InvitationResponse{
+		ID:         m.ID,
+		Email:      m.Email,
+		Roles:      m.RoleList(),
+		InviterID:  m.InviterID,
+		Status:     m.Status(now),
+		CreatedAt:  m.CreatedAt,
+		ExpiresAt:  m.ExpiresAt,
+		AcceptedAt: m.AcceptedAt,
+	}
+}
@@ -0,0 +1,150 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrInvitationNotFound = errors.New("invitation not found")
+	// ErrInvalidInvitation is returned for unknown, expired and accepted
+	// invitations
+	ErrInvalidInvitation = errors.New("invalid invitation")
+)
+
+type InvitationService struct {
+	db     *gorm.DB
+	ttl    time.Duration
+	logger *zap.Logger
+	now    func() time.Time
+}
+
+type InvitationServiceParams struct {
+	DB *gorm.DB
+	// TTL is how long an invitation can be accepted for, defaults to 7 days
+	TTL    time.Duration
+	Logger *zap.Logger
+}
+
+func NewInvitationService(p InvitationServiceParams) *InvitationService {
+
+	p.DB.AutoMigrate(&InvitationModel{})
+
+	if p.TTL <= 0 {
+		p.TTL = 7 * 24 * time.Hour
+	}
+
+	return &InvitationService{
+		db:     p.DB,
+		ttl:    p.TTL,
+		logger: p.Logger,
+		now:    time.Now,
+	}
+}
+
+func (s *InvitationService) TTL() time.Duration {
+	return s.ttl
+}
+
+// Create invites email, returning the token for the link. A pending
+// invitation of the same address is replaced.
+func (s *InvitationService) Create(inviterID uuid.UUID, email string, roles []string) (string, *InvitationModel, error) {
+	token, err := randomToken(32)
+	if err != nil {
+		return "", nil, err
+	}
+
+	model := &InvitationModel{
+		ID:        uuid.New(),
+		Email:     email,
+		TokenHash: hashToken(token),
+		InviterID: inviterID,
+		Roles:     strings.Join(roles, " "),
+		ExpiresAt: s.now().Add(s.ttl),
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("LOWER(email) = LOWER(?) AND accepted_at IS NULL", email).Delete(&InvitationModel{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(model).Error
+	})
+	if err != nil {
+		return "", nil, fmt.Errorf("creating invitation: %w", err)
+	}
+
+	return token, model, nil
+}
+
+// Find returns the pending invitation of a token.
+func (s *InvitationService) Find(token string) (*InvitationModel, error) {
+	var model InvitationModel
+	err := s.db.Where("token_hash = ?", hashToken(token)).First(&model).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrInvalidInvitation
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if model.Status(s.now()) != "pending" {
+		return nil, ErrInvalidInvitation
+	}
+
+	return &model, nil
+}
+
+// Accept records that userID registered with the invitation. It fails when
+// the invitation was accepted in the meantime.
+func (s *InvitationService) Accept(id, userID uuid.UUID) error {
+	result := s.db.Model(&InvitationModel{}).
+		Where("id = ? AND accepted_at IS NULL", id).
+		Updates(map[string]interface{}{
+			"accepted_at": s.now(),
+			"accepted_by": userID,
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrInvalidInvitation
+	}
+	return nil
+}
+
+// List returns the invitations sent by inviterID, or everyone's for
+// uuid.Nil, newest first.
+func (s *InvitationService) List(inviterID uuid.UUID) ([]InvitationModel, error) {
+	query := s.db.Order("created_at desc").Limit(200)
+	if inviterID != uuid.Nil {
+		query = query.Where("inviter_id = ?", inviterID)
+	}
+
+	var invitations []InvitationModel
+	err := query.Find(&invitations).Error
+	return invitations, err
+}
+
+// Revoke deletes an invitation that hasn't been accepted. Invitations of
+// other inviters are not found, unless inviterID is uuid.Nil.
+func (s *InvitationService) Revoke(inviterID, id uuid.UUID) error {
+	query := s.db.Where("id = ? AND accepted_at IS NULL", id)
+	if inviterID != uuid.Nil {
+		query = query.Where("inviter_id = ?", inviterID)
+	}
+
+	result := query.Delete(&InvitationModel{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrInvitationNotFound
+	}
+	return nil
+}
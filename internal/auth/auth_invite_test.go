@@ -0,0 +1,188 @@
+//go:build unit
+// +build unit
+
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/stretchr/testify/assert"
+	"github.com/tomdoestech/goth/internal/pkg/i18n"
+	"github.com/tomdoestech/goth/internal/pkg/identity"
+	users "github.com/tomdoestech/goth/internal/user"
+	"github.com/tomdoestech/goth/internal/web"
+	"go.uber.org/zap"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestRegistrationMode(t *testing.T) {
+	testCases := []struct {
+		mode    RegistrationMode
+		email   string
+		allowed bool
+	}{
+		{RegistrationOpen, "jane@example.com", true},
+		{RegistrationInvite, "jane@example.com", false},
+		{RegistrationClosed, "jane@example.com", false},
+		{RegistrationDomain, "jane@Example.com", true},
+		{RegistrationDomain, "jane@example.org", false},
+		{RegistrationDomain, "jane@sub.example.com", false},
+	}
+
+	for _, tc := range testCases {
+		assert.Equal(t, tc.allowed, tc.mode.allowsEmail(tc.email, []string{"example.com"}), "%s %s", tc.mode, tc.email)
+	}
+}
+
+func TestInvitations(t *testing.T) {
+	filename := TempFilename(t)
+	defer os.Remove(filename)
+
+	db, err := gorm.Open(sqlite.Open(filename), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	validate := validator.New()
+	bundle, err := i18n.NewBundle(i18n.BundleParams{Validate: validate})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	usersService := users.NewUserService(users.UserServiceParams{
+		Logger:      zap.NewNop(),
+		Validate:    validate,
+		DB:          db,
+		AdminEmails: []string{"admin@example.com"},
+	})
+	CreateUser(usersService, t, "admin@example.com", "tangerine-otter-89-lamp")
+	CreateUser(usersService, t, "member@example.com", "tangerine-otter-89-lamp")
+	admin, _ := usersService.FindUserByEmail("admin@example.com")
+	member, _ := usersService.FindUserByEmail("member@example.com")
+	assert.True(t, admin.HasRole(users.RoleAdmin))
+	assert.False(t, member.HasRole(users.RoleAdmin))
+
+	invitations := NewInvitationService(InvitationServiceParams{DB: db, Logger: zap.NewNop()})
+
+	var sent outbox
+	inviteHandler := NewInviteHandler(InviteHandlerParams{
+		InvitationService: invitations,
+		UserService:       usersService,
+		Mailer:            &sent,
+		BaseURL:           "https://example.com",
+		RegistrationMode:  RegistrationInvite,
+		Validate:          validate,
+		I18n:              bundle,
+		Logger:            zap.NewNop(),
+	})
+	authHandler := NewAuthHandler(AuthHandlerParams{
+		AuthService:       NewAuthService(AuthServiceParams{Logger: zap.NewNop()}),
+		UserService:       usersService,
+		InvitationService: invitations,
+		RegistrationMode:  RegistrationInvite,
+		Validate:          validate,
+		I18n:              bundle,
+		Logger:            zap.NewNop(),
+	})
+	errorHandler := web.NewErrorHandler(web.ErrorHandlerParams{I18n: bundle, Logger: zap.NewNop()})
+
+	send := func(method, path string, handler web.HandlerFunc, as *users.UserModel, form url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Accept", "application/json")
+		if as != nil {
+			req = req.WithContext(identity.NewContext(req.Context(), &identity.Principal{UserID: as.ID, Email: as.Email, Method: identity.MethodCookie}))
+		}
+		w := httptest.NewRecorder()
+		bundle.Middleware(errorHandler.Handle(handler)).ServeHTTP(w, req)
+		return w
+	}
+
+	invite := func(as *users.UserModel, form url.Values) (*httptest.ResponseRecorder, inviteResponse) {
+		w := send("POST", "/api/invites", inviteHandler.Create, as, form)
+		var res inviteResponse
+		if w.Code == http.StatusCreated {
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+		}
+		return w, res
+	}
+
+	register := func(form url.Values) *httptest.ResponseRecorder {
+		return send("POST", "/api/register", authHandler.Register, nil, form)
+	}
+
+	// without an invitation nobody can register
+	w := register(url.Values{"email": {"new@example.com"}, "password": {"velvet-harbor-42-moss"}})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w, _ = invite(member, url.Values{"email": {"new@example.com"}, "roles": {users.RoleAdmin}})
+	assert.Equal(t, http.StatusForbidden, w.Code, "only admins give roles")
+
+	w, _ = invite(admin, url.Values{"email": {"member@example.com"}})
+	assert.Equal(t, http.StatusConflict, w.Code, "registered addresses")
+
+	w, res := invite(admin, url.Values{"email": {"new@example.com"}, "roles": {users.RoleAdmin}})
+	if !assert.Equal(t, http.StatusCreated, w.Code) {
+		return
+	}
+	assert.Equal(t, []string{users.RoleAdmin}, res.Invitation.Roles)
+	if assert.Len(t, sent, 1) {
+		assert.Equal(t, "new@example.com", sent[0].To)
+		assert.Contains(t, sent[0].Text, res.Link)
+		assert.Contains(t, sent[0].Text, "admin@example.com invited you")
+	}
+
+	link, err := url.Parse(res.Link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := link.Query().Get("invite")
+
+	// the register page fills in and locks the email
+	req := httptest.NewRequest("GET", res.Link, nil)
+	w = httptest.NewRecorder()
+	bundle.Middleware(errorHandler.Handle(authHandler.RegisterPage)).ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `value="new@example.com" readonly=""`)
+	assert.Contains(t, w.Body.String(), `name="invite" value="`+token+`"`)
+
+	w = register(url.Values{"email": {"other@example.com"}, "password": {"velvet-harbor-42-moss"}, "invite": {token}})
+	assert.Equal(t, http.StatusBadRequest, w.Code, "another address")
+
+	w = register(url.Values{"email": {"new@example.com"}, "password": {"velvet-harbor-42-moss"}, "invite": {token}})
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	user, err := usersService.FindUserByEmail("new@example.com")
+	if assert.NoError(t, err) {
+		assert.True(t, user.HasRole(users.RoleAdmin))
+	}
+
+	w = register(url.Values{"email": {"new@example.com"}, "password": {"velvet-harbor-42-moss"}, "invite": {token}})
+	assert.Equal(t, http.StatusForbidden, w.Code, "invitations work once")
+
+	// members see and revoke their own invitations, admins everyone's
+	_, res = invite(member, url.Values{"email": {"friend@example.com"}})
+
+	var list []InvitationResponse
+	w = send("GET", "/api/invites", inviteHandler.List, member, nil)
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &list))
+	assert.Len(t, list, 1)
+
+	w = send("GET", "/api/invites", inviteHandler.List, admin, nil)
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &list))
+	if assert.Len(t, list, 2) {
+		assert.Equal(t, "pending", list[0].Status)
+		assert.Equal(t, "accepted", list[1].Status)
+	}
+
+	assert.ErrorIs(t, invitations.Revoke(admin.ID, res.Invitation.ID), ErrInvitationNotFound, "scoped to the inviter")
+	assert.NoError(t, invitations.Revoke(member.ID, res.Invitation.ID))
+}
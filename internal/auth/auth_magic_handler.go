@@ -0,0 +1,181 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tomdoestech/goth/internal/pkg/apperror"
+	"github.com/tomdoestech/goth/internal/pkg/mail"
+	users "github.com/tomdoestech/goth/internal/user"
+	"github.com/tomdoestech/goth/internal/web"
+	"go.uber.org/zap"
+)
+
+type magicLinkData struct {
+	Email string `json:"email" validate:"required,email"`
+	// Remember keeps the browser logged in after it is closed
+	Remember bool `json:"remember,omitempty"`
+	// Next is the page browsers are sent to after logging in
+	Next string `json:"next,omitempty"`
+}
+
+type magicLinkResponse struct {
+	// Message is the same whether the address has an account or not
+	Message string `json:"message"`
+}
+
+type useMagicLinkData struct {
+	Token string `json:"token" validate:"required"`
+}
+
+// MagicLinkPage asks for an email address to send a login link to. With the
+// link's token it asks to confirm instead, so mail scanners that follow
+// links don't use it up.
+func (a *AuthHandler) MagicLinkPage(w http.ResponseWriter, r *http.Request) error {
+	if a.magicLinks == nil {
+		return apperror.NotFound("")
+	}
+
+	w.Header().Set("Referrer-Policy", "no-referrer")
+
+	web.RenderTemplate(w, "magic_link.html", map[string]interface{}{
+		"Title": "magic.title",
+		"Token": r.URL.Query().Get("token"),
+		"Next":  r.URL.Query().Get("next"),
+	}, r)
+
+	return nil
+}
+
+// RequestMagicLink emails a login link to the address when it has an
+// account. The response is the same either way.
+func (a *AuthHandler) RequestMagicLink(w http.ResponseWriter, r *http.Request) error {
+	if a.magicLinks == nil {
+		return apperror.NotFound("")
+	}
+
+	loc := a.i18n.FromRequest(r)
+
+	var data magicLinkData
+	if err := web.Decode(r, &data); err != nil {
+		return err
+	}
+
+	if err := a.validate.Struct(&data); err != nil {
+		return web.ValidationError(loc, &data, err)
+	}
+
+	if ok, retry := a.magicLinks.Allow(strings.ToLower(data.Email)); !ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(retry.Seconds()+1)))
+		return apperror.TooManyRequests(loc.T("magic.rate_limited"))
+	}
+
+	minutes := int(a.magicLinks.TTL().Minutes())
+
+	if user, err := a.userService.FindUserByEmail(data.Email); err == nil {
+		token, device, err := a.magicLinks.Create(user.ID, data.Remember, localPath(data.Next), web.ClientIP(r))
+		if err != nil {
+			return apperror.Internal(fmt.Errorf("creating magic link: %w", err))
+		}
+
+		if device != "" {
+			http.SetCookie(w, &http.Cookie{
+				Name:     MagicLinkCookie,
+				Value:    device,
+				Path:     "/",
+				Expires:  time.Now().Add(a.magicLinks.TTL()),
+				HttpOnly: true,
+				Secure:   r.TLS != nil,
+				SameSite: http.SameSiteLaxMode,
+			})
+		}
+
+		link := a.baseURL + "/login/magic?token=" + url.QueryEscape(token)
+
+		// a failure is only logged, telling the client would tell it the
+		// address has an account
+		err = a.mailer.Send(r.Context(), mail.Message{
+			To:      user.Email,
+			Subject: loc.T("magic.email.subject"),
+			Text: strings.Join([]string{
+				loc.T("magic.email.body", link),
+				loc.T("magic.email.expires", minutes),
+				loc.T("magic.email.ignore"),
+			}, "\n\n"),
+		})
+		if err != nil {
+			a.logger.Error("Error sending magic link", zap.Error(err))
+		}
+	}
+
+	if web.WantsJSON(r) {
+		return web.WriteJSON(w, http.StatusAccepted, magicLinkResponse{
+			Message: loc.T("magic.sent", minutes),
+		})
+	}
+
+	web.RenderBlock(w, r, "magic_link.html", "magic-link-form", http.StatusOK, map[string]interface{}{
+		"Sent":    true,
+		"Minutes": minutes,
+	})
+
+	return nil
+}
+
+// UseMagicLink logs in the user a link was sent to.
+func (a *AuthHandler) UseMagicLink(w http.ResponseWriter, r *http.Request) error {
+	if a.magicLinks == nil {
+		return apperror.NotFound("")
+	}
+
+	loc := a.i18n.FromRequest(r)
+
+	var data useMagicLinkData
+	if err := web.Decode(r, &data); err != nil {
+		return err
+	}
+
+	if err := a.validate.Struct(&data); err != nil {
+		return web.ValidationError(loc, &data, err)
+	}
+
+	var device string
+	if cookie, err := r.Cookie(MagicLinkCookie); err == nil {
+		device = cookie.Value
+	}
+
+	link, err := a.magicLinks.Use(data.Token, device)
+	if errors.Is(err, ErrMagicLinkDevice) {
+		return apperror.Unauthorized(loc.T("magic.other_device"), err)
+	}
+	if errors.Is(err, ErrInvalidMagicLink) {
+		return apperror.Unauthorized(loc.T("magic.invalid"), err)
+	}
+	if err != nil {
+		return apperror.Internal(fmt.Errorf("using magic link: %w", err))
+	}
+
+	user, err := a.userService.FindUserByID(link.UserID)
+	if err != nil {
+		return apperror.Unauthorized(loc.T("magic.invalid"), fmt.Errorf("finding user: %w", err))
+	}
+
+	if device != "" {
+		clearCookie(w, MagicLinkCookie)
+	}
+
+	// the link was emailed, so following it verifies the address
+	if user.Status == users.StatusPending {
+		if err := a.userService.Activate(user.ID); err != nil {
+			return apperror.Internal(fmt.Errorf("activating user: %w", err))
+		}
+		user.Status = users.StatusActive
+	}
+
+	return a.logIn(w, r, user, link.Remember, link.Next)
+}
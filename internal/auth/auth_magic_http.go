@@ -0,0 +1,61 @@
+package auth
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/tomdoestech/goth/internal/pkg/openapi"
+	"github.com/tomdoestech/goth/internal/web"
+)
+
+type MagicLinkHTTPParams struct {
+	AuthHandler  *AuthHandler
+	ErrorHandler *web.ErrorHandler
+	API          *openapi.Document
+	Mux          *chi.Mux
+}
+
+func NewMagicLinkHTTP(p MagicLinkHTTPParams) {
+
+	r := openapi.NewRouter(p.Mux, p.API)
+	e := p.ErrorHandler
+
+	r.Get("/login/magic", openapi.Operation{
+		Summary:     "Magic link page",
+		Description: "Asks for the email address to send a login link to. Opened from the link, with its token, it asks to confirm logging in.",
+		Tags:        []string{"auth"},
+		Query: []openapi.Parameter{
+			{Name: "token", Description: "The token of an emailed link"},
+			{Name: "next", Description: "The page to go to after logging in"},
+		},
+		Responses: map[int]openapi.Response{
+			http.StatusOK: {Description: "The magic link form", ContentType: "text/html"},
+		},
+		Errors: []int{http.StatusNotFound},
+	}, e.Handle(p.AuthHandler.MagicLinkPage))
+
+	r.Post("/api/login/magic", openapi.Operation{
+		Summary:      "Email a login link",
+		Description:  "Sends a single use login link to the address if it has an account, the response doesn't say whether it does. Each address gets a few links every 15 minutes.",
+		Tags:         []string{"auth"},
+		Request:      magicLinkData{},
+		RequestTypes: formOrJSON,
+		Responses: map[int]openapi.Response{
+			http.StatusOK:       {Description: "Link sent, htmx gets a confirmation", ContentType: "text/html"},
+			http.StatusAccepted: {Description: "Link sent, for JSON clients", Body: magicLinkResponse{}},
+		},
+		Errors: []int{http.StatusBadRequest, http.StatusNotFound, http.StatusTooManyRequests},
+	}, e.Handle(p.AuthHandler.RequestMagicLink))
+
+	r.Post("/api/login/magic/verify", openapi.Operation{
+		Summary:      "Log in with a magic link",
+		Description:  "Uses the token of an emailed link, which then stops working. Responds like /api/login.",
+		Tags:         []string{"auth"},
+		Request:      useMagicLinkData{},
+		RequestTypes: formOrJSON,
+		Responses: map[int]openapi.Response{
+			http.StatusOK: {Description: "Logged in", Body: loginResponse{}},
+		},
+		Errors: []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusNotFound},
+	}, e.Handle(p.AuthHandler.UseMagicLink))
+}
@@ -0,0 +1,30 @@
+package auth
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// MagicLinkModel is a login link emailed to a user. The link carries a signed
+// token whose jti is the row's id; the row makes the link single use.
+type MagicLinkModel struct {
+	ID        uuid.UUID `gorm:"primaryKey;type:uuid"`
+	CreatedAt time.Time
+
+	UserID uuid.UUID `gorm:"type:uuid;index;not null"`
+	// DeviceHash is the SHA-256 of the device cookie set on the browser that
+	// asked for the link, empty when links aren't bound to it
+	DeviceHash string
+	// Remember and Next are the login form's remember me and next page
+	Remember bool
+	Next     string
+
+	IP        string
+	ExpiresAt time.Time `gorm:"index"`
+	UsedAt    *time.Time
+}
+
+func (MagicLinkModel) TableName() string {
+	return "magic_links"
+}
@@ -0,0 +1,218 @@
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	jwx "github.com/lestrrat-go/jwx/v2/jwt"
+	"github.com/tomdoestech/goth/internal/pkg/jobs"
+	"github.com/tomdoestech/goth/internal/pkg/ratelimit"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// MagicLinkCookie binds a magic link to the browser that asked for it.
+const MagicLinkCookie = "magic_device"
+
+// MagicLinkPurgeJobKind is the kind of the job deleting expired links.
+const MagicLinkPurgeJobKind = "auth.magic.purge"
+
+// magicLinkAudience keeps magic link tokens from being taken for anything
+// else. They have no id claim, so they never log anyone in on their own.
+const magicLinkAudience = "magic-link"
+
+var (
+	// ErrInvalidMagicLink is returned for forged, expired and used links
+	ErrInvalidMagicLink = errors.New("invalid magic link")
+	// ErrMagicLinkDevice is returned when a bound link is opened in another
+	// browser
+	ErrMagicLinkDevice = errors.New("magic link opened on another device")
+)
+
+// TokenCodec signs and verifies tokens, implemented by *keyring.Keyring and
+// *jwtauth.JWTAuth.
+type TokenCodec interface {
+	TokenEncoder
+	Decode(tokenString string) (jwx.Token, error)
+}
+
+type MagicLinkService struct {
+	db            *gorm.DB
+	keys          TokenCodec
+	limiter       *ratelimit.Limiter
+	ttl           time.Duration
+	deviceBinding bool
+	logger        *zap.Logger
+	now           func() time.Time
+}
+
+type MagicLinkServiceParams struct {
+	DB   *gorm.DB
+	Keys TokenCodec
+	// TTL is how long a link works for, defaults to 15 minutes
+	TTL time.Duration
+	// Limit is how many links an address gets per Window, defaults to 3 per
+	// 15 minutes
+	Limit  int
+	Window time.Duration
+	// DeviceBinding only lets links log in the browser that asked for them
+	DeviceBinding bool
+	// Queue runs the hourly job deleting expired links. Optional, it must
+	// run after the service is created.
+	Queue  *jobs.Queue
+	Logger *zap.Logger
+}
+
+func NewMagicLinkService(p MagicLinkServiceParams) *MagicLinkService {
+
+	p.DB.AutoMigrate(&MagicLinkModel{})
+
+	if p.TTL <= 0 {
+		p.TTL = 15 * time.Minute
+	}
+	if p.Limit <= 0 {
+		p.Limit = 3
+	}
+	if p.Window <= 0 {
+		p.Window = 15 * time.Minute
+	}
+
+	s := &MagicLinkService{
+		db:            p.DB,
+		keys:          p.Keys,
+		limiter:       ratelimit.New(ratelimit.Params{Limit: p.Limit, Window: p.Window}),
+		ttl:           p.TTL,
+		deviceBinding: p.DeviceBinding,
+		logger:        p.Logger,
+		now:           time.Now,
+	}
+
+	if p.Queue != nil {
+		jobs.Every(p.Queue, MagicLinkPurgeJobKind, time.Hour, s.DeleteExpired)
+	}
+
+	return s
+}
+
+// DeleteExpired deletes the links that expired, used or not.
+func (s *MagicLinkService) DeleteExpired(ctx context.Context) error {
+	result := s.db.WithContext(ctx).Where("expires_at < ?", s.now()).Delete(&MagicLinkModel{})
+	if result.Error != nil {
+		return fmt.Errorf("deleting expired magic links: %w", result.Error)
+	}
+	if result.RowsAffected > 0 {
+		s.logger.Debug("Deleted expired magic links", zap.Int64("count", result.RowsAffected))
+	}
+	return nil
+}
+
+func (s *MagicLinkService) TTL() time.Duration {
+	return s.ttl
+}
+
+// DeviceBinding reports whether links need the device cookie.
+func (s *MagicLinkService) DeviceBinding() bool {
+	return s.deviceBinding
+}
+
+// Allow counts a link sent to email, returning false and when to try again
+// once the address has had its share. Addresses without an account count
+// too, so the limit doesn't tell them apart.
+func (s *MagicLinkService) Allow(email string) (bool, time.Duration) {
+	return s.limiter.Allow(email)
+}
+
+// Create makes a link for the user, returning its token and, with device
+// binding, the value of the device cookie.
+func (s *MagicLinkService) Create(userID uuid.UUID, remember bool, next, ip string) (string, string, error) {
+	now := s.now()
+
+	model := &MagicLinkModel{
+		ID:        uuid.New(),
+		UserID:    userID,
+		Remember:  remember,
+		Next:      next,
+		IP:        ip,
+		ExpiresAt: now.Add(s.ttl),
+	}
+
+	var device string
+	if s.deviceBinding {
+		var err error
+		if device, err = randomToken(32); err != nil {
+			return "", "", err
+		}
+		model.DeviceHash = hashToken(device)
+	}
+
+	_, token, err := s.keys.Encode(map[string]interface{}{
+		jwx.JwtIDKey:      model.ID.String(),
+		jwx.SubjectKey:    userID.String(),
+		jwx.AudienceKey:   magicLinkAudience,
+		jwx.IssuedAtKey:   now.Unix(),
+		jwx.ExpirationKey: model.ExpiresAt.Unix(),
+	})
+	if err != nil {
+		return "", "", fmt.Errorf("signing magic link: %w", err)
+	}
+
+	if err := s.db.Create(model).Error; err != nil {
+		return "", "", fmt.Errorf("creating magic link: %w", err)
+	}
+
+	return token, device, nil
+}
+
+// Use checks a link's token and marks it used, returning the link. device
+// is the device cookie, only checked with device binding.
+func (s *MagicLinkService) Use(token, device string) (*MagicLinkModel, error) {
+	t, err := s.keys.Decode(token)
+	if err != nil {
+		return nil, ErrInvalidMagicLink
+	}
+	if err := jwx.Validate(t, jwx.WithAudience(magicLinkAudience), jwx.WithClock(jwx.ClockFunc(s.now))); err != nil {
+		return nil, ErrInvalidMagicLink
+	}
+
+	id, err := uuid.Parse(t.JwtID())
+	if err != nil {
+		return nil, ErrInvalidMagicLink
+	}
+
+	var model MagicLinkModel
+	err = s.db.First(&model, "id = ?", id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrInvalidMagicLink
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	now := s.now()
+	if model.UsedAt != nil || !now.Before(model.ExpiresAt) || model.UserID.String() != t.Subject() {
+		return nil, ErrInvalidMagicLink
+	}
+
+	if model.DeviceHash != "" && subtle.ConstantTimeCompare([]byte(model.DeviceHash), []byte(hashToken(device))) != 1 {
+		return nil, ErrMagicLinkDevice
+	}
+
+	// only one request gets to use the link
+	result := s.db.Model(&MagicLinkModel{}).
+		Where("id = ? AND used_at IS NULL", model.ID).
+		Update("used_at", now)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrInvalidMagicLink
+	}
+
+	model.UsedAt = &now
+
+	return &model, nil
+}
@@ -0,0 +1,164 @@
+//go:build unit
+// +build unit
+
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/jwtauth/v5"
+	"github.com/go-playground/validator/v10"
+	"github.com/stretchr/testify/assert"
+	"github.com/tomdoestech/goth/internal/pkg/i18n"
+	"github.com/tomdoestech/goth/internal/pkg/mail"
+	users "github.com/tomdoestech/goth/internal/user"
+	"github.com/tomdoestech/goth/internal/web"
+	"go.uber.org/zap"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// outbox keeps the messages it is asked to send.
+type outbox []mail.Message
+
+func (o *outbox) Send(ctx context.Context, m mail.Message) error {
+	*o = append(*o, m)
+	return nil
+}
+
+func TestMagicLink(t *testing.T) {
+	filename := TempFilename(t)
+	defer os.Remove(filename)
+
+	db, err := gorm.Open(sqlite.Open(filename), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	validate := validator.New()
+	bundle, err := i18n.NewBundle(i18n.BundleParams{Validate: validate})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	usersService := users.NewUserService(users.UserServiceParams{Logger: zap.NewNop(), Validate: validate, DB: db})
+	CreateUser(usersService, t, "test@example.com", "tangerine-otter-89-lamp")
+	user, err := usersService.FindUserByEmail("test@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tokenAuth := jwtauth.New("HS256", []byte("secret"), nil)
+	magicLinks := NewMagicLinkService(MagicLinkServiceParams{
+		DB:            db,
+		Keys:          tokenAuth,
+		DeviceBinding: true,
+		Logger:        zap.NewNop(),
+	})
+
+	var sent outbox
+	authHandler := NewAuthHandler(AuthHandlerParams{
+		AuthService:      NewAuthService(AuthServiceParams{Logger: zap.NewNop(), TokenAuth: tokenAuth}),
+		UserService:      usersService,
+		MagicLinkService: magicLinks,
+		Mailer:           &sent,
+		BaseURL:          "https://example.com/",
+		Validate:         validate,
+		I18n:             bundle,
+		Logger:           zap.NewNop(),
+	})
+	errorHandler := web.NewErrorHandler(web.ErrorHandlerParams{I18n: bundle, Logger: zap.NewNop()})
+
+	post := func(handler web.HandlerFunc, path string, form url.Values, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", path, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("HX-Request", "true")
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		w := httptest.NewRecorder()
+		bundle.Middleware(errorHandler.Handle(handler)).ServeHTTP(w, req)
+		return w
+	}
+
+	// unknown addresses get the same response, and no email
+	w := post(authHandler.RequestMagicLink, "/api/login/magic", url.Values{"email": {"nobody@example.com"}})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "If that address has an account")
+	assert.Empty(t, sent)
+
+	w = post(authHandler.RequestMagicLink, "/api/login/magic", url.Values{"email": {"test@example.com"}, "next": {"/account/sessions"}})
+	assert.Equal(t, http.StatusOK, w.Code)
+	if !assert.Len(t, sent, 1) {
+		return
+	}
+	assert.Equal(t, "test@example.com", sent[0].To)
+	assert.Contains(t, sent[0].Text, "within 15 minutes")
+
+	var device *http.Cookie
+	for _, c := range w.Result().Cookies() {
+		if c.Name == MagicLinkCookie {
+			device = c
+		}
+	}
+	if !assert.NotNil(t, device) {
+		return
+	}
+
+	start := strings.Index(sent[0].Text, "https://example.com/login/magic?token=")
+	if !assert.NotEqual(t, -1, start) {
+		return
+	}
+	link, err := url.Parse(strings.Fields(sent[0].Text[start:])[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := link.Query().Get("token")
+
+	w = post(authHandler.UseMagicLink, "/api/login/magic/verify", url.Values{"token": {token}})
+	assert.Equal(t, http.StatusUnauthorized, w.Code, "another browser")
+	assert.Contains(t, w.Body.String(), "browser you asked for it from")
+
+	w = post(authHandler.UseMagicLink, "/api/login/magic/verify", url.Values{"token": {token + "x"}}, device)
+	assert.Equal(t, http.StatusUnauthorized, w.Code, "forged token")
+
+	w = post(authHandler.UseMagicLink, "/api/login/magic/verify", url.Values{"token": {token}}, device)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "/account/sessions", w.Header().Get("HX-Redirect"))
+	assert.Contains(t, strings.Join(w.Header().Values("Set-Cookie"), "\n"), "token=")
+
+	w = post(authHandler.UseMagicLink, "/api/login/magic/verify", url.Values{"token": {token}}, device)
+	assert.Equal(t, http.StatusUnauthorized, w.Code, "links work once")
+	assert.Contains(t, w.Body.String(), "already used")
+
+	// expired links
+	token, device2, err := magicLinks.Create(user.ID, false, "/", "")
+	if assert.NoError(t, err) {
+		magicLinks.now = func() time.Time { return time.Now().Add(16 * time.Minute) }
+		_, err = magicLinks.Use(token, device2)
+		assert.ErrorIs(t, err, ErrInvalidMagicLink)
+
+		assert.NoError(t, magicLinks.DeleteExpired(context.Background()))
+		var count int64
+		db.Model(&MagicLinkModel{}).Count(&count)
+		assert.Zero(t, count, "expired links are deleted")
+		magicLinks.now = time.Now
+	}
+
+	// the address has had one of its three links, however it is written
+	for _, email := range []string{"Test@example.com", "test@example.com"} {
+		w = post(authHandler.RequestMagicLink, "/api/login/magic", url.Values{"email": {email}})
+		assert.Equal(t, http.StatusOK, w.Code)
+	}
+	w = post(authHandler.RequestMagicLink, "/api/login/magic", url.Values{"email": {"test@example.com"}})
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.NotEmpty(t, w.Header().Get("Retry-After"))
+	assert.Len(t, sent, 2)
+}
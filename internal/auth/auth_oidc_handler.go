@@ -0,0 +1,387 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-chi/jwtauth/v5"
+	"github.com/google/uuid"
+	"github.com/tomdoestech/goth/internal/pkg/apperror"
+	"github.com/tomdoestech/goth/internal/pkg/i18n"
+	"github.com/tomdoestech/goth/internal/pkg/identity"
+	"github.com/tomdoestech/goth/internal/web"
+	"go.uber.org/zap"
+)
+
+// OIDCHandler lets other apps sign their users in with this one, as an
+// OpenID Connect provider using the authorization code flow with PKCE.
+type OIDCHandler struct {
+	oidcService *OIDCService
+	i18n        *i18n.Bundle
+	logger      *zap.Logger
+}
+
+type OIDCHandlerParams struct {
+	OIDCService *OIDCService
+	I18n        *i18n.Bundle
+	Logger      *zap.Logger
+}
+
+// authorizeParams are the parameters of an authorization request, sent as
+// the query string and posted back from the consent screen.
+type authorizeParams struct {
+	ResponseType        string `json:"response_type"`
+	ClientID            string `json:"client_id"`
+	RedirectURI         string `json:"redirect_uri"`
+	Scope               string `json:"scope"`
+	State               string `json:"state"`
+	Nonce               string `json:"nonce"`
+	CodeChallenge       string `json:"code_challenge"`
+	CodeChallengeMethod string `json:"code_challenge_method"`
+	Prompt              string `json:"prompt"`
+	// Decision is allow or deny, from the consent screen
+	Decision string `json:"decision"`
+}
+
+type tokenParams struct {
+	GrantType    string `json:"grant_type"`
+	Code         string `json:"code"`
+	RedirectURI  string `json:"redirect_uri"`
+	CodeVerifier string `json:"code_verifier"`
+	RefreshToken string `json:"refresh_token"`
+	Scope        string `json:"scope"`
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+}
+
+func NewOIDCHandler(p OIDCHandlerParams) *OIDCHandler {
+	return &OIDCHandler{
+		oidcService: p.OIDCService,
+		i18n:        p.I18n,
+		logger:      p.Logger,
+	}
+}
+
+func (h *OIDCHandler) Discovery(w http.ResponseWriter, r *http.Request) error {
+	issuer := h.oidcService.Issuer()
+
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+
+	return web.WriteJSON(w, http.StatusOK, discoveryDocument{
+		Issuer:                            issuer,
+		AuthorizationEndpoint:             issuer + "/oauth/authorize",
+		TokenEndpoint:                     issuer + "/oauth/token",
+		UserinfoEndpoint:                  issuer + "/oauth/userinfo",
+		JWKSURI:                           issuer + "/.well-known/jwks.json",
+		ScopesSupported:                   OIDCScopes,
+		ResponseTypesSupported:            []string{"code"},
+		GrantTypesSupported:               []string{"authorization_code", "refresh_token"},
+		SubjectTypesSupported:             []string{"public"},
+		IDTokenSigningAlgValuesSupported:  h.oidcService.Algorithms(),
+		TokenEndpointAuthMethodsSupported: []string{"client_secret_basic", "client_secret_post", "none"},
+		CodeChallengeMethodsSupported:     []string{"S256"},
+		ClaimsSupported:                   []string{"iss", "sub", "aud", "exp", "iat", "auth_time", "nonce", "email", "locale"},
+	})
+}
+
+// redirectError is an authorization error reported to the client on its
+// redirect URI.
+type redirectError struct {
+	code        string
+	description string
+}
+
+func (e *redirectError) Error() string {
+	return e.code + ": " + e.description
+}
+
+// authorizeRequest is a validated authorization request.
+type authorizeRequest struct {
+	params authorizeParams
+	client *OIDCClientModel
+	scopes []string
+}
+
+// redirect returns the client's redirect URI with params and the state.
+func (a *authorizeRequest) redirect(params url.Values) string {
+	if a.params.State != "" {
+		params.Set("state", a.params.State)
+	}
+
+	u, _ := url.Parse(a.params.RedirectURI)
+	query := u.Query()
+	for name, values := range params {
+		query[name] = values
+	}
+	u.RawQuery = query.Encode()
+
+	return u.String()
+}
+
+// parseAuthorize validates an authorization request. An unknown client or
+// redirect URI is shown to the user, as redirecting could send them anywhere.
+// Other problems are sent back to the client as a *redirectError.
+func (h *OIDCHandler) parseAuthorize(r *http.Request) (*authorizeRequest, error) {
+	loc := h.i18n.FromRequest(r)
+
+	var params authorizeParams
+	if err := web.Decode(r, &params); err != nil {
+		return nil, err
+	}
+
+	client, err := h.oidcService.FindClient(params.ClientID)
+	if errors.Is(err, ErrClientNotFound) {
+		return nil, apperror.Validation(loc.T("oidc.invalid_request"), []string{loc.T("oidc.unknown_client")})
+	}
+	if err != nil {
+		return nil, apperror.Internal(fmt.Errorf("finding oidc client: %w", err))
+	}
+
+	if !client.AllowsRedirect(params.RedirectURI) {
+		return nil, apperror.Validation(loc.T("oidc.invalid_request"), []string{loc.T("oidc.invalid_redirect_uri")})
+	}
+
+	req := &authorizeRequest{params: params, client: client}
+
+	if params.ResponseType != "code" {
+		return req, &redirectError{"unsupported_response_type", "only the code response type is supported"}
+	}
+
+	// unknown scopes are ignored
+	for _, scope := range strings.Fields(params.Scope) {
+		if contains(OIDCScopes, scope) && !contains(req.scopes, scope) {
+			req.scopes = append(req.scopes, scope)
+		}
+	}
+	if !contains(req.scopes, ScopeOpenID) {
+		return req, &redirectError{"invalid_scope", "the openid scope is required"}
+	}
+
+	if params.CodeChallenge == "" || params.CodeChallengeMethod != "S256" {
+		return req, &redirectError{"invalid_request", "PKCE with the S256 method is required"}
+	}
+
+	return req, nil
+}
+
+// Authorize starts the sign in of a client: it sends anonymous users to the
+// login page, asks for consent when the client wants scopes the user hasn't
+// granted yet, and redirects back to the client with a code.
+func (h *OIDCHandler) Authorize(w http.ResponseWriter, r *http.Request) error {
+	req, err := h.parseAuthorize(r)
+	if err != nil {
+		return h.authorizeError(w, r, req, err)
+	}
+
+	p, ok := identity.FromContext(r.Context())
+	if !ok || p.Method != identity.MethodCookie {
+		if req.params.Prompt == "none" {
+			return h.authorizeError(w, r, req, &redirectError{"login_required", "the user is not logged in"})
+		}
+		http.Redirect(w, r, "/login?next="+url.QueryEscape(r.URL.RequestURI()), http.StatusFound)
+		return nil
+	}
+
+	// the client's tokens would outlast the impersonation and its restrictions
+	if p.Impersonated() {
+		return h.authorizeError(w, r, req, &redirectError{"login_required", "the user is being impersonated"})
+	}
+
+	if req.params.Prompt != "consent" {
+		granted, err := h.oidcService.HasConsent(p.UserID, req.client.ID, req.scopes)
+		if err != nil {
+			return apperror.Internal(fmt.Errorf("checking consent: %w", err))
+		}
+		if granted {
+			return h.issueCode(w, r, req, p.UserID)
+		}
+	}
+
+	if req.params.Prompt == "none" {
+		return h.authorizeError(w, r, req, &redirectError{"consent_required", "the user hasn't granted these scopes"})
+	}
+
+	web.RenderTemplate(w, "oidc_consent.html", map[string]interface{}{
+		"Title":  "oidc.title",
+		"Client": req.client,
+		"Scopes": req.scopes,
+		"Params": req.params,
+	}, r)
+
+	return nil
+}
+
+// Consent records the user's answer on the consent screen. It only accepts
+// htmx requests, which other sites can't forge.
+func (h *OIDCHandler) Consent(w http.ResponseWriter, r *http.Request) error {
+	loc := h.i18n.FromRequest(r)
+
+	p, ok := identity.FromContext(r.Context())
+	if !ok || p.Method != identity.MethodCookie {
+		return apperror.Unauthorized(loc.T("error.unauthorized.message"), nil)
+	}
+	if r.Header.Get("HX-Request") != "true" {
+		return apperror.Forbidden(loc.T("oidc.invalid_consent"))
+	}
+
+	req, err := h.parseAuthorize(r)
+	if err != nil {
+		return h.authorizeError(w, r, req, err)
+	}
+
+	if p.Impersonated() {
+		return h.authorizeError(w, r, req, &redirectError{"access_denied", "the user is being impersonated"})
+	}
+
+	if req.params.Decision != "allow" {
+		return h.authorizeError(w, r, req, &redirectError{"access_denied", "the user denied the request"})
+	}
+
+	if err := h.oidcService.SaveConsent(p.UserID, req.client.ID, req.scopes); err != nil {
+		return apperror.Internal(fmt.Errorf("saving consent: %w", err))
+	}
+
+	return h.issueCode(w, r, req, p.UserID)
+}
+
+func (h *OIDCHandler) issueCode(w http.ResponseWriter, r *http.Request, req *authorizeRequest, userID uuid.UUID) error {
+	authTime := time.Now()
+	if token, _, err := jwtauth.FromContext(r.Context()); err == nil && token != nil && !token.IssuedAt().IsZero() {
+		authTime = token.IssuedAt()
+	}
+
+	code, err := h.oidcService.CreateCode(AuthorizationRequest{
+		Client:        req.client,
+		UserID:        userID,
+		RedirectURI:   req.params.RedirectURI,
+		Scopes:        req.scopes,
+		Nonce:         req.params.Nonce,
+		CodeChallenge: req.params.CodeChallenge,
+		AuthTime:      authTime,
+	})
+	if err != nil {
+		return apperror.Internal(err)
+	}
+
+	redirect(w, r, req.redirect(url.Values{"code": {code}}))
+
+	return nil
+}
+
+// authorizeError sends a *redirectError back to the client and returns any
+// other error to be rendered.
+func (h *OIDCHandler) authorizeError(w http.ResponseWriter, r *http.Request, req *authorizeRequest, err error) error {
+	var rerr *redirectError
+	if !errors.As(err, &rerr) {
+		return err
+	}
+
+	redirect(w, r, req.redirect(url.Values{
+		"error":             {rerr.code},
+		"error_description": {rerr.description},
+	}))
+
+	return nil
+}
+
+// redirect sends the browser to url, with HX-Redirect for htmx requests.
+func redirect(w http.ResponseWriter, r *http.Request, url string) {
+	if r.Header.Get("HX-Request") == "true" {
+		w.Header().Set("HX-Redirect", url)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	http.Redirect(w, r, url, http.StatusFound)
+}
+
+// Token exchanges an authorization code or a refresh token for tokens.
+func (h *OIDCHandler) Token(w http.ResponseWriter, r *http.Request) error {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+
+	var params tokenParams
+	if err := web.Decode(r, &params); err != nil {
+		return writeOAuthError(w, http.StatusBadRequest, "invalid_request", "the request body can't be read")
+	}
+
+	id, secret, basic := r.BasicAuth()
+	if basic {
+		// client_secret_basic form encodes the credentials first
+		id, _ = url.QueryUnescape(id)
+		secret, _ = url.QueryUnescape(secret)
+	} else {
+		id, secret = params.ClientID, params.ClientSecret
+	}
+
+	client, err := h.oidcService.AuthenticateClient(id, secret)
+	if errors.Is(err, ErrInvalidClient) {
+		if basic {
+			w.Header().Set("WWW-Authenticate", `Basic realm="token"`)
+		}
+		return writeOAuthError(w, http.StatusUnauthorized, "invalid_client", "client authentication failed")
+	}
+	if err != nil {
+		return apperror.Internal(fmt.Errorf("authenticating oidc client: %w", err))
+	}
+
+	var res *TokenResponse
+	switch params.GrantType {
+	case "authorization_code":
+		res, err = h.oidcService.ExchangeCode(client, params.Code, params.RedirectURI, params.CodeVerifier)
+	case "refresh_token":
+		res, err = h.oidcService.Refresh(client, params.RefreshToken, strings.Fields(params.Scope))
+	default:
+		return writeOAuthError(w, http.StatusBadRequest, "unsupported_grant_type", "use authorization_code or refresh_token")
+	}
+
+	if errors.Is(err, ErrInvalidGrant) {
+		return writeOAuthError(w, http.StatusBadRequest, "invalid_grant", "the grant is invalid, expired or already used")
+	}
+	if err != nil {
+		return apperror.Internal(fmt.Errorf("issuing tokens: %w", err))
+	}
+
+	return web.WriteJSON(w, http.StatusOK, res)
+}
+
+// UserInfo returns the claims about the user an OIDC access token allows.
+// Session tokens are not accepted.
+func (h *OIDCHandler) UserInfo(w http.ResponseWriter, r *http.Request) error {
+	token, claims, err := jwtauth.FromContext(r.Context())
+	clientID, _ := claims["client_id"].(string)
+	scope, _ := claims["scope"].(string)
+	scopes := strings.Fields(scope)
+
+	if err != nil || token == nil || jwtauth.TokenFromHeader(r) == "" || clientID == "" || !contains(scopes, ScopeOpenID) {
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		return writeOAuthError(w, http.StatusUnauthorized, "invalid_token", "an access token from the token endpoint is required")
+	}
+
+	userID, err := uuid.Parse(token.Subject())
+	if err != nil {
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		return writeOAuthError(w, http.StatusUnauthorized, "invalid_token", "the token has no valid subject")
+	}
+
+	res, err := h.oidcService.UserInfo(userID, scopes)
+	if err != nil {
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		return writeOAuthError(w, http.StatusUnauthorized, "invalid_token", "the user no longer exists or is blocked")
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+
+	return web.WriteJSON(w, http.StatusOK, res)
+}
+
+func writeOAuthError(w http.ResponseWriter, status int, code, description string) error {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	return json.NewEncoder(w).Encode(oauthError{Error: code, ErrorDescription: description})
+}
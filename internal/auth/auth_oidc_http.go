@@ -0,0 +1,91 @@
+package auth
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/tomdoestech/goth/internal/pkg/openapi"
+	"github.com/tomdoestech/goth/internal/web"
+)
+
+type OIDCHTTPParams struct {
+	OIDCHandler  *OIDCHandler
+	ErrorHandler *web.ErrorHandler
+	API          *openapi.Document
+	Mux          *chi.Mux
+}
+
+func NewOIDCHTTP(p OIDCHTTPParams) {
+
+	r := openapi.NewRouter(p.Mux, p.API)
+	e := p.ErrorHandler
+
+	r.Get("/.well-known/openid-configuration", openapi.Operation{
+		Summary: "OpenID Connect discovery",
+		Tags:    []string{"oidc"},
+		Responses: map[int]openapi.Response{
+			http.StatusOK: {Description: "The provider metadata", Body: discoveryDocument{}},
+		},
+	}, e.Handle(p.OIDCHandler.Discovery))
+
+	r.Get("/oauth/authorize", openapi.Operation{
+		Summary:     "Authorization endpoint",
+		Description: "Starts the authorization code flow. PKCE with S256 is required. Anonymous users are sent to the login page, then asked to consent to scopes they haven't granted the client yet.",
+		Tags:        []string{"oidc"},
+		Query: []openapi.Parameter{
+			{Name: "response_type", Description: "Must be code", Required: true},
+			{Name: "client_id", Required: true},
+			{Name: "redirect_uri", Description: "One of the client's registered redirect URIs", Required: true},
+			{Name: "scope", Description: "Space separated, must include openid", Required: true},
+			{Name: "code_challenge", Required: true},
+			{Name: "code_challenge_method", Description: "Must be S256", Required: true},
+			{Name: "state"},
+			{Name: "nonce"},
+			{Name: "prompt", Description: "none or consent"},
+		},
+		Responses: map[int]openapi.Response{
+			http.StatusOK:    {Description: "The consent screen", ContentType: "text/html"},
+			http.StatusFound: {Description: "Redirect to the client with a code or an error, or to the login page"},
+		},
+		Errors: []int{http.StatusBadRequest},
+	}, e.Handle(p.OIDCHandler.Authorize))
+
+	r.Post("/oauth/authorize", openapi.Operation{
+		Summary:      "Consent decision",
+		Description:  "Posted by the consent screen with htmx, answers with an HX-Redirect back to the client.",
+		Tags:         []string{"oidc"},
+		Request:      authorizeParams{},
+		RequestTypes: []string{"application/x-www-form-urlencoded"},
+		Responses: map[int]openapi.Response{
+			http.StatusOK: {Description: "HX-Redirect to the client with a code or an error"},
+		},
+		Errors: []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden},
+		Auth:   true,
+	}, e.Handle(p.OIDCHandler.Consent))
+
+	r.Post("/oauth/token", openapi.Operation{
+		Summary:      "Token endpoint",
+		Description:  "Exchanges an authorization code or a refresh token. Confidential clients authenticate with HTTP Basic or client_secret in the body, public clients send only client_id. Refresh tokens are issued for the offline_access scope and can be used once.",
+		Tags:         []string{"oidc"},
+		Request:      tokenParams{},
+		RequestTypes: []string{"application/x-www-form-urlencoded"},
+		Responses: map[int]openapi.Response{
+			http.StatusOK:           {Description: "Tokens issued", Body: TokenResponse{}},
+			http.StatusBadRequest:   {Description: "An OAuth error", Body: oauthError{}},
+			http.StatusUnauthorized: {Description: "Client authentication failed", Body: oauthError{}},
+		},
+	}, e.Handle(p.OIDCHandler.Token))
+
+	userInfo := openapi.Operation{
+		Summary:     "UserInfo endpoint",
+		Description: "Needs an access token from the token endpoint as a bearer token. Returns email with the email scope and locale with the profile scope.",
+		Tags:        []string{"oidc"},
+		Responses: map[int]openapi.Response{
+			http.StatusOK:           {Description: "The user's claims", Body: userInfoResponse{}},
+			http.StatusUnauthorized: {Description: "Missing or invalid access token", Body: oauthError{}},
+		},
+	}
+
+	r.Get("/oauth/userinfo", userInfo, e.Handle(p.OIDCHandler.UserInfo))
+	r.Post("/oauth/userinfo", userInfo, e.Handle(p.OIDCHandler.UserInfo))
+}
@@ -0,0 +1,143 @@
+package auth
+
+import (
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// OIDCClientModel is an app that may sign its users in with this one.
+type OIDCClientModel struct {
+	// ID is the client_id
+	ID        string `gorm:"primaryKey"`
+	CreatedAt time.Time
+
+	Name string `gorm:"not null"`
+	// SecretHash is the SHA-256 of the client secret, empty for public
+	// clients such as single page apps, which rely on PKCE alone
+	SecretHash string
+	// RedirectURIs is space separated, redirect_uri must match one exactly
+	RedirectURIs string `gorm:"not null"`
+}
+
+func (OIDCClientModel) TableName() string {
+	return "oidc_clients"
+}
+
+func (c *OIDCClientModel) Public() bool {
+	return c.SecretHash == ""
+}
+
+func (c *OIDCClientModel) AllowsRedirect(uri string) bool {
+	for _, allowed := range strings.Fields(c.RedirectURIs) {
+		if uri == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// OIDCConsentModel remembers the scopes a user granted a client, so the
+// consent screen is only shown again for new scopes.
+type OIDCConsentModel struct {
+	UserID    uuid.UUID `gorm:"primaryKey;type:uuid"`
+	ClientID  string    `gorm:"primaryKey"`
+	UpdatedAt time.Time
+	// Scopes is space separated
+	Scopes string
+}
+
+func (OIDCConsentModel) TableName() string {
+	return "oidc_consents"
+}
+
+// OIDCCodeModel is an authorization code waiting to be exchanged for tokens.
+type OIDCCodeModel struct {
+	// Hash is the SHA-256 of the code, the code itself is never stored
+	Hash      string `gorm:"primaryKey"`
+	CreatedAt time.Time
+
+	ClientID    string    `gorm:"not null"`
+	UserID      uuid.UUID `gorm:"type:uuid;not null"`
+	RedirectURI string    `gorm:"not null"`
+	Scopes      string
+	Nonce       string
+	// CodeChallenge is the S256 PKCE challenge
+	CodeChallenge string `gorm:"not null"`
+	// FamilyID is shared by the refresh tokens issued for the code
+	FamilyID uuid.UUID `gorm:"type:uuid;not null"`
+	AuthTime time.Time
+
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+}
+
+func (OIDCCodeModel) TableName() string {
+	return "oidc_codes"
+}
+
+// OIDCRefreshTokenModel is a refresh token. Each one is used once and
+// replaced by a new one in the same family; using a replaced token again
+// revokes the whole family, as it has probably been stolen.
+type OIDCRefreshTokenModel struct {
+	ID        uuid.UUID `gorm:"primaryKey;type:uuid"`
+	CreatedAt time.Time
+
+	// Hash is the SHA-256 of the token, the token itself is never stored
+	Hash     string    `gorm:"uniqueIndex;not null"`
+	FamilyID uuid.UUID `gorm:"type:uuid;index;not null"`
+	ClientID string    `gorm:"not null"`
+	UserID   uuid.UUID `gorm:"type:uuid;index;not null"`
+	Scopes   string
+	AuthTime time.Time
+
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+	RevokedAt *time.Time
+}
+
+func (OIDCRefreshTokenModel) TableName() string {
+	return "oidc_refresh_tokens"
+}
+
+// TokenResponse is the token endpoint's answer.
+type TokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	IDToken      string `json:"id_token,omitempty"`
+	Scope        string `json:"scope"`
+}
+
+// oauthError is the error body of the token and userinfo endpoints, as
+// RFC 6749 defines it.
+type oauthError struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description,omitempty"`
+}
+
+// discoveryDocument is the OpenID Provider Metadata.
+type discoveryDocument struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	UserinfoEndpoint                  string   `json:"userinfo_endpoint"`
+	JWKSURI                           string   `json:"jwks_uri"`
+	ScopesSupported                   []string `json:"scopes_supported"`
+	ResponseTypesSupported            []string `json:"response_types_supported"`
+	GrantTypesSupported               []string `json:"grant_types_supported"`
+	SubjectTypesSupported             []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported"`
+	ClaimsSupported                   []string `json:"claims_supported"`
+}
+
+// userInfoResponse holds the claims about the user the token's scopes allow.
+type userInfoResponse struct {
+	Subject string `json:"sub"`
+	Email   string `json:"email,omitempty"`
+	Locale  string `json:"locale,omitempty"`
+}
@@ -0,0 +1,481 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	users "github.com/tomdoestech/goth/internal/user"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// OIDC scopes clients can ask for.
+const (
+	ScopeOpenID        = "openid"
+	ScopeEmail         = "email"
+	ScopeProfile       = "profile"
+	ScopeOfflineAccess = "offline_access"
+)
+
+var OIDCScopes = []string{ScopeOpenID, ScopeEmail, ScopeProfile, ScopeOfflineAccess}
+
+const (
+	codeTTL         = time.Minute
+	accessTokenTTL  = time.Hour
+	refreshTokenTTL = 30 * 24 * time.Hour
+)
+
+var (
+	ErrClientNotFound = errors.New("oidc client not found")
+	// ErrInvalidClient is returned when client authentication fails
+	ErrInvalidClient = errors.New("invalid client credentials")
+	// ErrInvalidGrant is returned for unknown, expired or reused codes and
+	// refresh tokens, and PKCE verifiers that don't match
+	ErrInvalidGrant = errors.New("invalid grant")
+)
+
+// SigningKeys encodes tokens and names the algorithms it signs with.
+type SigningKeys interface {
+	TokenEncoder
+	Algorithms() []string
+}
+
+type OIDCService struct {
+	db          *gorm.DB
+	keys        SigningKeys
+	userService *users.UserService
+	issuer      string
+	logger      *zap.Logger
+	now         func() time.Time
+}
+
+type OIDCServiceParams struct {
+	DB          *gorm.DB
+	Keys        SigningKeys
+	UserService *users.UserService
+	// Issuer is the public base URL of this app, e.g. https://accounts.example.com
+	Issuer string
+	Logger *zap.Logger
+}
+
+func NewOIDCService(p OIDCServiceParams) *OIDCService {
+
+	p.DB.AutoMigrate(&OIDCClientModel{}, &OIDCConsentModel{}, &OIDCCodeModel{}, &OIDCRefreshTokenModel{})
+
+	return &OIDCService{
+		db:          p.DB,
+		keys:        p.Keys,
+		userService: p.UserService,
+		issuer:      strings.TrimSuffix(p.Issuer, "/"),
+		logger:      p.Logger,
+		now:         time.Now,
+	}
+}
+
+func (s *OIDCService) Issuer() string {
+	return s.issuer
+}
+
+func (s *OIDCService) Algorithms() []string {
+	return s.keys.Algorithms()
+}
+
+// RegisterClient adds a client. Confidential clients get a secret, which is
+// returned once and stored hashed.
+func (s *OIDCService) RegisterClient(name string, redirectURIs []string, confidential bool) (*OIDCClientModel, string, error) {
+	id, err := randomToken(16)
+	if err != nil {
+		return nil, "", err
+	}
+
+	client := &OIDCClientModel{
+		ID:           id,
+		Name:         name,
+		RedirectURIs: strings.Join(redirectURIs, " "),
+	}
+
+	var secret string
+	if confidential {
+		secret, err = randomToken(32)
+		if err != nil {
+			return nil, "", err
+		}
+		client.SecretHash = hashToken(secret)
+	}
+
+	if err := s.db.Create(client).Error; err != nil {
+		return nil, "", fmt.Errorf("creating oidc client: %w", err)
+	}
+
+	return client, secret, nil
+}
+
+func (s *OIDCService) FindClient(id string) (*OIDCClientModel, error) {
+	var client OIDCClientModel
+	err := s.db.Where("id = ?", id).First(&client).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrClientNotFound
+	}
+	return &client, err
+}
+
+// AuthenticateClient checks the credentials a client sent to the token
+// endpoint. Public clients send no secret.
+func (s *OIDCService) AuthenticateClient(id, secret string) (*OIDCClientModel, error) {
+	client, err := s.FindClient(id)
+	if errors.Is(err, ErrClientNotFound) {
+		return nil, ErrInvalidClient
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if client.Public() {
+		if secret != "" {
+			return nil, ErrInvalidClient
+		}
+		return client, nil
+	}
+
+	if subtle.ConstantTimeCompare([]byte(client.SecretHash), []byte(hashToken(secret))) != 1 {
+		return nil, ErrInvalidClient
+	}
+	return client, nil
+}
+
+// HasConsent reports whether the user already granted the client all scopes.
+func (s *OIDCService) HasConsent(userID uuid.UUID, clientID string, scopes []string) (bool, error) {
+	var consent OIDCConsentModel
+	err := s.db.Where("user_id = ? AND client_id = ?", userID, clientID).First(&consent).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	granted := strings.Fields(consent.Scopes)
+	for _, scope := range scopes {
+		if !contains(granted, scope) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// SaveConsent adds scopes to those the user granted the client.
+func (s *OIDCService) SaveConsent(userID uuid.UUID, clientID string, scopes []string) error {
+	var consent OIDCConsentModel
+	err := s.db.Where("user_id = ? AND client_id = ?", userID, clientID).First(&consent).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+
+	granted := strings.Fields(consent.Scopes)
+	for _, scope := range scopes {
+		if !contains(granted, scope) {
+			granted = append(granted, scope)
+		}
+	}
+
+	return s.db.Clauses(clause.OnConflict{UpdateAll: true}).Create(&OIDCConsentModel{
+		UserID:   userID,
+		ClientID: clientID,
+		Scopes:   strings.Join(granted, " "),
+	}).Error
+}
+
+// AuthorizationRequest is what the user agreed to on the authorization
+// endpoint.
+type AuthorizationRequest struct {
+	Client        *OIDCClientModel
+	UserID        uuid.UUID
+	RedirectURI   string
+	Scopes        []string
+	Nonce         string
+	CodeChallenge string
+	AuthTime      time.Time
+}
+
+// CreateCode returns a single use authorization code for the request.
+func (s *OIDCService) CreateCode(req AuthorizationRequest) (string, error) {
+	code, err := randomToken(32)
+	if err != nil {
+		return "", err
+	}
+
+	now := s.now()
+
+	// expired codes are useless, clear them out on the way
+	if err := s.db.Where("expires_at < ?", now.Add(-time.Hour)).Delete(&OIDCCodeModel{}).Error; err != nil {
+		s.logger.Warn("Error deleting expired authorization codes", zap.Error(err))
+	}
+
+	err = s.db.Create(&OIDCCodeModel{
+		Hash:          hashToken(code),
+		ClientID:      req.Client.ID,
+		UserID:        req.UserID,
+		RedirectURI:   req.RedirectURI,
+		Scopes:        strings.Join(req.Scopes, " "),
+		Nonce:         req.Nonce,
+		CodeChallenge: req.CodeChallenge,
+		FamilyID:      uuid.New(),
+		AuthTime:      req.AuthTime,
+		ExpiresAt:     now.Add(codeTTL),
+	}).Error
+	if err != nil {
+		return "", fmt.Errorf("creating authorization code: %w", err)
+	}
+
+	return code, nil
+}
+
+// ExchangeCode trades an authorization code for tokens. A code that is used
+// twice revokes the refresh tokens issued the first time.
+func (s *OIDCService) ExchangeCode(client *OIDCClientModel, code, redirectURI, verifier string) (*TokenResponse, error) {
+	var model OIDCCodeModel
+	err := s.db.Where("hash = ?", hashToken(code)).First(&model).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrInvalidGrant
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	now := s.now()
+
+	// mark the code used before checking it, so it can't be raced
+	result := s.db.Model(&OIDCCodeModel{}).Where("hash = ? AND used_at IS NULL", model.Hash).Update("used_at", now)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		s.logger.Warn("Authorization code reused, revoking its tokens", zap.String("client_id", model.ClientID))
+		if err := s.revokeFamily(model.FamilyID); err != nil {
+			return nil, err
+		}
+		return nil, ErrInvalidGrant
+	}
+
+	if model.ClientID != client.ID || model.RedirectURI != redirectURI || !now.Before(model.ExpiresAt) {
+		return nil, ErrInvalidGrant
+	}
+
+	if subtle.ConstantTimeCompare([]byte(model.CodeChallenge), []byte(codeChallenge(verifier))) != 1 {
+		return nil, ErrInvalidGrant
+	}
+
+	return s.issue(client, model.UserID, strings.Fields(model.Scopes), model.Nonce, model.AuthTime, model.FamilyID)
+}
+
+// Refresh trades a refresh token for new tokens and a new refresh token.
+// scopes may narrow the original scopes, empty keeps them.
+func (s *OIDCService) Refresh(client *OIDCClientModel, token string, scopes []string) (*TokenResponse, error) {
+	var model OIDCRefreshTokenModel
+	err := s.db.Where("hash = ?", hashToken(token)).First(&model).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrInvalidGrant
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if model.ClientID != client.ID {
+		return nil, ErrInvalidGrant
+	}
+
+	now := s.now()
+
+	result := s.db.Model(&OIDCRefreshTokenModel{}).Where("id = ? AND used_at IS NULL", model.ID).Update("used_at", now)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		s.logger.Warn("Refresh token reused, revoking its family", zap.String("client_id", model.ClientID))
+		if err := s.revokeFamily(model.FamilyID); err != nil {
+			return nil, err
+		}
+		return nil, ErrInvalidGrant
+	}
+
+	if model.RevokedAt != nil || !now.Before(model.ExpiresAt) {
+		return nil, ErrInvalidGrant
+	}
+
+	// the client keeps no more access than the user, once suspended or
+	// deleted
+	if err := s.userService.StatusError(context.Background(), model.UserID); err != nil {
+		s.logger.Info("Refresh for a blocked account", zap.Stringer("user", model.UserID), zap.Error(err))
+		return nil, ErrInvalidGrant
+	}
+
+	granted := strings.Fields(model.Scopes)
+	if len(scopes) == 0 {
+		scopes = granted
+	}
+	for _, scope := range scopes {
+		if !contains(granted, scope) {
+			return nil, ErrInvalidGrant
+		}
+	}
+
+	return s.issue(client, model.UserID, scopes, "", model.AuthTime, model.FamilyID)
+}
+
+func (s *OIDCService) revokeFamily(familyID uuid.UUID) error {
+	return s.db.Model(&OIDCRefreshTokenModel{}).
+		Where("family_id = ? AND revoked_at IS NULL", familyID).
+		Update("revoked_at", s.now()).Error
+}
+
+// issue signs an access token and an ID token, with a refresh token when the
+// client was granted offline_access.
+func (s *OIDCService) issue(client *OIDCClientModel, userID uuid.UUID, scopes []string, nonce string, authTime time.Time, familyID uuid.UUID) (*TokenResponse, error) {
+	user, err := s.userService.FindUserByID(userID)
+	if err != nil {
+		// the account is gone
+		return nil, ErrInvalidGrant
+	}
+
+	now := s.now()
+	scope := strings.Join(scopes, " ")
+
+	// access tokens have no id claim, so they are never taken for a session
+	_, accessToken, err := s.keys.Encode(map[string]interface{}{
+		"iss":       s.issuer,
+		"sub":       user.ID.String(),
+		"aud":       s.issuer,
+		"client_id": client.ID,
+		"scope":     scope,
+		"iat":       now.Unix(),
+		"exp":       now.Add(accessTokenTTL).Unix(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("signing access token: %w", err)
+	}
+
+	idClaims := map[string]interface{}{
+		"iss":       s.issuer,
+		"sub":       user.ID.String(),
+		"aud":       client.ID,
+		"iat":       now.Unix(),
+		"exp":       now.Add(accessTokenTTL).Unix(),
+		"auth_time": authTime.Unix(),
+	}
+	if nonce != "" {
+		idClaims["nonce"] = nonce
+	}
+	for name, value := range userClaims(user, scopes) {
+		idClaims[name] = value
+	}
+
+	_, idToken, err := s.keys.Encode(idClaims)
+	if err != nil {
+		return nil, fmt.Errorf("signing id token: %w", err)
+	}
+
+	res := &TokenResponse{
+		AccessToken: accessToken,
+		TokenType:   "Bearer",
+		ExpiresIn:   int(accessTokenTTL.Seconds()),
+		IDToken:     idToken,
+		Scope:       scope,
+	}
+
+	if contains(scopes, ScopeOfflineAccess) {
+		refreshToken, err := randomToken(32)
+		if err != nil {
+			return nil, err
+		}
+
+		err = s.db.Create(&OIDCRefreshTokenModel{
+			ID:        uuid.New(),
+			Hash:      hashToken(refreshToken),
+			FamilyID:  familyID,
+			ClientID:  client.ID,
+			UserID:    user.ID,
+			Scopes:    scope,
+			AuthTime:  authTime,
+			ExpiresAt: now.Add(refreshTokenTTL),
+		}).Error
+		if err != nil {
+			return nil, fmt.Errorf("creating refresh token: %w", err)
+		}
+
+		res.RefreshToken = refreshToken
+	}
+
+	return res, nil
+}
+
+// UserInfo returns the claims about the user that scopes allow.
+func (s *OIDCService) UserInfo(userID uuid.UUID, scopes []string) (*userInfoResponse, error) {
+	user, err := s.userService.FindUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	// access tokens outlive a suspension, the client gets no more than the
+	// user, as with Refresh
+	if err := s.userService.StatusError(context.Background(), user.ID); err != nil {
+		return nil, err
+	}
+
+	claims := userClaims(user, scopes)
+
+	res := &userInfoResponse{Subject: user.ID.String()}
+	res.Email, _ = claims["email"].(string)
+	res.Locale, _ = claims["locale"].(string)
+
+	return res, nil
+}
+
+func userClaims(user *users.UserModel, scopes []string) map[string]interface{} {
+	claims := map[string]interface{}{}
+	if contains(scopes, ScopeEmail) {
+		claims["email"] = user.Email
+	}
+	if contains(scopes, ScopeProfile) && user.Locale != "" {
+		claims["locale"] = user.Locale
+	}
+	return claims
+}
+
+// codeChallenge is the S256 PKCE challenge of verifier.
+func codeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// randomToken returns n random bytes, base64url encoded.
+func randomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,263 @@
+//go:build unit
+// +build unit
+
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/stretchr/testify/assert"
+	"github.com/tomdoestech/goth/internal/pkg/i18n"
+	"github.com/tomdoestech/goth/internal/pkg/identity"
+	"github.com/tomdoestech/goth/internal/pkg/keyring"
+	users "github.com/tomdoestech/goth/internal/user"
+	"github.com/tomdoestech/goth/internal/web"
+	"go.uber.org/zap"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func newTestOIDC(t *testing.T) (*OIDCService, *users.UserModel, *keyring.Keyring) {
+	filename := TempFilename(t)
+	t.Cleanup(func() { os.Remove(filename) })
+
+	db, err := gorm.Open(sqlite.Open(filename), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	usersService := users.NewUserService(users.UserServiceParams{
+		Logger:   zap.NewNop(),
+		Validate: validator.New(),
+		DB:       db,
+	})
+	user, err := usersService.CreateUser("test@example.com", "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	private, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := keyring.New(keyring.Params{Key: private})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return NewOIDCService(OIDCServiceParams{
+		DB:          db,
+		Keys:        keys,
+		UserService: usersService,
+		Issuer:      "https://accounts.example.com/",
+		Logger:      zap.NewNop(),
+	}), user, keys
+}
+
+func TestOIDCCodeFlow(t *testing.T) {
+	assert := assert.New(t)
+
+	s, user, keys := newTestOIDC(t)
+
+	client, secret, err := s.RegisterClient("Wiki", []string{"https://wiki.example.com/callback"}, true)
+	assert.NoError(err)
+
+	_, err = s.AuthenticateClient(client.ID, "wrong")
+	assert.ErrorIs(err, ErrInvalidClient)
+	client, err = s.AuthenticateClient(client.ID, secret)
+	assert.NoError(err)
+
+	verifier := "a-long-random-verifier-a-long-random-verifier"
+	req := AuthorizationRequest{
+		Client:        client,
+		UserID:        user.ID,
+		RedirectURI:   "https://wiki.example.com/callback",
+		Scopes:        []string{ScopeOpenID, ScopeEmail, ScopeOfflineAccess},
+		Nonce:         "n-0S6",
+		CodeChallenge: codeChallenge(verifier),
+	}
+
+	code, err := s.CreateCode(req)
+	assert.NoError(err)
+
+	_, err = s.ExchangeCode(client, code, req.RedirectURI, "wrong verifier")
+	assert.ErrorIs(err, ErrInvalidGrant, "a failed exchange uses up the code")
+
+	code, err = s.CreateCode(req)
+	assert.NoError(err)
+
+	res, err := s.ExchangeCode(client, code, req.RedirectURI, verifier)
+	if !assert.NoError(err) {
+		return
+	}
+	assert.Equal("Bearer", res.TokenType)
+	assert.NotEmpty(res.RefreshToken)
+
+	idToken, err := keys.Decode(res.IDToken)
+	if assert.NoError(err) {
+		assert.Equal([]string{client.ID}, idToken.Audience())
+		assert.Equal(user.ID.String(), idToken.Subject())
+		assert.Equal("https://accounts.example.com", idToken.Issuer())
+		nonce, _ := idToken.Get("nonce")
+		assert.Equal("n-0S6", nonce)
+		email, _ := idToken.Get("email")
+		assert.Equal("test@example.com", email)
+	}
+
+	accessToken, err := keys.Decode(res.AccessToken)
+	if assert.NoError(err) {
+		_, hasID := accessToken.Get("id")
+		assert.False(hasID, "access tokens must not work as sessions")
+	}
+
+	// a code works once, using it again revokes what it was exchanged for
+	_, err = s.ExchangeCode(client, code, req.RedirectURI, verifier)
+	assert.ErrorIs(err, ErrInvalidGrant)
+	_, err = s.Refresh(client, res.RefreshToken, nil)
+	assert.ErrorIs(err, ErrInvalidGrant)
+}
+
+func TestOIDCRefreshRotation(t *testing.T) {
+	assert := assert.New(t)
+
+	s, user, _ := newTestOIDC(t)
+
+	client, _, err := s.RegisterClient("SPA", []string{"https://spa.example.com/"}, false)
+	assert.NoError(err)
+	assert.True(client.Public())
+
+	first, err := s.issue(client, user.ID, []string{ScopeOpenID, ScopeOfflineAccess}, "", s.now(), [16]byte{1})
+	assert.NoError(err)
+
+	other, _, err := s.RegisterClient("Other", []string{"https://other.example.com/"}, false)
+	assert.NoError(err)
+	_, err = s.Refresh(other, first.RefreshToken, nil)
+	assert.ErrorIs(err, ErrInvalidGrant, "refresh tokens are bound to their client")
+
+	_, err = s.Refresh(client, first.RefreshToken, []string{ScopeOpenID, ScopeEmail})
+	assert.ErrorIs(err, ErrInvalidGrant, "refreshing can't add scopes")
+
+	second, err := s.issue(client, user.ID, []string{ScopeOpenID, ScopeOfflineAccess}, "", s.now(), [16]byte{2})
+	assert.NoError(err)
+
+	rotated, err := s.Refresh(client, second.RefreshToken, nil)
+	assert.NoError(err)
+	assert.NotEqual(second.RefreshToken, rotated.RefreshToken)
+
+	// replaying the old token revokes the new one too
+	_, err = s.Refresh(client, second.RefreshToken, nil)
+	assert.ErrorIs(err, ErrInvalidGrant)
+	_, err = s.Refresh(client, rotated.RefreshToken, nil)
+	assert.ErrorIs(err, ErrInvalidGrant)
+
+	third, err := s.issue(client, user.ID, []string{ScopeOpenID, ScopeOfflineAccess}, "", s.now(), [16]byte{3})
+	assert.NoError(err)
+	_, err = s.UserInfo(user.ID, []string{ScopeOpenID, ScopeEmail})
+	assert.NoError(err)
+	assert.NoError(s.userService.Suspend(user.ID, "", nil))
+	_, err = s.UserInfo(user.ID, []string{ScopeOpenID, ScopeEmail})
+	assert.Error(err, "no profile for suspended users")
+	_, err = s.Refresh(client, third.RefreshToken, nil)
+	assert.ErrorIs(err, ErrInvalidGrant, "suspended users' clients can't refresh")
+}
+
+func TestOIDCAuthorize(t *testing.T) {
+	s, user, _ := newTestOIDC(t)
+
+	client, _, err := s.RegisterClient("Wiki", []string{"https://wiki.example.com/callback"}, true)
+	assert.NoError(t, err)
+
+	bundle, err := i18n.NewBundle(i18n.BundleParams{Validate: validator.New()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := NewOIDCHandler(OIDCHandlerParams{OIDCService: s, I18n: bundle, Logger: zap.NewNop()})
+	errorHandler := web.NewErrorHandler(web.ErrorHandlerParams{I18n: bundle, Logger: zap.NewNop()})
+
+	valid := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {client.ID},
+		"redirect_uri":          {"https://wiki.example.com/callback"},
+		"scope":                 {"openid email"},
+		"state":                 {"xyz"},
+		"code_challenge":        {codeChallenge("verifier")},
+		"code_challenge_method": {"S256"},
+	}
+	with := func(name, value string) url.Values {
+		v := url.Values{}
+		for k, vs := range valid {
+			v[k] = vs
+		}
+		v.Set(name, value)
+		return v
+	}
+
+	session := &identity.Principal{UserID: user.ID, Method: identity.MethodCookie}
+
+	testCases := []struct {
+		description      string
+		method           string
+		query            url.Values
+		principal        *identity.Principal
+		htmx             bool
+		expectedStatus   int
+		expectedLocation string
+	}{
+		{"unknown client", "GET", with("client_id", "nope"), session, false, 400, ""},
+		{"unregistered redirect", "GET", with("redirect_uri", "https://evil.example.com/"), session, false, 400, ""},
+		{"no PKCE", "GET", with("code_challenge_method", "plain"), session, false, 302, "error=invalid_request"},
+		{"no openid scope", "GET", with("scope", "email"), session, false, 302, "error=invalid_scope"},
+		{"anonymous", "GET", valid, nil, false, 302, "/login?next="},
+		{"anonymous - prompt none", "GET", with("prompt", "none"), nil, false, 302, "error=login_required"},
+		{"consent screen", "GET", valid, session, false, 200, ""},
+		{"consent - not htmx", "POST", with("decision", "allow"), session, false, 403, ""},
+		{"consent - deny", "POST", with("decision", "deny"), session, true, 200, "error=access_denied"},
+		{"consent - allow", "POST", with("decision", "allow"), session, true, 200, "code="},
+		{"consent remembered", "GET", valid, session, false, 302, "code="},
+		{"consent remembered - prompt consent", "GET", with("prompt", "consent"), session, false, 200, ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			assert := assert.New(t)
+
+			var req *http.Request
+			handler := h.Authorize
+			if tc.method == "POST" {
+				req = httptest.NewRequest("POST", "/oauth/authorize", strings.NewReader(tc.query.Encode()))
+				req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+				handler = h.Consent
+			} else {
+				req = httptest.NewRequest("GET", "/oauth/authorize?"+tc.query.Encode(), nil)
+			}
+			if tc.htmx {
+				req.Header.Set("HX-Request", "true")
+			}
+			if tc.principal != nil {
+				req = req.WithContext(identity.NewContext(req.Context(), tc.principal))
+			}
+
+			w := httptest.NewRecorder()
+			errorHandler.Handle(handler)(w, req)
+
+			assert.Equal(tc.expectedStatus, w.Code)
+
+			location := w.Header().Get("Location") + w.Header().Get("HX-Redirect")
+			if tc.expectedLocation != "" {
+				assert.Contains(location, tc.expectedLocation)
+			}
+			if strings.HasPrefix(location, "https://wiki.example.com/callback") {
+				assert.Contains(location, "state=xyz")
+			}
+		})
+	}
+}
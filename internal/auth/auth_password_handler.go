@@ -0,0 +1,276 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/google/uuid"
+	"github.com/tomdoestech/goth/internal/pkg/apperror"
+	"github.com/tomdoestech/goth/internal/pkg/i18n"
+	"github.com/tomdoestech/goth/internal/pkg/identity"
+	"github.com/tomdoestech/goth/internal/pkg/password"
+	users "github.com/tomdoestech/goth/internal/user"
+	"github.com/tomdoestech/goth/internal/web"
+	"go.uber.org/zap"
+)
+
+// PasswordHandler lets users change their password and shows how strong a
+// new password is while they type it.
+type PasswordHandler struct {
+	authService *AuthService
+	userService *users.UserService
+	policy      *password.Policy
+	validate    *validator.Validate
+	i18n        *i18n.Bundle
+	logger      *zap.Logger
+}
+
+type PasswordHandlerParams struct {
+	AuthService *AuthService
+	UserService *users.UserService
+	// Policy defaults to password.DefaultPolicy(), use the same one as the
+	// auth handler
+	Policy   *password.Policy
+	Validate *validator.Validate
+	I18n     *i18n.Bundle
+	Logger   *zap.Logger
+}
+
+type passwordStrengthData struct {
+	// Email is the address being registered, the logged in user's otherwise
+	Email    string `json:"email,omitempty"`
+	Password string `json:"password"`
+}
+
+type passwordStrengthResponse struct {
+	// Score goes from 0, guessed almost straight away, to 4
+	Score       int      `json:"score"`
+	Warning     string   `json:"warning,omitempty"`
+	Suggestions []string `json:"suggestions,omitempty"`
+	// Problems are the rules of the password policy it breaks
+	Problems   []string `json:"problems,omitempty"`
+	Acceptable bool     `json:"acceptable"`
+}
+
+type changePasswordData struct {
+	CurrentPassword string `json:"current_password" validate:"required"`
+	Password        string `json:"password" validate:"required"`
+}
+
+func NewPasswordHandler(p PasswordHandlerParams) *PasswordHandler {
+	if p.Policy == nil {
+		p.Policy = password.DefaultPolicy()
+	}
+
+	return &PasswordHandler{
+		authService: p.AuthService,
+		userService: p.UserService,
+		policy:      p.Policy,
+		validate:    p.Validate,
+		i18n:        p.I18n,
+		logger:      p.Logger,
+	}
+}
+
+// checkPassword applies policy to a new password for the user with email,
+// returning a validation error listing the rules it breaks. Passwords of
+// userID's history are rejected too, uuid.Nil skips that for new users.
+func checkPassword(ctx context.Context, loc *i18n.Localizer, policy *password.Policy, userService *users.UserService, pw string, email string, userID uuid.UUID) error {
+	_, violations, err := policy.Check(ctx, pw, email)
+	if err != nil {
+		return apperror.Internal(fmt.Errorf("checking password: %w", err))
+	}
+
+	if len(violations) == 0 && userID != uuid.Nil {
+		reused, err := userService.PasswordReused(userID, pw)
+		if err != nil {
+			return apperror.Internal(fmt.Errorf("checking password history: %w", err))
+		}
+		if reused {
+			violations = append(violations, password.Violation{Rule: password.RuleReused})
+		}
+	}
+
+	if len(violations) == 0 {
+		return nil
+	}
+
+	details := violationMessages(loc, violations)
+	appErr := apperror.Validation(loc.T("error.validation.title"), details)
+	appErr.Fields = map[string]string{"password": details[0]}
+
+	return appErr
+}
+
+// joinValidation merges two validation errors into one. Any other error is
+// returned as is.
+func joinValidation(a, b error) error {
+	if a == nil {
+		return b
+	}
+	if b == nil {
+		return a
+	}
+
+	var errA, errB *apperror.Error
+	if !errors.As(a, &errA) || errA.Kind != apperror.KindValidation {
+		return a
+	}
+	if !errors.As(b, &errB) || errB.Kind != apperror.KindValidation {
+		return b
+	}
+
+	joined := apperror.Validation(errA.Message, append(append([]string{}, errA.Details...), errB.Details...))
+	joined.Fields = map[string]string{}
+	for _, fields := range []map[string]string{errB.Fields, errA.Fields} {
+		for name, message := range fields {
+			joined.Fields[name] = message
+		}
+	}
+
+	return joined
+}
+
+func violationMessages(loc *i18n.Localizer, violations []password.Violation) []string {
+	messages := make([]string, 0, len(violations))
+	for _, v := range violations {
+		switch v.Rule {
+		case password.RuleMinLength, password.RuleMaxLength:
+			messages = append(messages, loc.T(v.Rule, v.Value))
+		default:
+			messages = append(messages, loc.T(v.Rule))
+		}
+	}
+	return messages
+}
+
+// Strength estimates a password being typed into the register or change
+// password form. htmx gets the meter to swap in below the field.
+func (h *PasswordHandler) Strength(w http.ResponseWriter, r *http.Request) error {
+	loc := h.i18n.FromRequest(r)
+
+	var data passwordStrengthData
+	if err := web.Decode(r, &data); err != nil {
+		return err
+	}
+
+	if data.Email == "" {
+		if p, ok := identity.FromContext(r.Context()); ok {
+			data.Email = p.Email
+		}
+	}
+
+	strength, violations, err := h.policy.Check(r.Context(), data.Password, data.Email)
+	if err != nil {
+		return apperror.Internal(fmt.Errorf("checking password: %w", err))
+	}
+
+	res := passwordStrengthResponse{
+		Score:      strength.Score,
+		Problems:   violationMessages(loc, violations),
+		Acceptable: len(violations) == 0,
+	}
+	if strength.Warning != "" {
+		res.Warning = loc.T(strength.Warning)
+	}
+	for _, s := range strength.Suggestions {
+		res.Suggestions = append(res.Suggestions, loc.T(s))
+	}
+
+	if web.WantsJSON(r) {
+		return web.WriteJSON(w, http.StatusOK, res)
+	}
+
+	web.RenderBlock(w, r, "register.html", "password-strength", http.StatusOK, map[string]interface{}{
+		"Typed":    data.Password != "",
+		"Strength": res,
+	})
+
+	return nil
+}
+
+// principal returns the logged in user. Passwords are changed with a
+// session only, and not by an admin impersonating the user.
+func (h *PasswordHandler) principal(r *http.Request) (*identity.Principal, error) {
+	loc := h.i18n.FromRequest(r)
+
+	p, ok := identity.FromContext(r.Context())
+	if !ok {
+		return nil, apperror.Unauthorized(loc.T("error.unauthorized.message"), nil)
+	}
+	if p.Method == identity.MethodAPIKey {
+		return nil, apperror.Forbidden(loc.T("password.session_required"))
+	}
+	if p.Impersonated() {
+		return nil, apperror.Forbidden(loc.T("impersonate.blocked"))
+	}
+	return p, nil
+}
+
+func (h *PasswordHandler) Page(w http.ResponseWriter, r *http.Request) error {
+	if _, err := h.principal(r); err != nil {
+		return err
+	}
+
+	web.RenderTemplate(w, "password.html", map[string]interface{}{
+		"Title": "password.title",
+	}, r)
+
+	return nil
+}
+
+// Change replaces the user's password once they have confirmed the current
+// one.
+func (h *PasswordHandler) Change(w http.ResponseWriter, r *http.Request) error {
+	p, err := h.principal(r)
+	if err != nil {
+		return err
+	}
+
+	loc := h.i18n.FromRequest(r)
+
+	var data changePasswordData
+	if err := web.Decode(r, &data); err != nil {
+		return err
+	}
+
+	if err := h.validate.Struct(&data); err != nil {
+		return web.ValidationError(loc, &data, err)
+	}
+
+	user, err := h.userService.FindUserByID(p.UserID)
+	if err != nil {
+		return apperror.Unauthorized(loc.T("error.unauthorized.message"), fmt.Errorf("finding user: %w", err))
+	}
+
+	if err := h.authService.VerifyPassword(user.Password, data.CurrentPassword); err != nil {
+		appErr := apperror.Validation(loc.T("error.validation.title"), []string{loc.T("password.current_wrong")})
+		appErr.Fields = map[string]string{"current_password": loc.T("password.current_wrong")}
+		return appErr
+	}
+
+	if err := checkPassword(r.Context(), loc, h.policy, h.userService, data.Password, user.Email, user.ID); err != nil {
+		return err
+	}
+
+	if err := h.userService.ChangePassword(user.ID, data.Password); err != nil {
+		if errors.Is(err, password.ErrTooLong) {
+			return apperror.Validation(loc.T("error.validation.title"), []string{loc.T(password.RuleMaxLength, 72)})
+		}
+		return apperror.Internal(fmt.Errorf("changing password: %w", err))
+	}
+
+	if web.WantsJSON(r) {
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+
+	web.RenderBlock(w, r, "password.html", "password-form", http.StatusOK, map[string]interface{}{
+		"Changed": true,
+	})
+
+	return nil
+}
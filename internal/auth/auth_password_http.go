@@ -0,0 +1,58 @@
+package auth
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/tomdoestech/goth/internal/pkg/openapi"
+	"github.com/tomdoestech/goth/internal/web"
+)
+
+type PasswordHTTPParams struct {
+	PasswordHandler *PasswordHandler
+	ErrorHandler    *web.ErrorHandler
+	API             *openapi.Document
+	Mux             *chi.Mux
+}
+
+func NewPasswordHTTP(p PasswordHTTPParams) {
+
+	r := openapi.NewRouter(p.Mux, p.API)
+	e := p.ErrorHandler
+
+	r.Post("/api/password/strength", openapi.Operation{
+		Summary:      "Check a new password",
+		Description:  "Estimates how hard the password is to guess and lists the rules of the password policy it breaks. htmx gets a strength meter to show below the field.",
+		Tags:         []string{"password"},
+		Request:      passwordStrengthData{},
+		RequestTypes: formOrJSON,
+		Responses: map[int]openapi.Response{
+			http.StatusOK: {Description: "The strength of the password", Body: passwordStrengthResponse{}},
+		},
+		Errors: []int{http.StatusBadRequest},
+	}, e.Handle(p.PasswordHandler.Strength))
+
+	r.Get("/account/password", openapi.Operation{
+		Summary: "Change password page",
+		Tags:    []string{"password"},
+		Responses: map[int]openapi.Response{
+			http.StatusOK: {Description: "The change password form", ContentType: "text/html"},
+		},
+		Errors: []int{http.StatusUnauthorized, http.StatusForbidden},
+		Auth:   true,
+	}, e.Handle(p.PasswordHandler.Page))
+
+	r.Post("/api/account/password", openapi.Operation{
+		Summary:      "Change password",
+		Description:  "The new password must satisfy the password policy and differ from the previous ones kept in the password history.",
+		Tags:         []string{"password"},
+		Request:      changePasswordData{},
+		RequestTypes: formOrJSON,
+		Responses: map[int]openapi.Response{
+			http.StatusOK:        {Description: "Password changed, htmx gets a confirmation", ContentType: "text/html"},
+			http.StatusNoContent: {Description: "Password changed, for JSON clients"},
+		},
+		Errors: []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden},
+		Auth:   true,
+	}, e.Handle(p.PasswordHandler.Change))
+}
@@ -0,0 +1,144 @@
+//go:build unit
+// +build unit
+
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/stretchr/testify/assert"
+	"github.com/tomdoestech/goth/internal/pkg/i18n"
+	"github.com/tomdoestech/goth/internal/pkg/identity"
+	"github.com/tomdoestech/goth/internal/pkg/password"
+	users "github.com/tomdoestech/goth/internal/user"
+	"github.com/tomdoestech/goth/internal/web"
+	"go.uber.org/zap"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestChangePassword(t *testing.T) {
+	filename := TempFilename(t)
+	defer os.Remove(filename)
+
+	db, err := gorm.Open(sqlite.Open(filename), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	validate := validator.New()
+	bundle, err := i18n.NewBundle(i18n.BundleParams{Validate: validate})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hasher := password.NewArgon2id(password.Argon2idParams{Memory: 64, Time: 1, Parallelism: 1})
+	usersService := users.NewUserService(users.UserServiceParams{
+		Logger:          zap.NewNop(),
+		Validate:        validate,
+		DB:              db,
+		Hasher:          hasher,
+		PasswordHistory: 2,
+	})
+	user, err := usersService.CreateUser("test@example.com", "tangerine-otter-89-lamp")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	passwordHandler := NewPasswordHandler(PasswordHandlerParams{
+		AuthService: NewAuthService(AuthServiceParams{Logger: zap.NewNop(), Hasher: hasher}),
+		UserService: usersService,
+		Validate:    validate,
+		I18n:        bundle,
+		Logger:      zap.NewNop(),
+	})
+	errorHandler := web.NewErrorHandler(web.ErrorHandlerParams{I18n: bundle, Logger: zap.NewNop()})
+
+	principal := &identity.Principal{UserID: user.ID, Email: user.Email, Method: identity.MethodCookie}
+
+	current := "tangerine-otter-89-lamp"
+	change := func(from, to string) *httptest.ResponseRecorder {
+		form := url.Values{"current_password": {from}, "password": {to}}
+		req := httptest.NewRequest("POST", "/api/account/password", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req = req.WithContext(identity.NewContext(req.Context(), principal))
+		w := httptest.NewRecorder()
+		bundle.Middleware(errorHandler.Handle(passwordHandler.Change)).ServeHTTP(w, req)
+		if w.Code == http.StatusOK {
+			current = to
+		}
+		return w
+	}
+
+	testCases := []struct {
+		description string
+		from        string
+		to          string
+		status      int
+		body        string
+	}{
+		{"wrong current password", "wrongpassword", "velvet-harbor-42-moss", http.StatusBadRequest, "Your current password is wrong"},
+		{"too weak", current, "password1", http.StatusBadRequest, "Password is too easy to guess"},
+		{"same password", current, "tangerine-otter-89-lamp", http.StatusBadRequest, "haven’t used recently"},
+		{"new password", current, "velvet-harbor-42-moss", http.StatusOK, "Your password has been changed."},
+		{"previous password", "velvet-harbor-42-moss", "tangerine-otter-89-lamp", http.StatusBadRequest, "haven’t used recently"},
+		{"another new password", "velvet-harbor-42-moss", "quiet-lantern-17-fjord", http.StatusOK, "Your password has been changed."},
+		{"third new password", "quiet-lantern-17-fjord", "amber-walrus-63-spoon", http.StatusOK, "Your password has been changed."},
+		{"older than the history", "amber-walrus-63-spoon", "tangerine-otter-89-lamp", http.StatusOK, "Your password has been changed."},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			w := change(tc.from, tc.to)
+			assert.Equal(t, tc.status, w.Code)
+			assert.Contains(t, w.Body.String(), tc.body)
+		})
+	}
+
+	stored, err := usersService.FindUserByID(user.ID)
+	if assert.NoError(t, err) {
+		assert.NoError(t, hasher.Verify(current, stored.Password))
+	}
+}
+
+func TestPasswordStrength(t *testing.T) {
+	bundle, err := i18n.NewBundle(i18n.BundleParams{Validate: validator.New()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	passwordHandler := NewPasswordHandler(PasswordHandlerParams{I18n: bundle, Logger: zap.NewNop()})
+	errorHandler := web.NewErrorHandler(web.ErrorHandlerParams{I18n: bundle, Logger: zap.NewNop()})
+
+	strength := func(form url.Values, accept string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/api/password/strength", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Accept", accept)
+		w := httptest.NewRecorder()
+		bundle.Middleware(errorHandler.Handle(passwordHandler.Strength)).ServeHTTP(w, req)
+		return w
+	}
+
+	w := strength(url.Values{"email": {"jane@example.com"}, "password": {"jane1990"}}, "application/json")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var res passwordStrengthResponse
+	if assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &res)) {
+		assert.False(t, res.Acceptable)
+		assert.Equal(t, "This is based on your email address.", res.Warning)
+		assert.Contains(t, res.Problems, "Password must not contain your email address")
+	}
+
+	w = strength(url.Values{"password": {"tangerine-otter-89-lamp"}}, "text/html")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `id="password-strength"`)
+	assert.Contains(t, w.Body.String(), `aria-valuenow="4"`)
+	assert.Contains(t, w.Body.String(), "Very strong")
+}
@@ -0,0 +1,40 @@
+package auth
+
+import "strings"
+
+// RegistrationMode decides who may create an account without an invitation.
+// Invitations work in every mode but closed.
+type RegistrationMode string
+
+const (
+	// RegistrationOpen lets anyone register
+	RegistrationOpen RegistrationMode = "open"
+	// RegistrationInvite only lets invited addresses register
+	RegistrationInvite RegistrationMode = "invite"
+	// RegistrationClosed doesn't let anyone register, invited or not
+	RegistrationClosed RegistrationMode = "closed"
+	// RegistrationDomain lets addresses of the allowed domains register
+	RegistrationDomain RegistrationMode = "domain"
+)
+
+// allowsEmail reports whether email may register without an invitation.
+func (m RegistrationMode) allowsEmail(email string, domains []string) bool {
+	switch m {
+	case RegistrationOpen:
+		return true
+	case RegistrationDomain:
+		at := strings.LastIndex(email, "@")
+		if at < 0 {
+			return false
+		}
+		domain := email[at+1:]
+		for _, d := range domains {
+			if strings.EqualFold(domain, strings.TrimPrefix(d, "@")) {
+				return true
+			}
+		}
+		return false
+	default:
+		return false
+	}
+}
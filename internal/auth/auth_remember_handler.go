@@ -0,0 +1,251 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/jwtauth/v5"
+	"github.com/google/uuid"
+	"github.com/tomdoestech/goth/internal/pkg/apperror"
+	"github.com/tomdoestech/goth/internal/pkg/i18n"
+	"github.com/tomdoestech/goth/internal/pkg/identity"
+	users "github.com/tomdoestech/goth/internal/user"
+	"github.com/tomdoestech/goth/internal/web"
+	"go.uber.org/zap"
+)
+
+// RememberHandler logs remembered browsers back in and lets users review
+// and forget them.
+type RememberHandler struct {
+	rememberService *RememberService
+	sessionService  *SessionService
+	authService     *AuthService
+	userService     *users.UserService
+	sessionMode     SessionMode
+	i18n            *i18n.Bundle
+	logger          *zap.Logger
+}
+
+type RememberHandlerParams struct {
+	RememberService *RememberService
+	// SessionService records the session started for a remembered browser,
+	// continuing the one it started before
+	SessionService *SessionService
+	AuthService    *AuthService
+	UserService    *users.UserService
+	// SessionMode defaults to SessionJWT
+	SessionMode SessionMode
+	I18n        *i18n.Bundle
+	Logger      *zap.Logger
+}
+
+func NewRememberHandler(p RememberHandlerParams) *RememberHandler {
+	if p.SessionMode == "" {
+		p.SessionMode = SessionJWT
+	}
+
+	return &RememberHandler{
+		rememberService: p.RememberService,
+		sessionService:  p.SessionService,
+		authService:     p.AuthService,
+		userService:     p.UserService,
+		sessionMode:     p.SessionMode,
+		i18n:            p.I18n,
+		logger:          p.Logger,
+	}
+}
+
+// Middleware starts a new session for remembered browsers whose session
+// cookie is gone or expired. It runs after the token is verified and before
+// the identity middleware, which then sees the new token.
+func (h *RememberHandler) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, _, err := jwtauth.FromContext(r.Context())
+		if (err == nil && token != nil) || jwtauth.TokenFromHeader(r) != "" || SessionPrincipal(r) != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cookie, cerr := r.Cookie(RememberCookie)
+		if cerr != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		value, model, err := h.rememberService.Use(cookie.Value, r.UserAgent(), web.ClientIP(r))
+		if err != nil {
+			if !errors.Is(err, ErrInvalidRememberToken) {
+				h.logger.Error("Error using remember token", zap.Error(err))
+			}
+			clearCookie(w, RememberCookie)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		user, err := h.userService.FindUserByID(model.UserID)
+		if err == nil {
+			// blocked users would get a session the identity middleware
+			// refuses, on every request, and the browser is remembered no
+			// longer once they are
+			err = h.userService.StatusError(r.Context(), user.ID)
+		}
+		if err != nil {
+			h.logger.Info("Remembered user can't log in", zap.Stringer("user", model.UserID), zap.Error(err))
+			if err := h.rememberService.Forget(cookie.Value); err != nil {
+				h.logger.Warn("Error forgetting browser", zap.Error(err))
+			}
+			clearCookie(w, RememberCookie)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		sessionID := uuid.Nil
+		if h.sessionService != nil {
+			session, err := h.sessionService.Start(user.ID, &model.ID, r.UserAgent(), web.ClientIP(r))
+			if err != nil {
+				h.logger.Error("Error starting session", zap.Error(err))
+				next.ServeHTTP(w, r)
+				return
+			}
+			sessionID = session.ID
+		}
+
+		if value != "" {
+			setRememberCookie(w, r, value, h.rememberService.TTL())
+		}
+
+		if h.sessionMode == SessionServer {
+			if err := logInSession(r, user, sessionID); err != nil {
+				h.logger.Error("Error storing session", zap.Error(err))
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		newToken, tokenString, err := h.authService.IssueToken(user, sessionID)
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		setTokenCookie(w, r, tokenString)
+
+		next.ServeHTTP(w, r.WithContext(jwtauth.NewContext(r.Context(), newToken, nil)))
+	})
+}
+
+// principal returns the logged in user. Devices are managed with a session
+// only.
+func (h *RememberHandler) principal(r *http.Request) (*identity.Principal, error) {
+	loc := h.i18n.FromRequest(r)
+
+	p, ok := identity.FromContext(r.Context())
+	if !ok {
+		return nil, apperror.Unauthorized(loc.T("error.unauthorized.message"), nil)
+	}
+	if p.Method == identity.MethodAPIKey {
+		return nil, apperror.Forbidden(loc.T("devices.session_required"))
+	}
+	if p.Impersonated() {
+		return nil, apperror.Forbidden(loc.T("impersonate.blocked"))
+	}
+	return p, nil
+}
+
+// devices lists the user's remembered browsers, marking the one making the
+// request.
+func (h *RememberHandler) devices(r *http.Request, userID uuid.UUID) ([]RememberedDevice, error) {
+	tokens, err := h.rememberService.List(userID)
+	if err != nil {
+		return nil, apperror.Internal(fmt.Errorf("listing remembered devices: %w", err))
+	}
+
+	var current uuid.UUID
+	if cookie, err := r.Cookie(RememberCookie); err == nil {
+		if model := h.rememberService.Find(cookie.Value); model != nil {
+			current = model.ID
+		}
+	}
+
+	devices := make([]RememberedDevice, 0, len(tokens))
+	for i := range tokens {
+		devices = append(devices, NewRememberedDevice(&tokens[i], tokens[i].ID == current))
+	}
+	return devices, nil
+}
+
+// Page renders the remembered devices section of the account settings.
+func (h *RememberHandler) Page(w http.ResponseWriter, r *http.Request) error {
+	p, err := h.principal(r)
+	if err != nil {
+		return err
+	}
+
+	devices, err := h.devices(r, p.UserID)
+	if err != nil {
+		return err
+	}
+
+	web.RenderTemplate(w, "devices.html", map[string]interface{}{
+		"Title":   "devices.title",
+		"Devices": devices,
+	}, r)
+
+	return nil
+}
+
+func (h *RememberHandler) List(w http.ResponseWriter, r *http.Request) error {
+	p, err := h.principal(r)
+	if err != nil {
+		return err
+	}
+
+	devices, err := h.devices(r, p.UserID)
+	if err != nil {
+		return err
+	}
+
+	return web.WriteJSON(w, http.StatusOK, devices)
+}
+
+// Revoke forgets a remembered browser, which is logged out once its
+// current session ends.
+func (h *RememberHandler) Revoke(w http.ResponseWriter, r *http.Request) error {
+	p, err := h.principal(r)
+	if err != nil {
+		return err
+	}
+
+	loc := h.i18n.FromRequest(r)
+
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		return apperror.NotFound(loc.T("devices.not_found"))
+	}
+
+	err = h.rememberService.Revoke(p.UserID, id)
+	if errors.Is(err, ErrRememberTokenNotFound) {
+		return apperror.NotFound(loc.T("devices.not_found"))
+	}
+	if err != nil {
+		return apperror.Internal(fmt.Errorf("revoking remembered device: %w", err))
+	}
+
+	if web.WantsJSON(r) {
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+
+	devices, err := h.devices(r, p.UserID)
+	if err != nil {
+		return err
+	}
+
+	web.RenderBlock(w, r, "devices.html", "devices", http.StatusOK, map[string]interface{}{
+		"Devices": devices,
+	})
+
+	return nil
+}
@@ -0,0 +1,54 @@
+package auth
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/tomdoestech/goth/internal/pkg/openapi"
+	"github.com/tomdoestech/goth/internal/web"
+)
+
+type RememberHTTPParams struct {
+	RememberHandler *RememberHandler
+	ErrorHandler    *web.ErrorHandler
+	API             *openapi.Document
+	Mux             *chi.Mux
+}
+
+func NewRememberHTTP(p RememberHTTPParams) {
+
+	r := openapi.NewRouter(p.Mux, p.API)
+	e := p.ErrorHandler
+
+	r.Get("/account/devices", openapi.Operation{
+		Summary: "Remembered devices page",
+		Tags:    []string{"devices"},
+		Responses: map[int]openapi.Response{
+			http.StatusOK: {Description: "The browsers that stay logged in with remember me", ContentType: "text/html"},
+		},
+		Errors: []int{http.StatusUnauthorized, http.StatusForbidden},
+		Auth:   true,
+	}, e.Handle(p.RememberHandler.Page))
+
+	r.Get("/api/devices", openapi.Operation{
+		Summary: "List remembered devices",
+		Tags:    []string{"devices"},
+		Responses: map[int]openapi.Response{
+			http.StatusOK: {Description: "The devices, most recently used first", Body: []RememberedDevice{}},
+		},
+		Errors: []int{http.StatusUnauthorized, http.StatusForbidden},
+		Auth:   true,
+	}, e.Handle(p.RememberHandler.List))
+
+	r.Delete("/api/devices/{id}", openapi.Operation{
+		Summary:     "Forget a remembered device",
+		Description: "The device is logged out when its current session ends.",
+		Tags:        []string{"devices"},
+		Responses: map[int]openapi.Response{
+			http.StatusOK:        {Description: "Device forgotten, htmx gets the updated list", ContentType: "text/html"},
+			http.StatusNoContent: {Description: "Device forgotten, for JSON clients"},
+		},
+		Errors: []int{http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound},
+		Auth:   true,
+	}, e.Handle(p.RememberHandler.Revoke))
+}
@@ -0,0 +1,58 @@
+package auth
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// RememberTokenModel keeps a browser logged in after its session cookie is
+// gone. The cookie holds selector:validator; the selector finds the row and
+// only a hash of the validator is stored. The validator changes every time
+// the token is used.
+type RememberTokenModel struct {
+	ID        uuid.UUID `gorm:"primaryKey;type:uuid" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+
+	UserID   uuid.UUID `gorm:"type:uuid;index;not null" json:"-"`
+	Selector string    `gorm:"uniqueIndex;not null" json:"-"`
+	// Hash is the SHA-256 of the current validator
+	Hash string `gorm:"not null" json:"-"`
+	// PreviousHash is accepted for a moment after rotating, for requests the
+	// browser sent before it got the new cookie
+	PreviousHash string    `json:"-"`
+	RotatedAt    time.Time `json:"-"`
+
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+func (RememberTokenModel) TableName() string {
+	return "remember_tokens"
+}
+
+// RememberedDevice is a remembered browser as shown to its user.
+type RememberedDevice struct {
+	ID         uuid.UUID `json:"id"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	// Current is the browser making the request
+	Current bool `json:"current"`
+}
+
+func NewRememberedDevice(m *RememberTokenModel, current bool) RememberedDevice {
+	return RememberedDevice{
+		ID:         m.ID,
+		UserAgent:  m.UserAgent,
+		IP:         m.IP,
+		CreatedAt:  m.CreatedAt,
+		LastUsedAt: m.LastUsedAt,
+		ExpiresAt:  m.ExpiresAt,
+		Current:    current,
+	}
+}
@@ -0,0 +1,239 @@
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/tomdoestech/goth/internal/pkg/jobs"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// RememberCookie holds the remember-me token.
+const RememberCookie = "remember"
+
+// RememberPurgeJobKind is the kind of the job deleting expired tokens.
+const RememberPurgeJobKind = "auth.remember.purge"
+
+// rotationGrace is how long the validator a token was rotated from still
+// works, for parallel requests that all carried the old cookie.
+const rotationGrace = time.Minute
+
+var (
+	ErrRememberTokenNotFound = errors.New("remember token not found")
+	// ErrInvalidRememberToken is returned for unknown, expired and stolen tokens
+	ErrInvalidRememberToken = errors.New("invalid remember token")
+)
+
+type RememberService struct {
+	db     *gorm.DB
+	ttl    time.Duration
+	logger *zap.Logger
+	now    func() time.Time
+}
+
+type RememberServiceParams struct {
+	DB *gorm.DB
+	// TTL is how long a browser stays remembered without visiting, defaults
+	// to 30 days
+	TTL time.Duration
+	// Queue runs the hourly job deleting expired tokens. Optional, it must
+	// run after the service is created.
+	Queue  *jobs.Queue
+	Logger *zap.Logger
+}
+
+func NewRememberService(p RememberServiceParams) *RememberService {
+
+	p.DB.AutoMigrate(&RememberTokenModel{})
+
+	if p.TTL <= 0 {
+		p.TTL = 30 * 24 * time.Hour
+	}
+
+	s := &RememberService{
+		db:     p.DB,
+		ttl:    p.TTL,
+		logger: p.Logger,
+		now:    time.Now,
+	}
+
+	if p.Queue != nil {
+		jobs.Every(p.Queue, RememberPurgeJobKind, time.Hour, s.DeleteExpired)
+	}
+
+	return s
+}
+
+// DeleteExpired deletes the tokens that expired.
+func (s *RememberService) DeleteExpired(ctx context.Context) error {
+	result := s.db.WithContext(ctx).Where("expires_at < ?", s.now()).Delete(&RememberTokenModel{})
+	if result.Error != nil {
+		return fmt.Errorf("deleting expired remember tokens: %w", result.Error)
+	}
+	if result.RowsAffected > 0 {
+		s.logger.Debug("Deleted expired remember tokens", zap.Int64("count", result.RowsAffected))
+	}
+	return nil
+}
+
+func (s *RememberService) TTL() time.Duration {
+	return s.ttl
+}
+
+// Create remembers a browser of the user, returning the cookie value.
+func (s *RememberService) Create(userID uuid.UUID, userAgent, ip string) (string, *RememberTokenModel, error) {
+	selector, err := randomToken(12)
+	if err != nil {
+		return "", nil, err
+	}
+	validator, err := randomToken(32)
+	if err != nil {
+		return "", nil, err
+	}
+
+	now := s.now()
+
+	model := &RememberTokenModel{
+		ID:         uuid.New(),
+		UserID:     userID,
+		Selector:   selector,
+		Hash:       hashToken(validator),
+		RotatedAt:  now,
+		UserAgent:  truncate(userAgent, 256),
+		IP:         ip,
+		LastUsedAt: now,
+		ExpiresAt:  now.Add(s.ttl),
+	}
+
+	if err := s.db.Create(model).Error; err != nil {
+		return "", nil, fmt.Errorf("creating remember token: %w", err)
+	}
+
+	return selector + ":" + validator, model, nil
+}
+
+// Use checks the cookie value and rotates its validator, returning the new
+// cookie value. Within the grace period after a rotation the previous value
+// still works, without rotating again, and the returned value is empty. A
+// validator that matches neither means the cookie was copied and used
+// elsewhere, so the token is deleted.
+func (s *RememberService) Use(value, userAgent, ip string) (string, *RememberTokenModel, error) {
+	selector, validator, ok := strings.Cut(value, ":")
+	if !ok || selector == "" || validator == "" {
+		return "", nil, ErrInvalidRememberToken
+	}
+
+	var model RememberTokenModel
+	err := s.db.Where("selector = ?", selector).First(&model).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return "", nil, ErrInvalidRememberToken
+	}
+	if err != nil {
+		return "", nil, err
+	}
+
+	now := s.now()
+	if !now.Before(model.ExpiresAt) {
+		return "", nil, ErrInvalidRememberToken
+	}
+
+	hash := hashToken(validator)
+
+	if subtle.ConstantTimeCompare([]byte(model.Hash), []byte(hash)) != 1 {
+		if model.PreviousHash != "" && now.Sub(model.RotatedAt) < rotationGrace &&
+			subtle.ConstantTimeCompare([]byte(model.PreviousHash), []byte(hash)) == 1 {
+			return "", &model, nil
+		}
+
+		s.logger.Warn("Remember token reused after rotation, forgetting the device", zap.String("user_id", model.UserID.String()))
+		if err := s.db.Delete(&model).Error; err != nil {
+			return "", nil, err
+		}
+		return "", nil, ErrInvalidRememberToken
+	}
+
+	next, err := randomToken(32)
+	if err != nil {
+		return "", nil, err
+	}
+
+	// only rotate from the validator that was just checked, a parallel
+	// request that got there first wins
+	result := s.db.Model(&RememberTokenModel{}).
+		Where("id = ? AND hash = ?", model.ID, model.Hash).
+		Updates(map[string]interface{}{
+			"hash":          hashToken(next),
+			"previous_hash": model.Hash,
+			"rotated_at":    now,
+			"user_agent":    truncate(userAgent, 256),
+			"ip":            ip,
+			"last_used_at":  now,
+			"expires_at":    now.Add(s.ttl),
+		})
+	if result.Error != nil {
+		return "", nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return "", &model, nil
+	}
+
+	return selector + ":" + next, &model, nil
+}
+
+// Find returns the token of a cookie value without using it, nil if there
+// is none.
+func (s *RememberService) Find(value string) *RememberTokenModel {
+	selector, _, _ := strings.Cut(value, ":")
+	if selector == "" {
+		return nil
+	}
+
+	var model RememberTokenModel
+	if err := s.db.Where("selector = ?", selector).First(&model).Error; err != nil {
+		return nil
+	}
+	return &model
+}
+
+// List returns the user's remembered browsers, most recently used first.
+func (s *RememberService) List(userID uuid.UUID) ([]RememberTokenModel, error) {
+	var tokens []RememberTokenModel
+	err := s.db.Where("user_id = ? AND expires_at > ?", userID, s.now()).
+		Order("last_used_at desc").
+		Find(&tokens).Error
+	return tokens, err
+}
+
+// Revoke forgets a browser of the user. Tokens of other users are not found.
+func (s *RememberService) Revoke(userID, id uuid.UUID) error {
+	result := s.db.Where("id = ? AND user_id = ?", id, userID).Delete(&RememberTokenModel{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrRememberTokenNotFound
+	}
+	return nil
+}
+
+// Forget deletes the token of a cookie value, when its browser logs out.
+func (s *RememberService) Forget(value string) error {
+	selector, _, _ := strings.Cut(value, ":")
+	if selector == "" {
+		return nil
+	}
+	return s.db.Where("selector = ?", selector).Delete(&RememberTokenModel{}).Error
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n]
+}
@@ -0,0 +1,122 @@
+//go:build unit
+// +build unit
+
+package auth
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func newTestRemember(t *testing.T) (*RememberService, *time.Time) {
+	filename := TempFilename(t)
+	t.Cleanup(func() { os.Remove(filename) })
+
+	db, err := gorm.Open(sqlite.Open(filename), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewRememberService(RememberServiceParams{DB: db, TTL: time.Hour, Logger: zap.NewNop()})
+
+	now := time.Now()
+	s.now = func() time.Time { return now }
+
+	return s, &now
+}
+
+func TestRememberRotation(t *testing.T) {
+	assert := assert.New(t)
+
+	s, now := newTestRemember(t)
+	userID := uuid.New()
+
+	first, _, err := s.Create(userID, "Firefox", "127.0.0.1")
+	assert.NoError(err)
+
+	second, model, err := s.Use(first, "Firefox", "127.0.0.2")
+	assert.NoError(err)
+	assert.NotEmpty(second)
+	assert.NotEqual(first, second)
+	assert.Equal(userID, model.UserID)
+
+	// a parallel request with the old cookie still works, without rotating
+	value, _, err := s.Use(first, "Firefox", "127.0.0.2")
+	assert.NoError(err)
+	assert.Empty(value)
+
+	*now = now.Add(2 * rotationGrace)
+
+	third, _, err := s.Use(second, "Firefox", "127.0.0.2")
+	assert.NoError(err)
+	assert.NotEmpty(third)
+
+	*now = now.Add(2 * time.Hour)
+
+	_, _, err = s.Use(third, "Firefox", "127.0.0.2")
+	assert.ErrorIs(err, ErrInvalidRememberToken, "tokens expire")
+
+	assert.NoError(s.DeleteExpired(context.Background()))
+	var count int64
+	s.db.Model(&RememberTokenModel{}).Count(&count)
+	assert.Zero(count, "expired tokens are deleted")
+}
+
+func TestRememberTheft(t *testing.T) {
+	assert := assert.New(t)
+
+	s, now := newTestRemember(t)
+	userID := uuid.New()
+
+	stolen, _, err := s.Create(userID, "Firefox", "127.0.0.1")
+	assert.NoError(err)
+
+	rotated, _, err := s.Use(stolen, "Firefox", "127.0.0.1")
+	assert.NoError(err)
+
+	*now = now.Add(2 * rotationGrace)
+
+	// the old validator after the grace period means it was copied
+	_, _, err = s.Use(stolen, "Curl", "10.0.0.1")
+	assert.ErrorIs(err, ErrInvalidRememberToken)
+
+	_, _, err = s.Use(rotated, "Firefox", "127.0.0.1")
+	assert.ErrorIs(err, ErrInvalidRememberToken, "the real browser is logged out too")
+
+	_, _, err = s.Use("nope:nope", "Firefox", "127.0.0.1")
+	assert.ErrorIs(err, ErrInvalidRememberToken)
+}
+
+func TestRememberRevoke(t *testing.T) {
+	assert := assert.New(t)
+
+	s, _ := newTestRemember(t)
+	userID := uuid.New()
+
+	value, model, err := s.Create(userID, "Firefox", "127.0.0.1")
+	assert.NoError(err)
+	_, _, err = s.Create(userID, "Safari", "127.0.0.1")
+	assert.NoError(err)
+
+	tokens, err := s.List(userID)
+	assert.NoError(err)
+	assert.Len(tokens, 2)
+
+	assert.ErrorIs(s.Revoke(uuid.New(), model.ID), ErrRememberTokenNotFound, "tokens of other users are not found")
+	assert.NoError(s.Revoke(userID, model.ID))
+
+	_, _, err = s.Use(value, "Firefox", "127.0.0.1")
+	assert.ErrorIs(err, ErrInvalidRememberToken)
+
+	tokens, err = s.List(userID)
+	assert.NoError(err)
+	assert.Len(tokens, 1)
+}
@@ -4,40 +4,65 @@ import (
 	"errors"
 	"time"
 
-	"github.com/go-chi/jwtauth/v5"
 	"github.com/go-playground/validator/v10"
 	"github.com/golang-jwt/jwt"
+	"github.com/google/uuid"
+	jwx "github.com/lestrrat-go/jwx/v2/jwt"
+	"github.com/tomdoestech/goth/internal/pkg/password"
 	users "github.com/tomdoestech/goth/internal/user"
 	"go.uber.org/zap"
-	"golang.org/x/crypto/bcrypt"
 )
 
+// TokenTTL is how long a token is valid for after login.
+const TokenTTL = 24 * time.Hour
+
+// TokenEncoder signs token claims, implemented by *keyring.Keyring and
+// *jwtauth.JWTAuth.
+type TokenEncoder interface {
+	Encode(claims map[string]interface{}) (jwx.Token, string, error)
+}
+
 type AuthService struct {
 	SecretKey []byte
 	logger    *zap.Logger
 	validate  *validator.Validate
-	tokenAuth *jwtauth.JWTAuth
+	tokenAuth TokenEncoder
+	hasher    password.Hasher
 }
 
 type AuthServiceParams struct {
 	Logger    *zap.Logger
 	SecretKey []byte
 	Validate  *validator.Validate
-	TokenAuth *jwtauth.JWTAuth
+	TokenAuth TokenEncoder
+	// Hasher verifies passwords, defaults to password.Default(). Use the
+	// same one as the user service.
+	Hasher password.Hasher
 }
 
 func NewAuthService(p AuthServiceParams) *AuthService {
+	if p.Hasher == nil {
+		p.Hasher = password.Default()
+	}
+
 	return &AuthService{
 		SecretKey: p.SecretKey,
 		logger:    p.Logger,
 		validate:  p.Validate,
 		tokenAuth: p.TokenAuth,
+		hasher:    p.Hasher,
 	}
 }
 
 // VerifyPassword checks if a provided password matches the hashed password
 func (a *AuthService) VerifyPassword(hashedPassword, inputPassword string) error {
-	return bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(inputPassword))
+	return a.hasher.Verify(inputPassword, hashedPassword)
+}
+
+// NeedsRehash reports whether a password hash was made with an outdated
+// algorithm or parameters, and should be replaced once the password is known.
+func (a *AuthService) NeedsRehash(hashedPassword string) bool {
+	return a.hasher.NeedsRehash(hashedPassword)
 }
 
 func (as *AuthService) FindUserByEmail(email string) (*users.UserModel, error) {
@@ -48,25 +73,58 @@ func (as *AuthService) FindUserByEmail(email string) (*users.UserModel, error) {
 	return user, nil
 }
 
-func (a *AuthService) GenerateToken(user *users.UserModel) (string, error) {
+func (a *AuthService) GenerateToken(user *users.UserModel, sessionID uuid.UUID) (string, error) {
+	_, tokenString, err := a.IssueToken(user, sessionID)
+	return tokenString, err
+}
+
+// TokenClaims are the optional claims of a session token.
+type TokenClaims struct {
+	// SessionID goes in the sid claim
+	SessionID uuid.UUID
+	// OrgID goes in the org claim, the organization the login switched to
+	OrgID uuid.UUID
+	// Impersonator goes in the act claim, the admin acting as the user
+	Impersonator uuid.UUID
+}
+
+// IssueToken signs a session token for user, returning it parsed as well so
+// it can be put in the request context straight away. sessionID goes in the
+// sid claim unless it is uuid.Nil.
+func (a *AuthService) IssueToken(user *users.UserModel, sessionID uuid.UUID) (jwx.Token, string, error) {
+	return a.IssueTokenWith(user, TokenClaims{SessionID: sessionID})
+}
+
+// IssueTokenWith is IssueToken with more claims, those left as uuid.Nil are
+// left out.
+func (a *AuthService) IssueTokenWith(user *users.UserModel, claims TokenClaims) (jwx.Token, string, error) {
 
 	payload := map[string]interface{}{
-		"id":    user.ID,
-		"email": user.Email,
-		"exp": time.Now().Add(time.Hour *
-			24).Unix(), // Token expires in 24 hours
+		"id":     user.ID.String(),
+		"email":  user.Email,
+		"locale": user.Locale,
+		"iat":    time.Now().Unix(),
+		"exp":    time.Now().Add(TokenTTL).Unix(),
+	}
+	if claims.SessionID != uuid.Nil {
+		payload["sid"] = claims.SessionID.String()
+	}
+	if claims.OrgID != uuid.Nil {
+		payload["org"] = claims.OrgID.String()
+	}
+	if claims.Impersonator != uuid.Nil {
+		// RFC 8693 names the actor of a delegated token this way
+		payload["act"] = map[string]interface{}{"sub": claims.Impersonator.String()}
 	}
 
-	_, tokenString, err := a.tokenAuth.Encode(payload)
-
-	// tokenString, err := token.SignedString(a.SecretKey)
+	token, tokenString, err := a.tokenAuth.Encode(payload)
 
 	if err != nil {
 		a.logger.Error("Error generating token", zap.Error(err))
-		return "", err
+		return nil, "", err
 	}
 
-	return tokenString, nil
+	return token, tokenString, nil
 }
 
 func (as *AuthService) ValidateToken(tokenString string) (jwt.MapClaims, error) {
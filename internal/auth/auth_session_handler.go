@@ -0,0 +1,212 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/jwtauth/v5"
+	"github.com/google/uuid"
+	"github.com/tomdoestech/goth/internal/pkg/apperror"
+	"github.com/tomdoestech/goth/internal/pkg/i18n"
+	"github.com/tomdoestech/goth/internal/pkg/identity"
+	"github.com/tomdoestech/goth/internal/pkg/session"
+	"github.com/tomdoestech/goth/internal/web"
+	"go.uber.org/zap"
+)
+
+// SessionHandler rejects tokens of revoked sessions and lets users review
+// and revoke where they are logged in.
+type SessionHandler struct {
+	sessionService *SessionService
+	i18n           *i18n.Bundle
+	logger         *zap.Logger
+}
+
+type SessionHandlerParams struct {
+	SessionService *SessionService
+	I18n           *i18n.Bundle
+	Logger         *zap.Logger
+}
+
+func NewSessionHandler(p SessionHandlerParams) *SessionHandler {
+	return &SessionHandler{
+		sessionService: p.SessionService,
+		i18n:           p.I18n,
+		logger:         p.Logger,
+	}
+}
+
+// Verify runs after the token is verified and drops it from the request when
+// its session has been revoked or has ended, as if it were invalid. So are
+// token cookies without a sid claim, issued before sessions were recorded;
+// bearer tokens without one pass. A server-side session of a revoked login
+// is destroyed.
+func (h *SessionHandler) Verify(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		if s := session.FromContext(ctx); s != nil {
+			if sid := s.GetString(sessionLoginID); sid != "" && !h.active(r, sid) {
+				s.Destroy()
+			}
+		}
+
+		token, claims, err := jwtauth.FromContext(ctx)
+		if err != nil || token == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cookie := jwtauth.TokenFromHeader(r) == ""
+
+		// cookies issued before sessions were recorded can't be listed or
+		// revoked, they log in again
+		sid, ok := claims["sid"].(string)
+		if !ok && !cookie {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if !ok || !h.active(r, sid) {
+			if cookie {
+				clearCookie(w, "token")
+			}
+			next.ServeHTTP(w, r.WithContext(jwtauth.NewContext(ctx, nil, ErrSessionRevoked)))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// active reports whether the login session sid is still going.
+func (h *SessionHandler) active(r *http.Request, sid string) bool {
+	id, err := uuid.Parse(sid)
+	if err != nil {
+		return false
+	}
+
+	_, err = h.sessionService.Check(id, r.UserAgent(), web.ClientIP(r))
+	if err != nil && !errors.Is(err, ErrSessionRevoked) {
+		h.logger.Error("Error checking session", zap.Error(err))
+	}
+	return err == nil
+}
+
+// principal returns the logged in user. Sessions are managed with a session
+// only.
+func (h *SessionHandler) principal(r *http.Request) (*identity.Principal, error) {
+	loc := h.i18n.FromRequest(r)
+
+	p, ok := identity.FromContext(r.Context())
+	if !ok {
+		return nil, apperror.Unauthorized(loc.T("error.unauthorized.message"), nil)
+	}
+	if p.Method == identity.MethodAPIKey {
+		return nil, apperror.Forbidden(loc.T("sessions.session_required"))
+	}
+	if p.Impersonated() {
+		return nil, apperror.Forbidden(loc.T("impersonate.blocked"))
+	}
+	return p, nil
+}
+
+// sessions lists the user's active sessions, marking the one making the
+// request.
+func (h *SessionHandler) sessions(p *identity.Principal) ([]Session, error) {
+	models, err := h.sessionService.List(p.UserID)
+	if err != nil {
+		return nil, apperror.Internal(fmt.Errorf("listing sessions: %w", err))
+	}
+
+	sessions := make([]Session, 0, len(models))
+	for i := range models {
+		sessions = append(sessions, NewSession(&models[i], models[i].ID == p.SessionID))
+	}
+	return sessions, nil
+}
+
+// Page renders the active sessions section of the account settings.
+func (h *SessionHandler) Page(w http.ResponseWriter, r *http.Request) error {
+	p, err := h.principal(r)
+	if err != nil {
+		return err
+	}
+
+	sessions, err := h.sessions(p)
+	if err != nil {
+		return err
+	}
+
+	web.RenderTemplate(w, "sessions.html", map[string]interface{}{
+		"Title":    "sessions.title",
+		"Sessions": sessions,
+	}, r)
+
+	return nil
+}
+
+func (h *SessionHandler) List(w http.ResponseWriter, r *http.Request) error {
+	p, err := h.principal(r)
+	if err != nil {
+		return err
+	}
+
+	sessions, err := h.sessions(p)
+	if err != nil {
+		return err
+	}
+
+	return web.WriteJSON(w, http.StatusOK, sessions)
+}
+
+// Revoke logs a session out straight away. Revoking the current session
+// logs the user out.
+func (h *SessionHandler) Revoke(w http.ResponseWriter, r *http.Request) error {
+	p, err := h.principal(r)
+	if err != nil {
+		return err
+	}
+
+	loc := h.i18n.FromRequest(r)
+
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		return apperror.NotFound(loc.T("sessions.not_found"))
+	}
+
+	err = h.sessionService.Revoke(p.UserID, id)
+	if errors.Is(err, ErrSessionNotFound) {
+		return apperror.NotFound(loc.T("sessions.not_found"))
+	}
+	if err != nil {
+		return apperror.Internal(fmt.Errorf("revoking session: %w", err))
+	}
+
+	if web.WantsJSON(r) {
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+
+	if id == p.SessionID {
+		clearCookie(w, "token")
+		logOutSession(r)
+		clearCookie(w, RememberCookie)
+		w.Header().Set("HX-Redirect", "/login")
+		w.WriteHeader(http.StatusOK)
+		return nil
+	}
+
+	sessions, err := h.sessions(p)
+	if err != nil {
+		return err
+	}
+
+	web.RenderBlock(w, r, "sessions.html", "sessions", http.StatusOK, map[string]interface{}{
+		"Sessions": sessions,
+	})
+
+	return nil
+}
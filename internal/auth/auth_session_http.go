@@ -0,0 +1,54 @@
+package auth
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/tomdoestech/goth/internal/pkg/openapi"
+	"github.com/tomdoestech/goth/internal/web"
+)
+
+type SessionHTTPParams struct {
+	SessionHandler *SessionHandler
+	ErrorHandler   *web.ErrorHandler
+	API            *openapi.Document
+	Mux            *chi.Mux
+}
+
+func NewSessionHTTP(p SessionHTTPParams) {
+
+	r := openapi.NewRouter(p.Mux, p.API)
+	e := p.ErrorHandler
+
+	r.Get("/account/sessions", openapi.Operation{
+		Summary: "Active sessions page",
+		Tags:    []string{"sessions"},
+		Responses: map[int]openapi.Response{
+			http.StatusOK: {Description: "Where the user is logged in", ContentType: "text/html"},
+		},
+		Errors: []int{http.StatusUnauthorized, http.StatusForbidden},
+		Auth:   true,
+	}, e.Handle(p.SessionHandler.Page))
+
+	r.Get("/api/sessions", openapi.Operation{
+		Summary: "List active sessions",
+		Tags:    []string{"sessions"},
+		Responses: map[int]openapi.Response{
+			http.StatusOK: {Description: "The sessions, most recently seen first", Body: []Session{}},
+		},
+		Errors: []int{http.StatusUnauthorized, http.StatusForbidden},
+		Auth:   true,
+	}, e.Handle(p.SessionHandler.List))
+
+	r.Delete("/api/sessions/{id}", openapi.Operation{
+		Summary:     "Revoke a session",
+		Description: "Its token stops working straight away, and its browser is no longer remembered.",
+		Tags:        []string{"sessions"},
+		Responses: map[int]openapi.Response{
+			http.StatusOK:        {Description: "Session revoked, htmx gets the updated list", ContentType: "text/html"},
+			http.StatusNoContent: {Description: "Session revoked, for JSON clients"},
+		},
+		Errors: []int{http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound},
+		Auth:   true,
+	}, e.Handle(p.SessionHandler.Revoke))
+}
@@ -0,0 +1,108 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/tomdoestech/goth/internal/pkg/identity"
+	"github.com/tomdoestech/goth/internal/pkg/session"
+	users "github.com/tomdoestech/goth/internal/user"
+)
+
+// SessionMode decides where a logged in browser's session lives.
+type SessionMode string
+
+const (
+	// SessionJWT puts a signed token with the user's id and email in the
+	// token cookie
+	SessionJWT SessionMode = "jwt"
+	// SessionServer puts an opaque id in the session cookie and keeps the
+	// login in a session.Store
+	SessionServer SessionMode = "server"
+)
+
+// Keys of the login in a server-side session.
+const (
+	sessionUserID = "user_id"
+	sessionEmail  = "email"
+	sessionLocale = "locale"
+	// sessionLoginID is the id of the SessionModel, like the token's sid claim
+	sessionLoginID = "sid"
+	// sessionOrgID is the organization switched to, like the token's org
+	// claim
+	sessionOrgID = "org"
+	// sessionImpersonator is the admin acting as the user, like the token's
+	// act claim
+	sessionImpersonator = "impersonator"
+)
+
+var errNoSession = errors.New("no session in the request context, is the session middleware installed?")
+
+// loginKeys are every key of the login, cleared when another one starts.
+var loginKeys = []string{sessionUserID, sessionEmail, sessionLocale, sessionLoginID, sessionOrgID, sessionImpersonator}
+
+// logInSession stores the login in the server-side session, giving it a new
+// id so an id planted before logging in is worthless. Nothing of the
+// previous login is kept, such as an impersonation or its organization.
+func logInSession(r *http.Request, user *users.UserModel, sessionID uuid.UUID) error {
+	s := session.FromContext(r.Context())
+	if s == nil {
+		return errNoSession
+	}
+
+	s.RenewID()
+
+	for _, key := range loginKeys {
+		s.Remove(key)
+	}
+
+	values := map[string]string{
+		sessionUserID: user.ID.String(),
+		sessionEmail:  user.Email,
+		sessionLocale: user.Locale,
+	}
+	if sessionID != uuid.Nil {
+		values[sessionLoginID] = sessionID.String()
+	}
+	for k, v := range values {
+		if err := s.Set(k, v); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// logOutSession ends the server-side session, if there is one.
+func logOutSession(r *http.Request) {
+	if s := session.FromContext(r.Context()); s != nil {
+		s.Destroy()
+	}
+}
+
+// SessionPrincipal returns the user logged in with a server-side session,
+// nil if there is none. It is the identity middleware's Sessions.
+func SessionPrincipal(r *http.Request) *identity.Principal {
+	s := session.FromContext(r.Context())
+	if s == nil {
+		return nil
+	}
+
+	id, err := uuid.Parse(s.GetString(sessionUserID))
+	if err != nil {
+		return nil
+	}
+
+	p := &identity.Principal{
+		UserID: id,
+		Email:  s.GetString(sessionEmail),
+		Locale: s.GetString(sessionLocale),
+		Method: identity.MethodCookie,
+	}
+	p.SessionID, _ = uuid.Parse(s.GetString(sessionLoginID))
+	p.OrgID, _ = uuid.Parse(s.GetString(sessionOrgID))
+	p.ImpersonatorID, _ = uuid.Parse(s.GetString(sessionImpersonator))
+
+	return p
+}
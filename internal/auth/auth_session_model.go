@@ -0,0 +1,62 @@
+package auth
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// SessionModel is one login of a user. Its id is the token's sid claim, so
+// revoking the session rejects the token.
+type SessionModel struct {
+	ID        uuid.UUID `gorm:"primaryKey;type:uuid" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+
+	UserID uuid.UUID `gorm:"type:uuid;index;not null" json:"-"`
+	// RememberTokenID is the remember me token that keeps the session going,
+	// it is forgotten with the session
+	RememberTokenID *uuid.UUID `gorm:"type:uuid;index" json:"-"`
+
+	IP        string `json:"ip"`
+	UserAgent string `json:"-"`
+	// Browser and Device are parsed from UserAgent
+	Browser  string `json:"browser"`
+	Device   string `json:"device"`
+	Location string `json:"location"`
+
+	LastSeenAt time.Time  `json:"last_seen_at"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	RevokedAt  *time.Time `json:"-"`
+}
+
+func (SessionModel) TableName() string {
+	return "login_sessions"
+}
+
+// Session is a login as shown to its user.
+type Session struct {
+	ID         uuid.UUID `json:"id"`
+	IP         string    `json:"ip"`
+	Browser    string    `json:"browser"`
+	Device     string    `json:"device"`
+	Location   string    `json:"location"`
+	Remembered bool      `json:"remembered"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	// Current is the session making the request
+	Current bool `json:"current"`
+}
+
+func NewSession(m *SessionModel, current bool) Session {
+	return Session{
+		ID:         m.ID,
+		IP:         m.IP,
+		Browser:    m.Browser,
+		Device:     m.Device,
+		Location:   m.Location,
+		Remembered: m.RememberTokenID != nil,
+		CreatedAt:  m.CreatedAt,
+		LastSeenAt: m.LastSeenAt,
+		Current:    current,
+	}
+}
@@ -0,0 +1,204 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/tomdoestech/goth/internal/pkg/geoip"
+	"github.com/tomdoestech/goth/internal/pkg/useragent"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// seenInterval is how often a session's last seen time and IP are updated,
+// rather than writing on every request.
+const seenInterval = time.Minute
+
+var (
+	// ErrSessionRevoked is returned for unknown, expired and revoked sessions
+	ErrSessionRevoked  = errors.New("session revoked")
+	ErrSessionNotFound = errors.New("session not found")
+)
+
+type SessionService struct {
+	db       *gorm.DB
+	geoIP    *geoip.DB
+	remember *RememberService
+	logger   *zap.Logger
+	now      func() time.Time
+}
+
+type SessionServiceParams struct {
+	DB *gorm.DB
+	// GeoIP locates sessions by IP address, nil leaves the location empty
+	GeoIP *geoip.DB
+	// RememberService forgets the remembered browser of a revoked session
+	RememberService *RememberService
+	Logger          *zap.Logger
+}
+
+func NewSessionService(p SessionServiceParams) *SessionService {
+
+	p.DB.AutoMigrate(&SessionModel{})
+
+	return &SessionService{
+		db:       p.DB,
+		geoIP:    p.GeoIP,
+		remember: p.RememberService,
+		logger:   p.Logger,
+		now:      time.Now,
+	}
+}
+
+// Start records a login. A remembered browser coming back continues the
+// session it started, so it doesn't show up again every day.
+func (s *SessionService) Start(userID uuid.UUID, rememberTokenID *uuid.UUID, userAgent, ip string) (*SessionModel, error) {
+	now := s.now()
+
+	// clear out expired sessions on the way
+	if err := s.db.Where("expires_at < ?", now.Add(-TokenTTL)).Delete(&SessionModel{}).Error; err != nil {
+		s.logger.Warn("Error deleting expired sessions", zap.Error(err))
+	}
+
+	if rememberTokenID != nil {
+		var model SessionModel
+		err := s.db.Where("user_id = ? AND remember_token_id = ? AND revoked_at IS NULL", userID, *rememberTokenID).
+			Order("created_at desc").
+			First(&model).Error
+		if err == nil {
+			s.seen(&model, userAgent, ip, now)
+			model.ExpiresAt = now.Add(TokenTTL)
+			if err := s.db.Save(&model).Error; err != nil {
+				return nil, fmt.Errorf("continuing session: %w", err)
+			}
+			return &model, nil
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, err
+		}
+	}
+
+	model := &SessionModel{
+		ID:              uuid.New(),
+		UserID:          userID,
+		RememberTokenID: rememberTokenID,
+		ExpiresAt:       now.Add(TokenTTL),
+	}
+	s.seen(model, userAgent, ip, now)
+
+	if err := s.db.Create(model).Error; err != nil {
+		return nil, fmt.Errorf("creating session: %w", err)
+	}
+
+	return model, nil
+}
+
+// seen updates where the session was last seen from.
+func (s *SessionService) seen(m *SessionModel, userAgent, ip string, now time.Time) {
+	m.LastSeenAt = now
+
+	if userAgent != m.UserAgent {
+		ua := useragent.Parse(userAgent)
+		m.UserAgent = truncate(userAgent, 256)
+		m.Browser = ua.String()
+		m.Device = ua.Device
+	}
+
+	if ip != m.IP {
+		m.IP = ip
+		loc, _ := s.geoIP.Lookup(ip)
+		m.Location = loc.String()
+	}
+}
+
+// Check returns the session if it is still active, ErrSessionRevoked
+// otherwise. Every now and then it records that the session was seen.
+func (s *SessionService) Check(id uuid.UUID, userAgent, ip string) (*SessionModel, error) {
+	var model SessionModel
+	err := s.db.Where("id = ?", id).First(&model).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrSessionRevoked
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	now := s.now()
+	if model.RevokedAt != nil || !now.Before(model.ExpiresAt) {
+		return nil, ErrSessionRevoked
+	}
+
+	if now.Sub(model.LastSeenAt) >= seenInterval || ip != model.IP {
+		s.seen(&model, userAgent, ip, now)
+		err := s.db.Model(&SessionModel{}).
+			Where("id = ? AND revoked_at IS NULL", model.ID).
+			Updates(map[string]interface{}{
+				"last_seen_at": model.LastSeenAt,
+				"user_agent":   model.UserAgent,
+				"browser":      model.Browser,
+				"device":       model.Device,
+				"ip":           model.IP,
+				"location":     model.Location,
+			}).Error
+		if err != nil {
+			s.logger.Warn("Error updating session", zap.Error(err))
+		}
+	}
+
+	return &model, nil
+}
+
+// List returns the user's active sessions, most recently seen first.
+func (s *SessionService) List(userID uuid.UUID) ([]SessionModel, error) {
+	var sessions []SessionModel
+	err := s.db.Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", userID, s.now()).
+		Order("last_seen_at desc").
+		Find(&sessions).Error
+	return sessions, err
+}
+
+// Revoke ends a session of the user, and forgets its browser if it was
+// remembered. Sessions of other users are not found.
+func (s *SessionService) Revoke(userID, id uuid.UUID) error {
+	var model SessionModel
+	err := s.db.Where("id = ? AND user_id = ? AND revoked_at IS NULL", id, userID).First(&model).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrSessionNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	return s.revoke(&model)
+}
+
+// End revokes the session when its browser logs out.
+func (s *SessionService) End(id uuid.UUID) error {
+	var model SessionModel
+	err := s.db.Where("id = ? AND revoked_at IS NULL", id).First(&model).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	return s.revoke(&model)
+}
+
+func (s *SessionService) revoke(m *SessionModel) error {
+	if err := s.db.Model(m).Update("revoked_at", s.now()).Error; err != nil {
+		return err
+	}
+
+	if m.RememberTokenID != nil && s.remember != nil {
+		err := s.remember.Revoke(m.UserID, *m.RememberTokenID)
+		if err != nil && !errors.Is(err, ErrRememberTokenNotFound) {
+			return err
+		}
+	}
+
+	return nil
+}
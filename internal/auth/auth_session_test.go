@@ -0,0 +1,262 @@
+//go:build unit
+// +build unit
+
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/jwtauth/v5"
+	"github.com/go-playground/validator/v10"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/tomdoestech/goth/internal/pkg/geoip"
+	"github.com/tomdoestech/goth/internal/pkg/i18n"
+	"github.com/tomdoestech/goth/internal/pkg/identity"
+	"github.com/tomdoestech/goth/internal/pkg/session"
+	users "github.com/tomdoestech/goth/internal/user"
+	"github.com/tomdoestech/goth/internal/web"
+	"go.uber.org/zap"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+const firefox = "Mozilla/5.0 (Windows NT 10.0; Win64; x64; rv:118.0) Gecko/20100101 Firefox/118.0"
+
+func newTestSessions(t *testing.T) (*SessionService, *RememberService) {
+	filename := TempFilename(t)
+	t.Cleanup(func() { os.Remove(filename) })
+
+	db, err := gorm.Open(sqlite.Open(filename), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	geoIP, err := geoip.Read(strings.NewReader("192.0.2.0,192.0.2.255,EU,FR,Auvergne-Rhône-Alpes,Lyon,45.75,4.85\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	remember := NewRememberService(RememberServiceParams{DB: db, Logger: zap.NewNop()})
+
+	return NewSessionService(SessionServiceParams{
+		DB:              db,
+		GeoIP:           geoIP,
+		RememberService: remember,
+		Logger:          zap.NewNop(),
+	}), remember
+}
+
+func TestSessions(t *testing.T) {
+	assert := assert.New(t)
+
+	s, remember := newTestSessions(t)
+	userID := uuid.New()
+
+	session, err := s.Start(userID, nil, firefox, "192.0.2.10")
+	if !assert.NoError(err) {
+		return
+	}
+	assert.Equal("Firefox 118 on Windows", session.Browser)
+	assert.Equal("Lyon, FR", session.Location)
+
+	_, err = s.Check(session.ID, firefox, "198.51.100.1")
+	assert.NoError(err)
+
+	sessions, err := s.List(userID)
+	assert.NoError(err)
+	if assert.Len(sessions, 1) {
+		assert.Equal("198.51.100.1", sessions[0].IP, "the IP follows the session")
+		assert.Empty(sessions[0].Location)
+	}
+
+	assert.ErrorIs(s.Revoke(uuid.New(), session.ID), ErrSessionNotFound, "sessions of other users are not found")
+	assert.NoError(s.Revoke(userID, session.ID))
+
+	_, err = s.Check(session.ID, firefox, "192.0.2.10")
+	assert.ErrorIs(err, ErrSessionRevoked)
+
+	// a remembered browser continues its session, until it is revoked
+	value, token, err := remember.Create(userID, firefox, "192.0.2.10")
+	assert.NoError(err)
+
+	first, err := s.Start(userID, &token.ID, firefox, "192.0.2.10")
+	assert.NoError(err)
+	again, err := s.Start(userID, &token.ID, firefox, "192.0.2.10")
+	assert.NoError(err)
+	assert.Equal(first.ID, again.ID)
+
+	assert.NoError(s.Revoke(userID, first.ID))
+	_, _, err = remember.Use(value, firefox, "192.0.2.10")
+	assert.ErrorIs(err, ErrInvalidRememberToken, "revoking the session forgets the browser")
+
+	expired, err := s.Start(userID, nil, firefox, "192.0.2.10")
+	assert.NoError(err)
+	s.now = func() time.Time { return time.Now().Add(TokenTTL) }
+	_, err = s.Check(expired.ID, firefox, "192.0.2.10")
+	assert.ErrorIs(err, ErrSessionRevoked, "sessions end with their token")
+}
+
+func TestSessionVerify(t *testing.T) {
+	s, _ := newTestSessions(t)
+	userID := uuid.New()
+
+	active, err := s.Start(userID, nil, firefox, "192.0.2.10")
+	assert.NoError(t, err)
+	revoked, err := s.Start(userID, nil, firefox, "192.0.2.10")
+	assert.NoError(t, err)
+	assert.NoError(t, s.Revoke(userID, revoked.ID))
+
+	tokenAuth := jwtauth.New("HS256", []byte("secret"), nil)
+	token := func(claims map[string]interface{}) string {
+		_, tokenString, err := tokenAuth.Encode(claims)
+		assert.NoError(t, err)
+		return tokenString
+	}
+
+	h := NewSessionHandler(SessionHandlerParams{SessionService: s, Logger: zap.NewNop()})
+	handler := jwtauth.Verify(tokenAuth, jwtauth.TokenFromCookie)(h.Verify(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token, _, err := jwtauth.FromContext(r.Context()); err == nil && token != nil {
+			w.Write([]byte("authenticated"))
+		}
+	})))
+
+	testCases := []struct {
+		description   string
+		claims        map[string]interface{}
+		authenticated bool
+	}{
+		{"active session", map[string]interface{}{"id": userID.String(), "sid": active.ID.String()}, true},
+		{"revoked session", map[string]interface{}{"id": userID.String(), "sid": revoked.ID.String()}, false},
+		{"unknown session", map[string]interface{}{"id": userID.String(), "sid": uuid.New().String()}, false},
+		{"invalid sid", map[string]interface{}{"id": userID.String(), "sid": "nope"}, false},
+		{"no sid", map[string]interface{}{"id": userID.String()}, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.AddCookie(&http.Cookie{Name: "jwt", Value: token(tc.claims)})
+			w := httptest.NewRecorder()
+
+			handler.ServeHTTP(w, req)
+
+			assert.Equal(t, tc.authenticated, w.Body.String() == "authenticated")
+			if !tc.authenticated {
+				assert.Contains(t, w.Header().Get("Set-Cookie"), "token=;", "the cookie is cleared")
+			}
+		})
+	}
+
+	t.Run("bearer without sid", func(t *testing.T) {
+		handler := jwtauth.Verify(tokenAuth, jwtauth.TokenFromHeader)(h.Verify(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if token, _, err := jwtauth.FromContext(r.Context()); err == nil && token != nil {
+				w.Write([]byte("authenticated"))
+			}
+		})))
+
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Authorization", "Bearer "+token(map[string]interface{}{"id": userID.String()}))
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		assert.Equal(t, "authenticated", w.Body.String())
+	})
+}
+
+func TestServerSessions(t *testing.T) {
+	assert := assert.New(t)
+
+	sessions, _ := newTestSessions(t)
+
+	usersService := users.NewUserService(users.UserServiceParams{
+		Logger:   zap.NewNop(),
+		Validate: validator.New(),
+		DB:       sessions.db,
+	})
+	CreateUser(usersService, t, "test@example.com", "password")
+
+	bundle, err := i18n.NewBundle(i18n.BundleParams{Validate: validator.New()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	authHandler := NewAuthHandler(AuthHandlerParams{
+		AuthService: NewAuthService(AuthServiceParams{
+			Logger:    zap.NewNop(),
+			TokenAuth: jwtauth.New("HS256", []byte("secret"), nil),
+		}),
+		UserService:    usersService,
+		SessionService: sessions,
+		Validate:       validator.New(),
+		I18n:           bundle,
+		SessionMode:    SessionServer,
+		Logger:         zap.NewNop(),
+	})
+	sessionHandler := NewSessionHandler(SessionHandlerParams{SessionService: sessions, I18n: bundle, Logger: zap.NewNop()})
+	errorHandler := web.NewErrorHandler(web.ErrorHandlerParams{I18n: bundle, Logger: zap.NewNop()})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/login", errorHandler.Handle(authHandler.Login))
+	mux.HandleFunc("/logout", errorHandler.Handle(authHandler.Logout))
+	mux.HandleFunc("/me", func(w http.ResponseWriter, r *http.Request) {
+		if p, ok := identity.FromContext(r.Context()); ok {
+			w.Write([]byte(p.Email))
+		}
+	})
+
+	handler := session.New(session.Params{Store: session.NewMemoryStore()}).Middleware(
+		sessionHandler.Verify(identity.Middleware(identity.MiddlewareParams{
+			Sessions: SessionPrincipal,
+			Logger:   zap.NewNop(),
+		})(mux)),
+	)
+
+	do := func(method, path, cookie string, form url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if cookie != "" {
+			req.AddCookie(&http.Cookie{Name: "session", Value: cookie})
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+	cookie := func(w *httptest.ResponseRecorder, name string) string {
+		for _, c := range w.Result().Cookies() {
+			if c.Name == name {
+				return c.Value
+			}
+		}
+		return ""
+	}
+
+	credentials := url.Values{"email": {"test@example.com"}, "password": {"password"}}
+
+	w := do("POST", "/login", "", credentials)
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Empty(cookie(w, "token"), "no token reaches the browser")
+	id := cookie(w, "session")
+	assert.NotEmpty(id)
+
+	assert.Equal("test@example.com", do("GET", "/me", id, nil).Body.String())
+
+	// revoking the login ends the server session
+	user, err := usersService.FindUserByEmail("test@example.com")
+	assert.NoError(err)
+	list, err := sessions.List(user.ID)
+	assert.NoError(err)
+	if assert.Len(list, 1) {
+		assert.NoError(sessions.Revoke(list[0].UserID, list[0].ID))
+	}
+	assert.Empty(do("GET", "/me", id, nil).Body.String())
+
+	id = cookie(do("POST", "/login", "", credentials), "session")
+	do("POST", "/logout", id, nil)
+	assert.Empty(do("GET", "/me", id, nil).Body.String())
+}
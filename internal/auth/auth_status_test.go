@@ -0,0 +1,324 @@
+//go:build unit
+// +build unit
+
+package auth
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/jwtauth/v5"
+	"github.com/go-playground/validator/v10"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/tomdoestech/goth/internal/pkg/i18n"
+	"github.com/tomdoestech/goth/internal/pkg/identity"
+	"github.com/tomdoestech/goth/internal/pkg/openapi"
+	"github.com/tomdoestech/goth/internal/pkg/realtime"
+	"github.com/tomdoestech/goth/internal/pkg/storage"
+	users "github.com/tomdoestech/goth/internal/user"
+	"github.com/tomdoestech/goth/internal/web"
+	"go.uber.org/zap"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestAccountStatus(t *testing.T) {
+	filename := TempFilename(t)
+	defer os.Remove(filename)
+
+	db, err := gorm.Open(sqlite.Open(filename), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	validate := validator.New()
+	bundle, err := i18n.NewBundle(i18n.BundleParams{Validate: validate})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	blobs, err := storage.NewLocalStore(t.TempDir(), storage.NewSigner([]byte("secret"), "/files/"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, db.AutoMigrate(&SessionModel{}, &RememberTokenModel{}, &InvitationModel{}))
+
+	usersService := users.NewUserService(users.UserServiceParams{
+		Logger:      zap.NewNop(),
+		Validate:    validate,
+		DB:          db,
+		AdminEmails: []string{"admin@example.com"},
+		VerifyEmail: true,
+		Store:       blobs,
+		Dependents: []users.Dependent{
+			{Table: SessionModel{}.TableName()},
+			{Table: RememberTokenModel{}.TableName()},
+			{Table: InvitationModel{}.TableName(), Column: "inviter_id"},
+		},
+	})
+	CreateUser(usersService, t, "admin@example.com", "tangerine-otter-89-lamp")
+	CreateUser(usersService, t, "member@example.com", "tangerine-otter-89-lamp")
+	admin, _ := usersService.FindUserByEmail("admin@example.com")
+	member, _ := usersService.FindUserByEmail("member@example.com")
+	assert.Equal(t, users.StatusPending, member.Status)
+
+	tokenAuth := jwtauth.New("HS256", []byte("secret"), nil)
+	authService := NewAuthService(AuthServiceParams{Logger: zap.NewNop(), TokenAuth: tokenAuth})
+	authHandler := NewAuthHandler(AuthHandlerParams{
+		AuthService: authService,
+		UserService: usersService,
+		Validate:    validate,
+		I18n:        bundle,
+		Logger:      zap.NewNop(),
+	})
+	errorHandler := web.NewErrorHandler(web.ErrorHandlerParams{I18n: bundle, Logger: zap.NewNop()})
+
+	mux := chi.NewMux()
+	users.NewUserHTTP(users.UserHTTPParams{
+		UserHandler: users.NewUserHandler(users.UserHandlerParams{
+			UserService: usersService,
+			Validate:    validate,
+			I18n:        bundle,
+			Logger:      zap.NewNop(),
+		}),
+		ErrorHandler: errorHandler,
+		API:          openapi.New(openapi.Params{Title: "goth", Version: "1.0.0"}),
+		Mux:          mux,
+	})
+	mux.Post("/api/login", errorHandler.Handle(authHandler.Login))
+	mux.Get("/", func(w http.ResponseWriter, r *http.Request) {
+		web.RenderTemplate(w, "home.html", map[string]interface{}{"Title": "Home"}, r)
+	})
+	mux.Handle("/events", realtime.NewBroker(realtime.BrokerParams{Logger: zap.NewNop()}))
+	mux.Get("/whoami", func(w http.ResponseWriter, r *http.Request) {
+		p, _ := identity.FromContext(r.Context())
+		if p != nil {
+			fmt.Fprint(w, p.UserID)
+		}
+	})
+
+	fromCookie := func(r *http.Request) string {
+		if cookie, err := r.Cookie("token"); err == nil {
+			return cookie.Value
+		}
+		return ""
+	}
+
+	handler := jwtauth.Verify(tokenAuth, jwtauth.TokenFromHeader, fromCookie)(identity.Middleware(identity.MiddlewareParams{
+		Status:       usersService.StatusError,
+		Unauthorized: errorHandler.Render,
+		Logger:       zap.NewNop(),
+	})(bundle.Middleware(mux)))
+
+	login := func(email string) *httptest.ResponseRecorder {
+		form := url.Values{"email": {email}, "password": {"tangerine-otter-89-lamp"}}
+		req := httptest.NewRequest("POST", "/api/login", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	setStatus := func(token string, id uuid.UUID, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("PUT", "/api/users/"+id.String()+"/status", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Accept", "application/json")
+		req.AddCookie(&http.Cookie{Name: "token", Value: token})
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	whoami := func(token string, bearer bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/whoami", nil)
+		if bearer {
+			req.Header.Set("Authorization", "Bearer "+token)
+		} else {
+			req.AddCookie(&http.Cookie{Name: "token", Value: token})
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	w := login("admin@example.com")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Contains(t, w.Body.String(), "Your email address isn’t verified yet.")
+
+	assert.NoError(t, usersService.Activate(admin.ID))
+	assert.NoError(t, usersService.Activate(member.ID))
+
+	w = login("member@example.com")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	adminToken, err := authService.GenerateToken(admin, uuid.Nil)
+	assert.NoError(t, err)
+	memberToken, err := authService.GenerateToken(member, uuid.Nil)
+	assert.NoError(t, err)
+
+	t.Run("only admins", func(t *testing.T) {
+		w := setStatus(memberToken, admin.ID, `{"status":"suspended"}`)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		w = setStatus(adminToken, admin.ID, `{"status":"suspended"}`)
+		assert.Equal(t, http.StatusForbidden, w.Code, "not themselves")
+		w = setStatus(adminToken, member.ID, `{"status":"banned"}`)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		w = setStatus(adminToken, member.ID, `{"status":"suspended","until":"2001-01-01T00:00:00Z"}`)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		w = setStatus(adminToken, uuid.New(), `{"status":"suspended"}`)
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("suspended", func(t *testing.T) {
+		until := time.Date(2100, 3, 1, 0, 0, 0, 0, time.UTC)
+		w := setStatus(adminToken, member.ID, `{"status":"suspended","reason":"Spam","until":"`+until.Format(time.RFC3339)+`"}`)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), `"status":"suspended"`)
+
+		w = login("member@example.com")
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Contains(t, w.Body.String(), "Your account is suspended until March 1, 2100. Reason: Spam")
+
+		w = whoami(memberToken, false)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Empty(t, w.Body.String(), "the cookie login is anonymous")
+
+		w = whoami(memberToken, true)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+	})
+
+	t.Run("suspended remembered browser", func(t *testing.T) {
+		rememberService := NewRememberService(RememberServiceParams{DB: db, Logger: zap.NewNop()})
+		sessionService := NewSessionService(SessionServiceParams{DB: db, RememberService: rememberService, Logger: zap.NewNop()})
+		rememberHandler := NewRememberHandler(RememberHandlerParams{
+			RememberService: rememberService,
+			SessionService:  sessionService,
+			AuthService:     authService,
+			UserService:     usersService,
+			I18n:            bundle,
+			Logger:          zap.NewNop(),
+		})
+		remembered := jwtauth.Verify(tokenAuth, fromCookie)(rememberHandler.Middleware(handler))
+
+		value, _, err := rememberService.Create(member.ID, "Firefox", "192.0.2.10")
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		var before int64
+		db.Model(&SessionModel{}).Count(&before)
+
+		req := httptest.NewRequest("GET", "/whoami", nil)
+		req.AddCookie(&http.Cookie{Name: RememberCookie, Value: value})
+		w := httptest.NewRecorder()
+		remembered.ServeHTTP(w, req)
+
+		assert.Empty(t, w.Body.String(), "not logged in")
+		setCookies := strings.Join(w.Header().Values("Set-Cookie"), "\n")
+		assert.NotContains(t, setCookies, "token=ey", "no token is issued")
+		assert.Contains(t, setCookies, RememberCookie+"=;", "the remember cookie is cleared")
+
+		var after int64
+		db.Model(&SessionModel{}).Count(&after)
+		assert.Equal(t, before, after, "no session is started")
+
+		_, _, err = rememberService.Use(value, "Firefox", "192.0.2.10")
+		assert.ErrorIs(t, err, ErrInvalidRememberToken, "the browser is forgotten")
+	})
+
+	t.Run("suspended cookie", func(t *testing.T) {
+		get := func(path, token string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest("GET", path, nil)
+			req.AddCookie(&http.Cookie{Name: "token", Value: token})
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+			return w
+		}
+
+		w := get("/", adminToken)
+		assert.Contains(t, w.Body.String(), "admin@example.com")
+
+		w = get("/", memberToken)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.NotContains(t, w.Body.String(), "member@example.com", "the page is rendered for a visitor")
+		cookies := w.Result().Cookies()
+		if assert.Len(t, cookies, 1) {
+			assert.Equal(t, "token", cookies[0].Name)
+			assert.Equal(t, -1, cookies[0].MaxAge, "the cookie is cleared")
+		}
+
+		w = get("/events", memberToken)
+		assert.Equal(t, http.StatusBadRequest, w.Code, "no user topic to subscribe to")
+	})
+
+	t.Run("suspension over", func(t *testing.T) {
+		until := time.Now().Add(time.Hour)
+		assert.NoError(t, usersService.Suspend(member.ID, "", &until))
+		assert.Error(t, usersService.StatusError(context.Background(), member.ID))
+
+		db.Model(&users.UserModel{}).Where("id = ?", member.ID).Update("suspended_until", time.Now().Add(-time.Minute))
+		assert.NoError(t, usersService.StatusError(context.Background(), member.ID))
+		assert.Equal(t, http.StatusOK, login("member@example.com").Code)
+	})
+
+	t.Run("deletion scheduled", func(t *testing.T) {
+		w := setStatus(adminToken, member.ID, `{"status":"deletion_scheduled"}`)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		w = login("member@example.com")
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Contains(t, w.Body.String(), "Your account is scheduled for deletion on")
+
+		n, err := usersService.DeleteDue(context.Background())
+		assert.NoError(t, err)
+		assert.Zero(t, n, "the grace period isn't over")
+
+		w = setStatus(adminToken, member.ID, `{"status":"active"}`)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, http.StatusOK, login("member@example.com").Code)
+
+		ctx := context.Background()
+		for _, size := range []int{users.AvatarSize, users.AvatarThumbnailSize} {
+			assert.NoError(t, blobs.Put(ctx, users.AvatarKey("avatars/member", size), strings.NewReader("png"), 3, "image/png"))
+		}
+		_, err = usersService.SetAvatar(member.ID, "avatars/member")
+		assert.NoError(t, err)
+		assert.NoError(t, db.Create(&SessionModel{ID: uuid.New(), UserID: member.ID}).Error)
+		assert.NoError(t, db.Create(&RememberTokenModel{ID: uuid.New(), UserID: member.ID, Selector: "s", Hash: "h"}).Error)
+		assert.NoError(t, db.Create(&SessionModel{ID: uuid.New(), UserID: admin.ID}).Error)
+		assert.NoError(t, db.Create(&InvitationModel{ID: uuid.New(), Email: "friend@example.com", TokenHash: "h", InviterID: member.ID}).Error)
+
+		assert.NoError(t, usersService.ScheduleDeletion(member.ID, 0))
+		n, err = usersService.DeleteDue(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), n)
+
+		_, err = usersService.FindUserByID(member.ID)
+		assert.Error(t, err)
+
+		var count int64
+		db.Unscoped().Model(&users.UserModel{}).Where("id = ?", member.ID).Count(&count)
+		assert.Zero(t, count, "the row is gone, not soft deleted")
+		db.Model(&SessionModel{}).Where("user_id = ?", member.ID).Count(&count)
+		assert.Zero(t, count)
+		db.Model(&RememberTokenModel{}).Where("user_id = ?", member.ID).Count(&count)
+		assert.Zero(t, count)
+		db.Model(&InvitationModel{}).Where("inviter_id = ?", member.ID).Count(&count)
+		assert.Zero(t, count, "by the column they name")
+		db.Model(&SessionModel{}).Where("user_id = ?", admin.ID).Count(&count)
+		assert.Equal(t, int64(1), count, "other users' rows are kept")
+
+		_, err = blobs.Get(ctx, users.AvatarKey("avatars/member", users.AvatarSize))
+		assert.Error(t, err, "the avatar is deleted")
+		assert.Equal(t, http.StatusOK, whoami(adminToken, true).Code)
+	})
+}
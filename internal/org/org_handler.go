@@ -0,0 +1,466 @@
+package orgs
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-playground/validator/v10"
+	"github.com/google/uuid"
+	"github.com/tomdoestech/goth/internal/pkg/apperror"
+	"github.com/tomdoestech/goth/internal/pkg/i18n"
+	"github.com/tomdoestech/goth/internal/pkg/identity"
+	"github.com/tomdoestech/goth/internal/pkg/tenant"
+	users "github.com/tomdoestech/goth/internal/user"
+	"github.com/tomdoestech/goth/internal/web"
+	"go.uber.org/zap"
+)
+
+// Switcher remembers the organization a login switched to, the auth
+// handler's SwitchOrg.
+type Switcher interface {
+	SwitchOrg(w http.ResponseWriter, r *http.Request, orgID uuid.UUID) error
+}
+
+// OrgHandler lets users create organizations, switch between those they
+// belong to, and manage the members of the current one.
+type OrgHandler struct {
+	orgService  *OrgService
+	userService *users.UserService
+	switcher    Switcher
+	validate    *validator.Validate
+	i18n        *i18n.Bundle
+	logger      *zap.Logger
+}
+
+type OrgHandlerParams struct {
+	OrgService  *OrgService
+	UserService *users.UserService
+	Switcher    Switcher
+	Validate    *validator.Validate
+	I18n        *i18n.Bundle
+	Logger      *zap.Logger
+}
+
+type createOrgData struct {
+	Name string `json:"name" validate:"required,max=100"`
+}
+
+type switchOrgData struct {
+	OrgID string `json:"org_id" validate:"required,uuid"`
+}
+
+type memberData struct {
+	Email string `json:"email" validate:"required,email"`
+	// Role defaults to member
+	Role string `json:"role,omitempty"`
+}
+
+type memberRoleData struct {
+	Role string `json:"role" validate:"required"`
+}
+
+func NewOrgHandler(p OrgHandlerParams) *OrgHandler {
+	return &OrgHandler{
+		orgService:  p.OrgService,
+		userService: p.UserService,
+		switcher:    p.Switcher,
+		validate:    p.Validate,
+		i18n:        p.I18n,
+		logger:      p.Logger,
+	}
+}
+
+// principal returns the logged in user. Organizations are managed with a
+// session only.
+func (h *OrgHandler) principal(r *http.Request) (*identity.Principal, error) {
+	loc := h.i18n.FromRequest(r)
+
+	p, ok := identity.FromContext(r.Context())
+	if !ok {
+		return nil, apperror.Unauthorized(loc.T("error.unauthorized.message"), nil)
+	}
+	if p.Method == identity.MethodAPIKey {
+		return nil, apperror.Forbidden(loc.T("org.session_required"))
+	}
+	return p, nil
+}
+
+// current returns the organization the request acts for, which the user may
+// manage if manage is set.
+func (h *OrgHandler) current(r *http.Request, manage bool) (*identity.Principal, *tenant.Org, error) {
+	p, err := h.principal(r)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	loc := h.i18n.FromRequest(r)
+
+	org, ok := tenant.FromContext(r.Context())
+	if !ok {
+		return nil, nil, apperror.NotFound(loc.T("org.none"))
+	}
+	if manage && !canManage(org.Role) {
+		return nil, nil, apperror.Forbidden(loc.T("org.admin_only"))
+	}
+
+	return p, org, nil
+}
+
+// Page renders the organizations of the user and the members of the current
+// one.
+func (h *OrgHandler) Page(w http.ResponseWriter, r *http.Request) error {
+	if _, err := h.principal(r); err != nil {
+		return err
+	}
+
+	data, err := h.pageData(r)
+	if err != nil {
+		return err
+	}
+
+	web.RenderTemplate(w, "orgs.html", data, r)
+
+	return nil
+}
+
+// List returns the organizations the user belongs to.
+func (h *OrgHandler) List(w http.ResponseWriter, r *http.Request) error {
+	if _, err := h.principal(r); err != nil {
+		return err
+	}
+
+	current, _ := tenant.FromContext(r.Context())
+
+	orgs := tenant.OrgsFromContext(r.Context())
+	res := make([]OrgResponse, 0, len(orgs))
+	for _, org := range orgs {
+		res = append(res, OrgResponse{
+			ID:      org.ID,
+			Name:    org.Name,
+			Slug:    org.Slug,
+			Role:    org.Role,
+			Current: current != nil && current.ID == org.ID,
+		})
+	}
+
+	return web.WriteJSON(w, http.StatusOK, res)
+}
+
+// Create starts an organization owned by the user and switches to it.
+func (h *OrgHandler) Create(w http.ResponseWriter, r *http.Request) error {
+	p, err := h.principal(r)
+	if err != nil {
+		return err
+	}
+
+	loc := h.i18n.FromRequest(r)
+
+	var data createOrgData
+	if err := web.Decode(r, &data); err != nil {
+		return err
+	}
+
+	if err := h.validate.Struct(&data); err != nil {
+		return web.ValidationError(loc, &data, err)
+	}
+
+	org, err := h.orgService.Create(p.UserID, data.Name)
+	if err != nil {
+		return apperror.Internal(err)
+	}
+
+	if err := h.switcher.SwitchOrg(w, r, org.ID); err != nil {
+		return apperror.Internal(fmt.Errorf("switching organization: %w", err))
+	}
+
+	if web.WantsJSON(r) {
+		return web.WriteJSON(w, http.StatusCreated, OrgResponse{
+			ID:      org.ID,
+			Name:    org.Name,
+			Slug:    org.Slug,
+			Role:    RoleOwner,
+			Current: true,
+		})
+	}
+
+	w.Header().Set("HX-Redirect", "/account/orgs")
+	w.WriteHeader(http.StatusCreated)
+
+	return nil
+}
+
+// Switch makes another organization of the user the current one.
+func (h *OrgHandler) Switch(w http.ResponseWriter, r *http.Request) error {
+	p, err := h.principal(r)
+	if err != nil {
+		return err
+	}
+
+	loc := h.i18n.FromRequest(r)
+
+	var data switchOrgData
+	if err := web.Decode(r, &data); err != nil {
+		return err
+	}
+
+	if err := h.validate.Struct(&data); err != nil {
+		return web.ValidationError(loc, &data, err)
+	}
+
+	orgID := uuid.MustParse(data.OrgID)
+
+	err = h.orgService.Touch(orgID, p.UserID)
+	if errors.Is(err, ErrNotMember) {
+		return apperror.Forbidden(loc.T("org.not_member"))
+	}
+	if err != nil {
+		return apperror.Internal(fmt.Errorf("switching organization: %w", err))
+	}
+
+	if err := h.switcher.SwitchOrg(w, r, orgID); err != nil {
+		return apperror.Internal(fmt.Errorf("switching organization: %w", err))
+	}
+
+	if web.WantsJSON(r) {
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+
+	// everything on the page may belong to the previous organization
+	w.Header().Set("HX-Refresh", "true")
+	w.WriteHeader(http.StatusOK)
+
+	return nil
+}
+
+// Members lists the members of the current organization.
+func (h *OrgHandler) Members(w http.ResponseWriter, r *http.Request) error {
+	_, org, err := h.current(r, false)
+	if err != nil {
+		return err
+	}
+
+	members, err := h.orgService.Members(org.ID)
+	if err != nil {
+		return apperror.Internal(fmt.Errorf("listing members: %w", err))
+	}
+	if members == nil {
+		members = []MemberResponse{}
+	}
+
+	return web.WriteJSON(w, http.StatusOK, members)
+}
+
+// AddMember adds a registered user to the current organization. Only owners
+// may add owners.
+func (h *OrgHandler) AddMember(w http.ResponseWriter, r *http.Request) error {
+	_, org, err := h.current(r, true)
+	if err != nil {
+		return err
+	}
+
+	loc := h.i18n.FromRequest(r)
+
+	var data memberData
+	if err := web.Decode(r, &data); err != nil {
+		return err
+	}
+
+	if err := h.validate.Struct(&data); err != nil {
+		return web.ValidationError(loc, &data, err)
+	}
+
+	if data.Role == "" {
+		data.Role = RoleMember
+	}
+	if err := h.checkRole(loc, org, data.Role); err != nil {
+		return err
+	}
+
+	user, err := h.userService.FindUserByEmail(data.Email)
+	if err != nil {
+		return apperror.NotFound(loc.T("org.user_not_found"))
+	}
+
+	err = h.orgService.AddMember(org.ID, user.ID, data.Role)
+	if errors.Is(err, ErrMemberExists) {
+		return apperror.Conflict(loc.T("org.member_exists"), err)
+	}
+	if err != nil {
+		return apperror.Internal(fmt.Errorf("adding member: %w", err))
+	}
+
+	if web.WantsJSON(r) {
+		return web.WriteJSON(w, http.StatusCreated, MemberResponse{
+			UserID: user.ID,
+			Email:  user.Email,
+			Role:   data.Role,
+		})
+	}
+
+	return h.renderMembers(w, r, http.StatusCreated)
+}
+
+// SetRole changes the role of a member of the current organization.
+func (h *OrgHandler) SetRole(w http.ResponseWriter, r *http.Request) error {
+	_, org, err := h.current(r, true)
+	if err != nil {
+		return err
+	}
+
+	loc := h.i18n.FromRequest(r)
+
+	userID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		return apperror.NotFound(loc.T("org.member_not_found"))
+	}
+
+	var data memberRoleData
+	if err := web.Decode(r, &data); err != nil {
+		return err
+	}
+
+	if err := h.validate.Struct(&data); err != nil {
+		return web.ValidationError(loc, &data, err)
+	}
+
+	if err := h.checkRole(loc, org, data.Role); err != nil {
+		return err
+	}
+
+	// admins can't touch owners
+	if err := h.checkMember(loc, org, userID); err != nil {
+		return err
+	}
+
+	if err := h.memberError(loc, h.orgService.SetRole(org.ID, userID, data.Role)); err != nil {
+		return err
+	}
+
+	if web.WantsJSON(r) {
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+
+	return h.renderMembers(w, r, http.StatusOK)
+}
+
+// RemoveMember takes someone out of the current organization. Anyone may
+// leave, owners and admins may remove others.
+func (h *OrgHandler) RemoveMember(w http.ResponseWriter, r *http.Request) error {
+	p, org, err := h.current(r, false)
+	if err != nil {
+		return err
+	}
+
+	loc := h.i18n.FromRequest(r)
+
+	userID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		return apperror.NotFound(loc.T("org.member_not_found"))
+	}
+
+	if userID != p.UserID {
+		if !canManage(org.Role) {
+			return apperror.Forbidden(loc.T("org.admin_only"))
+		}
+		if err := h.checkMember(loc, org, userID); err != nil {
+			return err
+		}
+	}
+
+	if err := h.memberError(loc, h.orgService.RemoveMember(org.ID, userID)); err != nil {
+		return err
+	}
+
+	if web.WantsJSON(r) {
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+
+	if userID == p.UserID {
+		w.Header().Set("HX-Redirect", "/account/orgs")
+		w.WriteHeader(http.StatusOK)
+		return nil
+	}
+
+	return h.renderMembers(w, r, http.StatusOK)
+}
+
+// checkRole rejects unknown roles, and owners being made by anyone but an
+// owner.
+func (h *OrgHandler) checkRole(loc *i18n.Localizer, org *tenant.Org, role string) error {
+	if !ValidRole(role) {
+		return apperror.Validation(loc.T("error.validation.title"), []string{loc.T("org.invalid_role", role)})
+	}
+	if role == RoleOwner && org.Role != RoleOwner {
+		return apperror.Forbidden(loc.T("org.owner_only"))
+	}
+	return nil
+}
+
+// checkMember stops admins from changing owners.
+func (h *OrgHandler) checkMember(loc *i18n.Localizer, org *tenant.Org, userID uuid.UUID) error {
+	role, err := h.orgService.Role(org.ID, userID)
+	if err != nil {
+		return h.memberError(loc, err)
+	}
+	if role == RoleOwner && org.Role != RoleOwner {
+		return apperror.Forbidden(loc.T("org.owner_only"))
+	}
+	return nil
+}
+
+func (h *OrgHandler) memberError(loc *i18n.Localizer, err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, ErrNotMember):
+		return apperror.NotFound(loc.T("org.member_not_found"))
+	case errors.Is(err, ErrLastOwner):
+		return apperror.Conflict(loc.T("org.last_owner"), err)
+	default:
+		return apperror.Internal(fmt.Errorf("changing member: %w", err))
+	}
+}
+
+// renderMembers renders the member list of the current organization for
+// htmx.
+func (h *OrgHandler) renderMembers(w http.ResponseWriter, r *http.Request, status int) error {
+	data, err := h.pageData(r)
+	if err != nil {
+		return err
+	}
+
+	web.RenderBlock(w, r, "orgs.html", "members", status, data)
+
+	return nil
+}
+
+func (h *OrgHandler) pageData(r *http.Request) (map[string]interface{}, error) {
+	data := map[string]interface{}{
+		"Title": "org.title",
+		"Roles": Roles,
+	}
+
+	org, ok := tenant.FromContext(r.Context())
+	if !ok {
+		return data, nil
+	}
+
+	members, err := h.orgService.Members(org.ID)
+	if err != nil {
+		return nil, apperror.Internal(fmt.Errorf("listing members: %w", err))
+	}
+
+	data["Members"] = members
+	data["Manage"] = canManage(org.Role)
+	data["Owner"] = org.Role == RoleOwner
+	if p, ok := identity.FromContext(r.Context()); ok {
+		data["UserID"] = p.UserID
+	}
+
+	return data, nil
+}
@@ -0,0 +1,122 @@
+package orgs
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/tomdoestech/goth/internal/pkg/openapi"
+	"github.com/tomdoestech/goth/internal/web"
+)
+
+type OrgHTTPParams struct {
+	OrgHandler   *OrgHandler
+	ErrorHandler *web.ErrorHandler
+	API          *openapi.Document
+	Mux          *chi.Mux
+}
+
+// formOrJSON are the body types the organization endpoints accept.
+var formOrJSON = []string{"application/json", "application/x-www-form-urlencoded"}
+
+func NewOrgHTTP(p OrgHTTPParams) {
+
+	r := openapi.NewRouter(p.Mux, p.API)
+	e := p.ErrorHandler
+
+	r.Get("/account/orgs", openapi.Operation{
+		Summary: "Organizations page",
+		Tags:    []string{"orgs"},
+		Responses: map[int]openapi.Response{
+			http.StatusOK: {Description: "The members of the current organization and a form to create one", ContentType: "text/html"},
+		},
+		Errors: []int{http.StatusUnauthorized, http.StatusForbidden},
+		Auth:   true,
+	}, e.Handle(p.OrgHandler.Page))
+
+	r.Get("/api/orgs", openapi.Operation{
+		Summary:     "List organizations",
+		Description: "The organizations the user belongs to, with their role in each. The current one is used unless a request sends the X-Org-ID header.",
+		Tags:        []string{"orgs"},
+		Responses: map[int]openapi.Response{
+			http.StatusOK: {Description: "The organizations, the last active first", Body: []OrgResponse{}},
+		},
+		Errors: []int{http.StatusUnauthorized, http.StatusForbidden},
+		Auth:   true,
+	}, e.Handle(p.OrgHandler.List))
+
+	r.Post("/api/orgs", openapi.Operation{
+		Summary:      "Create an organization",
+		Description:  "The user becomes its owner and switches to it.",
+		Tags:         []string{"orgs"},
+		Request:      createOrgData{},
+		RequestTypes: formOrJSON,
+		Responses: map[int]openapi.Response{
+			http.StatusCreated: {Description: "Organization created, htmx is redirected to the organizations page", Body: OrgResponse{}},
+		},
+		Errors: []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden},
+		Auth:   true,
+	}, e.Handle(p.OrgHandler.Create))
+
+	r.Post("/api/orgs/switch", openapi.Operation{
+		Summary:      "Switch organization",
+		Description:  "Makes another organization of the user the current one for this login. API clients can send the X-Org-ID header instead.",
+		Tags:         []string{"orgs"},
+		Request:      switchOrgData{},
+		RequestTypes: formOrJSON,
+		Responses: map[int]openapi.Response{
+			http.StatusOK:        {Description: "Switched, htmx reloads the page", ContentType: "text/html"},
+			http.StatusNoContent: {Description: "Switched, for JSON clients"},
+		},
+		Errors: []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden},
+		Auth:   true,
+	}, e.Handle(p.OrgHandler.Switch))
+
+	r.Get("/api/orgs/current/members", openapi.Operation{
+		Summary: "List members",
+		Tags:    []string{"orgs"},
+		Responses: map[int]openapi.Response{
+			http.StatusOK: {Description: "The members of the current organization", Body: []MemberResponse{}},
+		},
+		Errors: []int{http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound},
+		Auth:   true,
+	}, e.Handle(p.OrgHandler.Members))
+
+	r.Post("/api/orgs/current/members", openapi.Operation{
+		Summary:      "Add a member",
+		Description:  "Adds a registered user to the current organization. Owners and admins may add members, only owners may add owners.",
+		Tags:         []string{"orgs"},
+		Request:      memberData{},
+		RequestTypes: formOrJSON,
+		Responses: map[int]openapi.Response{
+			http.StatusCreated: {Description: "Member added, htmx gets the updated list", Body: MemberResponse{}},
+		},
+		Errors: []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound, http.StatusConflict},
+		Auth:   true,
+	}, e.Handle(p.OrgHandler.AddMember))
+
+	r.Patch("/api/orgs/current/members/{id}", openapi.Operation{
+		Summary:      "Change a member's role",
+		Description:  "The last owner can't be demoted. Admins can't change owners.",
+		Tags:         []string{"orgs"},
+		Request:      memberRoleData{},
+		RequestTypes: formOrJSON,
+		Responses: map[int]openapi.Response{
+			http.StatusOK:        {Description: "Role changed, htmx gets the updated list", ContentType: "text/html"},
+			http.StatusNoContent: {Description: "Role changed, for JSON clients"},
+		},
+		Errors: []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound, http.StatusConflict},
+		Auth:   true,
+	}, e.Handle(p.OrgHandler.SetRole))
+
+	r.Delete("/api/orgs/current/members/{id}", openapi.Operation{
+		Summary:     "Remove a member",
+		Description: "Members may remove themselves to leave. The last owner can't leave.",
+		Tags:        []string{"orgs"},
+		Responses: map[int]openapi.Response{
+			http.StatusOK:        {Description: "Member removed, htmx gets the updated list", ContentType: "text/html"},
+			http.StatusNoContent: {Description: "Member removed, for JSON clients"},
+		},
+		Errors: []int{http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound, http.StatusConflict},
+		Auth:   true,
+	}, e.Handle(p.OrgHandler.RemoveMember))
+}
@@ -0,0 +1,78 @@
+package orgs
+
+import (
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/tomdoestech/goth/internal/pkg/apperror"
+	"github.com/tomdoestech/goth/internal/pkg/i18n"
+	"github.com/tomdoestech/goth/internal/pkg/identity"
+	"github.com/tomdoestech/goth/internal/pkg/tenant"
+	"go.uber.org/zap"
+)
+
+// OrgHeader lets API clients pick the organization a request acts for.
+const OrgHeader = "X-Org-ID"
+
+type MiddlewareParams struct {
+	OrgService *OrgService
+	I18n       *i18n.Bundle
+	// Forbidden renders the error for an OrgHeader naming an organization
+	// the user isn't a member of
+	Forbidden func(w http.ResponseWriter, r *http.Request, err error)
+	Logger    *zap.Logger
+}
+
+// Middleware loads the organizations of the logged in user into the request
+// context, see tenant.FromContext. The current one is picked from, in order:
+// the OrgHeader, the organization the login switched to, and the one the
+// user was last active in. It runs after the identity middleware.
+func Middleware(p MiddlewareParams) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			principal, ok := identity.FromContext(r.Context())
+			if !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			orgs, err := p.OrgService.Orgs(principal.UserID)
+			if err != nil {
+				p.Logger.Error("Error loading organizations", zap.Error(err))
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			var current *tenant.Org
+			if header := r.Header.Get(OrgHeader); header != "" {
+				id, _ := uuid.Parse(header)
+				if current = find(orgs, id); current == nil {
+					loc := p.I18n.FromRequest(r)
+					p.Forbidden(w, r, apperror.Forbidden(loc.T("org.not_member")))
+					return
+				}
+			}
+			if current == nil {
+				current = find(orgs, principal.OrgID)
+			}
+			if current == nil && len(orgs) > 0 {
+				current = &orgs[0]
+			}
+
+			next.ServeHTTP(w, r.WithContext(tenant.NewContext(r.Context(), current, orgs)))
+		}
+		return http.HandlerFunc(fn)
+	}
+}
+
+func find(orgs []tenant.Org, id uuid.UUID) *tenant.Org {
+	if id == uuid.Nil {
+		return nil
+	}
+	for i := range orgs {
+		if orgs[i].ID == id {
+			return &orgs[i]
+		}
+	}
+	return nil
+}
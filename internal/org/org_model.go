@@ -0,0 +1,87 @@
+package orgs
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// OrganizationModel is a tenant. Its data is kept apart from other
+// organizations' with tenant.Model and tenant.Scope.
+type OrganizationModel struct {
+	ID        uuid.UUID `gorm:"primaryKey;type:uuid"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+
+	Name string `gorm:"not null"`
+	Slug string `gorm:"uniqueIndex;not null"`
+}
+
+func (OrganizationModel) TableName() string {
+	return "organizations"
+}
+
+// MembershipModel makes a user a member of an organization, with a role in
+// it. Users may belong to several organizations.
+type MembershipModel struct {
+	ID        uuid.UUID `gorm:"primaryKey;type:uuid"`
+	CreatedAt time.Time
+
+	OrgID  uuid.UUID `gorm:"type:uuid;uniqueIndex:idx_membership;not null"`
+	UserID uuid.UUID `gorm:"type:uuid;uniqueIndex:idx_membership;index;not null"`
+	Role   string    `gorm:"not null"`
+	// LastActiveAt is when the user last switched to the organization, the
+	// most recent one is used when a login doesn't name one
+	LastActiveAt *time.Time
+}
+
+func (MembershipModel) TableName() string {
+	return "memberships"
+}
+
+// Roles of a member within an organization, unrelated to the users.Roles of
+// the whole app.
+const (
+	// RoleOwner may do anything, including making others owners. Every
+	// organization keeps at least one.
+	RoleOwner = "owner"
+	// RoleAdmin may add and remove members
+	RoleAdmin  = "admin"
+	RoleMember = "member"
+)
+
+// Roles lists the roles from the most to the least powerful.
+var Roles = []string{RoleOwner, RoleAdmin, RoleMember}
+
+// ValidRole reports whether role is one of Roles.
+func ValidRole(role string) bool {
+	for _, r := range Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// canManage reports whether role may manage the members of the organization.
+func canManage(role string) bool {
+	return role == RoleOwner || role == RoleAdmin
+}
+
+// OrgResponse is the representation of an organization returned to API
+// clients, with the user's role in it.
+type OrgResponse struct {
+	ID      uuid.UUID `json:"id"`
+	Name    string    `json:"name"`
+	Slug    string    `json:"slug"`
+	Role    string    `json:"role"`
+	Current bool      `json:"current"`
+}
+
+// MemberResponse is a member of an organization.
+type MemberResponse struct {
+	UserID   uuid.UUID `json:"user_id"`
+	Email    string    `json:"email"`
+	Role     string    `json:"role"`
+	JoinedAt time.Time `json:"joined_at"`
+}
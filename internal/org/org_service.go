@@ -0,0 +1,302 @@
+package orgs
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/google/uuid"
+	"github.com/tomdoestech/goth/internal/pkg/tenant"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrNotMember    = errors.New("not a member of the organization")
+	ErrMemberExists = errors.New("already a member of the organization")
+	// ErrLastOwner is returned when a change would leave an organization
+	// without an owner
+	ErrLastOwner = errors.New("the organization needs an owner")
+)
+
+type OrgService struct {
+	db     *gorm.DB
+	logger *zap.Logger
+	now    func() time.Time
+}
+
+type OrgServiceParams struct {
+	DB     *gorm.DB
+	Logger *zap.Logger
+}
+
+func NewOrgService(p OrgServiceParams) *OrgService {
+
+	p.DB.AutoMigrate(&OrganizationModel{}, &MembershipModel{})
+
+	return &OrgService{
+		db:     p.DB,
+		logger: p.Logger,
+		now:    time.Now,
+	}
+}
+
+// Create starts an organization owned by userID, who switches to it.
+func (s *OrgService) Create(userID uuid.UUID, name string) (*OrganizationModel, error) {
+	slug, err := s.slug(name)
+	if err != nil {
+		return nil, err
+	}
+
+	now := s.now()
+	org := &OrganizationModel{
+		ID:   uuid.New(),
+		Name: strings.TrimSpace(name),
+		Slug: slug,
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(org).Error; err != nil {
+			return err
+		}
+		return tx.Create(&MembershipModel{
+			ID:           uuid.New(),
+			OrgID:        org.ID,
+			UserID:       userID,
+			Role:         RoleOwner,
+			LastActiveAt: &now,
+		}).Error
+	})
+	if err != nil {
+		return nil, fmt.Errorf("creating organization: %w", err)
+	}
+
+	return org, nil
+}
+
+// slug turns name into a unique slug for URLs, adding a random suffix
+// when it is taken.
+func (s *OrgService) slug(name string) (string, error) {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(name) {
+		if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			b.WriteRune(r)
+			dash = false
+		} else if !dash && b.Len() > 0 {
+			b.WriteByte('-')
+			dash = true
+		}
+	}
+	slug := strings.TrimSuffix(b.String(), "-")
+	if slug == "" {
+		slug = "org"
+	}
+
+	var count int64
+	if err := s.db.Model(&OrganizationModel{}).Where("slug = ?", slug).Count(&count).Error; err != nil {
+		return "", err
+	}
+	if count == 0 {
+		return slug, nil
+	}
+
+	suffix := make([]byte, 3)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", err
+	}
+	return slug + "-" + hex.EncodeToString(suffix), nil
+}
+
+// Orgs returns the organizations userID belongs to, the one they were last
+// active in first.
+func (s *OrgService) Orgs(userID uuid.UUID) ([]tenant.Org, error) {
+	var orgs []tenant.Org
+	err := s.db.Table("memberships").
+		Select("organizations.id, organizations.name, organizations.slug, memberships.role").
+		Joins("JOIN organizations ON organizations.id = memberships.org_id").
+		Where("memberships.user_id = ?", userID).
+		// databases disagree on where NULLs sort
+		Order("memberships.last_active_at IS NULL, memberships.last_active_at DESC, organizations.name").
+		Scan(&orgs).Error
+	return orgs, err
+}
+
+// Touch records that userID switched to orgID.
+func (s *OrgService) Touch(orgID, userID uuid.UUID) error {
+	result := s.db.Model(&MembershipModel{}).
+		Where("org_id = ? AND user_id = ?", orgID, userID).
+		Update("last_active_at", s.now())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotMember
+	}
+	return nil
+}
+
+// Members returns the members of orgID in the order they joined.
+func (s *OrgService) Members(orgID uuid.UUID) ([]MemberResponse, error) {
+	var members []MemberResponse
+	err := s.db.Table("memberships").
+		Select("memberships.user_id, users.email, memberships.role, memberships.created_at AS joined_at").
+		Joins("JOIN users ON users.id = memberships.user_id").
+		Where("memberships.org_id = ?", orgID).
+		Order("memberships.created_at").
+		Scan(&members).Error
+	return members, err
+}
+
+// AddMember gives userID role in orgID.
+func (s *OrgService) AddMember(orgID, userID uuid.UUID, role string) error {
+	var count int64
+	err := s.db.Model(&MembershipModel{}).Where("org_id = ? AND user_id = ?", orgID, userID).Count(&count).Error
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return ErrMemberExists
+	}
+
+	return s.db.Create(&MembershipModel{
+		ID:     uuid.New(),
+		OrgID:  orgID,
+		UserID: userID,
+		Role:   role,
+	}).Error
+}
+
+// Role returns userID's role in orgID.
+func (s *OrgService) Role(orgID, userID uuid.UUID) (string, error) {
+	var membership MembershipModel
+	err := s.db.Where("org_id = ? AND user_id = ?", orgID, userID).First(&membership).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return "", ErrNotMember
+	}
+	return membership.Role, err
+}
+
+// SetRole changes userID's role in orgID. The last owner can't give up
+// ownership.
+func (s *OrgService) SetRole(orgID, userID uuid.UUID, role string) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if role != RoleOwner {
+			if err := s.keepOwner(tx, orgID, userID); err != nil {
+				return err
+			}
+		}
+
+		result := tx.Model(&MembershipModel{}).
+			Where("org_id = ? AND user_id = ?", orgID, userID).
+			Update("role", role)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrNotMember
+		}
+		return nil
+	})
+}
+
+// RemoveMember takes userID out of orgID, unless they are its last owner.
+func (s *OrgService) RemoveMember(orgID, userID uuid.UUID) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := s.keepOwner(tx, orgID, userID); err != nil {
+			return err
+		}
+
+		result := tx.Where("org_id = ? AND user_id = ?", orgID, userID).Delete(&MembershipModel{})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrNotMember
+		}
+		return nil
+	})
+}
+
+// keepOwner fails with ErrLastOwner when userID is the only owner of orgID.
+func (s *OrgService) keepOwner(tx *gorm.DB, orgID, userID uuid.UUID) error {
+	var others int64
+	err := tx.Model(&MembershipModel{}).
+		Where("org_id = ? AND role = ? AND user_id <> ?", orgID, RoleOwner, userID).
+		Count(&others).Error
+	if err != nil {
+		return err
+	}
+	if others > 0 {
+		return nil
+	}
+
+	var owner int64
+	err = tx.Model(&MembershipModel{}).
+		Where("org_id = ? AND role = ? AND user_id = ?", orgID, RoleOwner, userID).
+		Count(&owner).Error
+	if err != nil {
+		return err
+	}
+	if owner > 0 {
+		return ErrLastOwner
+	}
+	return nil
+}
+
+// HandOver makes another member owner of the organizations userIDs are the
+// only owners of, an admin if there is one, otherwise the longest-standing
+// member. Organizations without other members are deleted. It is the
+// OnDelete of the users being deleted, and runs in their transaction.
+func (s *OrgService) HandOver(tx *gorm.DB, userIDs []uuid.UUID) error {
+	var orgIDs []uuid.UUID
+	err := tx.Model(&MembershipModel{}).
+		Where("user_id IN ? AND role = ?", userIDs, RoleOwner).
+		Distinct().
+		Pluck("org_id", &orgIDs).Error
+	if err != nil {
+		return err
+	}
+
+	for _, orgID := range orgIDs {
+		var owners int64
+		err := tx.Model(&MembershipModel{}).
+			Where("org_id = ? AND role = ? AND user_id NOT IN ?", orgID, RoleOwner, userIDs).
+			Count(&owners).Error
+		if err != nil {
+			return err
+		}
+		if owners > 0 {
+			continue
+		}
+
+		var heir MembershipModel
+		err = tx.Where("org_id = ? AND user_id NOT IN ?", orgID, userIDs).
+			Order("CASE WHEN role = '" + RoleAdmin + "' THEN 0 ELSE 1 END, created_at").
+			First(&heir).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			if err := tx.Where("org_id = ?", orgID).Delete(&MembershipModel{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Where("id = ?", orgID).Delete(&OrganizationModel{}).Error; err != nil {
+				return err
+			}
+			s.logger.Info("Deleted organization left without members", zap.Stringer("org", orgID))
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := tx.Model(&heir).Update("role", RoleOwner).Error; err != nil {
+			return err
+		}
+		s.logger.Info("Handed organization over", zap.Stringer("org", orgID), zap.Stringer("owner", heir.UserID))
+	}
+
+	return nil
+}
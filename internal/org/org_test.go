@@ -0,0 +1,301 @@
+//go:build unit
+// +build unit
+
+package orgs
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-playground/validator/v10"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/tomdoestech/goth/internal/pkg/i18n"
+	"github.com/tomdoestech/goth/internal/pkg/identity"
+	"github.com/tomdoestech/goth/internal/pkg/openapi"
+	"github.com/tomdoestech/goth/internal/pkg/tenant"
+	users "github.com/tomdoestech/goth/internal/user"
+	"github.com/tomdoestech/goth/internal/web"
+	"go.uber.org/zap"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// switches records the organizations logins switched to.
+type switches map[uuid.UUID]uuid.UUID
+
+func (s switches) SwitchOrg(w http.ResponseWriter, r *http.Request, orgID uuid.UUID) error {
+	p, _ := identity.FromContext(r.Context())
+	s[p.UserID] = orgID
+	return nil
+}
+
+func TestOrgs(t *testing.T) {
+	f, err := os.CreateTemp("", "test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+
+	db, err := gorm.Open(sqlite.Open(f.Name()), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	validate := validator.New()
+	bundle, err := i18n.NewBundle(i18n.BundleParams{Validate: validate})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	usersService := users.NewUserService(users.UserServiceParams{Logger: zap.NewNop(), Validate: validate, DB: db})
+	alice, err := usersService.CreateUser("alice@example.com", "tangerine-otter-89-lamp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, _ := usersService.CreateUser("bob@example.com", "tangerine-otter-89-lamp")
+	carol, _ := usersService.CreateUser("carol@example.com", "tangerine-otter-89-lamp")
+
+	orgService := NewOrgService(OrgServiceParams{DB: db, Logger: zap.NewNop()})
+	now := time.Now()
+	orgService.now = func() time.Time { return now }
+
+	acme, err := orgService.Create(alice.ID, "Acme, Inc.")
+	if assert.NoError(t, err) {
+		assert.Equal(t, "acme-inc", acme.Slug)
+	}
+	now = now.Add(time.Minute)
+	other, err := orgService.Create(alice.ID, "Acme Inc")
+	if assert.NoError(t, err) {
+		assert.True(t, strings.HasPrefix(other.Slug, "acme-inc-"), other.Slug)
+	}
+
+	orgs, err := orgService.Orgs(alice.ID)
+	if assert.NoError(t, err) && assert.Len(t, orgs, 2) {
+		assert.Equal(t, other.ID, orgs[0].ID, "the last active first")
+		assert.Equal(t, RoleOwner, orgs[0].Role)
+	}
+
+	errorHandler := web.NewErrorHandler(web.ErrorHandlerParams{I18n: bundle, Logger: zap.NewNop()})
+	switched := switches{}
+
+	mux := chi.NewMux()
+	NewOrgHTTP(OrgHTTPParams{
+		OrgHandler: NewOrgHandler(OrgHandlerParams{
+			OrgService:  orgService,
+			UserService: usersService,
+			Switcher:    switched,
+			Validate:    validate,
+			I18n:        bundle,
+			Logger:      zap.NewNop(),
+		}),
+		ErrorHandler: errorHandler,
+		API:          openapi.New(openapi.Params{Title: "goth", Version: "1.0.0"}),
+		Mux:          mux,
+	})
+
+	// what the handlers see of the current organization
+	var seen *tenant.Org
+	mux.Get("/whoami", func(w http.ResponseWriter, r *http.Request) {
+		seen, _ = tenant.FromContext(r.Context())
+	})
+
+	handler := bundle.Middleware(Middleware(MiddlewareParams{
+		OrgService: orgService,
+		I18n:       bundle,
+		Forbidden:  errorHandler.Render,
+		Logger:     zap.NewNop(),
+	})(mux))
+
+	do := func(p *identity.Principal, method, path string, form url.Values, header http.Header) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Accept", "application/json")
+		for k, v := range header {
+			req.Header.Set(k, v[0])
+		}
+		req = req.WithContext(identity.NewContext(req.Context(), p))
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	asAlice := &identity.Principal{UserID: alice.ID, Email: alice.Email, Method: identity.MethodCookie}
+	asBob := &identity.Principal{UserID: bob.ID, Email: bob.Email, Method: identity.MethodCookie}
+	asCarol := &identity.Principal{UserID: carol.ID, Email: carol.Email, Method: identity.MethodCookie}
+
+	t.Run("current organization", func(t *testing.T) {
+		do(asAlice, "GET", "/whoami", nil, nil)
+		if assert.NotNil(t, seen) {
+			assert.Equal(t, other.ID, seen.ID)
+		}
+
+		switchedTo := &identity.Principal{UserID: alice.ID, Method: identity.MethodCookie, OrgID: acme.ID}
+		do(switchedTo, "GET", "/whoami", nil, nil)
+		if assert.NotNil(t, seen) {
+			assert.Equal(t, acme.ID, seen.ID)
+		}
+
+		do(switchedTo, "GET", "/whoami", nil, http.Header{OrgHeader: {other.ID.String()}})
+		if assert.NotNil(t, seen) {
+			assert.Equal(t, other.ID, seen.ID)
+		}
+
+		seen = nil
+		do(asCarol, "GET", "/whoami", nil, nil)
+		assert.Nil(t, seen)
+
+		w := do(asCarol, "GET", "/whoami", nil, http.Header{OrgHeader: {acme.ID.String()}})
+		assert.Equal(t, http.StatusForbidden, w.Code)
+	})
+
+	acmeHeader := http.Header{OrgHeader: {acme.ID.String()}}
+
+	t.Run("members", func(t *testing.T) {
+		w := do(asAlice, "POST", "/api/orgs/current/members", url.Values{"email": {"bob@example.com"}, "role": {RoleAdmin}}, acmeHeader)
+		assert.Equal(t, http.StatusCreated, w.Code)
+
+		w = do(asAlice, "POST", "/api/orgs/current/members", url.Values{"email": {"bob@example.com"}}, acmeHeader)
+		assert.Equal(t, http.StatusConflict, w.Code)
+
+		w = do(asAlice, "POST", "/api/orgs/current/members", url.Values{"email": {"nobody@example.com"}}, acmeHeader)
+		assert.Equal(t, http.StatusNotFound, w.Code)
+
+		// admins can't make owners or touch them
+		w = do(asBob, "POST", "/api/orgs/current/members", url.Values{"email": {"carol@example.com"}, "role": {RoleOwner}}, acmeHeader)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		w = do(asBob, "DELETE", "/api/orgs/current/members/"+alice.ID.String(), nil, acmeHeader)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+
+		w = do(asBob, "POST", "/api/orgs/current/members", url.Values{"email": {"carol@example.com"}}, acmeHeader)
+		assert.Equal(t, http.StatusCreated, w.Code)
+
+		// members can't manage anyone
+		w = do(asCarol, "PATCH", "/api/orgs/current/members/"+bob.ID.String(), url.Values{"role": {RoleMember}}, acmeHeader)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+
+		w = do(asCarol, "GET", "/api/orgs/current/members", nil, acmeHeader)
+		assert.Equal(t, http.StatusOK, w.Code)
+		var members []MemberResponse
+		if assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &members)) && assert.Len(t, members, 3) {
+			assert.Equal(t, "alice@example.com", members[0].Email)
+			assert.Equal(t, RoleOwner, members[0].Role)
+			assert.False(t, members[0].JoinedAt.IsZero())
+		}
+
+		w = do(asAlice, "DELETE", "/api/orgs/current/members/"+alice.ID.String(), nil, acmeHeader)
+		assert.Equal(t, http.StatusConflict, w.Code, "the last owner can't leave")
+		w = do(asAlice, "PATCH", "/api/orgs/current/members/"+alice.ID.String(), url.Values{"role": {RoleMember}}, acmeHeader)
+		assert.Equal(t, http.StatusConflict, w.Code, "the last owner can't step down")
+
+		w = do(asAlice, "PATCH", "/api/orgs/current/members/"+bob.ID.String(), url.Values{"role": {RoleOwner}}, acmeHeader)
+		assert.Equal(t, http.StatusNoContent, w.Code)
+		w = do(asAlice, "DELETE", "/api/orgs/current/members/"+alice.ID.String(), nil, acmeHeader)
+		assert.Equal(t, http.StatusNoContent, w.Code)
+
+		w = do(asCarol, "DELETE", "/api/orgs/current/members/"+carol.ID.String(), nil, acmeHeader)
+		assert.Equal(t, http.StatusNoContent, w.Code, "anyone can leave")
+	})
+
+	t.Run("switch", func(t *testing.T) {
+		w := do(asAlice, "POST", "/api/orgs/switch", url.Values{"org_id": {acme.ID.String()}}, nil)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+
+		now = now.Add(time.Minute)
+		w = do(asBob, "POST", "/api/orgs", url.Values{"name": {"Globex"}}, nil)
+		assert.Equal(t, http.StatusCreated, w.Code)
+		var created OrgResponse
+		if assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &created)) {
+			assert.Equal(t, created.ID, switched[bob.ID])
+		}
+
+		now = now.Add(time.Minute)
+		w = do(asBob, "POST", "/api/orgs/switch", url.Values{"org_id": {acme.ID.String()}}, nil)
+		assert.Equal(t, http.StatusNoContent, w.Code)
+		assert.Equal(t, acme.ID, switched[bob.ID])
+
+		// a new login starts where the user left off
+		do(asBob, "GET", "/whoami", nil, nil)
+		if assert.NotNil(t, seen) {
+			assert.Equal(t, acme.ID, seen.ID)
+		}
+	})
+
+	t.Run("page", func(t *testing.T) {
+		w := do(asBob, "GET", "/account/orgs", nil, http.Header{"Accept": {"text/html"}})
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), "Current organization: Acme, Inc. (Owner)")
+		assert.Contains(t, w.Body.String(), `<option value="`+acme.ID.String()+`" selected>Acme, Inc.</option>`)
+		assert.Contains(t, w.Body.String(), "Globex")
+	})
+}
+
+func TestHandOver(t *testing.T) {
+	f, err := os.CreateTemp("", "test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+
+	db, err := gorm.Open(sqlite.Open(f.Name()), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewOrgService(OrgServiceParams{DB: db, Logger: zap.NewNop()})
+	usersService := users.NewUserService(users.UserServiceParams{
+		Logger:     zap.NewNop(),
+		Validate:   validator.New(),
+		DB:         db,
+		Dependents: []users.Dependent{{Table: MembershipModel{}.TableName()}},
+		OnDelete:   []func(tx *gorm.DB, userIDs []uuid.UUID) error{s.HandOver},
+	})
+
+	create := func(email string) uuid.UUID {
+		user, err := usersService.CreateUser(email, "tangerine-otter-89-lamp")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return user.ID
+	}
+	alice, bob, carol, dave := create("alice@example.com"), create("bob@example.com"), create("carol@example.com"), create("dave@example.com")
+
+	shared, _ := s.Create(alice, "Shared")
+	assert.NoError(t, s.AddMember(shared.ID, carol, RoleMember))
+	assert.NoError(t, s.AddMember(shared.ID, bob, RoleAdmin))
+	alone, _ := s.Create(alice, "Alone")
+	owned, _ := s.Create(alice, "Owned")
+	assert.NoError(t, s.AddMember(owned.ID, dave, RoleOwner))
+	assert.NoError(t, s.AddMember(owned.ID, carol, RoleAdmin))
+
+	assert.NoError(t, usersService.ScheduleDeletion(alice, 0))
+	n, err := usersService.DeleteDue(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), n)
+
+	role, err := s.Role(shared.ID, bob)
+	assert.NoError(t, err)
+	assert.Equal(t, RoleOwner, role, "admins first")
+	role, _ = s.Role(shared.ID, carol)
+	assert.Equal(t, RoleMember, role)
+
+	var count int64
+	db.Model(&OrganizationModel{}).Where("id = ?", alone.ID).Count(&count)
+	assert.Zero(t, count, "no one left to own it")
+
+	role, _ = s.Role(owned.ID, carol)
+	assert.Equal(t, RoleAdmin, role, "it still has an owner")
+
+	_, err = s.Role(shared.ID, alice)
+	assert.Error(t, err)
+}
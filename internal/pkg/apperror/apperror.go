@@ -0,0 +1,116 @@
+package apperror
+
+import (
+	"errors"
+	"net/http"
+)
+
+// Kind classifies an Error and decides the HTTP status it is reported with.
+type Kind int
+
+const (
+	KindInternal Kind = iota
+	KindNotFound
+	KindUnauthorized
+	KindForbidden
+	KindValidation
+	KindMethodNotAllowed
+	KindConflict
+	KindTooManyRequests
+)
+
+func (k Kind) Status() int {
+	switch k {
+	case KindNotFound:
+		return http.StatusNotFound
+	case KindUnauthorized:
+		return http.StatusUnauthorized
+	case KindForbidden:
+		return http.StatusForbidden
+	case KindValidation:
+		return http.StatusBadRequest
+	case KindMethodNotAllowed:
+		return http.StatusMethodNotAllowed
+	case KindConflict:
+		return http.StatusConflict
+	case KindTooManyRequests:
+		return http.StatusTooManyRequests
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// Error is the error type handlers return. Message is safe to show to the user,
+// Err is the underlying cause and is only ever logged.
+type Error struct {
+	Kind    Kind
+	Message string
+	// Details holds one message per invalid field for validation errors
+	Details []string
+	// Fields holds the same messages keyed by the field's name in the request
+	Fields map[string]string
+	Err    error
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		if e.Message == "" {
+			return e.Err.Error()
+		}
+		return e.Message + ": " + e.Err.Error()
+	}
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func (e *Error) Status() int {
+	return e.Kind.Status()
+}
+
+func New(kind Kind, message string, err error) *Error {
+	return &Error{Kind: kind, Message: message, Err: err}
+}
+
+func NotFound(message string) *Error {
+	return &Error{Kind: KindNotFound, Message: message}
+}
+
+func Unauthorized(message string, err error) *Error {
+	return &Error{Kind: KindUnauthorized, Message: message, Err: err}
+}
+
+func Forbidden(message string) *Error {
+	return &Error{Kind: KindForbidden, Message: message}
+}
+
+func Validation(message string, details []string) *Error {
+	return &Error{Kind: KindValidation, Message: message, Details: details}
+}
+
+// Conflict is for requests that clash with existing state, such as creating
+// something that already exists.
+func Conflict(message string, err error) *Error {
+	return &Error{Kind: KindConflict, Message: message, Err: err}
+}
+
+// TooManyRequests is for clients that hit a rate limit.
+func TooManyRequests(message string) *Error {
+	return &Error{Kind: KindTooManyRequests, Message: message}
+}
+
+// Internal wraps an unexpected error. The cause is logged but never shown.
+func Internal(err error) *Error {
+	return &Error{Kind: KindInternal, Err: err}
+}
+
+// From converts any error to an *Error, treating unknown errors as internal.
+func From(err error) *Error {
+	var appErr *Error
+	if errors.As(err, &appErr) {
+		return appErr
+	}
+	return Internal(err)
+}
@@ -0,0 +1,220 @@
+package assets
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/andybalholm/brotli"
+	"github.com/tomdoestech/goth/internal/pkg/compress"
+)
+
+// compressible lists the extensions worth compressing, images are already compressed.
+var compressible = map[string]bool{
+	".css":  true,
+	".js":   true,
+	".svg":  true,
+	".json": true,
+	".html": true,
+	".txt":  true,
+	".map":  true,
+}
+
+type file struct {
+	hash    string
+	body    []byte
+	gzip    []byte
+	brotli  []byte
+	modTime time.Time
+}
+
+// Assets serves a file system of static assets under content hashed URLs.
+type Assets struct {
+	prefix string
+	// files is keyed by the logical name, e.g. css/style.css
+	files map[string]*file
+	// hashed maps fingerprinted names, e.g. css/style.3f2a1b9c0d.css, to logical names
+	hashed map[string]string
+}
+
+type Params struct {
+	FS fs.FS
+	// Prefix is the URL path the handler is mounted at, e.g. /static/
+	Prefix string
+}
+
+// New reads every file in p.FS, fingerprints it and prepares gzip and brotli
+// variants. Precompressed siblings (style.css.br, style.css.gz) are used
+// instead of compressing at startup when present.
+func New(p Params) (*Assets, error) {
+	a := &Assets{
+		prefix: p.Prefix,
+		files:  map[string]*file{},
+		hashed: map[string]string{},
+	}
+
+	err := fs.WalkDir(p.FS, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+
+		ext := path.Ext(name)
+		if ext == ".br" || ext == ".gz" || ext == ".go" {
+			return nil
+		}
+
+		body, err := fs.ReadFile(p.FS, name)
+		if err != nil {
+			return err
+		}
+
+		sum := sha256.Sum256(body)
+		f := &file{
+			hash: hex.EncodeToString(sum[:])[:10],
+			body: body,
+		}
+
+		if info, err := d.Info(); err == nil {
+			f.modTime = info.ModTime()
+		}
+
+		if compressible[ext] {
+			if f.gzip, err = precompressed(p.FS, name+".gz", body, gzipBytes); err != nil {
+				return err
+			}
+			if f.brotli, err = precompressed(p.FS, name+".br", body, brotliBytes); err != nil {
+				return err
+			}
+		}
+
+		a.files[name] = f
+		a.hashed[fingerprint(name, f.hash)] = name
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("loading assets: %w", err)
+	}
+
+	return a, nil
+}
+
+// URL returns the fingerprinted URL for the logical name, falling back to the
+// plain URL for unknown files.
+func (a *Assets) URL(name string) string {
+	name = strings.TrimPrefix(name, "/")
+	f, ok := a.files[name]
+	if !ok {
+		return a.prefix + name
+	}
+	return a.prefix + fingerprint(name, f.hash)
+}
+
+// ServeHTTP serves assets by logical or fingerprinted name, relative to the
+// prefix. Fingerprinted URLs never change content, so they are cached forever;
+// plain URLs are revalidated with their ETag. Directories are never listed.
+func (a *Assets) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/")
+
+	immutable := false
+	if logical, ok := a.hashed[name]; ok {
+		name, immutable = logical, true
+	}
+
+	f, ok := a.files[name]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	h := w.Header()
+
+	if immutable {
+		h.Set("Cache-Control", "public, max-age=31536000, immutable")
+	} else {
+		h.Set("Cache-Control", "no-cache")
+	}
+
+	if ctype := mime.TypeByExtension(path.Ext(name)); ctype != "" {
+		h.Set("Content-Type", ctype)
+	}
+
+	body, etag := f.body, f.hash
+	if f.gzip != nil || f.brotli != nil {
+		if !strings.Contains(strings.Join(h.Values("Vary"), ","), "Accept-Encoding") {
+			h.Add("Vary", "Accept-Encoding")
+		}
+
+		accept := r.Header.Get("Accept-Encoding")
+		switch {
+		case f.brotli != nil && compress.AcceptsEncoding(accept, "br"):
+			body, etag = f.brotli, f.hash+"-br"
+			h.Set("Content-Encoding", "br")
+		case f.gzip != nil && compress.AcceptsEncoding(accept, "gzip"):
+			body, etag = f.gzip, f.hash+"-gz"
+			h.Set("Content-Encoding", "gzip")
+		}
+	}
+
+	// ServeContent answers If-None-Match with 304 once the ETag is set
+	h.Set("ETag", `"`+etag+`"`)
+
+	http.ServeContent(w, r, name, f.modTime, bytes.NewReader(body))
+}
+
+// fingerprint inserts hash before the extension: css/style.css -> css/style.<hash>.css
+func fingerprint(name, hash string) string {
+	ext := path.Ext(name)
+	return strings.TrimSuffix(name, ext) + "." + hash + ext
+}
+
+// precompressed returns the sibling file if it exists, otherwise compresses
+// body. Variants that aren't smaller than the original are dropped.
+func precompressed(fsys fs.FS, name string, body []byte, compress func([]byte) ([]byte, error)) ([]byte, error) {
+	out, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		if out, err = compress(body); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(out) >= len(body) {
+		return nil, nil
+	}
+	return out, nil
+}
+
+func gzipBytes(body []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	zw, err := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := zw.Write(body); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func brotliBytes(body []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	bw := brotli.NewWriterLevel(&buf, brotli.BestCompression)
+	if _, err := bw.Write(body); err != nil {
+		return nil, err
+	}
+	if err := bw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,115 @@
+//go:build unit
+// +build unit
+
+package assets
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAssets(t *testing.T) {
+
+	css := strings.Repeat("body { color: red; }\n", 100)
+
+	a, err := New(Params{
+		FS: fstest.MapFS{
+			"css/style.css": {Data: []byte(css)},
+			"img/go.png":    {Data: []byte("\x89PNG")},
+		},
+		Prefix: "/static/",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hashedURL := a.URL("css/style.css")
+
+	testCases := []struct {
+		description          string
+		path                 string
+		headers              map[string]string
+		expectedStatusCode   int
+		expectedCacheControl string
+		expectedEncoding     string
+	}{
+		{
+			description:          "fingerprinted url is immutable",
+			path:                 strings.TrimPrefix(hashedURL, "/static"),
+			expectedStatusCode:   200,
+			expectedCacheControl: "public, max-age=31536000, immutable",
+		},
+		{
+			description:          "plain url is revalidated",
+			path:                 "/css/style.css",
+			expectedStatusCode:   200,
+			expectedCacheControl: "no-cache",
+		},
+		{
+			description:          "brotli is preferred",
+			path:                 "/css/style.css",
+			headers:              map[string]string{"Accept-Encoding": "gzip, br"},
+			expectedStatusCode:   200,
+			expectedCacheControl: "no-cache",
+			expectedEncoding:     "br",
+		},
+		{
+			description:          "gzip when brotli is refused",
+			path:                 "/css/style.css",
+			headers:              map[string]string{"Accept-Encoding": "gzip, br;q=0"},
+			expectedStatusCode:   200,
+			expectedCacheControl: "no-cache",
+			expectedEncoding:     "gzip",
+		},
+		{
+			description:        "directories are not listed",
+			path:               "/css/",
+			expectedStatusCode: 404,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+
+			assert := assert.New(t)
+
+			req := httptest.NewRequest("GET", tc.path, nil)
+			for key, value := range tc.headers {
+				req.Header.Set(key, value)
+			}
+			w := httptest.NewRecorder()
+
+			a.ServeHTTP(w, req)
+
+			assert.Equal(tc.expectedStatusCode, w.Code)
+			assert.Equal(tc.expectedCacheControl, w.Header().Get("Cache-Control"))
+			assert.Equal(tc.expectedEncoding, w.Header().Get("Content-Encoding"))
+		})
+	}
+
+	t.Run("if-none-match", func(t *testing.T) {
+
+		assert := assert.New(t)
+
+		w := httptest.NewRecorder()
+		a.ServeHTTP(w, httptest.NewRequest("GET", "/css/style.css", nil))
+
+		req := httptest.NewRequest("GET", "/css/style.css", nil)
+		req.Header.Set("If-None-Match", w.Header().Get("ETag"))
+		w = httptest.NewRecorder()
+
+		a.ServeHTTP(w, req)
+
+		assert.Equal(http.StatusNotModified, w.Code)
+	})
+
+	t.Run("unknown asset url", func(t *testing.T) {
+		assert.Equal(t, "/static/missing.js", a.URL("missing.js"))
+		assert.Regexp(t, `^/static/css/style\.[0-9a-f]{10}\.css$`, hashedURL)
+	})
+}
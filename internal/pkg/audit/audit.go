@@ -0,0 +1,101 @@
+package audit
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// Actions recorded in the audit trail.
+const (
+	ActionImpersonationStart = "impersonation.start"
+	ActionImpersonationStop  = "impersonation.stop"
+)
+
+// Event is something a user did that may need explaining later.
+type Event struct {
+	Action string
+	// ActorID is who did it
+	ActorID uuid.UUID
+	// SubjectID is the user it was done to, uuid.Nil for none
+	SubjectID uuid.UUID
+	IP        string
+	UserAgent string
+}
+
+// EventModel is a recorded Event. Events are only ever added.
+type EventModel struct {
+	ID        uuid.UUID `gorm:"primaryKey;type:uuid"`
+	CreatedAt time.Time `gorm:"index"`
+	Action    string    `gorm:"index;not null"`
+	ActorID   uuid.UUID `gorm:"type:uuid;index"`
+	SubjectID uuid.UUID `gorm:"type:uuid;index"`
+	IP        string
+	UserAgent string
+}
+
+func (EventModel) TableName() string {
+	return "audit_events"
+}
+
+// Trail records events in the audit_events table.
+type Trail struct {
+	db     *gorm.DB
+	logger *zap.Logger
+	now    func() time.Time
+}
+
+type Params struct {
+	DB     *gorm.DB
+	Logger *zap.Logger
+}
+
+func New(p Params) *Trail {
+
+	p.DB.AutoMigrate(&EventModel{})
+
+	return &Trail{
+		db:     p.DB,
+		logger: p.Logger,
+		now:    time.Now,
+	}
+}
+
+// Record adds e to the trail. The event is logged too, so it isn't lost when
+// the database fails.
+func (t *Trail) Record(e Event) error {
+	t.logger.Info("Audit event",
+		zap.String("action", e.Action),
+		zap.Stringer("actor", e.ActorID),
+		zap.Stringer("subject", e.SubjectID),
+		zap.String("ip", e.IP),
+	)
+
+	err := t.db.Create(&EventModel{
+		ID:        uuid.New(),
+		CreatedAt: t.now(),
+		Action:    e.Action,
+		ActorID:   e.ActorID,
+		SubjectID: e.SubjectID,
+		IP:        e.IP,
+		UserAgent: e.UserAgent,
+	}).Error
+	if err != nil {
+		return fmt.Errorf("recording %s: %w", e.Action, err)
+	}
+	return nil
+}
+
+// List returns the events where userID is the actor or the subject, newest
+// first.
+func (t *Trail) List(userID uuid.UUID, limit int) ([]EventModel, error) {
+	var events []EventModel
+	err := t.db.Where("actor_id = ? OR subject_id = ?", userID, userID).
+		Order("created_at desc").
+		Limit(limit).
+		Find(&events).Error
+	return events, err
+}
@@ -0,0 +1,317 @@
+package compress
+
+import (
+	"bufio"
+	"compress/gzip"
+	"errors"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+)
+
+// skipTypes are content types that are already compressed.
+var skipTypes = map[string]bool{
+	"application/gzip":             true,
+	"application/zip":              true,
+	"application/x-7z-compressed":  true,
+	"application/x-rar-compressed": true,
+	"application/pdf":              true,
+	"font/woff":                    true,
+	"font/woff2":                   true,
+}
+
+// skipPrefixes are families of already compressed media. SVG is the exception.
+var skipPrefixes = []string{"image/", "video/", "audio/"}
+
+type Params struct {
+	// MinSize is the smallest body worth compressing, defaults to 1024 bytes.
+	// Streamed responses are compressed regardless once they are flushed.
+	MinSize int
+	// GzipLevel defaults to gzip.DefaultCompression
+	GzipLevel int
+	// BrotliLevel defaults to 4, a good speed to ratio for dynamic content
+	BrotliLevel int
+}
+
+// Middleware compresses responses with brotli or gzip, whichever the client
+// prefers, skipping small bodies, already compressed content types and
+// responses that set their own Content-Encoding.
+func Middleware(p Params) func(next http.Handler) http.Handler {
+	if p.MinSize == 0 {
+		p.MinSize = 1024
+	}
+	if p.GzipLevel == 0 {
+		p.GzipLevel = gzip.DefaultCompression
+	}
+	if p.BrotliLevel == 0 {
+		p.BrotliLevel = 4
+	}
+
+	gzipPool := sync.Pool{New: func() interface{} {
+		zw, _ := gzip.NewWriterLevel(io.Discard, p.GzipLevel)
+		return zw
+	}}
+	brotliPool := sync.Pool{New: func() interface{} {
+		return brotli.NewWriterLevel(io.Discard, p.BrotliLevel)
+	}}
+
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("Vary", "Accept-Encoding")
+
+			if r.Method == http.MethodHead || r.Header.Get("Upgrade") != "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			accept := r.Header.Get("Accept-Encoding")
+
+			var encoding string
+			switch {
+			case AcceptsEncoding(accept, "br"):
+				encoding = "br"
+			case AcceptsEncoding(accept, "gzip"):
+				encoding = "gzip"
+			default:
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			cw := &responseWriter{
+				ResponseWriter: w,
+				encoding:       encoding,
+				minSize:        p.MinSize,
+				gzipPool:       &gzipPool,
+				brotliPool:     &brotliPool,
+			}
+			defer cw.Close()
+
+			next.ServeHTTP(cw, r)
+		}
+		return http.HandlerFunc(fn)
+	}
+}
+
+// AcceptsEncoding reports whether the Accept-Encoding header allows coding
+// with a non zero quality.
+func AcceptsEncoding(header, coding string) bool {
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(strings.TrimSpace(part), ";")
+		if !strings.EqualFold(strings.TrimSpace(fields[0]), coding) {
+			continue
+		}
+		for _, param := range fields[1:] {
+			if q := strings.TrimSpace(param); q == "q=0" || q == "q=0.0" || q == "q=0.00" || q == "q=0.000" {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+type encoder interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+// responseWriter buffers the start of the body until it knows whether to
+// compress: when the buffer reaches minSize, on Flush, or on Close.
+type responseWriter struct {
+	http.ResponseWriter
+
+	encoding   string
+	minSize    int
+	gzipPool   *sync.Pool
+	brotliPool *sync.Pool
+
+	status  int
+	buf     []byte
+	decided bool
+	enc     encoder
+	closed  bool
+}
+
+func (cw *responseWriter) WriteHeader(status int) {
+	if cw.status != 0 || cw.decided {
+		return
+	}
+
+	// informational responses go straight through
+	if status >= 100 && status < 200 {
+		cw.ResponseWriter.WriteHeader(status)
+		return
+	}
+
+	cw.status = status
+}
+
+func (cw *responseWriter) Write(b []byte) (int, error) {
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+
+	if cw.decided {
+		if cw.enc != nil {
+			return cw.enc.Write(b)
+		}
+		return cw.ResponseWriter.Write(b)
+	}
+
+	cw.buf = append(cw.buf, b...)
+	if len(cw.buf) >= cw.minSize {
+		if err := cw.decide(true); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(b), nil
+}
+
+// decide picks compression or passthrough, writes the header and the
+// buffered body. large is false when the complete body is below minSize.
+func (cw *responseWriter) decide(large bool) error {
+	cw.decided = true
+
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+
+	h := cw.Header()
+
+	if large && cw.shouldCompress() {
+		h.Del("Content-Length")
+		h.Set("Content-Encoding", cw.encoding)
+
+		if cw.encoding == "br" {
+			cw.enc = cw.brotliPool.Get().(*brotli.Writer)
+		} else {
+			cw.enc = cw.gzipPool.Get().(*gzip.Writer)
+		}
+		cw.enc.Reset(cw.ResponseWriter)
+	}
+
+	cw.ResponseWriter.WriteHeader(cw.status)
+
+	if len(cw.buf) == 0 {
+		return nil
+	}
+
+	var err error
+	if cw.enc != nil {
+		_, err = cw.enc.Write(cw.buf)
+	} else {
+		_, err = cw.ResponseWriter.Write(cw.buf)
+	}
+	cw.buf = nil
+
+	return err
+}
+
+func (cw *responseWriter) shouldCompress() bool {
+	h := cw.Header()
+
+	if h.Get("Content-Encoding") != "" {
+		return false
+	}
+
+	if cw.status < 200 || cw.status == http.StatusNoContent || cw.status == http.StatusNotModified || cw.status == http.StatusPartialContent {
+		return false
+	}
+
+	ctype := h.Get("Content-Type")
+	if ctype == "" {
+		ctype = http.DetectContentType(cw.buf)
+		h.Set("Content-Type", ctype)
+	}
+
+	mediaType, _, err := mime.ParseMediaType(ctype)
+	if err != nil {
+		return false
+	}
+
+	if skipTypes[mediaType] {
+		return false
+	}
+
+	for _, prefix := range skipPrefixes {
+		if strings.HasPrefix(mediaType, prefix) && mediaType != "image/svg+xml" {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Flush sends everything written so far. A response that is flushed is
+// treated as a stream, such as server-sent events, and compressed regardless
+// of its size so far.
+func (cw *responseWriter) Flush() {
+	if !cw.decided {
+		if err := cw.decide(true); err != nil {
+			return
+		}
+	}
+
+	if cw.enc != nil {
+		if err := cw.enc.Flush(); err != nil {
+			return
+		}
+	}
+
+	if f, ok := cw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Close finishes the compressed stream and returns the encoder to its pool.
+func (cw *responseWriter) Close() error {
+	if cw.closed {
+		return nil
+	}
+	cw.closed = true
+
+	if !cw.decided {
+		if cw.status == 0 {
+			// the handler wrote nothing, leave the response to the server
+			return nil
+		}
+		if err := cw.decide(len(cw.buf) >= cw.minSize); err != nil {
+			return err
+		}
+	}
+
+	if cw.enc == nil {
+		return nil
+	}
+
+	err := cw.enc.Close()
+
+	cw.enc.Reset(io.Discard)
+	if cw.encoding == "br" {
+		cw.brotliPool.Put(cw.enc)
+	} else {
+		cw.gzipPool.Put(cw.enc)
+	}
+	cw.enc = nil
+
+	return err
+}
+
+func (cw *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if hj, ok := cw.ResponseWriter.(http.Hijacker); ok {
+		return hj.Hijack()
+	}
+	return nil, nil, errors.New("compress: underlying ResponseWriter does not implement http.Hijacker")
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (cw *responseWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
@@ -0,0 +1,174 @@
+//go:build unit
+// +build unit
+
+package compress
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMiddleware(t *testing.T) {
+
+	large := strings.Repeat("<p>Hello</p>", 200)
+
+	testCases := []struct {
+		description      string
+		acceptEncoding   string
+		handler          http.HandlerFunc
+		expectedEncoding string
+		expectedBody     string
+	}{
+		{
+			description:      "large html - brotli",
+			acceptEncoding:   "gzip, deflate, br",
+			handler:          func(w http.ResponseWriter, r *http.Request) { io.WriteString(w, large) },
+			expectedEncoding: "br",
+			expectedBody:     large,
+		},
+		{
+			description:      "large html - gzip",
+			acceptEncoding:   "gzip",
+			handler:          func(w http.ResponseWriter, r *http.Request) { io.WriteString(w, large) },
+			expectedEncoding: "gzip",
+			expectedBody:     large,
+		},
+		{
+			description:    "small body is not compressed",
+			acceptEncoding: "gzip, br",
+			handler:        func(w http.ResponseWriter, r *http.Request) { io.WriteString(w, "<p>Hello</p>") },
+			expectedBody:   "<p>Hello</p>",
+		},
+		{
+			description:    "images are not compressed",
+			acceptEncoding: "gzip, br",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "image/png")
+				io.WriteString(w, large)
+			},
+			expectedBody: large,
+		},
+		{
+			description:    "existing content encoding is kept",
+			acceptEncoding: "gzip, br",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Encoding", "identity")
+				io.WriteString(w, large)
+			},
+			expectedEncoding: "identity",
+			expectedBody:     large,
+		},
+		{
+			description:    "no accept encoding",
+			acceptEncoding: "",
+			handler:        func(w http.ResponseWriter, r *http.Request) { io.WriteString(w, large) },
+			expectedBody:   large,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+
+			assert := assert.New(t)
+
+			req := httptest.NewRequest("GET", "/", nil)
+			req.Header.Set("Accept-Encoding", tc.acceptEncoding)
+			w := httptest.NewRecorder()
+
+			Middleware(Params{})(tc.handler).ServeHTTP(w, req)
+
+			assert.Equal(200, w.Code)
+			assert.Equal(tc.expectedEncoding, w.Header().Get("Content-Encoding"))
+			assert.Equal(tc.expectedBody, decode(t, w.Header().Get("Content-Encoding"), w.Body))
+		})
+	}
+}
+
+func TestMiddlewareStreaming(t *testing.T) {
+
+	assert := assert.New(t)
+
+	events := make(chan int)
+	flushed := make(chan struct{})
+
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for i := range events {
+			fmt.Fprintf(w, "data: %d\n\n", i)
+			w.(http.Flusher).Flush()
+			flushed <- struct{}{}
+		}
+	}
+
+	// the metrics middleware wraps the writer before compress sees it
+	var status int
+	metricsLike := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			next.ServeHTTP(ww, r)
+			status = ww.Status()
+		})
+	}
+
+	srv := httptest.NewServer(metricsLike(Middleware(Params{})(http.HandlerFunc(handler))))
+	defer srv.Close()
+
+	req, _ := http.NewRequest("GET", srv.URL, nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+
+	go func() { events <- 1 }()
+
+	res, err := http.DefaultTransport.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	<-flushed
+
+	assert.Equal("gzip", res.Header.Get("Content-Encoding"))
+
+	zr, err := gzip.NewReader(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the first event must be readable before the stream ends
+	buf := make([]byte, len("data: 1\n\n"))
+	_, err = io.ReadFull(zr, buf)
+	assert.NoError(err)
+	assert.Equal("data: 1\n\n", string(buf))
+
+	close(events)
+	io.Copy(io.Discard, zr)
+
+	assert.Equal(200, status)
+}
+
+func decode(t *testing.T, encoding string, body io.Reader) string {
+	var r io.Reader = body
+	switch encoding {
+	case "gzip":
+		zr, err := gzip.NewReader(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r = zr
+	case "br":
+		r = brotli.NewReader(body)
+	}
+
+	b, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
@@ -4,6 +4,8 @@ import (
 	"crypto/rsa"
 	"fmt"
 	"log"
+	"strings"
+	"time"
 
 	b64 "encoding/base64"
 
@@ -20,9 +22,135 @@ type Config struct {
 	DBName      string
 	Port        string
 	ServiceName string
+	Environment string
+	SentryDSN   string
 
+	CSPReportOnly bool
+	// CSPDirectives replace or add to the default policy's directives, such as
+	// "img-src 'self' https://cdn.example.com; connect-src 'self'"
+	CSPDirectives string
+	// CSPReportURI, ReferrerPolicy, PermissionsPolicy,
+	// CrossOriginOpenerPolicy and CrossOriginEmbedderPolicy replace the
+	// defaults when set, "off" leaves them out
+	CSPReportURI              string
+	ReferrerPolicy            string
+	PermissionsPolicy         string
+	CrossOriginOpenerPolicy   string
+	CrossOriginEmbedderPolicy string
+	HSTSMaxAge                int
+	HSTSIncludeSubdomains     bool
+	HSTSPreload               bool
+
+	// AllowedOrigins may open WebSockets besides the site itself
+	AllowedOrigins []string
+
+	// APITokenDelivery is how JSON clients get their token on login: cookie, body or both
+	APITokenDelivery string
+
+	// JWTPrivateKey is optional when JWTKeyDir holds the signing keys
 	JWTPrivateKey *rsa.PrivateKey
 	JWTPublicKey  *rsa.PublicKey
+
+	// BaseURL is the public URL of the app, used in links sent by email
+	BaseURL string
+
+	// OIDCIssuer is the public base URL other apps reach this one at when it
+	// signs their users in, defaults to BaseURL
+	OIDCIssuer string
+
+	// RegistrationMode is who may register without an invitation: open,
+	// invite, closed or domain
+	RegistrationMode string
+	// RegistrationDomains are the email domains the domain mode lets register
+	RegistrationDomains []string
+	// InvitationTTL is how long an invitation can be accepted for
+	InvitationTTL time.Duration
+	// AdminEmails are the addresses of the admins
+	AdminEmails []string
+	// VerifyEmail makes new users verify their email address, with a magic
+	// link, before they can log in
+	VerifyEmail bool
+
+	// MailFrom is the sender of emails, such as "Goth <noreply@example.com>"
+	MailFrom string
+	// SMTPHost is the mail server, without one emails are only logged in
+	// development and not sent at all otherwise
+	SMTPHost     string
+	SMTPPort     int
+	SMTPUsername string
+	SMTPPassword string
+
+	// MagicLinks lets users log in with a link sent to their email address
+	MagicLinks bool
+	// MagicLinkTTL is how long a link works for
+	MagicLinkTTL time.Duration
+	// MagicLinkDeviceBinding only lets a link log in the browser that asked for it
+	MagicLinkDeviceBinding bool
+
+	// GeoIPDatabase is a DB-IP lite CSV used to locate sessions, optional
+	GeoIPDatabase string
+
+	// SessionMode is jwt for a signed token cookie or server for an opaque
+	// session id with the data kept on the server
+	SessionMode string
+	// SessionStore is where server sessions are kept: database, file or memory
+	SessionStore string
+	// SessionDir holds the files of the file session store
+	SessionDir string
+	// SessionIdleTimeout ends server sessions that aren't used for that long
+	SessionIdleTimeout time.Duration
+	// SessionAbsoluteTimeout ends server sessions that long after logging in, 0 never
+	SessionAbsoluteTimeout time.Duration
+
+	// PasswordAlgorithm hashes new passwords: argon2id or bcrypt. Hashes of
+	// the other one are still accepted and replaced on login.
+	PasswordAlgorithm string
+	// Argon2Memory in KiB, Argon2Time and Argon2Parallelism tune argon2id, 0
+	// for the defaults
+	Argon2Memory      int
+	Argon2Time        int
+	Argon2Parallelism int
+	// BcryptCost tunes bcrypt, 0 for the default
+	BcryptCost int
+
+	// PasswordMinLength and PasswordMaxLength bound new passwords, in characters
+	PasswordMinLength int
+	PasswordMaxLength int
+	// PasswordMinScore is the lowest strength score, 0 to 4, new passwords need
+	PasswordMinScore int
+	// PasswordDisallowEmail rejects passwords containing the user's email
+	PasswordDisallowEmail bool
+	// PasswordHistory is how many previous passwords can't be chosen again
+	PasswordHistory int
+	// PasswordBreachDir holds the Pwned Passwords ranges, optional
+	PasswordBreachDir string
+
+	// Storage is where uploads are kept: local or s3
+	Storage string
+	// StorageDir holds the uploads of the local store
+	StorageDir string
+	// StorageSecret signs the links to uploads the app serves itself. Without
+	// one a random secret is used, which doesn't survive restarts.
+	StorageSecret string
+	// S3Endpoint, S3Region, S3Bucket, S3AccessKeyID and S3SecretAccessKey
+	// configure the s3 store
+	S3Endpoint        string
+	S3Region          string
+	S3Bucket          string
+	S3AccessKeyID     string
+	S3SecretAccessKey string
+	// UploadMaxSize is the largest upload in bytes
+	UploadMaxSize int64
+	// JobsConcurrency is how many background jobs run at the same time
+	JobsConcurrency int
+	// JobsShutdownTimeout is how long shutting down waits for running jobs
+	JobsShutdownTimeout time.Duration
+
+	JWTKeyDir string
+	// JWTAlgorithm is the algorithm of generated keys: RS256, ES256 or EdDSA
+	JWTAlgorithm string
+	// JWTRotationInterval is how often a new signing key is generated, 0 never
+	JWTRotationInterval time.Duration
 }
 
 func Must() Config {
@@ -45,27 +173,63 @@ func Must() Config {
 		port = ":" + port
 	}
 
+	JWTKeyDir := viper.GetString("JWT_KEY_DIR")
+
+	var JWTPrivateKey *rsa.PrivateKey
+	var JWTPublicKey *rsa.PublicKey
+
 	JWTPrivateKeyEncoded := viper.GetString("JWT_PRIVATE_KEY")
-	JWTPrivateKeyStr, err := b64.URLEncoding.DecodeString(JWTPrivateKeyEncoded)
-	if err != nil {
-		log.Fatal("Error decoding JWT_PRIVATE_KEY", err)
+
+	// the keys in JWT_KEY_DIR are enough on their own
+	if JWTPrivateKeyEncoded != "" || JWTKeyDir == "" {
+		JWTPrivateKeyStr, err := b64.URLEncoding.DecodeString(JWTPrivateKeyEncoded)
+		if err != nil {
+			log.Fatal("Error decoding JWT_PRIVATE_KEY", err)
+		}
+
+		JWTPrivateKey, err = jwt.ParseRSAPrivateKeyFromPEM([]byte(JWTPrivateKeyStr))
+		if err != nil {
+			fmt.Println("Error parsing private key:", err)
+			log.Fatal("Error parsing private key:", err)
+		}
+
+		JWTPublicKeyEncoded := viper.GetString("JWT_PUBLIC_KEY")
+		JWTPublicKeyStr, err := b64.URLEncoding.DecodeString(JWTPublicKeyEncoded)
+		if err != nil {
+			log.Fatal("Error decoding JWT_PUBLIC_KEY", err)
+		}
+
+		JWTPublicKey, err = jwt.ParseRSAPublicKeyFromPEM([]byte(JWTPublicKeyStr))
+		if err != nil {
+			log.Fatal("Error parsing public key:", err)
+		}
+
+		if !JWTPublicKey.Equal(JWTPrivateKey.Public()) {
+			log.Fatal("JWT_PUBLIC_KEY doesn't belong to JWT_PRIVATE_KEY")
+		}
 	}
 
-	JWTPrivateKey, err := jwt.ParseRSAPrivateKeyFromPEM([]byte(JWTPrivateKeyStr))
-	if err != nil {
-		fmt.Println("Error parsing private key:", err)
-		log.Fatal("Error parsing private key:", err)
+	JWTAlgorithm := viper.GetString("JWT_ALGORITHM")
+
+	switch JWTAlgorithm {
+	case "":
+		JWTAlgorithm = "RS256"
+	case "RS256", "ES256", "EdDSA":
+	default:
+		log.Fatal("JWT_ALGORITHM must be one of RS256, ES256 or EdDSA, got ", JWTAlgorithm)
 	}
 
-	JWTPublicKeyEncoded := viper.GetString("JWT_PUBLIC_KEY")
-	JWTPublicKeyStr, err := b64.URLEncoding.DecodeString(JWTPublicKeyEncoded)
-	if err != nil {
-		log.Fatal("Error decoding JWT_PUBLIC_KEY", err)
+	var JWTRotationInterval time.Duration
+
+	if viper.IsSet("JWT_ROTATION_INTERVAL") {
+		JWTRotationInterval, err = time.ParseDuration(viper.GetString("JWT_ROTATION_INTERVAL"))
+		if err != nil {
+			log.Fatal("Error parsing JWT_ROTATION_INTERVAL", err)
+		}
 	}
 
-	JWTPublicKey, err := jwt.ParseRSAPublicKeyFromPEM([]byte(JWTPublicKeyStr))
-	if err != nil {
-		log.Fatal("Error parsing public key:", err)
+	if JWTRotationInterval > 0 && JWTKeyDir == "" {
+		log.Fatal("JWT_ROTATION_INTERVAL needs JWT_KEY_DIR to keep the generated keys")
 	}
 
 	ServiceName := viper.GetString("SERVICE_NAME")
@@ -74,14 +238,351 @@ func Must() Config {
 		ServiceName = "goth"
 	}
 
+	Environment := viper.GetString("ENVIRONMENT")
+
+	if Environment == "" {
+		Environment = "development"
+	}
+
+	HSTSMaxAge := 63072000
+
+	if viper.IsSet("HSTS_MAX_AGE") {
+		HSTSMaxAge = viper.GetInt("HSTS_MAX_AGE")
+	}
+
+	HSTSIncludeSubdomains := true
+
+	if viper.IsSet("HSTS_INCLUDE_SUBDOMAINS") {
+		HSTSIncludeSubdomains = viper.GetBool("HSTS_INCLUDE_SUBDOMAINS")
+	}
+
+	AllowedOrigins := list(viper.GetString("ALLOWED_ORIGINS"))
+
+	APITokenDelivery := viper.GetString("API_TOKEN_DELIVERY")
+
+	switch APITokenDelivery {
+	case "":
+		APITokenDelivery = "cookie"
+	case "cookie", "body", "both":
+	default:
+		log.Fatal("API_TOKEN_DELIVERY must be one of cookie, body or both, got ", APITokenDelivery)
+	}
+
+	SessionMode := viper.GetString("SESSION_MODE")
+
+	switch SessionMode {
+	case "":
+		SessionMode = "jwt"
+	case "jwt", "server":
+	default:
+		log.Fatal("SESSION_MODE must be one of jwt or server, got ", SessionMode)
+	}
+
+	SessionStore := viper.GetString("SESSION_STORE")
+
+	switch SessionStore {
+	case "":
+		SessionStore = "database"
+	case "database", "file", "memory":
+	default:
+		log.Fatal("SESSION_STORE must be one of database, file or memory, got ", SessionStore)
+	}
+
+	SessionDir := viper.GetString("SESSION_DIR")
+
+	if SessionDir == "" {
+		SessionDir = "sessions"
+	}
+
+	Storage := viper.GetString("STORAGE")
+
+	switch Storage {
+	case "":
+		Storage = "local"
+	case "local":
+	case "s3":
+		if viper.GetString("S3_ENDPOINT") == "" || viper.GetString("S3_BUCKET") == "" {
+			log.Fatal("STORAGE=s3 needs S3_ENDPOINT and S3_BUCKET")
+		}
+	default:
+		log.Fatal("STORAGE must be local or s3, got ", Storage)
+	}
+
+	StorageDir := viper.GetString("STORAGE_DIR")
+
+	if StorageDir == "" {
+		StorageDir = "uploads"
+	}
+
+	UploadMaxSize := int64(5 << 20)
+
+	if viper.IsSet("UPLOAD_MAX_SIZE") {
+		UploadMaxSize = viper.GetInt64("UPLOAD_MAX_SIZE")
+	}
+
+	if UploadMaxSize <= 0 {
+		log.Fatal("UPLOAD_MAX_SIZE must be positive, got ", UploadMaxSize)
+	}
+
+	JobsConcurrency := 4
+
+	if viper.IsSet("JOBS_CONCURRENCY") {
+		JobsConcurrency = viper.GetInt("JOBS_CONCURRENCY")
+	}
+
+	if JobsConcurrency <= 0 {
+		log.Fatal("JOBS_CONCURRENCY must be positive, got ", JobsConcurrency)
+	}
+
+	JobsShutdownTimeout := 10 * time.Second
+
+	if viper.IsSet("JOBS_SHUTDOWN_TIMEOUT") {
+		JobsShutdownTimeout, err = time.ParseDuration(viper.GetString("JOBS_SHUTDOWN_TIMEOUT"))
+		if err != nil {
+			log.Fatal("Error parsing JOBS_SHUTDOWN_TIMEOUT", err)
+		}
+	}
+
+	SessionIdleTimeout := 24 * time.Hour
+
+	if viper.IsSet("SESSION_IDLE_TIMEOUT") {
+		SessionIdleTimeout, err = time.ParseDuration(viper.GetString("SESSION_IDLE_TIMEOUT"))
+		if err != nil {
+			log.Fatal("Error parsing SESSION_IDLE_TIMEOUT", err)
+		}
+	}
+
+	var SessionAbsoluteTimeout time.Duration
+
+	if viper.IsSet("SESSION_ABSOLUTE_TIMEOUT") {
+		SessionAbsoluteTimeout, err = time.ParseDuration(viper.GetString("SESSION_ABSOLUTE_TIMEOUT"))
+		if err != nil {
+			log.Fatal("Error parsing SESSION_ABSOLUTE_TIMEOUT", err)
+		}
+	}
+
+	PasswordAlgorithm := viper.GetString("PASSWORD_ALGORITHM")
+
+	switch PasswordAlgorithm {
+	case "":
+		PasswordAlgorithm = "argon2id"
+	case "argon2id", "bcrypt":
+	default:
+		log.Fatal("PASSWORD_ALGORITHM must be one of argon2id or bcrypt, got ", PasswordAlgorithm)
+	}
+
+	Argon2Memory := viper.GetInt("ARGON2_MEMORY")
+	Argon2Time := viper.GetInt("ARGON2_TIME")
+	Argon2Parallelism := viper.GetInt("ARGON2_PARALLELISM")
+
+	if Argon2Memory < 0 || Argon2Time < 0 || Argon2Parallelism < 0 || Argon2Parallelism > 255 {
+		log.Fatal("ARGON2_MEMORY, ARGON2_TIME and ARGON2_PARALLELISM must be positive, ARGON2_PARALLELISM at most 255")
+	}
+
+	BcryptCost := viper.GetInt("BCRYPT_COST")
+
+	if BcryptCost != 0 && (BcryptCost < 4 || BcryptCost > 31) {
+		log.Fatal("BCRYPT_COST must be between 4 and 31, got ", BcryptCost)
+	}
+
+	PasswordMinLength := 8
+
+	if viper.IsSet("PASSWORD_MIN_LENGTH") {
+		PasswordMinLength = viper.GetInt("PASSWORD_MIN_LENGTH")
+	}
+
+	PasswordMaxLength := 128
+
+	// bcrypt only hashes the first 72 bytes
+	if PasswordAlgorithm == "bcrypt" {
+		PasswordMaxLength = 72
+	}
+
+	if viper.IsSet("PASSWORD_MAX_LENGTH") {
+		PasswordMaxLength = viper.GetInt("PASSWORD_MAX_LENGTH")
+	}
+
+	if PasswordMinLength < 1 || PasswordMaxLength < PasswordMinLength {
+		log.Fatal("PASSWORD_MIN_LENGTH must be at least 1 and PASSWORD_MAX_LENGTH at least PASSWORD_MIN_LENGTH")
+	}
+
+	if PasswordAlgorithm == "bcrypt" && PasswordMaxLength > 72 {
+		log.Fatal("PASSWORD_MAX_LENGTH can't be more than 72 with bcrypt, got ", PasswordMaxLength)
+	}
+
+	PasswordMinScore := 2
+
+	if viper.IsSet("PASSWORD_MIN_SCORE") {
+		PasswordMinScore = viper.GetInt("PASSWORD_MIN_SCORE")
+	}
+
+	if PasswordMinScore < 0 || PasswordMinScore > 4 {
+		log.Fatal("PASSWORD_MIN_SCORE must be between 0 and 4, got ", PasswordMinScore)
+	}
+
+	PasswordDisallowEmail := true
+
+	if viper.IsSet("PASSWORD_DISALLOW_EMAIL") {
+		PasswordDisallowEmail = viper.GetBool("PASSWORD_DISALLOW_EMAIL")
+	}
+
+	PasswordHistory := viper.GetInt("PASSWORD_HISTORY")
+
+	if PasswordHistory < 0 {
+		log.Fatal("PASSWORD_HISTORY must be positive, got ", PasswordHistory)
+	}
+
+	BaseURL := strings.TrimSuffix(viper.GetString("BASE_URL"), "/")
+
+	if BaseURL == "" {
+		BaseURL = "http://localhost" + port
+	}
+
+	OIDCIssuer := strings.TrimSuffix(viper.GetString("OIDC_ISSUER"), "/")
+
+	if OIDCIssuer == "" {
+		OIDCIssuer = BaseURL
+	}
+
+	RegistrationMode := viper.GetString("REGISTRATION_MODE")
+
+	switch RegistrationMode {
+	case "":
+		RegistrationMode = "open"
+	case "open", "invite", "closed", "domain":
+	default:
+		log.Fatal("REGISTRATION_MODE must be one of open, invite, closed or domain, got ", RegistrationMode)
+	}
+
+	RegistrationDomains := list(viper.GetString("REGISTRATION_DOMAINS"))
+
+	if RegistrationMode == "domain" && len(RegistrationDomains) == 0 {
+		log.Fatal("REGISTRATION_MODE=domain needs REGISTRATION_DOMAINS")
+	}
+
+	InvitationTTL := 7 * 24 * time.Hour
+
+	if viper.IsSet("INVITATION_TTL") {
+		InvitationTTL, err = time.ParseDuration(viper.GetString("INVITATION_TTL"))
+		if err != nil {
+			log.Fatal("Error parsing INVITATION_TTL", err)
+		}
+	}
+
+	if InvitationTTL <= 0 {
+		log.Fatal("INVITATION_TTL must be positive, got ", InvitationTTL)
+	}
+
+	MailFrom := viper.GetString("MAIL_FROM")
+
+	if MailFrom == "" {
+		MailFrom = ServiceName + " <noreply@localhost>"
+	}
+
+	SMTPPort := 587
+
+	if viper.IsSet("SMTP_PORT") {
+		SMTPPort = viper.GetInt("SMTP_PORT")
+	}
+
+	MagicLinks := true
+
+	if viper.IsSet("MAGIC_LINKS") {
+		MagicLinks = viper.GetBool("MAGIC_LINKS")
+	}
+
+	MagicLinkTTL := 15 * time.Minute
+
+	if viper.IsSet("MAGIC_LINK_TTL") {
+		MagicLinkTTL, err = time.ParseDuration(viper.GetString("MAGIC_LINK_TTL"))
+		if err != nil {
+			log.Fatal("Error parsing MAGIC_LINK_TTL", err)
+		}
+	}
+
+	if MagicLinkTTL <= 0 {
+		log.Fatal("MAGIC_LINK_TTL must be positive, got ", MagicLinkTTL)
+	}
+
 	return Config{
-		DBHost:        viper.GetString("DATABASE_HOST"),
-		DBUser:        viper.GetString("DATABASE_USER"),
-		DBPassword:    viper.GetString("DATABASE_PASSWORD"),
-		DBName:        viper.GetString("DATABASE_NAME"),
-		ServiceName:   ServiceName,
-		JWTPrivateKey: JWTPrivateKey,
-		JWTPublicKey:  JWTPublicKey,
-		Port:          port,
+		DBHost:                    viper.GetString("DATABASE_HOST"),
+		DBUser:                    viper.GetString("DATABASE_USER"),
+		DBPassword:                viper.GetString("DATABASE_PASSWORD"),
+		DBName:                    viper.GetString("DATABASE_NAME"),
+		ServiceName:               ServiceName,
+		Environment:               Environment,
+		SentryDSN:                 viper.GetString("SENTRY_DSN"),
+		CSPReportOnly:             viper.GetBool("CSP_REPORT_ONLY"),
+		CSPDirectives:             viper.GetString("CSP_DIRECTIVES"),
+		CSPReportURI:              viper.GetString("CSP_REPORT_URI"),
+		ReferrerPolicy:            viper.GetString("REFERRER_POLICY"),
+		PermissionsPolicy:         viper.GetString("PERMISSIONS_POLICY"),
+		CrossOriginOpenerPolicy:   viper.GetString("CROSS_ORIGIN_OPENER_POLICY"),
+		CrossOriginEmbedderPolicy: viper.GetString("CROSS_ORIGIN_EMBEDDER_POLICY"),
+		HSTSMaxAge:                HSTSMaxAge,
+		HSTSIncludeSubdomains:     HSTSIncludeSubdomains,
+		HSTSPreload:               viper.GetBool("HSTS_PRELOAD"),
+		AllowedOrigins:            AllowedOrigins,
+		APITokenDelivery:          APITokenDelivery,
+		JWTPrivateKey:             JWTPrivateKey,
+		JWTPublicKey:              JWTPublicKey,
+		JWTKeyDir:                 JWTKeyDir,
+		JWTAlgorithm:              JWTAlgorithm,
+		JWTRotationInterval:       JWTRotationInterval,
+		BaseURL:                   BaseURL,
+		OIDCIssuer:                OIDCIssuer,
+		RegistrationMode:          RegistrationMode,
+		RegistrationDomains:       RegistrationDomains,
+		InvitationTTL:             InvitationTTL,
+		AdminEmails:               list(viper.GetString("ADMIN_EMAILS")),
+		VerifyEmail:               viper.GetBool("VERIFY_EMAIL"),
+		MailFrom:                  MailFrom,
+		SMTPHost:                  viper.GetString("SMTP_HOST"),
+		SMTPPort:                  SMTPPort,
+		SMTPUsername:              viper.GetString("SMTP_USERNAME"),
+		SMTPPassword:              viper.GetString("SMTP_PASSWORD"),
+		MagicLinks:                MagicLinks,
+		MagicLinkTTL:              MagicLinkTTL,
+		MagicLinkDeviceBinding:    viper.GetBool("MAGIC_LINK_DEVICE_BINDING"),
+		GeoIPDatabase:             viper.GetString("GEOIP_DATABASE"),
+		SessionMode:               SessionMode,
+		SessionStore:              SessionStore,
+		SessionDir:                SessionDir,
+		SessionIdleTimeout:        SessionIdleTimeout,
+		Storage:                   Storage,
+		StorageDir:                StorageDir,
+		StorageSecret:             viper.GetString("STORAGE_SECRET"),
+		S3Endpoint:                viper.GetString("S3_ENDPOINT"),
+		S3Region:                  viper.GetString("S3_REGION"),
+		S3Bucket:                  viper.GetString("S3_BUCKET"),
+		S3AccessKeyID:             viper.GetString("S3_ACCESS_KEY_ID"),
+		S3SecretAccessKey:         viper.GetString("S3_SECRET_ACCESS_KEY"),
+		UploadMaxSize:             UploadMaxSize,
+		JobsConcurrency:           JobsConcurrency,
+		JobsShutdownTimeout:       JobsShutdownTimeout,
+		SessionAbsoluteTimeout:    SessionAbsoluteTimeout,
+		PasswordAlgorithm:         PasswordAlgorithm,
+		Argon2Memory:              Argon2Memory,
+		Argon2Time:                Argon2Time,
+		Argon2Parallelism:         Argon2Parallelism,
+		BcryptCost:                BcryptCost,
+		PasswordMinLength:         PasswordMinLength,
+		PasswordMaxLength:         PasswordMaxLength,
+		PasswordMinScore:          PasswordMinScore,
+		PasswordDisallowEmail:     PasswordDisallowEmail,
+		PasswordHistory:           PasswordHistory,
+		PasswordBreachDir:         viper.GetString("PASSWORD_BREACH_DIR"),
+		Port:                      port,
+	}
+}
+
+// list splits a comma separated setting, skipping empty items.
+func list(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
 	}
+	return items
 }
@@ -0,0 +1,29 @@
+package errreport
+
+import (
+	"context"
+	"time"
+)
+
+// Event describes a recovered panic or unexpected error.
+type Event struct {
+	Message   string
+	Stack     string
+	RequestID string
+	Method    string
+	URL       string
+	UserID    string
+	Time      time.Time
+}
+
+// Reporter forwards events to an external error tracking service.
+type Reporter interface {
+	Report(ctx context.Context, event Event) error
+}
+
+// Nop discards every event, it is used when no reporter is configured.
+type Nop struct{}
+
+func (Nop) Report(ctx context.Context, event Event) error {
+	return nil
+}
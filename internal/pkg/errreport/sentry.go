@@ -0,0 +1,132 @@
+package errreport
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Sentry sends events to a Sentry compatible store endpoint, which is also what
+// self hosted alternatives such as GlitchTip accept.
+type Sentry struct {
+	endpoint    string
+	key         string
+	environment string
+	client      *http.Client
+}
+
+type SentryParams struct {
+	// DSN in the form https://<key>@<host>/<project>
+	DSN         string
+	Environment string
+	// Client defaults to an http.Client with a five second timeout
+	Client *http.Client
+}
+
+func NewSentry(p SentryParams) (*Sentry, error) {
+	dsn, err := url.Parse(p.DSN)
+	if err != nil {
+		return nil, fmt.Errorf("parsing sentry dsn: %w", err)
+	}
+
+	if dsn.User == nil || dsn.User.Username() == "" {
+		return nil, errors.New("sentry dsn has no public key")
+	}
+
+	project := strings.Trim(dsn.Path, "/")
+	if project == "" {
+		return nil, errors.New("sentry dsn has no project id")
+	}
+
+	client := p.Client
+	if client == nil {
+		client = &http.Client{Timeout: 5 * time.Second}
+	}
+
+	return &Sentry{
+		endpoint:    fmt.Sprintf("%s://%s/api/%s/store/", dsn.Scheme, dsn.Host, project),
+		key:         dsn.User.Username(),
+		environment: p.Environment,
+		client:      client,
+	}, nil
+}
+
+type sentryEvent struct {
+	EventID     string            `json:"event_id"`
+	Timestamp   string            `json:"timestamp"`
+	Level       string            `json:"level"`
+	Platform    string            `json:"platform"`
+	Logger      string            `json:"logger"`
+	Environment string            `json:"environment,omitempty"`
+	Message     string            `json:"message"`
+	Tags        map[string]string `json:"tags,omitempty"`
+	Request     *sentryRequest    `json:"request,omitempty"`
+	User        *sentryUser       `json:"user,omitempty"`
+	Extra       map[string]string `json:"extra,omitempty"`
+}
+
+type sentryRequest struct {
+	URL    string `json:"url"`
+	Method string `json:"method"`
+}
+
+type sentryUser struct {
+	ID string `json:"id"`
+}
+
+func (s *Sentry) Report(ctx context.Context, event Event) error {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return err
+	}
+
+	payload := sentryEvent{
+		EventID:     hex.EncodeToString(id),
+		Timestamp:   event.Time.UTC().Format(time.RFC3339),
+		Level:       "fatal",
+		Platform:    "go",
+		Logger:      "goth",
+		Environment: s.environment,
+		Message:     event.Message,
+		Tags:        map[string]string{"request_id": event.RequestID},
+		Request:     &sentryRequest{URL: event.URL, Method: event.Method},
+		Extra:       map[string]string{"stack": event.Stack},
+	}
+
+	if event.UserID != "" {
+		payload.User = &sentryUser{ID: event.UserID}
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Sentry-Auth", fmt.Sprintf("Sentry sentry_version=7, sentry_client=goth/1.0, sentry_key=%s", s.key))
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("sending event to sentry: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= 300 {
+		return fmt.Errorf("sentry responded with status %d", res.StatusCode)
+	}
+
+	return nil
+}
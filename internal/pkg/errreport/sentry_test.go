@@ -0,0 +1,85 @@
+//go:build unit
+// +build unit
+
+package errreport
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSentryReport(t *testing.T) {
+
+	assert := assert.New(t)
+
+	var gotPath, gotAuth string
+	var gotEvent sentryEvent
+
+	stub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		gotAuth = r.Header.Get("X-Sentry-Auth")
+		json.NewDecoder(r.Body).Decode(&gotEvent)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer stub.Close()
+
+	dsn := strings.Replace(stub.URL, "http://", "http://publickey@", 1) + "/42"
+
+	reporter, err := NewSentry(SentryParams{DSN: dsn, Environment: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = reporter.Report(context.Background(), Event{
+		Message:   "boom",
+		Stack:     "goroutine 1 [running]",
+		RequestID: "req-1",
+		Method:    "GET",
+		URL:       "/about",
+		UserID:    "user-1",
+		Time:      time.Now(),
+	})
+
+	assert.NoError(err)
+	assert.Equal("/api/42/store/", gotPath)
+	assert.Contains(gotAuth, "sentry_key=publickey")
+	assert.Equal("boom", gotEvent.Message)
+	assert.Equal("test", gotEvent.Environment)
+	assert.Equal("req-1", gotEvent.Tags["request_id"])
+	assert.Equal("user-1", gotEvent.User.ID)
+	assert.Len(gotEvent.EventID, 32)
+}
+
+func TestSentryReportError(t *testing.T) {
+
+	stub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer stub.Close()
+
+	dsn := strings.Replace(stub.URL, "http://", "http://publickey@", 1) + "/42"
+
+	reporter, err := NewSentry(SentryParams{DSN: dsn})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = reporter.Report(context.Background(), Event{Message: "boom", Time: time.Now()})
+
+	assert.Error(t, err)
+}
+
+func TestNewSentryInvalidDSN(t *testing.T) {
+
+	for _, dsn := range []string{"https://sentry.example.com/1", "https://key@sentry.example.com/"} {
+		_, err := NewSentry(SentryParams{DSN: dsn})
+		assert.Error(t, err, dsn)
+	}
+}
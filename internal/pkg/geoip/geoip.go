@@ -0,0 +1,173 @@
+// Package geoip finds the approximate location of an IP address in a local
+// database file, so no request leaves the server.
+//
+// The file is a DB-IP "IP to Country Lite" or "IP to City Lite" CSV, from
+// https://db-ip.com/db/lite.php, optionally still gzipped.
+package geoip
+
+import (
+	"compress/gzip"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Location is where an IP address is, as precisely as the database knows.
+type Location struct {
+	// Country is the ISO 3166-1 alpha-2 code
+	Country string `json:"country,omitempty"`
+	Region  string `json:"region,omitempty"`
+	City    string `json:"city,omitempty"`
+}
+
+// String describes the location, e.g. "Lyon, FR", or returns "" when it is
+// unknown.
+func (l Location) String() string {
+	var parts []string
+	if l.City != "" {
+		parts = append(parts, l.City)
+	} else if l.Region != "" {
+		parts = append(parts, l.Region)
+	}
+	if l.Country != "" {
+		parts = append(parts, l.Country)
+	}
+	return strings.Join(parts, ", ")
+}
+
+type ipRange struct {
+	start, end netip.Addr
+	location   Location
+}
+
+// DB is a loaded database. A nil *DB finds nothing, so the database file can
+// be optional.
+type DB struct {
+	ranges []ipRange
+}
+
+// Open loads a database file. Files ending in .gz are decompressed.
+func Open(path string) (*DB, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if strings.HasSuffix(path, ".gz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %w", path, err)
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	db, err := Read(r)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+	return db, nil
+}
+
+// Read loads a database in the DB-IP CSV format: start,end,country for the
+// country database, start,end,continent,country,region,city,... for the city
+// database.
+func Read(r io.Reader) (*DB, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.ReuseRecord = true
+
+	// the city database repeats the same few thousand names millions of times
+	names := map[string]string{}
+	intern := func(s string) string {
+		if v, ok := names[s]; ok {
+			return v
+		}
+		names[s] = s
+		return s
+	}
+
+	db := &DB{}
+	for line := 1; ; line++ {
+		record, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var loc Location
+		switch {
+		case len(record) == 3:
+			loc.Country = intern(record[2])
+		case len(record) >= 6:
+			loc = Location{Country: intern(record[3]), Region: intern(record[4]), City: intern(record[5])}
+		default:
+			return nil, fmt.Errorf("line %d: expected 3 or at least 6 fields, got %d", line, len(record))
+		}
+
+		start, err := netip.ParseAddr(record[0])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		end, err := netip.ParseAddr(record[1])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		if end.Less(start) || start.Is4() != end.Is4() {
+			return nil, fmt.Errorf("line %d: invalid range %s-%s", line, start, end)
+		}
+
+		db.ranges = append(db.ranges, ipRange{start: start, end: end, location: loc})
+	}
+
+	sort.Slice(db.ranges, func(i, j int) bool {
+		return db.ranges[i].start.Less(db.ranges[j].start)
+	})
+
+	return db, nil
+}
+
+// Lookup returns the location of ip, false when it isn't in the database or
+// isn't an IP address.
+func (db *DB) Lookup(ip string) (Location, bool) {
+	if db == nil {
+		return Location{}, false
+	}
+
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return Location{}, false
+	}
+	addr = addr.Unmap()
+
+	// the first range starting after addr, the one before may contain it
+	i := sort.Search(len(db.ranges), func(i int) bool {
+		return addr.Less(db.ranges[i].start)
+	})
+	if i == 0 {
+		return Location{}, false
+	}
+
+	r := db.ranges[i-1]
+	if r.start.Is4() != addr.Is4() || r.end.Less(addr) {
+		return Location{}, false
+	}
+	return r.location, true
+}
+
+// Len returns the number of ranges in the database.
+func (db *DB) Len() int {
+	if db == nil {
+		return 0
+	}
+	return len(db.ranges)
+}
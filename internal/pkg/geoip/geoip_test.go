@@ -0,0 +1,62 @@
+//go:build unit
+// +build unit
+
+package geoip
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const cityCSV = `1.0.0.0,1.0.0.255,OC,AU,Queensland,"South Brisbane",-27.4767,153.017
+2.0.0.0,2.0.255.255,EU,FR,"Auvergne-Rhône-Alpes",Lyon,45.75,4.85
+2001:db8::,2001:db8::ffff,EU,DE,Berlin,Berlin,52.52,13.405
+`
+
+func TestLookup(t *testing.T) {
+	db, err := Read(strings.NewReader(cityCSV))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 3, db.Len())
+
+	testCases := []struct {
+		ip       string
+		expected string
+		found    bool
+	}{
+		{"1.0.0.1", "South Brisbane, AU", true},
+		{"2.0.10.20", "Lyon, FR", true},
+		{"::ffff:2.0.0.1", "Lyon, FR", true},
+		{"2001:db8::1", "Berlin, DE", true},
+		{"1.0.1.0", "", false},
+		{"0.0.0.1", "", false},
+		{"10.0.0.1", "", false},
+		{"not an ip", "", false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.ip, func(t *testing.T) {
+			loc, found := db.Lookup(tc.ip)
+			assert.Equal(t, tc.found, found)
+			assert.Equal(t, tc.expected, loc.String())
+		})
+	}
+
+	var none *DB
+	_, found := none.Lookup("1.0.0.1")
+	assert.False(t, found, "a nil database finds nothing")
+}
+
+func TestReadCountry(t *testing.T) {
+	db, err := Read(strings.NewReader("1.0.0.0,1.0.0.255,AU\n"))
+	if assert.NoError(t, err) {
+		loc, _ := db.Lookup("1.0.0.7")
+		assert.Equal(t, Location{Country: "AU"}, loc)
+	}
+
+	_, err = Read(strings.NewReader("1.0.0.255,1.0.0.0,AU\n"))
+	assert.Error(t, err)
+}
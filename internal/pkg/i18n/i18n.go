@@ -0,0 +1,256 @@
+package i18n
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/go-playground/locales"
+	"github.com/go-playground/locales/en"
+	"github.com/go-playground/locales/fr"
+	ut "github.com/go-playground/universal-translator"
+	"github.com/go-playground/validator/v10"
+	en_translations "github.com/go-playground/validator/v10/translations/en"
+	fr_translations "github.com/go-playground/validator/v10/translations/fr"
+	"golang.org/x/text/language"
+)
+
+//go:embed locales/*.json
+var catalogs embed.FS
+
+// supported lists every locale we ship a catalog for, the first one is the fallback.
+var supported = []struct {
+	tag        language.Tag
+	translator locales.Translator
+	validator  func(v *validator.Validate, trans ut.Translator) error
+}{
+	{language.English, en.New(), en_translations.RegisterDefaultTranslations},
+	{language.French, fr.New(), fr_translations.RegisterDefaultTranslations},
+}
+
+var pluralRules = map[string]locales.PluralRule{
+	"zero":  locales.PluralRuleZero,
+	"one":   locales.PluralRuleOne,
+	"two":   locales.PluralRuleTwo,
+	"few":   locales.PluralRuleFew,
+	"many":  locales.PluralRuleMany,
+	"other": locales.PluralRuleOther,
+}
+
+// Bundle holds the message catalogs for every supported locale.
+type Bundle struct {
+	universal *ut.UniversalTranslator
+	matcher   language.Matcher
+	locales   []string
+	plurals   map[string]bool
+}
+
+type BundleParams struct {
+	// Validate, when set, gets the validator translations registered for every locale
+	Validate *validator.Validate
+}
+
+func NewBundle(p BundleParams) (*Bundle, error) {
+	translators := make([]locales.Translator, 0, len(supported))
+	tags := make([]language.Tag, 0, len(supported))
+	names := make([]string, 0, len(supported))
+	for _, s := range supported {
+		translators = append(translators, s.translator)
+		tags = append(tags, s.tag)
+		names = append(names, s.translator.Locale())
+	}
+
+	b := &Bundle{
+		universal: ut.New(translators[0], translators...),
+		matcher:   language.NewMatcher(tags),
+		locales:   names,
+		plurals:   map[string]bool{},
+	}
+
+	for _, s := range supported {
+		trans, _ := b.universal.GetTranslator(s.translator.Locale())
+
+		if err := b.loadCatalog(trans); err != nil {
+			return nil, err
+		}
+
+		if p.Validate != nil {
+			if err := s.validator(p.Validate, trans); err != nil {
+				return nil, fmt.Errorf("registering validator translations for %s: %w", trans.Locale(), err)
+			}
+		}
+	}
+
+	return b, nil
+}
+
+// loadCatalog adds the messages from locales/<locale>.json to trans. A message is
+// either a string or an object keyed by CLDR plural category (one, other, ...).
+func (b *Bundle) loadCatalog(trans ut.Translator) error {
+	raw, err := fs.ReadFile(catalogs, path.Join("locales", trans.Locale()+".json"))
+	if err != nil {
+		return fmt.Errorf("reading catalog for %s: %w", trans.Locale(), err)
+	}
+
+	var messages map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &messages); err != nil {
+		return fmt.Errorf("parsing catalog for %s: %w", trans.Locale(), err)
+	}
+
+	for key, message := range messages {
+		var text string
+		if err := json.Unmarshal(message, &text); err == nil {
+			if err := trans.Add(key, text, true); err != nil {
+				return err
+			}
+			continue
+		}
+
+		var forms map[string]string
+		if err := json.Unmarshal(message, &forms); err != nil {
+			return fmt.Errorf("message %q in %s catalog must be a string or plural object", key, trans.Locale())
+		}
+
+		for form, text := range forms {
+			rule, ok := pluralRules[form]
+			if !ok {
+				return fmt.Errorf("message %q in %s catalog has unknown plural form %q", key, trans.Locale(), form)
+			}
+			if err := trans.AddCardinal(key, text, rule, true); err != nil {
+				return err
+			}
+		}
+		b.plurals[key] = true
+	}
+
+	return nil
+}
+
+// Locales returns the supported locale names, the default first.
+func (b *Bundle) Locales() []string {
+	return b.locales
+}
+
+// Supports reports whether locale has a catalog.
+func (b *Bundle) Supports(locale string) bool {
+	_, ok := b.universal.GetTranslator(locale)
+	return ok
+}
+
+// Match picks the best supported locale for the given preferences, in order. Each
+// preference may be a locale name or an Accept-Language header value.
+func (b *Bundle) Match(preferences ...string) string {
+	for _, pref := range preferences {
+		if pref == "" {
+			continue
+		}
+
+		tags, _, err := language.ParseAcceptLanguage(pref)
+		if err != nil || len(tags) == 0 {
+			continue
+		}
+
+		_, index, confidence := b.matcher.Match(tags...)
+		if confidence != language.No {
+			return b.locales[index]
+		}
+	}
+
+	return b.locales[0]
+}
+
+// Localizer returns a Localizer for locale, falling back to the default locale.
+func (b *Bundle) Localizer(locale string) *Localizer {
+	trans, ok := b.universal.GetTranslator(locale)
+	if !ok {
+		trans = b.universal.GetFallback()
+	}
+
+	return &Localizer{
+		bundle:   b,
+		trans:    trans,
+		fallback: b.universal.GetFallback(),
+	}
+}
+
+// Localizer translates messages for a single locale.
+type Localizer struct {
+	bundle   *Bundle
+	trans    ut.Translator
+	fallback ut.Translator
+}
+
+// Locale returns the locale name, e.g. "en".
+func (l *Localizer) Locale() string {
+	return l.trans.Locale()
+}
+
+// Locales returns every supported locale, for language switchers.
+func (l *Localizer) Locales() []string {
+	return l.bundle.Locales()
+}
+
+// Translator exposes the underlying translator, used to translate validator errors.
+func (l *Localizer) Translator() ut.Translator {
+	return l.trans
+}
+
+// T translates key. For plural messages the first argument is the count, which
+// selects the plural form and is substituted for {0}. Other arguments replace
+// {0}, {1}, ... in order. Unknown keys fall back to the default locale and then
+// to the key itself.
+func (l *Localizer) T(key string, args ...interface{}) string {
+	if text, err := l.translate(l.trans, key, args); err == nil {
+		return text
+	}
+
+	if text, err := l.translate(l.fallback, key, args); err == nil {
+		return text
+	}
+
+	return key
+}
+
+func (l *Localizer) translate(trans ut.Translator, key string, args []interface{}) (string, error) {
+	if l.bundle.plurals[key] && len(args) > 0 {
+		count, digits, err := number(args[0])
+		if err != nil {
+			return "", err
+		}
+		return trans.C(key, count, digits, trans.FmtNumber(count, digits))
+	}
+
+	params := make([]string, 0, len(args))
+	for _, arg := range args {
+		params = append(params, fmt.Sprint(arg))
+	}
+
+	return trans.T(key, params...)
+}
+
+// number converts a template argument to a float and the count of its visible
+// fraction digits, which CLDR plural rules depend on.
+func number(v interface{}) (float64, uint64, error) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), 0, nil
+	case int64:
+		return float64(n), 0, nil
+	case uint:
+		return float64(n), 0, nil
+	case uint64:
+		return float64(n), 0, nil
+	case float64:
+		s := strconv.FormatFloat(n, 'f', -1, 64)
+		if i := strings.IndexByte(s, '.'); i >= 0 {
+			return n, uint64(len(s) - i - 1), nil
+		}
+		return n, 0, nil
+	default:
+		return 0, 0, fmt.Errorf("plural count must be a number, got %T", v)
+	}
+}
@@ -0,0 +1,113 @@
+//go:build unit
+// +build unit
+
+package i18n
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/jwtauth/v5"
+	"github.com/go-playground/validator/v10"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/tomdoestech/goth/internal/pkg/identity"
+)
+
+func newBundle(t *testing.T, validate *validator.Validate) *Bundle {
+	b, err := NewBundle(BundleParams{Validate: validate})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestNegotiation(t *testing.T) {
+	b := newBundle(t, nil)
+
+	negotiate := func(cookie, userLocale, acceptLanguage string) string {
+		req := httptest.NewRequest("GET", "/", nil)
+		if cookie != "" {
+			req.AddCookie(&http.Cookie{Name: CookieName, Value: cookie})
+		}
+		if userLocale != "" {
+			req = req.WithContext(identity.NewContext(req.Context(), &identity.Principal{UserID: uuid.New(), Locale: userLocale}))
+		}
+		if acceptLanguage != "" {
+			req.Header.Set("Accept-Language", acceptLanguage)
+		}
+
+		var locale string
+		w := httptest.NewRecorder()
+		b.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			locale = b.FromRequest(r).Locale()
+		})).ServeHTTP(w, req)
+
+		assert.Equal(t, locale, w.Header().Get("Content-Language"))
+		assert.Equal(t, "Accept-Language", w.Header().Get("Vary"))
+		return locale
+	}
+
+	assert.Equal(t, "fr", negotiate("fr", "en", "en"), "the cookie wins")
+	assert.Equal(t, "en", negotiate("de", "en", "fr"), "unsupported cookies are ignored")
+	assert.Equal(t, "fr", negotiate("", "fr", "en"), "then the user's preference")
+	assert.Equal(t, "en", negotiate("", "de", "en"), "unsupported preferences are ignored")
+	assert.Equal(t, "fr", negotiate("", "", "de;q=1, fr-CA;q=0.8, en;q=0.5"), "then the best Accept-Language match")
+	assert.Equal(t, "en", negotiate("", "", "fr;q=0.3, en;q=0.7"), "by q-value")
+	assert.Equal(t, "en", negotiate("", "", "de, ja"), "then the fallback")
+	assert.Equal(t, "en", negotiate("", "", ""))
+
+	// the token claims, before identity has run
+	req := httptest.NewRequest("GET", "/", nil)
+	token := jwtauth.New("HS256", []byte("secret"), nil)
+	jwt, _, _ := token.Encode(map[string]interface{}{"locale": "fr"})
+	req = req.WithContext(jwtauth.NewContext(req.Context(), jwt, nil))
+	var locale string
+	b.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		locale = b.FromRequest(r).Locale()
+	})).ServeHTTP(httptest.NewRecorder(), req)
+	assert.Equal(t, "fr", locale)
+
+	assert.Equal(t, "en", b.FromRequest(httptest.NewRequest("GET", "/", nil)).Locale(), "without the middleware")
+	_, ok := FromContext(context.Background())
+	assert.False(t, ok)
+}
+
+func TestPlurals(t *testing.T) {
+	b := newBundle(t, nil)
+	en, fr := b.Localizer("en"), b.Localizer("fr")
+
+	assert.Equal(t, "In 1 day", en.T("apikey.expires.days", 1))
+	assert.Equal(t, "In 0 days", en.T("apikey.expires.days", 0))
+	assert.Equal(t, "In 2 days", en.T("apikey.expires.days", 2))
+
+	// French counts 0 and 1.5 as singular
+	assert.Equal(t, "Dans 1 jour", fr.T("apikey.expires.days", 1))
+	assert.Equal(t, "Dans 0 jour", fr.T("apikey.expires.days", 0))
+	assert.Equal(t, "Dans 1,5 jour", fr.T("apikey.expires.days", 1.5))
+	assert.Equal(t, "Dans 2 jours", fr.T("apikey.expires.days", 2))
+
+	assert.Equal(t, "missing.key", fr.T("missing.key"), "unknown keys are returned as is")
+	assert.Equal(t, en.T("apikey.expires.days", 2), b.Localizer("de").T("apikey.expires.days", 2), "unsupported locales fall back")
+}
+
+func TestValidatorMessages(t *testing.T) {
+	validate := validator.New()
+	b := newBundle(t, validate)
+
+	var data struct {
+		Email string `validate:"required"`
+	}
+	err := validate.Struct(data)
+
+	var errs validator.ValidationErrors
+	if !errors.As(err, &errs) {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, "Email is a required field", errs[0].Translate(b.Localizer("en").Translator()))
+	assert.Equal(t, "Email est un champ obligatoire", errs[0].Translate(b.Localizer("fr").Translator()))
+}
@@ -0,0 +1,74 @@
+package i18n
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/jwtauth/v5"
+	"github.com/tomdoestech/goth/internal/pkg/identity"
+)
+
+// CookieName is the cookie holding an explicitly chosen locale.
+const CookieName = "lang"
+
+type contextKey struct{}
+
+// Middleware negotiates the locale for every request and stores a Localizer in
+// the request context.
+func (b *Bundle) Middleware(next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		locale := b.negotiate(r)
+
+		w.Header().Add("Vary", "Accept-Language")
+		w.Header().Set("Content-Language", locale)
+
+		ctx := context.WithValue(r.Context(), contextKey{}, b.Localizer(locale))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	}
+	return http.HandlerFunc(fn)
+}
+
+// negotiate picks the locale of r. The explicit cookie wins, then the logged
+// in user's preference, then the Accept-Language header.
+func (b *Bundle) negotiate(r *http.Request) string {
+	var cookieLocale, userLocale string
+
+	if cookie, err := r.Cookie(CookieName); err == nil && b.Supports(cookie.Value) {
+		cookieLocale = cookie.Value
+	}
+
+	if p, ok := identity.FromContext(r.Context()); ok && b.Supports(p.Locale) {
+		userLocale = p.Locale
+	} else if _, claims, err := jwtauth.FromContext(r.Context()); err == nil {
+		if locale, ok := claims["locale"].(string); ok && b.Supports(locale) {
+			userLocale = locale
+		}
+	}
+
+	return b.Match(cookieLocale, userLocale, r.Header.Get("Accept-Language"))
+}
+
+// FromRequest returns the Localizer negotiated by Middleware. When the
+// middleware did not run, such as for panics recovered in front of it, the
+// locale is negotiated from what the request has.
+func (b *Bundle) FromRequest(r *http.Request) *Localizer {
+	if l, ok := r.Context().Value(contextKey{}).(*Localizer); ok {
+		return l
+	}
+	return b.Localizer(b.negotiate(r))
+}
+
+// FromContext returns the Localizer stored by Middleware, if any.
+func FromContext(ctx context.Context) (*Localizer, bool) {
+	l, ok := ctx.Value(contextKey{}).(*Localizer)
+	return l, ok
+}
+
+// SetCookie remembers an explicitly chosen locale for a year.
+func SetCookie(w http.ResponseWriter, locale string) {
+	expiration := time.Now().Add(365 * 24 * time.Hour)
+	cookie := http.Cookie{Name: CookieName, Value: locale, Expires: expiration, Path: "/", SameSite: http.SameSiteLaxMode}
+
+	http.SetCookie(w, &cookie)
+}
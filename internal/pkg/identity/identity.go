@@ -0,0 +1,225 @@
+package identity
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/jwtauth/v5"
+	"github.com/google/uuid"
+	"github.com/tomdoestech/goth/internal/pkg/apperror"
+	"go.uber.org/zap"
+)
+
+// Method is how a principal authenticated.
+type Method string
+
+const (
+	MethodCookie Method = "cookie"
+	MethodBearer Method = "bearer"
+	MethodAPIKey Method = "api_key"
+)
+
+// Scopes an API key can be granted. Sessions are not limited by scopes.
+const (
+	ScopeProfileRead  = "profile:read"
+	ScopeProfileWrite = "profile:write"
+)
+
+// Scopes lists every scope in the order they are offered.
+var Scopes = []string{ScopeProfileRead, ScopeProfileWrite}
+
+// ErrInvalidCredentials is the cause of the error passed to Unauthorized when
+// the request carries a bearer token or API key that doesn't check out.
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
+// Principal is the authenticated caller of a request, whichever credential
+// they used.
+type Principal struct {
+	UserID uuid.UUID
+	Email  string
+	Locale string
+	Method Method
+	// Scopes limits what an API key may do
+	Scopes []string
+	// APIKeyID is set when Method is MethodAPIKey
+	APIKeyID uuid.UUID
+	// SessionID is the login the token belongs to, from its sid claim
+	SessionID uuid.UUID
+	// OrgID is the organization the login last switched to, from its org
+	// claim
+	OrgID uuid.UUID
+	// ImpersonatorID is the admin acting as the user, from the token's act
+	// claim
+	ImpersonatorID uuid.UUID
+}
+
+// Impersonated reports whether an admin is acting as the user. Sensitive
+// actions, such as changing the password, are refused then.
+func (p *Principal) Impersonated() bool {
+	return p.ImpersonatorID != uuid.Nil
+}
+
+// Can reports whether the principal is allowed to act within scope.
+func (p *Principal) Can(scope string) bool {
+	if p.Method != MethodAPIKey {
+		return true
+	}
+	for _, s := range p.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+type contextKey struct{}
+
+func NewContext(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, contextKey{}, p)
+}
+
+// FromContext returns the principal of the request, if it is authenticated.
+func FromContext(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(contextKey{}).(*Principal)
+	return p, ok && p != nil
+}
+
+// APIKeyResolver looks up the principal an API key belongs to.
+type APIKeyResolver interface {
+	ResolveAPIKey(ctx context.Context, key string) (*Principal, error)
+}
+
+type MiddlewareParams struct {
+	APIKeys APIKeyResolver
+	// APIKeyPrefix tells API keys apart from JWTs in the Authorization header
+	APIKeyPrefix string
+	// Sessions returns the user of a server-side session, nil for anonymous
+	// requests. Optional, requests without a bearer token try it before the
+	// token cookie.
+	Sessions func(r *http.Request) *Principal
+	// Status returns why the user can't use their account, nil when they
+	// can. Optional, it is checked on every authenticated request.
+	Status func(ctx context.Context, userID uuid.UUID) error
+	// Impersonator returns why the admin impersonating the user can no
+	// longer do so, nil when they can. Optional, it is checked on every
+	// impersonated request, as Status is.
+	Impersonator func(ctx context.Context, adminID uuid.UUID) error
+	// Unauthorized renders the unauthorized error for requests with invalid credentials
+	Unauthorized func(w http.ResponseWriter, r *http.Request, err error)
+	Logger       *zap.Logger
+}
+
+// Middleware resolves the request's credentials to a Principal. It runs after
+// jwtauth.Verify: API keys in the Authorization header are looked up with
+// APIKeys, then a server-side session with Sessions, otherwise the verified
+// JWT, from the header or the cookie, is used.
+// Invalid credentials in the Authorization header are rejected; an invalid
+// cookie leaves the request anonymous, as it was before. The same goes for
+// users whose account Status blocks, such as suspended ones, whose token
+// cookie is cleared as well.
+func Middleware(p MiddlewareParams) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+			bearer := jwtauth.TokenFromHeader(r)
+
+			serve := func(principal *Principal) {
+				if err := p.check(ctx, principal); err != nil {
+					p.Logger.Info("Blocked account", zap.Stringer("user", principal.UserID), zap.Error(err))
+					if bearer != "" {
+						p.Unauthorized(w, r, apperror.New(apperror.KindForbidden, "", err))
+						return
+					}
+					// the token's claims would still show them logged in,
+					// to the pages and streams reading them
+					if _, err := r.Cookie("token"); err == nil {
+						http.SetCookie(w, &http.Cookie{Name: "token", Value: "", Path: "/", Expires: time.Unix(0, 0), MaxAge: -1})
+					}
+					next.ServeHTTP(w, r.WithContext(jwtauth.NewContext(ctx, nil, err)))
+					return
+				}
+				next.ServeHTTP(w, r.WithContext(NewContext(ctx, principal)))
+			}
+
+			if p.APIKeys != nil && bearer != "" && strings.HasPrefix(bearer, p.APIKeyPrefix) {
+				principal, err := p.APIKeys.ResolveAPIKey(ctx, bearer)
+				if err != nil {
+					p.Logger.Info("Rejected API key", zap.Error(err))
+					p.reject(w, r)
+					return
+				}
+				serve(principal)
+				return
+			}
+
+			if p.Sessions != nil && bearer == "" {
+				if principal := p.Sessions(r); principal != nil {
+					serve(principal)
+					return
+				}
+			}
+
+			token, claims, err := jwtauth.FromContext(ctx)
+			if err != nil || token == nil {
+				if bearer != "" {
+					p.reject(w, r)
+					return
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			idStr, _ := claims["id"].(string)
+			id, err := uuid.Parse(idStr)
+			if err != nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			principal := &Principal{UserID: id, Method: MethodCookie}
+			principal.Email, _ = claims["email"].(string)
+			principal.Locale, _ = claims["locale"].(string)
+			if sid, ok := claims["sid"].(string); ok {
+				principal.SessionID, _ = uuid.Parse(sid)
+			}
+			if org, ok := claims["org"].(string); ok {
+				principal.OrgID, _ = uuid.Parse(org)
+			}
+			if act, ok := claims["act"].(map[string]interface{}); ok {
+				sub, _ := act["sub"].(string)
+				principal.ImpersonatorID, _ = uuid.Parse(sub)
+			}
+			if bearer != "" {
+				principal.Method = MethodBearer
+			}
+
+			serve(principal)
+		}
+		return http.HandlerFunc(fn)
+	}
+}
+
+// check returns why the principal can't be let in: the user's account is
+// blocked, or the admin impersonating them may no longer.
+func (p MiddlewareParams) check(ctx context.Context, principal *Principal) error {
+	if p.Status != nil {
+		if err := p.Status(ctx, principal.UserID); err != nil {
+			return err
+		}
+	}
+	if p.Impersonator != nil && principal.Impersonated() {
+		if err := p.Impersonator(ctx, principal.ImpersonatorID); err != nil {
+			return fmt.Errorf("impersonator: %w", err)
+		}
+	}
+	return nil
+}
+
+func (p MiddlewareParams) reject(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+	p.Unauthorized(w, r, apperror.Unauthorized("", ErrInvalidCredentials))
+}
@@ -0,0 +1,96 @@
+//go:build unit
+// +build unit
+
+package identity
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/jwtauth/v5"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+type fakeResolver map[string]*Principal
+
+func (f fakeResolver) ResolveAPIKey(ctx context.Context, key string) (*Principal, error) {
+	if p, ok := f[key]; ok {
+		return p, nil
+	}
+	return nil, errors.New("unknown key")
+}
+
+func TestMiddleware(t *testing.T) {
+
+	userID := uuid.New()
+	keyPrincipal := &Principal{UserID: userID, Method: MethodAPIKey, Scopes: []string{ScopeProfileRead}}
+
+	tokenAuth := jwtauth.New("HS256", []byte("secret"), nil)
+	_, token, err := tokenAuth.Encode(map[string]interface{}{"id": userID.String(), "email": "test@example.com"})
+	assert.NoError(t, err)
+
+	fromCookie := func(r *http.Request) string {
+		if cookie, err := r.Cookie("token"); err == nil {
+			return cookie.Value
+		}
+		return ""
+	}
+	fromHeader := func(r *http.Request) string {
+		if t := jwtauth.TokenFromHeader(r); len(t) < 5 || t[:5] != "goth_" {
+			return t
+		}
+		return ""
+	}
+
+	handler := jwtauth.Verify(tokenAuth, fromHeader, fromCookie)(Middleware(MiddlewareParams{
+		APIKeys:      fakeResolver{"goth_valid": keyPrincipal},
+		APIKeyPrefix: "goth_",
+		Unauthorized: func(w http.ResponseWriter, r *http.Request, err error) {
+			w.WriteHeader(http.StatusUnauthorized)
+		},
+		Logger: zap.NewNop(),
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if p, ok := FromContext(r.Context()); ok {
+			w.Write([]byte(p.Method))
+		}
+	})))
+
+	tests := []struct {
+		name          string
+		authorization string
+		cookie        string
+		status        int
+		method        Method
+	}{
+		{name: "anonymous", status: http.StatusOK},
+		{name: "cookie", cookie: token, status: http.StatusOK, method: MethodCookie},
+		{name: "invalid cookie", cookie: "nonsense", status: http.StatusOK},
+		{name: "bearer", authorization: "Bearer " + token, status: http.StatusOK, method: MethodBearer},
+		{name: "invalid bearer", authorization: "Bearer nonsense", status: http.StatusUnauthorized},
+		{name: "api key", authorization: "Bearer goth_valid", status: http.StatusOK, method: MethodAPIKey},
+		{name: "invalid api key", authorization: "Bearer goth_revoked", status: http.StatusUnauthorized},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			if test.authorization != "" {
+				req.Header.Set("Authorization", test.authorization)
+			}
+			if test.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: "token", Value: test.cookie})
+			}
+			w := httptest.NewRecorder()
+
+			handler.ServeHTTP(w, req)
+
+			assert.Equal(t, test.status, w.Code)
+			assert.Equal(t, string(test.method), w.Body.String())
+		})
+	}
+}
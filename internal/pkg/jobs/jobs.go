@@ -0,0 +1,345 @@
+// Package jobs runs work outside of requests, from a queue kept in the jobs
+// table. Jobs survive restarts, failed ones are retried with exponential
+// back-off, and the ones that keep failing are kept as dead for a look.
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Statuses of a job.
+const (
+	StatusPending = "pending"
+	StatusRunning = "running"
+	StatusDone    = "done"
+	// StatusDead is the dead letter: the job failed too often, or for good,
+	// and waits for Retry
+	StatusDead = "dead"
+)
+
+var (
+	// ErrUnknownKind is returned by Enqueue for kinds nothing was registered
+	// for.
+	ErrUnknownKind = errors.New("unknown job kind")
+	// ErrNotFound is returned by Retry when there is no dead job with the id.
+	ErrNotFound = errors.New("job not found")
+)
+
+// JobModel is a job in the queue. Its payload is JSON, decoded into the type
+// its kind was registered with.
+type JobModel struct {
+	ID      uuid.UUID `gorm:"primaryKey;type:uuid"`
+	Kind    string    `gorm:"index;not null"`
+	Payload []byte
+	Status  string    `gorm:"not null;index:idx_jobs_status_run_at"`
+	RunAt   time.Time `gorm:"index:idx_jobs_status_run_at"`
+	// UniqueKey is set while the job is pending or running, no job with the
+	// same key can be enqueued meanwhile
+	UniqueKey   *string `gorm:"uniqueIndex"`
+	Attempts    int
+	MaxAttempts int
+	// LockedUntil is when a running job is presumed lost, and run again
+	LockedUntil *time.Time
+	LastError   string
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	FinishedAt  *time.Time `gorm:"index"`
+}
+
+func (JobModel) TableName() string {
+	return "jobs"
+}
+
+// Options of an enqueued job.
+type Options struct {
+	// RunAt delays the job until then, it runs as soon as possible when zero
+	RunAt time.Time
+	// UniqueKey drops the job when one of the same kind and key is pending or
+	// running, and returns that one instead
+	UniqueKey string
+	// MaxAttempts is how many times the job runs before it is dead, defaults
+	// to the queue's
+	MaxAttempts int
+}
+
+// permanentError is a failure retrying won't fix.
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e permanentError) Unwrap() error {
+	return e.err
+}
+
+// Permanent marks the error of a job as one that retrying won't fix, so the
+// job is dead straight away.
+func Permanent(err error) error {
+	return permanentError{err: err}
+}
+
+type handler func(ctx context.Context, payload []byte) error
+
+type Params struct {
+	DB *gorm.DB
+	// Concurrency is how many jobs run at the same time, defaults to 4
+	Concurrency int
+	// PollInterval is how often idle workers look for jobs, defaults to a
+	// second. Jobs enqueued by this instance wake them straight away.
+	PollInterval time.Duration
+	// Timeout is how long a job may run, defaults to 5 minutes. A job still
+	// running that long after it started, because its instance died, is run
+	// again.
+	Timeout time.Duration
+	// MaxAttempts defaults to 5
+	MaxAttempts int
+	// Backoff is the delay before the first retry, defaults to 10 seconds. It
+	// doubles with every attempt, up to MaxBackoff, an hour by default.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// Retention is how long finished jobs are kept, defaults to a day. Dead
+	// jobs are kept until retried or deleted by hand.
+	Retention time.Duration
+	Logger    *zap.Logger
+}
+
+// Queue enqueues jobs and runs them with a pool of workers, see Run.
+type Queue struct {
+	db           *gorm.DB
+	concurrency  int
+	pollInterval time.Duration
+	timeout      time.Duration
+	maxAttempts  int
+	backoff      time.Duration
+	maxBackoff   time.Duration
+	retention    time.Duration
+	logger       *zap.Logger
+	now          func() time.Time
+
+	mu       sync.RWMutex
+	handlers map[string]handler
+	// periodic are the intervals of the kinds registered with Every
+	periodic map[string]time.Duration
+
+	// wake tells an idle worker a job was enqueued
+	wake     chan struct{}
+	stop     chan struct{}
+	stopOnce sync.Once
+	workers  sync.WaitGroup
+
+	// jobs is the parent of the running jobs' contexts, cancelled when
+	// Shutdown gives up waiting
+	jobs       context.Context
+	cancelJobs context.CancelFunc
+}
+
+func New(p Params) (*Queue, error) {
+	if p.Concurrency <= 0 {
+		p.Concurrency = 4
+	}
+	if p.PollInterval <= 0 {
+		p.PollInterval = time.Second
+	}
+	if p.Timeout <= 0 {
+		p.Timeout = 5 * time.Minute
+	}
+	if p.MaxAttempts <= 0 {
+		p.MaxAttempts = 5
+	}
+	if p.Backoff <= 0 {
+		p.Backoff = 10 * time.Second
+	}
+	if p.MaxBackoff <= 0 {
+		p.MaxBackoff = time.Hour
+	}
+	if p.Retention <= 0 {
+		p.Retention = 24 * time.Hour
+	}
+	if p.Logger == nil {
+		p.Logger = zap.NewNop()
+	}
+
+	if err := p.DB.AutoMigrate(&JobModel{}); err != nil {
+		return nil, err
+	}
+
+	jobs, cancelJobs := context.WithCancel(context.Background())
+
+	return &Queue{
+		db:           p.DB,
+		concurrency:  p.Concurrency,
+		pollInterval: p.PollInterval,
+		timeout:      p.Timeout,
+		maxAttempts:  p.MaxAttempts,
+		backoff:      p.Backoff,
+		maxBackoff:   p.MaxBackoff,
+		retention:    p.Retention,
+		logger:       p.Logger,
+		now:          time.Now,
+		handlers:     map[string]handler{},
+		periodic:     map[string]time.Duration{},
+		wake:         make(chan struct{}, 1),
+		stop:         make(chan struct{}),
+		jobs:         jobs,
+		cancelJobs:   cancelJobs,
+	}, nil
+}
+
+// Register sets fn to run the jobs of kind, with their payload decoded into
+// a T. Register every kind before Run, a kind can only be registered once.
+//
+// fn may run more than once for the same job, when it fails or its instance
+// dies, so it should be safe to repeat. Return Permanent errors for failures
+// that retrying won't fix.
+func Register[T any](q *Queue, kind string, fn func(ctx context.Context, payload T) error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if _, ok := q.handlers[kind]; ok {
+		panic("jobs: kind registered twice: " + kind)
+	}
+
+	q.handlers[kind] = func(ctx context.Context, payload []byte) error {
+		var v T
+		if err := json.Unmarshal(payload, &v); err != nil {
+			return Permanent(fmt.Errorf("decoding payload: %w", err))
+		}
+		return fn(ctx, v)
+	}
+}
+
+// Every registers fn to run every interval, as a job of kind that Run keeps
+// scheduled. The kind is its unique key, so it runs on one instance at a
+// time, and interval after the previous run finished.
+func Every(q *Queue, kind string, interval time.Duration, fn func(ctx context.Context) error) {
+	Register(q, kind, func(ctx context.Context, _ struct{}) error {
+		return fn(ctx)
+	})
+
+	q.mu.Lock()
+	q.periodic[kind] = interval
+	q.mu.Unlock()
+}
+
+// Enqueue adds a job of kind with payload, which must encode to JSON as the
+// type kind was registered with.
+func (q *Queue) Enqueue(ctx context.Context, kind string, payload interface{}, opts Options) (*JobModel, error) {
+	q.mu.RLock()
+	_, ok := q.handlers[kind]
+	q.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownKind, kind)
+	}
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("encoding payload: %w", err)
+	}
+
+	now := q.now()
+	job := &JobModel{
+		Kind:        kind,
+		Payload:     data,
+		Status:      StatusPending,
+		RunAt:       opts.RunAt,
+		MaxAttempts: opts.MaxAttempts,
+		CreatedAt:   now,
+	}
+	if job.RunAt.IsZero() {
+		job.RunAt = now
+	}
+	if job.MaxAttempts <= 0 {
+		job.MaxAttempts = q.maxAttempts
+	}
+	if opts.UniqueKey != "" {
+		key := kind + ":" + opts.UniqueKey
+		job.UniqueKey = &key
+	}
+
+	// the other job may finish between the insert and the lookup, then try
+	// again
+	for tries := 0; tries < 3; tries++ {
+		job.ID = uuid.New()
+
+		result := q.db.WithContext(ctx).
+			Clauses(clause.OnConflict{Columns: []clause.Column{{Name: "unique_key"}}, DoNothing: true}).
+			Create(job)
+		if result.Error != nil {
+			return nil, result.Error
+		}
+
+		if result.RowsAffected == 1 {
+			if !job.RunAt.After(now) {
+				q.notify()
+			}
+			return job, nil
+		}
+
+		var existing JobModel
+		err := q.db.WithContext(ctx).Where("unique_key = ?", *job.UniqueKey).First(&existing).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return &existing, nil
+	}
+
+	return nil, fmt.Errorf("enqueuing unique job %s", *job.UniqueKey)
+}
+
+// Retry puts a dead job back in the queue, with its attempts reset.
+func (q *Queue) Retry(ctx context.Context, id uuid.UUID) error {
+	result := q.db.WithContext(ctx).Model(&JobModel{}).
+		Where("id = ? AND status = ?", id, StatusDead).
+		Updates(map[string]interface{}{
+			"status":      StatusPending,
+			"run_at":      q.now(),
+			"attempts":    0,
+			"finished_at": nil,
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+
+	q.notify()
+
+	return nil
+}
+
+// Find returns the job with id.
+func (q *Queue) Find(ctx context.Context, id uuid.UUID) (*JobModel, error) {
+	var job JobModel
+	err := q.db.WithContext(ctx).Where("id = ?", id).First(&job).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+func (q *Queue) notify() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,347 @@
+//go:build unit
+// +build unit
+
+package jobs
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+type greeting struct {
+	Name string `json:"name"`
+}
+
+func newQueue(t *testing.T) *Queue {
+	f, err := os.CreateTemp("", "test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	t.Cleanup(func() { os.Remove(f.Name()) })
+
+	db, err := gorm.Open(sqlite.Open(f.Name()), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	q, err := New(Params{
+		DB:           db,
+		Concurrency:  2,
+		PollInterval: 10 * time.Millisecond,
+		Backoff:      time.Millisecond,
+		MaxBackoff:   5 * time.Millisecond,
+		MaxAttempts:  3,
+		Logger:       zap.NewNop(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return q
+}
+
+// waitFor waits for the job to reach status.
+func waitFor(t *testing.T, q *Queue, job *JobModel, status string) *JobModel {
+	var found *JobModel
+	assert.Eventually(t, func() bool {
+		var err error
+		found, err = q.Find(context.Background(), job.ID)
+		return err == nil && found.Status == status
+	}, 5*time.Second, 10*time.Millisecond, "job %s should be %s", job.Kind, status)
+	return found
+}
+
+func TestQueue(t *testing.T) {
+	q := newQueue(t)
+	ctx := context.Background()
+
+	greeted := make(chan string, 10)
+	Register(q, "greet", func(ctx context.Context, g greeting) error {
+		greeted <- g.Name
+		return nil
+	})
+
+	var flaky int32
+	Register(q, "flaky", func(ctx context.Context, g greeting) error {
+		if atomic.AddInt32(&flaky, 1) < 3 {
+			return errors.New("try again")
+		}
+		return nil
+	})
+
+	var broken int32
+	Register(q, "broken", func(ctx context.Context, g greeting) error {
+		if atomic.AddInt32(&broken, 1) == 1 {
+			panic("oops")
+		}
+		return errors.New("still broken")
+	})
+
+	Register(q, "invalid", func(ctx context.Context, g greeting) error {
+		return Permanent(errors.New("no such name"))
+	})
+
+	_, err := q.Enqueue(ctx, "unknown", greeting{}, Options{})
+	assert.ErrorIs(t, err, ErrUnknownKind)
+
+	runCtx, stop := context.WithCancel(ctx)
+	defer stop()
+	go q.Run(runCtx)
+
+	t.Run("typed payload", func(t *testing.T) {
+		job, err := q.Enqueue(ctx, "greet", greeting{Name: "alice"}, Options{})
+		if !assert.NoError(t, err) {
+			return
+		}
+		select {
+		case name := <-greeted:
+			assert.Equal(t, "alice", name)
+		case <-time.After(5 * time.Second):
+			t.Fatal("the job didn't run")
+		}
+
+		done := waitFor(t, q, job, StatusDone)
+		assert.Equal(t, 1, done.Attempts)
+		assert.Empty(t, done.Payload, "done jobs keep no payload")
+		assert.NotNil(t, done.FinishedAt)
+	})
+
+	t.Run("retries", func(t *testing.T) {
+		job, _ := q.Enqueue(ctx, "flaky", greeting{}, Options{})
+		done := waitFor(t, q, job, StatusDone)
+		assert.Equal(t, 3, done.Attempts)
+	})
+
+	t.Run("dead letter", func(t *testing.T) {
+		job, _ := q.Enqueue(ctx, "broken", greeting{}, Options{})
+		dead := waitFor(t, q, job, StatusDead)
+		assert.Equal(t, 3, dead.Attempts)
+		assert.Equal(t, "still broken", dead.LastError)
+		assert.NotEmpty(t, dead.Payload, "dead jobs keep their payload to be retried")
+
+		assert.NoError(t, q.Retry(ctx, job.ID))
+		dead = waitFor(t, q, job, StatusDead)
+		assert.Equal(t, 3, dead.Attempts)
+		assert.Equal(t, int32(6), atomic.LoadInt32(&broken))
+
+		done, _ := q.Enqueue(ctx, "greet", greeting{Name: "bob"}, Options{})
+		<-greeted
+		waitFor(t, q, done, StatusDone)
+		assert.ErrorIs(t, q.Retry(ctx, done.ID), ErrNotFound, "only dead jobs are retried")
+	})
+
+	t.Run("permanent errors", func(t *testing.T) {
+		job, _ := q.Enqueue(ctx, "invalid", greeting{}, Options{})
+		dead := waitFor(t, q, job, StatusDead)
+		assert.Equal(t, 1, dead.Attempts)
+		assert.Equal(t, "no such name", dead.LastError)
+	})
+}
+
+func TestUniqueAndScheduled(t *testing.T) {
+	q := newQueue(t)
+	ctx := context.Background()
+
+	Register(q, "digest", func(ctx context.Context, g greeting) error { return nil })
+
+	now := time.Now()
+	q.now = func() time.Time { return now }
+
+	later := now.Add(time.Hour)
+	first, err := q.Enqueue(ctx, "digest", greeting{Name: "alice"}, Options{UniqueKey: "alice", RunAt: later})
+	if !assert.NoError(t, err) {
+		return
+	}
+	again, err := q.Enqueue(ctx, "digest", greeting{Name: "alice"}, Options{UniqueKey: "alice"})
+	if assert.NoError(t, err) {
+		assert.Equal(t, first.ID, again.ID, "the pending job is returned")
+	}
+	other, _ := q.Enqueue(ctx, "digest", greeting{Name: "bob"}, Options{UniqueKey: "bob", RunAt: later.Add(time.Hour)})
+	assert.NotEqual(t, first.ID, other.ID)
+
+	job, err := q.claim(ctx)
+	assert.NoError(t, err)
+	assert.Nil(t, job, "scheduled jobs wait for their time")
+
+	now = later
+	job, err = q.claim(ctx)
+	if assert.NoError(t, err) && assert.NotNil(t, job) {
+		assert.Equal(t, first.ID, job.ID)
+		assert.Equal(t, StatusRunning, job.Status)
+	}
+
+	again, _ = q.Enqueue(ctx, "digest", greeting{Name: "alice"}, Options{UniqueKey: "alice"})
+	assert.Equal(t, first.ID, again.ID, "running jobs count too")
+
+	// the instance running it died, another one takes over
+	now = now.Add(q.timeout + time.Second)
+	lost := job
+	job, _ = q.claim(ctx)
+	if assert.NotNil(t, job) {
+		assert.Equal(t, first.ID, job.ID)
+		assert.Equal(t, 2, job.Attempts)
+	}
+
+	q.finish(lost, errors.New("too late"))
+	found, _ := q.Find(ctx, first.ID)
+	assert.Equal(t, StatusRunning, found.Status, "the lost run doesn't overwrite the new one")
+
+	q.finish(job, nil)
+	again, _ = q.Enqueue(ctx, "digest", greeting{Name: "alice"}, Options{UniqueKey: "alice"})
+	assert.NotEqual(t, first.ID, again.ID, "the key is free once the job is done")
+}
+
+func TestLockExpiredOnLastAttempt(t *testing.T) {
+	q := newQueue(t)
+	ctx := context.Background()
+
+	Register(q, "crash", func(ctx context.Context, g greeting) error { return nil })
+
+	now := time.Now()
+	q.now = func() time.Time { return now }
+
+	enqueued, err := q.Enqueue(ctx, "crash", greeting{}, Options{UniqueKey: "crash", MaxAttempts: 2})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	// the instance running it dies, twice
+	for attempt := 1; attempt <= 2; attempt++ {
+		job, _ := q.claim(ctx)
+		if assert.NotNil(t, job) {
+			assert.Equal(t, attempt, job.Attempts)
+		}
+		now = now.Add(q.timeout + time.Second)
+	}
+
+	job, err := q.claim(ctx)
+	assert.NoError(t, err)
+	assert.Nil(t, job, "not run past its attempts")
+
+	found, _ := q.Find(ctx, enqueued.ID)
+	assert.Equal(t, StatusDead, found.Status)
+	assert.Equal(t, "lock expired", found.LastError)
+	assert.Nil(t, found.UniqueKey)
+	assert.Equal(t, 2, found.Attempts)
+}
+
+func TestEvery(t *testing.T) {
+	q := newQueue(t)
+	ctx := context.Background()
+
+	var runs int32
+	Every(q, "purge", time.Hour, func(ctx context.Context) error {
+		atomic.AddInt32(&runs, 1)
+		return nil
+	})
+
+	now := time.Now()
+	q.now = func() time.Time { return now }
+
+	q.schedule(ctx)
+	q.schedule(ctx)
+	var scheduled []JobModel
+	q.db.Where("kind = ?", "purge").Find(&scheduled)
+	if assert.Len(t, scheduled, 1, "one run at a time") {
+		assert.WithinDuration(t, now.Add(time.Hour), scheduled[0].RunAt, time.Second)
+	}
+
+	job, _ := q.claim(ctx)
+	assert.Nil(t, job, "not before the interval")
+
+	now = now.Add(time.Hour)
+	job, _ = q.claim(ctx)
+	if assert.NotNil(t, job) {
+		q.execute(job)
+		assert.Equal(t, int32(1), atomic.LoadInt32(&runs))
+	}
+
+	q.schedule(ctx)
+	var next JobModel
+	err := q.db.Where("kind = ? AND status = ?", "purge", StatusPending).First(&next).Error
+	if assert.NoError(t, err, "the next run is scheduled") {
+		assert.WithinDuration(t, now.Add(time.Hour), next.RunAt, time.Second)
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	q := newQueue(t)
+	ctx := context.Background()
+
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	Register(q, "slow", func(ctx context.Context, g greeting) error {
+		started <- struct{}{}
+		select {
+		case <-release:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+
+	go q.Run(ctx)
+
+	job, _ := q.Enqueue(ctx, "slow", greeting{}, Options{})
+	<-started
+
+	// the running job finishes before Shutdown returns
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		close(release)
+	}()
+	assert.NoError(t, q.Shutdown(ctx))
+	waitFor(t, q, job, StatusDone)
+
+	// jobs enqueued after shutdown wait for the next start
+	pending, _ := q.Enqueue(ctx, "slow", greeting{}, Options{})
+	time.Sleep(50 * time.Millisecond)
+	found, _ := q.Find(ctx, pending.ID)
+	assert.Equal(t, StatusPending, found.Status)
+}
+
+func TestShutdownTimeout(t *testing.T) {
+	q := newQueue(t)
+	ctx := context.Background()
+
+	started := make(chan struct{}, 1)
+	Register(q, "stuck", func(ctx context.Context, g greeting) error {
+		started <- struct{}{}
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	go q.Run(ctx)
+
+	job, _ := q.Enqueue(ctx, "stuck", greeting{}, Options{})
+	<-started
+
+	shutdownCtx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, q.Shutdown(shutdownCtx), context.DeadlineExceeded)
+
+	found := waitFor(t, q, job, StatusPending)
+	assert.Equal(t, 0, found.Attempts, "an interrupted attempt doesn't count")
+	assert.Empty(t, found.LastError)
+}
+
+func TestDelay(t *testing.T) {
+	q := newQueue(t)
+	q.backoff = time.Second
+	q.maxBackoff = time.Minute
+
+	for attempt, want := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 4: 8 * time.Second, 10: time.Minute, 100: time.Minute} {
+		d := q.delay(attempt)
+		assert.GreaterOrEqual(t, d, want, "attempt %d", attempt)
+		assert.LessOrEqual(t, d, want+want/5, "attempt %d", attempt)
+	}
+}
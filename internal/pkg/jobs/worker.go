@@ -0,0 +1,348 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/tomdoestech/goth/internal/pkg/metrics"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// maintenanceInterval is how often Run updates the queue metrics, deletes
+// finished jobs and schedules the periodic ones.
+const maintenanceInterval = 15 * time.Second
+
+// releaseGrace is how long Shutdown waits for cancelled jobs to be put back
+// in the queue.
+const releaseGrace = time.Second
+
+// Outcomes of running a job, in the metrics.
+const (
+	outcomeDone        = "done"
+	outcomeRetry       = "retry"
+	outcomeDead        = "dead"
+	outcomeInterrupted = "interrupted"
+)
+
+// Run works through the queue until ctx is done or Shutdown is called. Run it
+// once, after registering every kind.
+func (q *Queue) Run(ctx context.Context) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	go func() {
+		select {
+		case <-q.stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	for i := 0; i < q.concurrency; i++ {
+		q.workers.Add(1)
+		go q.work(ctx)
+	}
+
+	ticker := time.NewTicker(maintenanceInterval)
+	defer ticker.Stop()
+
+	for {
+		q.maintain(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Shutdown stops taking jobs and waits for the running ones to finish. When
+// ctx ends first, the jobs' contexts are cancelled and they are put back in
+// the queue, to run again without counting the attempt.
+func (q *Queue) Shutdown(ctx context.Context) error {
+	q.stopOnce.Do(func() { close(q.stop) })
+
+	done := make(chan struct{})
+	go func() {
+		q.workers.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+	}
+
+	q.cancelJobs()
+
+	select {
+	case <-done:
+	case <-time.After(releaseGrace):
+	}
+	return ctx.Err()
+}
+
+func (q *Queue) work(ctx context.Context) {
+	defer q.workers.Done()
+
+	ticker := time.NewTicker(q.pollInterval)
+	defer ticker.Stop()
+
+	for ctx.Err() == nil {
+		job, err := q.claim(ctx)
+		if err != nil && ctx.Err() == nil {
+			q.logger.Error("Error claiming job", zap.Error(err))
+		}
+		if job != nil {
+			q.execute(job)
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-q.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// claim marks the next due job as running, or a running one whose lock
+// expired. Several workers, of several instances, may go for the same job;
+// only the one whose update matches the attempts it read gets it.
+func (q *Queue) claim(ctx context.Context) (*JobModel, error) {
+	for tries := 0; tries < 3; tries++ {
+		now := q.now()
+
+		var job JobModel
+		err := q.db.WithContext(ctx).
+			Where("(status = ? AND run_at <= ?) OR (status = ? AND locked_until < ?)", StatusPending, now, StatusRunning, now).
+			Order("run_at").
+			First(&job).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		// a job that keeps taking its instance down would otherwise run
+		// forever
+		if job.Status == StatusRunning && job.Attempts >= job.MaxAttempts {
+			if err := q.bury(ctx, &job, now); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		lockedUntil := now.Add(q.timeout)
+		result := q.db.WithContext(ctx).Model(&JobModel{}).
+			Where("id = ? AND status = ? AND attempts = ?", job.ID, job.Status, job.Attempts).
+			Updates(map[string]interface{}{
+				"status":       StatusRunning,
+				"attempts":     job.Attempts + 1,
+				"locked_until": lockedUntil,
+			})
+		if result.Error != nil {
+			return nil, result.Error
+		}
+		if result.RowsAffected == 1 {
+			job.Status = StatusRunning
+			job.Attempts++
+			job.LockedUntil = &lockedUntil
+			return &job, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// bury marks a running job whose lock expired on its last attempt as dead.
+func (q *Queue) bury(ctx context.Context, job *JobModel, now time.Time) error {
+	result := q.db.WithContext(ctx).Model(&JobModel{}).
+		Where("id = ? AND status = ? AND attempts = ?", job.ID, StatusRunning, job.Attempts).
+		Updates(map[string]interface{}{
+			"status":       StatusDead,
+			"unique_key":   nil,
+			"locked_until": nil,
+			"last_error":   "lock expired",
+			"finished_at":  now,
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 1 {
+		q.logger.Error("Job failed for good, its lock expired", zap.Stringer("job", job.ID),
+			zap.String("kind", job.Kind), zap.Int("attempt", job.Attempts))
+	}
+	return nil
+}
+
+func (q *Queue) execute(job *JobModel) {
+	q.mu.RLock()
+	h := q.handlers[job.Kind]
+	q.mu.RUnlock()
+
+	start := time.Now()
+	wait := q.now().Sub(job.RunAt)
+
+	ctx, cancel := context.WithTimeout(q.jobs, q.timeout)
+	err := call(ctx, h, job)
+	cancel()
+
+	outcome := q.finish(job, err)
+	metrics.RecordJob(job.Kind, outcome, wait, time.Since(start))
+}
+
+func call(ctx context.Context, h handler, job *JobModel) (err error) {
+	if h == nil {
+		return Permanent(fmt.Errorf("%w: %s", ErrUnknownKind, job.Kind))
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+
+	return h(ctx, job.Payload)
+}
+
+// finish records how the job went. The update only applies while the job
+// still has the attempts it was claimed with, so a job that ran past its
+// lock and was claimed again isn't overwritten.
+func (q *Queue) finish(job *JobModel, err error) string {
+	now := q.now()
+	logger := q.logger.With(zap.Stringer("job", job.ID), zap.String("kind", job.Kind), zap.Int("attempt", job.Attempts))
+
+	var outcome string
+	var updates map[string]interface{}
+
+	var permanent permanentError
+	switch {
+	case err == nil:
+		outcome = outcomeDone
+		// done jobs keep no payload, it may hold links or addresses
+		updates = map[string]interface{}{
+			"status":       StatusDone,
+			"payload":      nil,
+			"unique_key":   nil,
+			"locked_until": nil,
+			"last_error":   "",
+			"finished_at":  now,
+		}
+	case q.jobs.Err() != nil:
+		outcome = outcomeInterrupted
+		logger.Warn("Job interrupted by shutdown", zap.Error(err))
+		updates = map[string]interface{}{
+			"status":       StatusPending,
+			"attempts":     job.Attempts - 1,
+			"run_at":       now,
+			"locked_until": nil,
+		}
+	case errors.As(err, &permanent) || job.Attempts >= job.MaxAttempts:
+		outcome = outcomeDead
+		logger.Error("Job failed for good", zap.Error(err))
+		updates = map[string]interface{}{
+			"status":       StatusDead,
+			"unique_key":   nil,
+			"locked_until": nil,
+			"last_error":   err.Error(),
+			"finished_at":  now,
+		}
+	default:
+		outcome = outcomeRetry
+		delay := q.delay(job.Attempts)
+		logger.Warn("Job failed, retrying", zap.Duration("in", delay), zap.Error(err))
+		updates = map[string]interface{}{
+			"status":       StatusPending,
+			"run_at":       now.Add(delay),
+			"locked_until": nil,
+			"last_error":   err.Error(),
+		}
+	}
+
+	// the job is over whatever the state of the queue's contexts
+	result := q.db.WithContext(context.Background()).Model(&JobModel{}).
+		Where("id = ? AND attempts = ?", job.ID, job.Attempts).
+		Updates(updates)
+	if result.Error != nil {
+		logger.Error("Error recording job outcome", zap.String("outcome", outcome), zap.Error(result.Error))
+	}
+
+	return outcome
+}
+
+// delay is the exponential back-off after attempt failed, plus up to a fifth
+// of jitter so jobs that failed together don't retry together.
+func (q *Queue) delay(attempt int) time.Duration {
+	d := q.maxBackoff
+	if attempt <= 32 {
+		if b := q.backoff << (attempt - 1); b > 0 && b < d {
+			d = b
+		}
+	}
+	return d + time.Duration(rand.Int63n(int64(d)/5+1))
+}
+
+// maintain publishes the size of the queue, deletes old finished jobs and
+// schedules the next run of the periodic ones.
+func (q *Queue) maintain(ctx context.Context) {
+	q.schedule(ctx)
+
+	var counts []struct {
+		Kind   string
+		Status string
+		Count  int
+	}
+	err := q.db.WithContext(ctx).Model(&JobModel{}).
+		Select("kind, status, count(*) AS count").
+		Where("status IN ?", []string{StatusPending, StatusRunning, StatusDead}).
+		Group("kind, status").
+		Scan(&counts).Error
+	if err != nil {
+		if ctx.Err() == nil {
+			q.logger.Error("Error counting jobs", zap.Error(err))
+		}
+		return
+	}
+
+	metrics.ResetJobCounts()
+	for _, c := range counts {
+		metrics.SetJobCount(c.Kind, c.Status, c.Count)
+	}
+
+	result := q.db.WithContext(ctx).
+		Where("status = ? AND finished_at < ?", StatusDone, q.now().Add(-q.retention)).
+		Delete(&JobModel{})
+	if result.Error != nil {
+		q.logger.Error("Error deleting finished jobs", zap.Error(result.Error))
+		return
+	}
+	if result.RowsAffected > 0 {
+		q.logger.Debug("Deleted finished jobs", zap.Int64("count", result.RowsAffected))
+	}
+}
+
+// schedule enqueues the next run of the periodic jobs that have none pending
+// or running, an interval from now.
+func (q *Queue) schedule(ctx context.Context) {
+	q.mu.RLock()
+	periodic := make(map[string]time.Duration, len(q.periodic))
+	for kind, interval := range q.periodic {
+		periodic[kind] = interval
+	}
+	q.mu.RUnlock()
+
+	for kind, interval := range periodic {
+		_, err := q.Enqueue(ctx, kind, struct{}{}, Options{RunAt: q.now().Add(interval), UniqueKey: kind})
+		if err != nil && ctx.Err() == nil {
+			q.logger.Error("Error scheduling job", zap.String("kind", kind), zap.Error(err))
+		}
+	}
+}
@@ -0,0 +1,575 @@
+package keyring
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/jwtauth/v5"
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/lestrrat-go/jwx/v2/jws"
+	"github.com/lestrrat-go/jwx/v2/jwt"
+	"go.uber.org/zap"
+)
+
+const (
+	RS256 = "RS256"
+	ES256 = "ES256"
+	EdDSA = "EdDSA"
+)
+
+var ErrNoKeys = errors.New("no signing keys")
+
+// key is a private signing key and its published public half.
+type key struct {
+	id      string
+	private jwk.Key
+	public  jwk.Key
+	created time.Time
+	// file is where the key was loaded from, empty for the environment key
+	file string
+}
+
+// Keyring signs tokens with the newest of several keys and verifies them with
+// any key that hasn't expired yet, so keys can be rotated without logging
+// anybody out. Every token carries the id of its key in the kid header, and
+// the public keys are published as a JWKS.
+type Keyring struct {
+	logger       *zap.Logger
+	dir          string
+	algorithm    string
+	rotation     time.Duration
+	retain       time.Duration
+	publishAhead time.Duration
+	reload       time.Duration
+	env          *key
+	now          func() time.Time
+
+	mu   sync.RWMutex
+	keys []*key
+}
+
+type Params struct {
+	Logger *zap.Logger
+	// Key is a key from the environment, used besides the keys in Dir
+	Key crypto.Signer
+	// Dir holds private keys as PEM files named <kid>.pem, the newest file is
+	// the signing key. Generated keys are written there.
+	Dir string
+	// Algorithm of generated keys: RS256 (the default), ES256 or EdDSA. Loaded
+	// keys use the algorithm that matches their type.
+	Algorithm string
+	// RotationInterval is how old the signing key gets before a new one is
+	// generated, 0 never rotates. Rotation needs a Dir.
+	RotationInterval time.Duration
+	// Retain is how long a replaced key keeps verifying, the longest lifetime
+	// of a token.
+	Retain time.Duration
+	// PublishAhead is how long a new key is published before tokens are signed
+	// with it, so other instances and JWKS caches know it by then. Defaults to
+	// twice the Reload interval.
+	PublishAhead time.Duration
+	// Reload is how often Run re-reads Dir and checks for rotation, defaults
+	// to a minute.
+	Reload time.Duration
+}
+
+// New loads the keys and generates the first one when rotation is on and
+// there are none yet.
+func New(p Params) (*Keyring, error) {
+	if p.Algorithm == "" {
+		p.Algorithm = RS256
+	}
+	if p.Reload <= 0 {
+		p.Reload = time.Minute
+	}
+	if p.PublishAhead == 0 {
+		p.PublishAhead = 2 * p.Reload
+	}
+	if p.RotationInterval > 0 && p.Dir == "" {
+		return nil, errors.New("key rotation needs a key directory")
+	}
+	switch p.Algorithm {
+	case RS256, ES256, EdDSA:
+	default:
+		return nil, fmt.Errorf("unsupported algorithm %q, use RS256, ES256 or EdDSA", p.Algorithm)
+	}
+
+	k := &Keyring{
+		logger:       p.Logger,
+		dir:          p.Dir,
+		algorithm:    p.Algorithm,
+		rotation:     p.RotationInterval,
+		retain:       p.Retain,
+		publishAhead: p.PublishAhead,
+		reload:       p.Reload,
+		now:          time.Now,
+	}
+
+	if p.Key != nil {
+		env, err := newKey("", p.Key, time.Time{})
+		if err != nil {
+			return nil, fmt.Errorf("environment key: %w", err)
+		}
+		k.env = env
+	}
+
+	if err := k.Load(); err != nil {
+		return nil, err
+	}
+
+	if err := k.rotateIfDue(); err != nil {
+		return nil, err
+	}
+
+	if len(k.keys) == 0 {
+		return nil, ErrNoKeys
+	}
+
+	return k, nil
+}
+
+// newKey wraps private, its id is the RFC 7638 thumbprint unless given.
+func newKey(id string, private crypto.Signer, created time.Time) (*key, error) {
+	alg, err := algorithmOf(private)
+	if err != nil {
+		return nil, err
+	}
+
+	priv, err := jwk.FromRaw(private)
+	if err != nil {
+		return nil, err
+	}
+
+	if id == "" {
+		thumbprint, err := priv.Thumbprint(crypto.SHA256)
+		if err != nil {
+			return nil, err
+		}
+		id = base64.RawURLEncoding.EncodeToString(thumbprint)
+	}
+
+	pub, err := jwk.PublicKeyOf(priv)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, jk := range []jwk.Key{priv, pub} {
+		if err := jk.Set(jwk.KeyIDKey, id); err != nil {
+			return nil, err
+		}
+		if err := jk.Set(jwk.AlgorithmKey, alg); err != nil {
+			return nil, err
+		}
+	}
+	if err := pub.Set(jwk.KeyUsageKey, jwk.ForSignature); err != nil {
+		return nil, err
+	}
+
+	return &key{id: id, private: priv, public: pub, created: created}, nil
+}
+
+func algorithmOf(private crypto.Signer) (jwa.SignatureAlgorithm, error) {
+	switch k := private.(type) {
+	case *rsa.PrivateKey:
+		return jwa.RS256, nil
+	case *ecdsa.PrivateKey:
+		switch k.Curve {
+		case elliptic.P256():
+			return jwa.ES256, nil
+		case elliptic.P384():
+			return jwa.ES384, nil
+		case elliptic.P521():
+			return jwa.ES512, nil
+		}
+	case ed25519.PrivateKey:
+		return jwa.EdDSA, nil
+	}
+	return "", fmt.Errorf("unsupported key type %T", private)
+}
+
+func generate(algorithm string) (crypto.Signer, error) {
+	switch algorithm {
+	case RS256:
+		return rsa.GenerateKey(rand.Reader, 2048)
+	case ES256:
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case EdDSA:
+		_, private, err := ed25519.GenerateKey(rand.Reader)
+		return private, err
+	}
+	return nil, fmt.Errorf("unsupported algorithm %q", algorithm)
+}
+
+// Load re-reads the key directory.
+func (k *Keyring) Load() error {
+	var keys []*key
+	if k.env != nil {
+		keys = append(keys, k.env)
+	}
+
+	if k.dir != "" {
+		files, err := filepath.Glob(filepath.Join(k.dir, "*.pem"))
+		if err != nil {
+			return err
+		}
+
+		for _, file := range files {
+			loaded, err := loadKey(file)
+			if errors.Is(err, os.ErrNotExist) {
+				// pruned by another instance
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("loading %s: %w", file, err)
+			}
+			keys = append(keys, loaded)
+		}
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].created.Equal(keys[j].created) {
+			return keys[i].id < keys[j].id
+		}
+		return keys[i].created.Before(keys[j].created)
+	})
+
+	k.mu.Lock()
+	k.keys = keys
+	k.mu.Unlock()
+
+	return nil
+}
+
+func loadKey(file string) (*key, error) {
+	info, err := os.Stat(file)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, errors.New("no PEM data")
+	}
+
+	var private interface{}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		private, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		private, err = x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		private, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	signer, ok := private.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported key type %T", private)
+	}
+
+	id := strings.TrimSuffix(filepath.Base(file), ".pem")
+	loaded, err := newKey(id, signer, info.ModTime())
+	if err != nil {
+		return nil, err
+	}
+	loaded.file = file
+
+	return loaded, nil
+}
+
+// Rotate generates a new key and writes it to the key directory. It is
+// published straight away and signs tokens once PublishAhead has passed.
+func (k *Keyring) Rotate() error {
+	if k.dir == "" {
+		return errors.New("key rotation needs a key directory")
+	}
+
+	private, err := generate(k.algorithm)
+	if err != nil {
+		return err
+	}
+
+	created := k.now()
+	generated, err := newKey("", private, created)
+	if err != nil {
+		return err
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(k.dir, 0o700); err != nil {
+		return err
+	}
+
+	// write to a temporary name first so other instances never load half a key
+	file := filepath.Join(k.dir, generated.id+".pem")
+	tmp := file + ".tmp"
+	if err := os.WriteFile(tmp, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+		return err
+	}
+	if err := os.Chtimes(tmp, created, created); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, file); err != nil {
+		return err
+	}
+	generated.file = file
+
+	k.mu.Lock()
+	k.keys = append(k.keys, generated)
+	k.mu.Unlock()
+
+	if k.logger != nil {
+		k.logger.Info("Generated signing key", zap.String("kid", generated.id), zap.String("alg", k.algorithm))
+	}
+
+	return nil
+}
+
+// rotateIfDue generates a key when the newest one is older than the rotation
+// interval, then drops keys that no longer verify any token.
+func (k *Keyring) rotateIfDue() error {
+	if k.rotation > 0 {
+		k.mu.RLock()
+		due := len(k.keys) == 0 || !k.now().Before(k.keys[len(k.keys)-1].created.Add(k.rotation))
+		k.mu.RUnlock()
+
+		if due {
+			if err := k.Rotate(); err != nil {
+				return err
+			}
+		}
+	}
+
+	k.prune()
+
+	return nil
+}
+
+// signer returns the index of the signing key: the newest key published for
+// at least PublishAhead, or the oldest key when they are all new.
+func (k *Keyring) signer() int {
+	cutoff := k.now().Add(-k.publishAhead)
+	for i := len(k.keys) - 1; i >= 0; i-- {
+		if !k.keys[i].created.After(cutoff) {
+			return i
+		}
+	}
+	return 0
+}
+
+// prune drops keys that were replaced longer than Retain ago, deleting their
+// files.
+func (k *Keyring) prune() {
+	if k.retain <= 0 {
+		return
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if len(k.keys) == 0 {
+		return
+	}
+
+	signer := k.signer()
+	now := k.now()
+
+	var kept []*key
+	for i, kk := range k.keys {
+		// a key stops signing when the next one starts
+		if i < signer && now.After(k.keys[i+1].created.Add(k.publishAhead).Add(k.retain)) {
+			if kk == k.env {
+				k.env = nil
+			}
+			if kk.file != "" {
+				if err := os.Remove(kk.file); err != nil && !errors.Is(err, os.ErrNotExist) && k.logger != nil {
+					k.logger.Error("Error removing expired signing key", zap.String("kid", kk.id), zap.Error(err))
+				}
+			}
+			if k.logger != nil {
+				k.logger.Info("Removed expired signing key", zap.String("kid", kk.id))
+			}
+			continue
+		}
+		kept = append(kept, kk)
+	}
+	k.keys = kept
+}
+
+// Run reloads the key directory and rotates keys until ctx is done.
+func (k *Keyring) Run(ctx context.Context) {
+	ticker := time.NewTicker(k.reload)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if err := k.Load(); err != nil && k.logger != nil {
+			k.logger.Error("Error loading signing keys", zap.Error(err))
+			continue
+		}
+		if err := k.rotateIfDue(); err != nil && k.logger != nil {
+			k.logger.Error("Error rotating signing keys", zap.Error(err))
+		}
+	}
+}
+
+// Encode signs claims with the current signing key, the same as
+// jwtauth.JWTAuth.Encode.
+func (k *Keyring) Encode(claims map[string]interface{}) (jwt.Token, string, error) {
+	k.mu.RLock()
+	if len(k.keys) == 0 {
+		k.mu.RUnlock()
+		return nil, "", ErrNoKeys
+	}
+	signing := k.keys[k.signer()]
+	k.mu.RUnlock()
+
+	t := jwt.New()
+	for name, v := range claims {
+		if err := t.Set(name, v); err != nil {
+			return nil, "", err
+		}
+	}
+
+	payload, err := jwt.Sign(t, jwt.WithKey(signing.private.Algorithm(), signing.private))
+	if err != nil {
+		return nil, "", err
+	}
+
+	return t, string(payload), nil
+}
+
+// Decode verifies the signature of a token with the key named in its kid
+// header. Tokens without a kid, signed before keys had ids, are tried against
+// every key. Claims are validated by Verify.
+func (k *Keyring) Decode(tokenString string) (jwt.Token, error) {
+	return jwt.Parse([]byte(tokenString),
+		jwt.WithKeySet(k.publicSet(), jws.WithRequireKid(false)),
+		jwt.WithValidate(false),
+	)
+}
+
+// Algorithms lists the algorithms of the keys in the ring.
+func (k *Keyring) Algorithms() []string {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	seen := map[string]bool{}
+	var algs []string
+	for _, kk := range k.keys {
+		alg := kk.public.Algorithm().String()
+		if !seen[alg] {
+			seen[alg] = true
+			algs = append(algs, alg)
+		}
+	}
+	return algs
+}
+
+func (k *Keyring) publicSet() jwk.Set {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	set := jwk.NewSet()
+	for _, kk := range k.keys {
+		set.AddKey(kk.public)
+	}
+	return set
+}
+
+// Verify is jwtauth.Verify for the keyring: it puts the verified token, or the
+// reason it isn't valid, in the request context for jwtauth.FromContext.
+func (k *Keyring) Verify(findTokenFns ...func(r *http.Request) string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token, err := k.verifyRequest(r, findTokenFns)
+			next.ServeHTTP(w, r.WithContext(jwtauth.NewContext(r.Context(), token, err)))
+		})
+	}
+}
+
+func (k *Keyring) verifyRequest(r *http.Request, findTokenFns []func(r *http.Request) string) (jwt.Token, error) {
+	var tokenString string
+	for _, fn := range findTokenFns {
+		if tokenString = fn(r); tokenString != "" {
+			break
+		}
+	}
+	if tokenString == "" {
+		return nil, jwtauth.ErrNoTokenFound
+	}
+
+	token, err := k.Decode(tokenString)
+	if err != nil {
+		return token, jwtauth.ErrorReason(err)
+	}
+	if err := jwt.Validate(token); err != nil {
+		return token, jwtauth.ErrorReason(err)
+	}
+
+	return token, nil
+}
+
+// JWKS is a JSON Web Key Set.
+type JWKS struct {
+	Keys []json.RawMessage `json:"keys"`
+}
+
+// ServeHTTP publishes the public keys. Caches may keep them for PublishAhead,
+// new keys are published that long before they're used.
+func (k *Keyring) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	k.mu.RLock()
+	set := JWKS{Keys: []json.RawMessage{}}
+	for _, kk := range k.keys {
+		b, err := json.Marshal(kk.public)
+		if err != nil {
+			k.mu.RUnlock()
+			http.Error(w, fmt.Sprintf("encoding key %s: %v", kk.id, err), http.StatusInternalServerError)
+			return
+		}
+		set.Keys = append(set.Keys, b)
+	}
+	k.mu.RUnlock()
+
+	w.Header().Set("Content-Type", "application/jwk-set+json")
+	w.Header().Set("Cache-Control", "public, max-age="+strconv.Itoa(int(k.publishAhead.Seconds())))
+	json.NewEncoder(w).Encode(set)
+}
@@ -0,0 +1,226 @@
+//go:build unit
+// +build unit
+
+package keyring
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-chi/jwtauth/v5"
+	"github.com/lestrrat-go/jwx/v2/jws"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func kid(t *testing.T, token string) string {
+	msg, err := jws.Parse([]byte(token))
+	if !assert.NoError(t, err) {
+		return ""
+	}
+	return msg.Signatures()[0].ProtectedHeaders().KeyID()
+}
+
+func TestKeyringAlgorithms(t *testing.T) {
+	for _, alg := range []string{RS256, ES256, EdDSA} {
+		t.Run(alg, func(t *testing.T) {
+			k, err := New(Params{
+				Logger:           zap.NewNop(),
+				Dir:              t.TempDir(),
+				Algorithm:        alg,
+				RotationInterval: time.Hour,
+			})
+			if !assert.NoError(t, err) {
+				return
+			}
+
+			_, token, err := k.Encode(map[string]interface{}{"id": "user-1"})
+			assert.NoError(t, err)
+			assert.Equal(t, k.keys[0].id, kid(t, token))
+
+			decoded, err := k.Decode(token)
+			if assert.NoError(t, err) {
+				id, _ := decoded.Get("id")
+				assert.Equal(t, "user-1", id)
+			}
+		})
+	}
+}
+
+func TestKeyringRotation(t *testing.T) {
+	assert := assert.New(t)
+
+	dir := t.TempDir()
+	now := time.Now().Add(-time.Hour).Truncate(time.Second)
+
+	k, err := New(Params{
+		Logger:       zap.NewNop(),
+		Dir:          dir,
+		Retain:       24 * time.Hour,
+		PublishAhead: 10 * time.Minute,
+		Key:          mustRSA(t),
+	})
+	assert.NoError(err)
+
+	k.now = func() time.Time { return now }
+	k.rotation = 30 * 24 * time.Hour
+
+	_, oldToken, err := k.Encode(map[string]interface{}{"id": "user-1"})
+	assert.NoError(err)
+	oldKid := kid(t, oldToken)
+
+	// the environment key is older than any interval, a new key is generated
+	assert.NoError(k.rotateIfDue())
+	assert.Len(k.keys, 2)
+	files, _ := filepath.Glob(filepath.Join(dir, "*.pem"))
+	assert.Len(files, 1)
+
+	// the new key is published but doesn't sign yet
+	_, token, err := k.Encode(map[string]interface{}{"id": "user-1"})
+	assert.NoError(err)
+	assert.Equal(oldKid, kid(t, token))
+
+	now = now.Add(11 * time.Minute)
+	_, newToken, err := k.Encode(map[string]interface{}{"id": "user-1"})
+	assert.NoError(err)
+	assert.NotEqual(oldKid, kid(t, newToken))
+
+	// reloading from disk keeps the order and the signing key
+	assert.NoError(k.Load())
+	_, token, err = k.Encode(map[string]interface{}{"id": "user-1"})
+	assert.NoError(err)
+	assert.Equal(kid(t, newToken), kid(t, token))
+
+	// tokens of the replaced key verify until they would have expired
+	now = now.Add(23 * time.Hour)
+	assert.NoError(k.rotateIfDue())
+	_, err = k.Decode(oldToken)
+	assert.NoError(err)
+
+	now = now.Add(2 * time.Hour)
+	assert.NoError(k.rotateIfDue())
+	assert.NoError(k.Load())
+	assert.Len(k.keys, 1)
+	_, err = k.Decode(oldToken)
+	assert.Error(err)
+	_, err = k.Decode(newToken)
+	assert.NoError(err)
+}
+
+func TestKeyringLoadsDirectory(t *testing.T) {
+	dir := t.TempDir()
+
+	generator, err := New(Params{Dir: dir, Algorithm: ES256, RotationInterval: time.Hour})
+	assert.NoError(t, err)
+	_, token, err := generator.Encode(map[string]interface{}{"id": "user-1"})
+	assert.NoError(t, err)
+
+	// another instance sharing the directory verifies the token
+	other, err := New(Params{Dir: dir})
+	assert.NoError(t, err)
+	_, err = other.Decode(token)
+	assert.NoError(t, err)
+
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "broken.pem"), []byte("not a key"), 0o600))
+	assert.Error(t, other.Load())
+
+	_, err = New(Params{Dir: t.TempDir()})
+	assert.ErrorIs(t, err, ErrNoKeys)
+
+	_, err = New(Params{RotationInterval: time.Hour})
+	assert.Error(t, err, "rotation without a directory loses keys on restart")
+}
+
+func TestKeyringVerify(t *testing.T) {
+	private := mustRSA(t)
+	k, err := New(Params{Key: private})
+	assert.NoError(t, err)
+
+	// tokens signed before keys had ids still verify
+	legacy := jwtauth.New("RS256", private, &private.PublicKey)
+	_, legacyToken, err := legacy.Encode(map[string]interface{}{"id": "user-1"})
+	assert.NoError(t, err)
+
+	_, expiredToken, err := k.Encode(map[string]interface{}{"id": "user-1", "exp": time.Now().Add(-time.Minute).Unix()})
+	assert.NoError(t, err)
+
+	other, err := New(Params{Key: mustRSA(t)})
+	assert.NoError(t, err)
+	_, foreignToken, err := other.Encode(map[string]interface{}{"id": "user-1"})
+	assert.NoError(t, err)
+
+	tests := []struct {
+		description string
+		token       string
+		expectedErr error
+	}{
+		{"no token", "", jwtauth.ErrNoTokenFound},
+		{"token without kid", legacyToken, nil},
+		{"expired token", expiredToken, jwtauth.ErrExpired},
+		{"token from another keyring", foreignToken, jwtauth.ErrUnauthorized},
+		{"garbage", "not.a.token", jwtauth.ErrUnauthorized},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			var gotErr error
+			var gotID interface{}
+
+			handler := k.Verify(func(r *http.Request) string { return r.Header.Get("X-Token") })(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				_, claims, err := jwtauth.FromContext(r.Context())
+				gotErr, gotID = err, claims["id"]
+			}))
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("X-Token", tc.token)
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+
+			assert.Equal(t, tc.expectedErr, gotErr)
+			if tc.expectedErr == nil {
+				assert.Equal(t, "user-1", gotID)
+			}
+		})
+	}
+}
+
+func TestKeyringJWKS(t *testing.T) {
+	assert := assert.New(t)
+
+	k, err := New(Params{Dir: t.TempDir(), Algorithm: EdDSA, RotationInterval: time.Hour, Key: mustRSA(t)})
+	assert.NoError(err)
+
+	w := httptest.NewRecorder()
+	k.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/.well-known/jwks.json", nil))
+
+	assert.Equal("application/jwk-set+json", w.Header().Get("Content-Type"))
+
+	var set struct {
+		Keys []map[string]interface{} `json:"keys"`
+	}
+	assert.NoError(json.Unmarshal(w.Body.Bytes(), &set))
+
+	if assert.Len(set.Keys, 2) {
+		assert.Equal("RS256", set.Keys[0]["alg"])
+		assert.Equal("EdDSA", set.Keys[1]["alg"])
+		for _, key := range set.Keys {
+			assert.NotEmpty(key["kid"])
+			assert.Equal("sig", key["use"])
+			assert.NotContains(key, "d", "private keys are never published")
+		}
+	}
+}
+
+func mustRSA(t *testing.T) *rsa.PrivateKey {
+	private, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return private
+}
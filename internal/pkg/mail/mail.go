@@ -0,0 +1,97 @@
+package mail
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	netmail "net/mail"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// ErrNotConfigured is returned by senders that can't deliver mail.
+var ErrNotConfigured = errors.New("no mail server configured")
+
+// Message is a plain text email to a single recipient.
+type Message struct {
+	To      string
+	Subject string
+	Text    string
+}
+
+// Sender delivers messages.
+type Sender interface {
+	Send(ctx context.Context, m Message) error
+}
+
+// Bytes encodes m as an RFC 5322 message from the address from, with the
+// body in quoted-printable UTF-8.
+func (m Message) Bytes(from string, date time.Time) ([]byte, error) {
+	fromAddr, err := netmail.ParseAddress(from)
+	if err != nil {
+		return nil, fmt.Errorf("parsing from address: %w", err)
+	}
+	toAddr, err := netmail.ParseAddress(m.To)
+	if err != nil {
+		return nil, fmt.Errorf("parsing to address: %w", err)
+	}
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	_, domain, _ := strings.Cut(fromAddr.Address, "@")
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", fromAddr.String())
+	fmt.Fprintf(&b, "To: %s\r\n", toAddr.String())
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", m.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", date.Format(time.RFC1123Z))
+	fmt.Fprintf(&b, "Message-ID: <%s@%s>\r\n", hex.EncodeToString(id), domain)
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+
+	qp := quotedprintable.NewWriter(&b)
+	if _, err := qp.Write([]byte(strings.ReplaceAll(m.Text, "\n", "\r\n"))); err != nil {
+		return nil, err
+	}
+	if err := qp.Close(); err != nil {
+		return nil, err
+	}
+	b.WriteString("\r\n")
+
+	return b.Bytes(), nil
+}
+
+// envelope returns the bare address of an address with an optional name,
+// for the SMTP envelope.
+func envelope(address string) (string, error) {
+	a, err := netmail.ParseAddress(address)
+	if err != nil {
+		return "", fmt.Errorf("parsing address: %w", err)
+	}
+	return a.Address, nil
+}
+
+// LogSender writes messages to the log instead of sending them, for
+// development without a mail server.
+type LogSender struct {
+	logger *zap.Logger
+}
+
+func NewLogSender(logger *zap.Logger) *LogSender {
+	return &LogSender{logger: logger}
+}
+
+func (s *LogSender) Send(ctx context.Context, m Message) error {
+	s.logger.Info("Email", zap.String("to", m.To), zap.String("subject", m.Subject), zap.String("text", m.Text))
+	return nil
+}
@@ -0,0 +1,115 @@
+//go:build unit
+// +build unit
+
+package mail
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMessageBytes(t *testing.T) {
+	m := Message{To: "jane@example.com", Subject: "Connexion à Goth\r\nBcc: evil@example.com", Text: "Bonjour,\n\nVoilà votre lien."}
+
+	b, err := m.Bytes("Goth <noreply@example.com>", time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := string(b)
+	assert.Contains(t, s, "From: \"Goth\" <noreply@example.com>\r\n")
+	assert.Contains(t, s, "To: <jane@example.com>\r\n")
+	assert.Contains(t, s, "Subject: =?utf-8?q?")
+	assert.NotContains(t, s, "\r\nBcc:")
+	assert.Contains(t, s, "Date: Tue, 02 Jan 2024 03:04:05 +0000\r\n")
+	assert.Contains(t, s, "@example.com>\r\n")
+	assert.Contains(t, s, "Bonjour,\r\n\r\nVoil=C3=A0 votre lien.")
+
+	_, err = Message{To: "not an address"}.Bytes("noreply@example.com", time.Now())
+	assert.Error(t, err)
+}
+
+// fakeSMTP accepts a single message and sends what it received on the channel.
+func fakeSMTP(t *testing.T) (int, <-chan string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	received := make(chan string, 1)
+
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		r := bufio.NewReader(conn)
+		reply := func(s string) { conn.Write([]byte(s + "\r\n")) }
+
+		var transcript strings.Builder
+		reply("220 localhost ESMTP")
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			transcript.WriteString(line)
+
+			switch cmd := strings.ToUpper(strings.TrimSpace(line)); {
+			case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+				reply("250 localhost")
+			case cmd == "DATA":
+				reply("354 go ahead")
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					if line == ".\r\n" {
+						break
+					}
+					transcript.WriteString(line)
+				}
+				reply("250 queued")
+			case cmd == "QUIT":
+				reply("221 bye")
+				received <- transcript.String()
+				return
+			default:
+				reply("250 ok")
+			}
+		}
+	}()
+
+	return l.Addr().(*net.TCPAddr).Port, received
+}
+
+func TestSMTP(t *testing.T) {
+	port, received := fakeSMTP(t)
+
+	s := NewSMTP(SMTPParams{Host: "127.0.0.1", Port: port, From: "Goth <noreply@example.com>"})
+
+	err := s.Send(context.Background(), Message{To: "Jane <jane@example.com>", Subject: "Hello", Text: "Hi Jane"})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	select {
+	case transcript := <-received:
+		assert.Contains(t, transcript, "MAIL FROM:<noreply@example.com>")
+		assert.Contains(t, transcript, "RCPT TO:<jane@example.com>")
+		assert.Contains(t, transcript, "Subject: Hello\r\n")
+		assert.Contains(t, transcript, "Hi Jane")
+	case <-time.After(5 * time.Second):
+		t.Fatal("no message received")
+	}
+}
@@ -0,0 +1,44 @@
+package mail
+
+import (
+	"context"
+	"errors"
+
+	"github.com/tomdoestech/goth/internal/pkg/jobs"
+)
+
+// JobKind is the kind of the jobs that send messages.
+const JobKind = "mail.send"
+
+// QueueSender hands messages to the job queue, so requests don't wait for the
+// mail server and messages it refuses are sent again later.
+type QueueSender struct {
+	queue *jobs.Queue
+}
+
+type QueueSenderParams struct {
+	Queue *jobs.Queue
+	// Sender delivers the messages, from the queue's workers
+	Sender Sender
+}
+
+// NewQueueSender registers the job sending the messages, so it must be called
+// before the queue runs.
+func NewQueueSender(p QueueSenderParams) *QueueSender {
+	jobs.Register(p.Queue, JobKind, func(ctx context.Context, m Message) error {
+		err := p.Sender.Send(ctx, m)
+		if errors.Is(err, ErrNotConfigured) {
+			return jobs.Permanent(err)
+		}
+		return err
+	})
+
+	return &QueueSender{queue: p.Queue}
+}
+
+// Send enqueues m. It only fails when the queue does, delivery errors are
+// logged by the queue.
+func (s *QueueSender) Send(ctx context.Context, m Message) error {
+	_, err := s.queue.Enqueue(ctx, JobKind, m, jobs.Options{})
+	return err
+}
@@ -0,0 +1,76 @@
+package mail
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strconv"
+	"time"
+)
+
+// SMTP sends messages through a mail server, upgrading the connection with
+// STARTTLS when the server offers it.
+type SMTP struct {
+	addr string
+	auth smtp.Auth
+	from string
+	now  func() time.Time
+}
+
+type SMTPParams struct {
+	Host string
+	// Port defaults to 587
+	Port int
+	// Username and Password log in to the server, leave them empty for
+	// servers that don't need it
+	Username string
+	Password string
+	// From is the sender of every message, such as "Goth <noreply@example.com>"
+	From string
+}
+
+func NewSMTP(p SMTPParams) *SMTP {
+	if p.Port == 0 {
+		p.Port = 587
+	}
+
+	var auth smtp.Auth
+	if p.Username != "" {
+		auth = smtp.PlainAuth("", p.Username, p.Password, p.Host)
+	}
+
+	return &SMTP{
+		addr: net.JoinHostPort(p.Host, strconv.Itoa(p.Port)),
+		auth: auth,
+		from: p.From,
+		now:  time.Now,
+	}
+}
+
+// Send delivers m. The context is only checked before connecting, net/smtp
+// has no way to cancel a conversation.
+func (s *SMTP) Send(ctx context.Context, m Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	msg, err := m.Bytes(s.from, s.now())
+	if err != nil {
+		return err
+	}
+
+	from, err := envelope(s.from)
+	if err != nil {
+		return err
+	}
+	to, err := envelope(m.To)
+	if err != nil {
+		return err
+	}
+
+	if err := smtp.SendMail(s.addr, s.auth, from, []string{to}, msg); err != nil {
+		return fmt.Errorf("sending mail to %s: %w", s.addr, err)
+	}
+	return nil
+}
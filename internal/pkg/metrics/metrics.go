@@ -19,6 +19,13 @@ const (
 	latencyName        = "request_duration_milliseconds"
 	patternReqsName    = "pattern_requests_total"
 	patternLatencyName = "pattern_request_duration_milliseconds"
+	panicsName         = "panics_total"
+	wsConnectionsName  = "websocket_connections"
+	wsRoomsName        = "websocket_rooms"
+	jobsName           = "jobs"
+	jobsRunName        = "jobs_run_total"
+	jobWaitName        = "job_wait_milliseconds"
+	jobDurationName    = "job_duration_milliseconds"
 )
 
 // Middleware is a handler that exposes prometheus metrics for the number of requests,
@@ -69,6 +76,96 @@ func (c Middleware) patternHandler(next http.Handler) http.Handler {
 	return http.HandlerFunc(fn)
 }
 
+var panics = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: panicsName,
+		Help: "How many panics were recovered while serving HTTP requests, partitioned by HTTP path (with patterns).",
+	},
+	[]string{"path"},
+)
+
+var wsConnections = prometheus.NewGauge(
+	prometheus.GaugeOpts{
+		Name: wsConnectionsName,
+		Help: "How many WebSocket connections are open.",
+	},
+)
+
+var wsRooms = prometheus.NewGauge(
+	prometheus.GaugeOpts{
+		Name: wsRoomsName,
+		Help: "How many WebSocket rooms have at least one member.",
+	},
+)
+
+var jobs = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: jobsName,
+		Help: "How many jobs are in the queue, partitioned by kind and status (pending, running or dead).",
+	},
+	[]string{"kind", "status"},
+)
+
+var jobsRun = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: jobsRunName,
+		Help: "How many jobs were run, partitioned by kind and outcome (done, retry, dead or interrupted).",
+	},
+	[]string{"kind", "outcome"},
+)
+
+var jobWait = prometheus.NewHistogramVec(
+	prometheus.HistogramOpts{
+		Name:    jobWaitName,
+		Help:    "How long jobs waited past their run time before starting, partitioned by kind.",
+		Buckets: prometheus.ExponentialBuckets(10, 4, 8),
+	},
+	[]string{"kind"},
+)
+
+var jobDuration = prometheus.NewHistogramVec(
+	prometheus.HistogramOpts{
+		Name:    jobDurationName,
+		Help:    "How long jobs ran, partitioned by kind and outcome.",
+		Buckets: prometheus.ExponentialBuckets(10, 4, 8),
+	},
+	[]string{"kind", "outcome"},
+)
+
+func init() {
+	prometheus.MustRegister(panics, wsConnections, wsRooms, jobs, jobsRun, jobWait, jobDuration)
+}
+
+// RecordPanic counts a panic recovered while serving the route pattern path.
+func RecordPanic(path string) {
+	panics.WithLabelValues(path).Inc()
+}
+
+// SetWebSocketCounts records the number of open WebSocket connections and occupied rooms.
+func SetWebSocketCounts(connections, rooms int) {
+	wsConnections.Set(float64(connections))
+	wsRooms.Set(float64(rooms))
+}
+
+// ResetJobCounts forgets the queue sizes, before SetJobCount sets the current
+// ones.
+func ResetJobCounts() {
+	jobs.Reset()
+}
+
+// SetJobCount records how many jobs of kind are in status.
+func SetJobCount(kind, status string, n int) {
+	jobs.WithLabelValues(kind, status).Set(float64(n))
+}
+
+// RecordJob counts a job that ran, how long it waited to start and how long
+// it ran.
+func RecordJob(kind, outcome string, wait, duration time.Duration) {
+	jobsRun.WithLabelValues(kind, outcome).Inc()
+	jobWait.WithLabelValues(kind).Observe(float64(wait.Nanoseconds()) / 1000000)
+	jobDuration.WithLabelValues(kind, outcome).Observe(float64(duration.Nanoseconds()) / 1000000)
+}
+
 func StartMetricsServer(logger *zap.Logger) {
 	http.Handle("/metrics", promhttp.Handler())
 	http.ListenAndServe(":9100", nil)
@@ -0,0 +1,399 @@
+package openapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Operation describes a single route.
+type Operation struct {
+	Summary     string
+	Description string
+	Tags        []string
+	// Request is a value of the request body type, e.g. loginData{}
+	Request interface{}
+	// RequestTypes are the accepted body content types, defaults to application/json
+	RequestTypes []string
+	Query        []Parameter
+	Responses    map[int]Response
+	// Errors lists the statuses that can be returned as problem details
+	Errors []int
+	// Auth marks routes that need a logged in user. Scopes are what an API key
+	// must be granted to call it, also on routes where logging in is optional.
+	Auth   bool
+	Scopes []string
+}
+
+type Response struct {
+	Description string
+	// Body is a value of the response body type, nil for empty bodies
+	Body interface{}
+	// ContentType defaults to application/json when there is a Body
+	ContentType string
+}
+
+type Parameter struct {
+	Name        string
+	Description string
+	Required    bool
+}
+
+// Document collects the operations registered through a Router and renders
+// them as an OpenAPI 3.1 document.
+type Document struct {
+	title       string
+	version     string
+	description string
+	problem     interface{}
+
+	mu         sync.Mutex
+	operations map[string]map[string]Operation
+}
+
+type Params struct {
+	Title       string
+	Version     string
+	Description string
+	// Problem is a value of the type error responses are encoded as
+	Problem interface{}
+}
+
+func New(p Params) *Document {
+	return &Document{
+		title:       p.Title,
+		version:     p.Version,
+		description: p.Description,
+		problem:     p.Problem,
+		operations:  map[string]map[string]Operation{},
+	}
+}
+
+// Add documents the route registered with the chi pattern for method.
+func (d *Document) Add(method, pattern string, op Operation) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	path := Path(pattern)
+	if d.operations[path] == nil {
+		d.operations[path] = map[string]Operation{}
+	}
+	d.operations[path][strings.ToUpper(method)] = op
+}
+
+// Documented reports whether the route registered with the chi pattern for
+// method has been documented.
+func (d *Document) Documented(method, pattern string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	_, ok := d.operations[Path(pattern)][strings.ToUpper(method)]
+	return ok
+}
+
+// Undocumented returns the routes of r, as "METHOD /pattern", that are missing
+// from d.
+func Undocumented(r chi.Routes, d *Document) ([]string, error) {
+	var missing []string
+
+	err := chi.Walk(r, func(method, route string, handler http.Handler, middlewares ...func(http.Handler) http.Handler) error {
+		if !d.Documented(method, route) {
+			missing = append(missing, method+" "+route)
+		}
+		return nil
+	})
+
+	sort.Strings(missing)
+
+	return missing, err
+}
+
+var paramPattern = regexp.MustCompile(`\{([^}:]+)(:[^}]*)?\}`)
+
+// Path converts a chi route pattern to an OpenAPI path: regexp constraints
+// are dropped and a trailing wildcard becomes the {path} parameter.
+func Path(pattern string) string {
+	path := paramPattern.ReplaceAllString(pattern, "{$1}")
+	if strings.HasSuffix(path, "/*") {
+		path = strings.TrimSuffix(path, "*") + "{path}"
+	}
+	return path
+}
+
+// Entry is a documented operation in a form templates can render.
+type Entry struct {
+	Method    string
+	Path      string
+	Operation Operation
+	Request   string
+	Responses []EntryResponse
+}
+
+type EntryResponse struct {
+	Status      int
+	Description string
+	ContentType string
+	Schema      string
+}
+
+// Entries lists every operation ordered by path and method, with schemas as
+// indented JSON.
+func (d *Document) Entries() []Entry {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	s := newSchemas()
+	var entries []Entry
+
+	for _, path := range sortedPaths(d.operations) {
+		for _, method := range sortedMethods(d.operations[path]) {
+			op := d.operations[path][method]
+
+			entry := Entry{Method: method, Path: path, Operation: op}
+			if op.Request != nil {
+				entry.Request = indent(s.of(op.Request))
+			}
+
+			for status, res := range op.Responses {
+				er := EntryResponse{Status: status, Description: res.Description, ContentType: contentType(res)}
+				if res.Body != nil {
+					er.Schema = indent(s.of(res.Body))
+				}
+				entry.Responses = append(entry.Responses, er)
+			}
+			for _, status := range op.Errors {
+				entry.Responses = append(entry.Responses, EntryResponse{
+					Status:      status,
+					Description: http.StatusText(status),
+					ContentType: "application/problem+json",
+				})
+			}
+			sort.Slice(entry.Responses, func(i, j int) bool {
+				return entry.Responses[i].Status < entry.Responses[j].Status
+			})
+
+			entries = append(entries, entry)
+		}
+	}
+
+	return entries
+}
+
+// Schemas returns every named schema referenced by the operations as
+// indented JSON, keyed by name.
+func (d *Document) Schemas() map[string]string {
+	spec := d.Spec()
+
+	out := map[string]string{}
+	components := spec["components"].(map[string]interface{})
+	for name, schema := range components["schemas"].(map[string]interface{}) {
+		out[name] = indent(schema)
+	}
+	return out
+}
+
+// Spec builds the OpenAPI document.
+func (d *Document) Spec() map[string]interface{} {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	s := newSchemas()
+
+	var problem map[string]interface{}
+	if d.problem != nil {
+		problem = s.of(d.problem)
+	}
+
+	paths := map[string]interface{}{}
+	for path, methods := range d.operations {
+		item := map[string]interface{}{}
+		for method, op := range methods {
+			item[strings.ToLower(method)] = d.operation(s, path, op, problem)
+		}
+		paths[path] = item
+	}
+
+	info := map[string]interface{}{
+		"title":   d.title,
+		"version": d.version,
+	}
+	if d.description != "" {
+		info["description"] = d.description
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.1.0",
+		"info":    info,
+		"paths":   paths,
+		"components": map[string]interface{}{
+			"schemas": s.components,
+			"securitySchemes": map[string]interface{}{
+				"cookie": map[string]interface{}{
+					"type": "apiKey",
+					"in":   "cookie",
+					"name": "token",
+				},
+				"bearer": map[string]interface{}{
+					"type":         "http",
+					"scheme":       "bearer",
+					"bearerFormat": "JWT",
+				},
+				"apiKey": map[string]interface{}{
+					"type":        "http",
+					"scheme":      "bearer",
+					"description": "A personal API key, limited to the scopes it was created with",
+				},
+			},
+		},
+	}
+}
+
+func (d *Document) operation(s *schemas, path string, op Operation, problem map[string]interface{}) map[string]interface{} {
+	out := map[string]interface{}{}
+
+	if op.Summary != "" {
+		out["summary"] = op.Summary
+	}
+	if op.Description != "" {
+		out["description"] = op.Description
+	}
+	if len(op.Tags) > 0 {
+		out["tags"] = op.Tags
+	}
+
+	var params []interface{}
+	for _, match := range paramPattern.FindAllStringSubmatch(path, -1) {
+		params = append(params, map[string]interface{}{
+			"name":     match[1],
+			"in":       "path",
+			"required": true,
+			"schema":   map[string]interface{}{"type": "string"},
+		})
+	}
+	for _, q := range op.Query {
+		param := map[string]interface{}{
+			"name":     q.Name,
+			"in":       "query",
+			"required": q.Required,
+			"schema":   map[string]interface{}{"type": "string"},
+		}
+		if q.Description != "" {
+			param["description"] = q.Description
+		}
+		params = append(params, param)
+	}
+	if len(params) > 0 {
+		out["parameters"] = params
+	}
+
+	if op.Request != nil {
+		types := op.RequestTypes
+		if len(types) == 0 {
+			types = []string{"application/json"}
+		}
+		schema := s.of(op.Request)
+		content := map[string]interface{}{}
+		for _, t := range types {
+			content[t] = map[string]interface{}{"schema": schema}
+		}
+		out["requestBody"] = map[string]interface{}{
+			"required": true,
+			"content":  content,
+		}
+	}
+
+	responses := map[string]interface{}{}
+	for status, res := range op.Responses {
+		r := map[string]interface{}{"description": res.Description}
+		if ct := contentType(res); ct != "" {
+			media := map[string]interface{}{}
+			if res.Body != nil {
+				media["schema"] = s.of(res.Body)
+			}
+			r["content"] = map[string]interface{}{ct: media}
+		}
+		responses[strconv.Itoa(status)] = r
+	}
+	for _, status := range op.Errors {
+		r := map[string]interface{}{"description": http.StatusText(status)}
+		if problem != nil {
+			r["content"] = map[string]interface{}{
+				"application/problem+json": map[string]interface{}{"schema": problem},
+			}
+		}
+		responses[strconv.Itoa(status)] = r
+	}
+	out["responses"] = responses
+
+	if op.Auth || len(op.Scopes) > 0 {
+		scopes := op.Scopes
+		if scopes == nil {
+			scopes = []string{}
+		}
+		security := []interface{}{
+			map[string]interface{}{"cookie": []string{}},
+			map[string]interface{}{"bearer": []string{}},
+			map[string]interface{}{"apiKey": scopes},
+		}
+		if !op.Auth {
+			// credentials are optional
+			security = append(security, map[string]interface{}{})
+		}
+		out["security"] = security
+	}
+
+	return out
+}
+
+// ServeHTTP serves the document as JSON.
+func (d *Document) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(d.Spec()); err != nil {
+		http.Error(w, fmt.Sprintf("encoding OpenAPI document: %v", err), http.StatusInternalServerError)
+	}
+}
+
+func contentType(res Response) string {
+	if res.ContentType != "" {
+		return res.ContentType
+	}
+	if res.Body != nil {
+		return "application/json"
+	}
+	return ""
+}
+
+func indent(v interface{}) string {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return ""
+	}
+	return string(b)
+}
+
+func sortedPaths(m map[string]map[string]Operation) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func sortedMethods(m map[string]Operation) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,63 @@
+//go:build unit
+// +build unit
+
+package openapi
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPath(t *testing.T) {
+	tests := []struct {
+		pattern string
+		want    string
+	}{
+		{"/", "/"},
+		{"/api/api-keys/{id}", "/api/api-keys/{id}"},
+		{"/users/{id:[0-9]+}/posts/{slug}", "/users/{id}/posts/{slug}"},
+		{"/static/*", "/static/{path}"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern, func(t *testing.T) {
+			assert.Equal(t, tt.want, Path(tt.pattern))
+		})
+	}
+}
+
+type item struct {
+	Name    string     `json:"name" validate:"required,min=1,max=64"`
+	Email   string     `json:"email,omitempty" validate:"omitempty,email"`
+	Count   int        `json:"count"`
+	Tags    []string   `json:"tags,omitempty"`
+	Expires *time.Time `json:"expires"`
+	secret  string
+}
+
+func TestSchema(t *testing.T) {
+	s := newSchemas()
+
+	ref := s.of(item{})
+	assert.Equal(t, "#/components/schemas/Item", ref["$ref"])
+
+	schema := s.components["Item"].(map[string]interface{})
+	props := schema["properties"].(map[string]interface{})
+
+	assert.Len(t, props, 5)
+	assert.Equal(t, []string{"name", "count", "expires"}, schema["required"])
+	assert.Equal(t, map[string]interface{}{"type": "string", "minLength": 1, "maxLength": 64}, props["name"])
+	assert.Equal(t, "email", props["email"].(map[string]interface{})["format"])
+	assert.Equal(t, "array", props["tags"].(map[string]interface{})["type"])
+	assert.Contains(t, props["expires"].(map[string]interface{}), "anyOf")
+}
+
+func TestUndocumented(t *testing.T) {
+	d := New(Params{Title: "test", Version: "1"})
+	d.Add("get", "/items/{id:[0-9]+}", Operation{Summary: "Get an item"})
+
+	assert.True(t, d.Documented("GET", "/items/{id:[0-9]+}"))
+	assert.False(t, d.Documented("DELETE", "/items/{id:[0-9]+}"))
+}
@@ -0,0 +1,43 @@
+package openapi
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Router registers routes on a chi router and documents them in the same
+// call, so a route can't be added without its description.
+type Router struct {
+	mux chi.Router
+	doc *Document
+}
+
+func NewRouter(mux chi.Router, doc *Document) *Router {
+	return &Router{mux: mux, doc: doc}
+}
+
+func (rt *Router) Method(method, pattern string, op Operation, h http.Handler) {
+	rt.doc.Add(method, pattern, op)
+	rt.mux.Method(method, pattern, h)
+}
+
+func (rt *Router) Get(pattern string, op Operation, h http.Handler) {
+	rt.Method(http.MethodGet, pattern, op, h)
+}
+
+func (rt *Router) Post(pattern string, op Operation, h http.Handler) {
+	rt.Method(http.MethodPost, pattern, op, h)
+}
+
+func (rt *Router) Put(pattern string, op Operation, h http.Handler) {
+	rt.Method(http.MethodPut, pattern, op, h)
+}
+
+func (rt *Router) Patch(pattern string, op Operation, h http.Handler) {
+	rt.Method(http.MethodPatch, pattern, op, h)
+}
+
+func (rt *Router) Delete(pattern string, op Operation, h http.Handler) {
+	rt.Method(http.MethodDelete, pattern, op, h)
+}
@@ -0,0 +1,188 @@
+package openapi
+
+import (
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/google/uuid"
+)
+
+var (
+	timeType    = reflect.TypeOf(time.Time{})
+	uuidType    = reflect.TypeOf(uuid.UUID{})
+	rawJSONType = reflect.TypeOf(json.RawMessage{})
+)
+
+// schemas builds JSON Schemas from Go types. Named structs become components
+// that are referenced by name.
+type schemas struct {
+	components map[string]interface{}
+	names      map[reflect.Type]string
+}
+
+func newSchemas() *schemas {
+	return &schemas{
+		components: map[string]interface{}{},
+		names:      map[reflect.Type]string{},
+	}
+}
+
+func (s *schemas) of(v interface{}) map[string]interface{} {
+	return s.schema(reflect.TypeOf(v))
+}
+
+func (s *schemas) schema(t reflect.Type) map[string]interface{} {
+	switch t {
+	case timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case uuidType:
+		return map[string]interface{}{"type": "string", "format": "uuid"}
+	case rawJSONType:
+		return map[string]interface{}{}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		return map[string]interface{}{
+			"anyOf": []interface{}{s.schema(t.Elem()), map[string]interface{}{"type": "null"}},
+		}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": s.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": s.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return s.object(t)
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + s.component(t)}
+	default:
+		return map[string]interface{}{}
+	}
+}
+
+// component registers the named struct t and returns its component name.
+func (s *schemas) component(t reflect.Type) string {
+	if name, ok := s.names[t]; ok {
+		return name
+	}
+
+	name := exported(t.Name())
+	for _, taken := range s.names {
+		if taken == name {
+			// the same name in two packages, qualify the second one
+			pkg := t.PkgPath()
+			name = exported(pkg[strings.LastIndex(pkg, "/")+1:]) + name
+			break
+		}
+	}
+
+	// register before building so recursive types refer to themselves
+	s.names[t] = name
+	s.components[name] = s.object(t)
+
+	return name
+}
+
+// object describes the JSON encoding of struct t. Fields are required when
+// their validate tag requires them or, without one, unless they are omitempty.
+func (s *schemas) object(t reflect.Type) map[string]interface{} {
+	properties := map[string]interface{}{}
+	var required []string
+
+	var walk func(t reflect.Type)
+	walk = func(t reflect.Type) {
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+
+			if field.Anonymous && field.Type.Kind() == reflect.Struct && field.Tag.Get("json") == "" {
+				walk(field.Type)
+				continue
+			}
+			if !field.IsExported() {
+				continue
+			}
+
+			tag := strings.Split(field.Tag.Get("json"), ",")
+			name := tag[0]
+			if name == "-" {
+				continue
+			}
+			if name == "" {
+				name = field.Name
+			}
+
+			schema := s.schema(field.Type)
+
+			rules, hasRules := field.Tag.Lookup("validate")
+			for _, rule := range strings.Split(rules, ",") {
+				if rule == "dive" {
+					break
+				}
+				constrain(schema, field.Type, rule)
+			}
+
+			properties[name] = schema
+
+			omitempty := len(tag) > 1 && tag[1] == "omitempty"
+			if hasRules && strings.Contains(","+rules+",", ",required,") || !hasRules && !omitempty {
+				required = append(required, name)
+			}
+		}
+	}
+	walk(t)
+
+	out := map[string]interface{}{
+		"type":       "object",
+		"properties": properties,
+	}
+	if len(required) > 0 {
+		out["required"] = required
+	}
+	return out
+}
+
+// constrain adds the JSON Schema equivalent of a validator rule.
+func constrain(schema map[string]interface{}, t reflect.Type, rule string) {
+	key, value, _ := strings.Cut(rule, "=")
+
+	if key == "email" {
+		schema["format"] = "email"
+		return
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil || (key != "min" && key != "max") {
+		return
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		schema[map[string]string{"min": "minLength", "max": "maxLength"}[key]] = n
+	case reflect.Slice:
+		schema[map[string]string{"min": "minItems", "max": "maxItems"}[key]] = n
+	case reflect.Int, reflect.Int64:
+		schema[map[string]string{"min": "minimum", "max": "maximum"}[key]] = n
+	}
+}
+
+func exported(name string) string {
+	if name == "" {
+		return name
+	}
+	r := []rune(name)
+	r[0] = unicode.ToUpper(r[0])
+	return string(r)
+}
@@ -0,0 +1,131 @@
+package password
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+type Argon2idParams struct {
+	// Memory in KiB, defaults to 64 MiB
+	Memory uint32
+	// Time is the number of passes over the memory, defaults to 3
+	Time uint32
+	// Parallelism is the number of lanes, defaults to 4
+	Parallelism uint8
+	// SaltLength defaults to 16 bytes
+	SaltLength int
+	// KeyLength defaults to 32 bytes
+	KeyLength uint32
+}
+
+// Argon2id hashes with Argon2id. The defaults are the second recommended
+// option of RFC 9106.
+type Argon2id struct {
+	p Argon2idParams
+}
+
+func NewArgon2id(p Argon2idParams) *Argon2id {
+	if p.Memory == 0 {
+		p.Memory = 64 * 1024
+	}
+	if p.Time == 0 {
+		p.Time = 3
+	}
+	if p.Parallelism == 0 {
+		p.Parallelism = 4
+	}
+	if p.SaltLength == 0 {
+		p.SaltLength = 16
+	}
+	if p.KeyLength == 0 {
+		p.KeyLength = 32
+	}
+	return &Argon2id{p: p}
+}
+
+var b64 = base64.RawStdEncoding
+
+func (a *Argon2id) Hash(password string) (string, error) {
+	salt := make([]byte, a.p.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey([]byte(password), salt, a.p.Time, a.p.Memory, a.p.Parallelism, a.p.KeyLength)
+
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, a.p.Memory, a.p.Time, a.p.Parallelism, b64.EncodeToString(salt), b64.EncodeToString(key)), nil
+}
+
+type argon2idHash struct {
+	version     int
+	memory      uint32
+	time        uint32
+	parallelism uint8
+	salt        []byte
+	key         []byte
+}
+
+func parseArgon2id(encoded string) (*argon2idHash, error) {
+	// "", "argon2id", "v=19", "m=..,t=..,p=..", salt, key
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return nil, ErrInvalidHash
+	}
+
+	var h argon2idHash
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &h.version); err != nil {
+		return nil, ErrInvalidHash
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &h.memory, &h.time, &h.parallelism); err != nil {
+		return nil, ErrInvalidHash
+	}
+
+	var err error
+	if h.salt, err = b64.DecodeString(parts[4]); err != nil {
+		return nil, ErrInvalidHash
+	}
+	if h.key, err = b64.DecodeString(parts[5]); err != nil || len(h.key) == 0 {
+		return nil, ErrInvalidHash
+	}
+
+	return &h, nil
+}
+
+func (a *Argon2id) Verify(password, encoded string) error {
+	h, err := parseArgon2id(encoded)
+	if err != nil {
+		return err
+	}
+	if h.version != argon2.Version {
+		return ErrInvalidHash
+	}
+
+	key := argon2.IDKey([]byte(password), h.salt, h.time, h.memory, h.parallelism, uint32(len(h.key)))
+	if subtle.ConstantTimeCompare(key, h.key) != 1 {
+		return ErrMismatch
+	}
+	return nil
+}
+
+func (a *Argon2id) Recognizes(encoded string) bool {
+	return algorithm(encoded) == "argon2id"
+}
+
+func (a *Argon2id) NeedsRehash(encoded string) bool {
+	h, err := parseArgon2id(encoded)
+	if err != nil {
+		return true
+	}
+	return h.version != argon2.Version ||
+		h.memory != a.p.Memory ||
+		h.time != a.p.Time ||
+		h.parallelism != a.p.Parallelism ||
+		len(h.salt) != a.p.SaltLength ||
+		len(h.key) != int(a.p.KeyLength)
+}
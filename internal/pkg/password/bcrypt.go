@@ -0,0 +1,67 @@
+package password
+
+import (
+	"errors"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ErrTooLong is returned by Bcrypt for passwords it would silently truncate.
+var ErrTooLong = errors.New("password: longer than 72 bytes, bcrypt would truncate it")
+
+// bcryptMaxLength is the number of bytes bcrypt looks at.
+const bcryptMaxLength = 72
+
+// Bcrypt hashes with bcrypt, in its own $2a$<cost>$ format. It refuses
+// passwords over 72 bytes rather than ignoring the rest.
+type Bcrypt struct {
+	cost int
+}
+
+// NewBcrypt returns a bcrypt hasher, cost 0 means bcrypt.DefaultCost.
+func NewBcrypt(cost int) *Bcrypt {
+	if cost == 0 {
+		cost = bcrypt.DefaultCost
+	}
+	return &Bcrypt{cost: cost}
+}
+
+func (b *Bcrypt) Hash(password string) (string, error) {
+	if len(password) > bcryptMaxLength {
+		return "", ErrTooLong
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), b.cost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+func (b *Bcrypt) Verify(password, encoded string) error {
+	// only the first 72 bytes would be compared
+	if len(password) > bcryptMaxLength {
+		return ErrMismatch
+	}
+
+	err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return ErrMismatch
+	}
+	if err != nil {
+		return ErrInvalidHash
+	}
+	return nil
+}
+
+func (b *Bcrypt) Recognizes(encoded string) bool {
+	switch algorithm(encoded) {
+	case "2a", "2b", "2y":
+		return true
+	}
+	return false
+}
+
+func (b *Bcrypt) NeedsRehash(encoded string) bool {
+	cost, err := bcrypt.Cost([]byte(encoded))
+	return err != nil || cost != b.cost
+}
@@ -0,0 +1,85 @@
+package password
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// BreachChecker reports how many times a password appears in known data
+// breaches.
+type BreachChecker interface {
+	Breached(ctx context.Context, password string) (int, error)
+}
+
+// RangeDir checks passwords against a local copy of the Pwned Passwords
+// ranges, as written by the PwnedPasswordsDownloader with one file per hash
+// prefix. Each file, named after the first 5 hex digits of the SHA-1 hashes
+// it holds, has a SUFFIX:COUNT line per hash. Like the online API, only the
+// range of the password's prefix is ever read.
+type RangeDir struct {
+	dir string
+}
+
+func NewRangeDir(dir string) (*RangeDir, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", dir)
+	}
+	return &RangeDir{dir: dir}, nil
+}
+
+func (d *RangeDir) Breached(ctx context.Context, password string) (int, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := hash[:5], hash[5:]
+
+	f, err := d.open(prefix)
+	if errors.Is(err, fs.ErrNotExist) {
+		// a partial copy, the password can't be in it
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if err := ctx.Err(); err != nil {
+			return 0, err
+		}
+
+		s, count, ok := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		if !ok || !strings.EqualFold(s, suffix) {
+			continue
+		}
+		// padding lines have a count of 0
+		n, err := strconv.Atoi(count)
+		if err != nil {
+			return 0, fmt.Errorf("reading range %s: %w", prefix, err)
+		}
+		return n, nil
+	}
+	return 0, scanner.Err()
+}
+
+// open returns the file of a range, with or without a .txt extension.
+func (d *RangeDir) open(prefix string) (*os.File, error) {
+	f, err := os.Open(filepath.Join(d.dir, prefix+".txt"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return os.Open(filepath.Join(d.dir, prefix))
+	}
+	return f, err
+}
@@ -0,0 +1,85 @@
+// Package password hashes passwords and decides which new ones are
+// acceptable. Hashes are PHC strings such as
+// $argon2id$v=19$m=65536,t=3,p=4$<salt>$<hash>, or bcrypt's own $2a$ format,
+// so each hash says how it was made and old hashes keep working after the
+// algorithm or its parameters change.
+package password
+
+import (
+	"errors"
+	"strings"
+)
+
+var (
+	// ErrMismatch is returned when the password doesn't match the hash
+	ErrMismatch = errors.New("password: mismatch")
+	// ErrInvalidHash is returned for hashes that can't be parsed
+	ErrInvalidHash = errors.New("password: invalid hash")
+	// ErrUnknownAlgorithm is returned for hashes no hasher recognizes
+	ErrUnknownAlgorithm = errors.New("password: unknown algorithm")
+)
+
+// Hasher hashes and verifies passwords with one algorithm.
+type Hasher interface {
+	// Hash returns the encoded hash of password with a new random salt
+	Hash(password string) (string, error)
+	// Verify returns nil if password matches encoded, ErrMismatch otherwise
+	Verify(password, encoded string) error
+	// Recognizes reports whether encoded was made by this algorithm
+	Recognizes(encoded string) bool
+	// NeedsRehash reports whether encoded was made with other parameters
+	// than the hasher uses now
+	NeedsRehash(encoded string) bool
+}
+
+// Chain hashes new passwords with its first hasher and verifies hashes made
+// by any of them. Hashes not made by the first hasher, with its current
+// parameters, need a rehash.
+type Chain struct {
+	hashers []Hasher
+}
+
+func NewChain(current Hasher, legacy ...Hasher) *Chain {
+	return &Chain{hashers: append([]Hasher{current}, legacy...)}
+}
+
+// Default hashes with Argon2id and still verifies bcrypt hashes.
+func Default() *Chain {
+	return NewChain(NewArgon2id(Argon2idParams{}), NewBcrypt(0))
+}
+
+func (c *Chain) Hash(password string) (string, error) {
+	return c.hashers[0].Hash(password)
+}
+
+func (c *Chain) Verify(password, encoded string) error {
+	for _, h := range c.hashers {
+		if h.Recognizes(encoded) {
+			return h.Verify(password, encoded)
+		}
+	}
+	return ErrUnknownAlgorithm
+}
+
+func (c *Chain) Recognizes(encoded string) bool {
+	for _, h := range c.hashers {
+		if h.Recognizes(encoded) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Chain) NeedsRehash(encoded string) bool {
+	current := c.hashers[0]
+	return !current.Recognizes(encoded) || current.NeedsRehash(encoded)
+}
+
+// algorithm returns the id of a PHC string, "" if it isn't one.
+func algorithm(encoded string) string {
+	if !strings.HasPrefix(encoded, "$") {
+		return ""
+	}
+	id, _, _ := strings.Cut(encoded[1:], "$")
+	return id
+}
@@ -0,0 +1,90 @@
+//go:build unit
+// +build unit
+
+package password
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// cheap parameters, the defaults take a while
+var testArgon2id = Argon2idParams{Memory: 1024, Time: 1, Parallelism: 1}
+
+func TestArgon2id(t *testing.T) {
+	assert := assert.New(t)
+
+	h := NewArgon2id(testArgon2id)
+
+	encoded, err := h.Hash("correct horse battery staple")
+	assert.NoError(err)
+	assert.True(strings.HasPrefix(encoded, "$argon2id$v=19$m=1024,t=1,p=1$"))
+	assert.True(h.Recognizes(encoded))
+	assert.False(h.NeedsRehash(encoded))
+
+	assert.NoError(h.Verify("correct horse battery staple", encoded))
+	assert.ErrorIs(h.Verify("Correct horse battery staple", encoded), ErrMismatch)
+	assert.ErrorIs(h.Verify("x", "$argon2id$v=19$m=1024$salt$key"), ErrInvalidHash)
+
+	other, err := h.Hash("correct horse battery staple")
+	assert.NoError(err)
+	assert.NotEqual(encoded, other, "every hash has its own salt")
+
+	long := strings.Repeat("a", 100)
+	encoded, err = h.Hash(long)
+	assert.NoError(err)
+	assert.ErrorIs(h.Verify(long[:72], encoded), ErrMismatch, "nothing is truncated")
+
+	stronger := NewArgon2id(Argon2idParams{Memory: 2048, Time: 1, Parallelism: 1})
+	assert.True(stronger.NeedsRehash(encoded))
+	assert.NoError(stronger.Verify(long, encoded), "old parameters still verify")
+}
+
+func TestBcrypt(t *testing.T) {
+	assert := assert.New(t)
+
+	h := NewBcrypt(bcrypt.MinCost)
+
+	encoded, err := h.Hash("password")
+	assert.NoError(err)
+	assert.True(h.Recognizes(encoded))
+	assert.NoError(h.Verify("password", encoded))
+	assert.ErrorIs(h.Verify("passw0rd", encoded), ErrMismatch)
+	assert.True(NewBcrypt(bcrypt.MinCost + 1).NeedsRehash(encoded))
+
+	_, err = h.Hash(strings.Repeat("a", 73))
+	assert.ErrorIs(err, ErrTooLong)
+}
+
+func TestChain(t *testing.T) {
+	c := NewChain(NewArgon2id(testArgon2id), NewBcrypt(bcrypt.MinCost))
+
+	legacy, err := NewBcrypt(bcrypt.MinCost).Hash("password")
+	assert.NoError(t, err)
+	current, err := c.Hash("password")
+	assert.NoError(t, err)
+
+	testCases := []struct {
+		description string
+		encoded     string
+		password    string
+		err         error
+		rehash      bool
+	}{
+		{"current", current, "password", nil, false},
+		{"legacy bcrypt", legacy, "password", nil, true},
+		{"legacy bcrypt - wrong password", legacy, "nope", ErrMismatch, true},
+		{"unknown algorithm", "$md5$abc", "password", ErrUnknownAlgorithm, true},
+		{"plain text", "password", "password", ErrUnknownAlgorithm, true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			assert.ErrorIs(t, c.Verify(tc.password, tc.encoded), tc.err)
+			assert.Equal(t, tc.rehash, c.NeedsRehash(tc.encoded))
+		})
+	}
+}
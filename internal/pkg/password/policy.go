@@ -0,0 +1,100 @@
+package password
+
+import (
+	"context"
+	"strings"
+	"unicode/utf8"
+)
+
+// Rules a new password can break, which are also their message keys.
+const (
+	RuleMinLength = "password.min_length"
+	RuleMaxLength = "password.max_length"
+	RuleStrength  = "password.too_weak"
+	RuleEmail     = "password.contains_email"
+	RuleBreached  = "password.breached"
+	// RuleReused is checked by whoever keeps the old hashes, see
+	// users.UserService.PasswordReused
+	RuleReused = "password.reused"
+)
+
+// Violation is a rule a password breaks. Value is the limit it missed, the
+// minimum length for RuleMinLength for instance.
+type Violation struct {
+	Rule  string `json:"rule"`
+	Value int    `json:"value,omitempty"`
+}
+
+// Policy decides which new passwords are acceptable. Existing passwords keep
+// working when it changes.
+type Policy struct {
+	// MinLength and MaxLength count characters, 0 for no limit
+	MinLength int
+	MaxLength int
+	// MinScore is the lowest Estimate score accepted, from 0 to 4
+	MinScore int
+	// DisallowEmail rejects passwords containing the user's email address or
+	// the part before the @
+	DisallowEmail bool
+	// Breaches, when set, rejects passwords found in data breaches
+	Breaches BreachChecker
+}
+
+// DefaultPolicy follows NIST SP 800-63B: at least 8 characters, room for
+// long passphrases, and no passwords that are easy to guess.
+func DefaultPolicy() *Policy {
+	return &Policy{
+		MinLength:     8,
+		MaxLength:     128,
+		MinScore:      2,
+		DisallowEmail: true,
+	}
+}
+
+// Check estimates the strength of password for the user with email and
+// returns the rules it breaks, none if it is acceptable.
+func (p *Policy) Check(ctx context.Context, password string, email string) (Strength, []Violation, error) {
+	var violations []Violation
+
+	length := utf8.RuneCountInString(password)
+	if length < p.MinLength {
+		violations = append(violations, Violation{Rule: RuleMinLength, Value: p.MinLength})
+	}
+	if p.MaxLength > 0 && length > p.MaxLength {
+		// don't spend time estimating what is rejected anyway
+		return Strength{}, append(violations, Violation{Rule: RuleMaxLength, Value: p.MaxLength}), nil
+	}
+
+	strength := Estimate(password, email)
+	if strength.Score < p.MinScore {
+		violations = append(violations, Violation{Rule: RuleStrength, Value: p.MinScore})
+	}
+
+	if p.DisallowEmail && containsEmail(password, email) {
+		violations = append(violations, Violation{Rule: RuleEmail})
+	}
+
+	if p.Breaches != nil && password != "" {
+		count, err := p.Breaches.Breached(ctx, password)
+		if err != nil {
+			return strength, violations, err
+		}
+		if count > 0 {
+			violations = append(violations, Violation{Rule: RuleBreached, Value: count})
+		}
+	}
+
+	return strength, violations, nil
+}
+
+func containsEmail(password, email string) bool {
+	password, email = strings.ToLower(password), strings.ToLower(strings.TrimSpace(email))
+	if email == "" {
+		return false
+	}
+	if strings.Contains(password, email) {
+		return true
+	}
+	local, _, _ := strings.Cut(email, "@")
+	return len(local) >= 3 && strings.Contains(password, local)
+}
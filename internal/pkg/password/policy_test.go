@@ -0,0 +1,77 @@
+//go:build unit
+// +build unit
+
+package password
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEstimate(t *testing.T) {
+	testCases := []struct {
+		password string
+		maxScore int
+		minScore int
+		warning  string
+	}{
+		{"password", 0, 0, WarningCommon},
+		{"P@ssw0rd", 0, 0, WarningSimilar},
+		{"drowssap", 0, 0, WarningSimilar},
+		{"abcdefgh", 0, 0, WarningSequence},
+		{"aaaaaaaaaa", 0, 0, WarningRepeat},
+		{"zxcvbnm,./", 1, 0, WarningKeyboard},
+		{"01011990", 1, 0, WarningDate},
+		{"jane.doe", 0, 0, WarningPersonal},
+		{"tangerine-otter-89-lamp", 4, 4, ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.password, func(t *testing.T) {
+			s := Estimate(tc.password, "jane.doe@example.com")
+			assert.LessOrEqual(t, s.Score, tc.maxScore)
+			assert.GreaterOrEqual(t, s.Score, tc.minScore)
+			assert.Equal(t, tc.warning, s.Warning)
+		})
+	}
+}
+
+func TestPolicy(t *testing.T) {
+	// pretend "tangerine-otter-89-lamp" was breached, next to a padding line
+	sum := sha1.Sum([]byte("tangerine-otter-89-lamp"))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	dir := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, hash[:5]+".txt"), []byte("0000000000000000000000000000000000A:0\r\n"+hash[5:]+":3\r\n"), 0o600))
+	breaches, err := NewRangeDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	policy := DefaultPolicy()
+	policy.Breaches = breaches
+
+	testCases := []struct {
+		password   string
+		violations []Violation
+	}{
+		{"velvet-harbor-42-moss", nil},
+		{"short", []Violation{{Rule: RuleMinLength, Value: 8}, {Rule: RuleStrength, Value: 2}}},
+		{"password", []Violation{{Rule: RuleStrength, Value: 2}}},
+		{"janedoe-velvet-harbor-42", []Violation{{Rule: RuleEmail}}},
+		{"tangerine-otter-89-lamp", []Violation{{Rule: RuleBreached, Value: 3}}},
+		{string(make([]byte, 129)), []Violation{{Rule: RuleMaxLength, Value: 128}}},
+	}
+
+	for _, tc := range testCases {
+		_, violations, err := policy.Check(context.Background(), tc.password, "janedoe@example.com")
+		assert.NoError(t, err)
+		assert.Equal(t, tc.violations, violations, tc.password)
+	}
+}
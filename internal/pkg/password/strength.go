@@ -0,0 +1,631 @@
+package password
+
+import (
+	_ "embed"
+	"math"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Strength is an estimate of how hard a password is to guess, in the spirit
+// of zxcvbn: the password is split into the cheapest sequence of patterns an
+// attacker would try, common passwords, words from the user's details,
+// sequences, repeats, keyboard walks, dates, and the guesses each takes are
+// multiplied.
+type Strength struct {
+	// Score goes from 0, guessed almost straight away, to 4, very unlikely
+	// to be guessed
+	Score int `json:"score"`
+	// Guesses is the log10 of the estimated number of guesses
+	Guesses float64 `json:"guesses_log10"`
+	// Warning is the message key of the password's main weakness, empty for
+	// passwords scoring 3 or more
+	Warning string `json:"warning,omitempty"`
+	// Suggestions are message keys of ways to improve the password
+	Suggestions []string `json:"suggestions,omitempty"`
+}
+
+// Feedback message keys.
+const (
+	WarningCommon   = "password.warning.common"
+	WarningSimilar  = "password.warning.similar"
+	WarningPersonal = "password.warning.personal"
+	WarningSequence = "password.warning.sequence"
+	WarningRepeat   = "password.warning.repeat"
+	WarningKeyboard = "password.warning.keyboard"
+	WarningDate     = "password.warning.date"
+
+	SuggestionWords         = "password.suggestion.words"
+	SuggestionCapitals      = "password.suggestion.capitals"
+	SuggestionSubstitutions = "password.suggestion.substitutions"
+	SuggestionReversed      = "password.suggestion.reversed"
+	SuggestionPatterns      = "password.suggestion.patterns"
+	SuggestionDates         = "password.suggestion.dates"
+	SuggestionPersonal      = "password.suggestion.personal"
+)
+
+//go:embed data/common.txt
+var commonList string
+
+// common ranks common passwords, 1 being the most common.
+var common = rankedWords(strings.Fields(commonList))
+
+// keyboardWalks are rows and columns of a qwerty keyboard, any stretch of
+// which is a keyboard walk.
+var keyboardWalks = []string{
+	"`1234567890-=",
+	"qwertyuiop[]\\",
+	"asdfghjkl;'",
+	"zxcvbnm,./",
+	"1qaz2wsx3edc4rfv5tgb6yhn7ujm8ik,9ol.0p;/",
+	"zaq1xsw2cde3vfr4bgt5nhy6mju7,ki8.lo9/;p0",
+}
+
+// leet undoes the usual substitutions of letters by digits and symbols.
+var leet = map[rune]rune{
+	'4': 'a', '@': 'a', '8': 'b', '(': 'c', '{': 'c', '[': 'c', '<': 'c',
+	'3': 'e', '6': 'g', '9': 'g', '1': 'i', '!': 'i', '|': 'i', '0': 'o',
+	'$': 's', '5': 's', '7': 't', '+': 't', '2': 'z', '%': 'x',
+}
+
+const (
+	// bruteforceCardinality is the guesses per character of stretches no
+	// pattern explains
+	bruteforceCardinality = 10
+	// minSubmatchGuesses keep short patterns inside a longer password from
+	// looking cheaper than guessing a character or two
+	minSubmatchGuessesSingle = 10
+	minSubmatchGuessesMulti  = 50
+	// minGuessesBeforeGrowing is added for every pattern after the first, so
+	// splitting into many patterns isn't free
+	minGuessesBeforeGrowing = 10000
+)
+
+type pattern int
+
+const (
+	patternBruteforce pattern = iota
+	patternDictionary
+	patternSequence
+	patternRepeat
+	patternKeyboard
+	patternDate
+)
+
+// match is a stretch of the password, from i to j inclusive, explained by a
+// pattern.
+type match struct {
+	i, j    int
+	pattern pattern
+	// guesses is the log10 of the guesses the pattern takes
+	guesses float64
+
+	personal    bool
+	rank        int
+	reversed    bool
+	leet        bool
+	capitalized bool
+}
+
+// Estimate returns the strength of password. userInputs are words an
+// attacker could know, such as the user's email address, which count as
+// very common.
+func Estimate(password string, userInputs ...string) Strength {
+	runes := []rune(password)
+	if len(runes) == 0 {
+		return Strength{Suggestions: []string{SuggestionWords}}
+	}
+
+	personal := rankedWords(personalWords(userInputs))
+	matches := findMatches(runes, personal)
+	guesses, sequence := cheapest(runes, matches)
+
+	s := Strength{Score: score(guesses), Guesses: guesses}
+	if s.Score < 3 {
+		s.Warning, s.Suggestions = feedback(sequence)
+	}
+	return s
+}
+
+func score(guesses float64) int {
+	switch {
+	case guesses < 3:
+		return 0
+	case guesses < 6:
+		return 1
+	case guesses < 8:
+		return 2
+	case guesses < 10:
+		return 3
+	default:
+		return 4
+	}
+}
+
+func rankedWords(words []string) map[string]int {
+	ranks := make(map[string]int, len(words))
+	for i, w := range words {
+		w = strings.ToLower(w)
+		if _, ok := ranks[w]; !ok {
+			ranks[w] = i + 1
+		}
+	}
+	return ranks
+}
+
+// personalWords splits inputs such as email addresses into the words an
+// attacker would try.
+func personalWords(inputs []string) []string {
+	var words []string
+	for _, input := range inputs {
+		input = strings.ToLower(input)
+		if local, _, ok := strings.Cut(input, "@"); ok && len(local) >= 3 {
+			words = append(words, local)
+		}
+		for _, w := range strings.FieldsFunc(input, func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		}) {
+			if len([]rune(w)) >= 3 {
+				words = append(words, w)
+			}
+		}
+	}
+	return words
+}
+
+func findMatches(runes []rune, personal map[string]int) []match {
+	var matches []match
+	matches = append(matches, dictionaryMatches(runes, personal)...)
+	matches = append(matches, sequenceMatches(runes)...)
+	matches = append(matches, repeatMatches(runes, personal)...)
+	matches = append(matches, keyboardMatches(runes)...)
+	matches = append(matches, dateMatches(runes)...)
+	return matches
+}
+
+func dictionaryMatches(runes []rune, personal map[string]int) []match {
+	lower := []rune(strings.ToLower(string(runes)))
+	unleet := make([]rune, len(lower))
+	for i, r := range lower {
+		if l, ok := leet[r]; ok {
+			unleet[i] = l
+		} else {
+			unleet[i] = r
+		}
+	}
+
+	lookup := func(word string) (int, bool, bool) {
+		if rank, ok := personal[word]; ok {
+			return rank, true, true
+		}
+		rank, ok := common[word]
+		return rank, false, ok
+	}
+
+	var matches []match
+	for i := range lower {
+		for j := i; j < len(lower); j++ {
+			word := string(lower[i : j+1])
+			variants := []struct {
+				word     string
+				reversed bool
+				leet     bool
+			}{
+				{word, false, false},
+				{string(unleet[i : j+1]), false, true},
+			}
+			if j-i >= 2 {
+				variants = append(variants, struct {
+					word     string
+					reversed bool
+					leet     bool
+				}{reverse(word), true, false})
+			}
+
+			for _, v := range variants {
+				if v.leet && v.word == word {
+					continue
+				}
+				rank, isPersonal, ok := lookup(v.word)
+				if !ok {
+					continue
+				}
+
+				m := match{i: i, j: j, pattern: patternDictionary, rank: rank, personal: isPersonal, reversed: v.reversed, leet: v.leet}
+				guesses := float64(rank) * uppercaseVariations(runes[i:j+1])
+				if v.reversed {
+					guesses *= 2
+				}
+				if v.leet {
+					guesses *= leetVariations(lower[i : j+1])
+				}
+				m.capitalized = uppercaseVariations(runes[i:j+1]) > 1
+				m.guesses = math.Log10(guesses)
+				matches = append(matches, m)
+			}
+		}
+	}
+	return matches
+}
+
+// uppercaseVariations is how many ways of capitalizing a word an attacker
+// tries before this one.
+func uppercaseVariations(word []rune) float64 {
+	upper, lower := 0, 0
+	for _, r := range word {
+		switch {
+		case unicode.IsUpper(r):
+			upper++
+		case unicode.IsLower(r):
+			lower++
+		}
+	}
+	if upper == 0 {
+		return 1
+	}
+	// all caps, or only the first or last letter
+	if lower == 0 || (upper == 1 && (unicode.IsUpper(word[0]) || unicode.IsUpper(word[len(word)-1]))) {
+		return 2
+	}
+
+	variations := 0.0
+	for k := 1; k <= upper && k <= lower; k++ {
+		variations += binomial(upper+lower, k)
+	}
+	return math.Max(variations, 2)
+}
+
+func leetVariations(word []rune) float64 {
+	subs := 0
+	for _, r := range word {
+		if _, ok := leet[r]; ok {
+			subs++
+		}
+	}
+	return math.Max(math.Pow(2, float64(subs)), 2)
+}
+
+func sequenceMatches(runes []rune) []match {
+	var matches []match
+
+	add := func(i, j, delta int) {
+		if j-i < 2 {
+			return
+		}
+		first := unicode.ToLower(runes[i])
+		var base float64
+		switch {
+		case strings.ContainsRune("az019", first):
+			base = 4
+		case unicode.IsDigit(first):
+			base = 10
+		default:
+			base = 26
+		}
+		if delta < 0 {
+			base *= 2
+		}
+		matches = append(matches, match{i: i, j: j, pattern: patternSequence, guesses: math.Log10(base * float64(j-i+1))})
+	}
+
+	i := 0
+	for i < len(runes)-1 {
+		delta := int(unicode.ToLower(runes[i+1])) - int(unicode.ToLower(runes[i]))
+		j := i + 1
+		if delta == 1 || delta == -1 {
+			for j+1 < len(runes) && int(unicode.ToLower(runes[j+1]))-int(unicode.ToLower(runes[j])) == delta {
+				j++
+			}
+			add(i, j, delta)
+		}
+		i = j
+	}
+	return matches
+}
+
+func repeatMatches(runes []rune, personal map[string]int) []match {
+	var matches []match
+	for i := range runes {
+		best := match{}
+		for size := 1; i+2*size <= len(runes); size++ {
+			chunk := runes[i : i+size]
+			count := 1
+			for i+(count+1)*size <= len(runes) && string(runes[i+count*size:i+(count+1)*size]) == string(chunk) {
+				count++
+			}
+			if count < 2 || count*size < 3 {
+				continue
+			}
+
+			j := i + count*size - 1
+			if j <= best.j && best.pattern == patternRepeat {
+				continue
+			}
+			base, _ := cheapest(chunk, findMatches(chunk, personal))
+			best = match{i: i, j: j, pattern: patternRepeat, guesses: base + math.Log10(float64(count))}
+		}
+		if best.pattern == patternRepeat {
+			matches = append(matches, best)
+		}
+	}
+	return matches
+}
+
+func keyboardMatches(runes []rune) []match {
+	lower := []rune(strings.ToLower(string(runes)))
+
+	var matches []match
+	for i := range lower {
+		longest := 0
+		for _, walk := range keyboardWalks {
+			for _, w := range []string{walk, reverse(walk)} {
+				for j := len(lower) - 1; j >= i+2 && j-i+1 > longest; j-- {
+					if strings.Contains(w, string(lower[i:j+1])) {
+						longest = j - i + 1
+					}
+				}
+			}
+		}
+		if longest > 0 {
+			// a start key, a direction and the length
+			guesses := 47.0 * 2 * float64(longest)
+			matches = append(matches, match{i: i, j: i + longest - 1, pattern: patternKeyboard, guesses: math.Log10(guesses)})
+		}
+	}
+	return matches
+}
+
+// dateMatches finds years and dates written as digits only, ddmmyyyy,
+// mmddyyyy, yyyymmdd, ddmmyy and the like.
+func dateMatches(runes []rune) []match {
+	reference := time.Now().Year()
+
+	yearGuesses := func(year int) float64 {
+		return math.Max(math.Abs(float64(year-reference)), 20)
+	}
+
+	var matches []match
+	for i := range runes {
+		for _, size := range []int{4, 6, 8} {
+			j := i + size - 1
+			if j >= len(runes) || !allDigits(runes[i:j+1]) {
+				continue
+			}
+			digits := string(runes[i : j+1])
+
+			if size == 4 {
+				if year := atoi(digits); year >= 1900 && year <= 2099 {
+					matches = append(matches, match{i: i, j: j, pattern: patternDate, guesses: math.Log10(yearGuesses(year))})
+				}
+				continue
+			}
+
+			if year, ok := dateYear(digits); ok {
+				matches = append(matches, match{i: i, j: j, pattern: patternDate, guesses: math.Log10(365 * yearGuesses(year))})
+			}
+		}
+	}
+	return matches
+}
+
+// dateYear returns the year of digits if they read as a date in any of the
+// usual orders.
+func dateYear(digits string) (int, bool) {
+	type split struct{ year, a, b string }
+	var splits []split
+	if len(digits) == 8 {
+		splits = []split{{digits[4:], digits[:2], digits[2:4]}, {digits[:4], digits[4:6], digits[6:]}}
+	} else {
+		splits = []split{{digits[4:], digits[:2], digits[2:4]}, {digits[:2], digits[2:4], digits[4:]}}
+	}
+
+	for _, s := range splits {
+		year := atoi(s.year)
+		if len(s.year) == 2 {
+			if year > 50 {
+				year += 1900
+			} else {
+				year += 2000
+			}
+		}
+		if year < 1900 || year > 2099 {
+			continue
+		}
+		a, b := atoi(s.a), atoi(s.b)
+		if validDay(a, b) || validDay(b, a) {
+			return year, true
+		}
+	}
+	return 0, false
+}
+
+func validDay(day, month int) bool {
+	return day >= 1 && day <= 31 && month >= 1 && month <= 12
+}
+
+// cheapest finds the sequence of matches and bruteforced stretches covering
+// runes that takes the fewest guesses, returning the log10 of the guesses.
+func cheapest(runes []rune, matches []match) (float64, []match) {
+	n := len(runes)
+
+	// candidates ending at each position, bruteforce included
+	byEnd := make([][]match, n)
+	for _, m := range matches {
+		if m.i > 0 || m.j < n-1 {
+			min := float64(minSubmatchGuessesMulti)
+			if m.i == m.j {
+				min = minSubmatchGuessesSingle
+			}
+			m.guesses = math.Max(m.guesses, math.Log10(min))
+		}
+		byEnd[m.j] = append(byEnd[m.j], m)
+	}
+	for j := 0; j < n; j++ {
+		for i := 0; i <= j; i++ {
+			guesses := float64(j-i+1) * math.Log10(bruteforceCardinality)
+			min := float64(minSubmatchGuessesMulti + 1)
+			if i == j {
+				min = minSubmatchGuessesSingle + 1
+			}
+			byEnd[j] = append(byEnd[j], match{i: i, j: j, pattern: patternBruteforce, guesses: math.Max(guesses, math.Log10(min))})
+		}
+	}
+
+	// best[k][j] is the cheapest product of k matches covering runes[:j+1]
+	type step struct {
+		product float64
+		m       match
+		ok      bool
+	}
+	best := make([][]step, n+1)
+	for k := range best {
+		best[k] = make([]step, n)
+	}
+
+	for j := 0; j < n; j++ {
+		for _, m := range byEnd[j] {
+			if m.i == 0 {
+				if s := best[1][j]; !s.ok || m.guesses < s.product {
+					best[1][j] = step{product: m.guesses, m: m, ok: true}
+				}
+				continue
+			}
+			for k := 1; k < n; k++ {
+				prev := best[k][m.i-1]
+				if !prev.ok {
+					continue
+				}
+				// two bruteforce stretches in a row are one stretch
+				if prev.m.pattern == patternBruteforce && m.pattern == patternBruteforce {
+					continue
+				}
+				product := prev.product + m.guesses
+				if s := best[k+1][j]; !s.ok || product < s.product {
+					best[k+1][j] = step{product: product, m: m, ok: true}
+				}
+			}
+		}
+	}
+
+	total, count := math.Inf(1), 0
+	for k := 1; k <= n; k++ {
+		s := best[k][n-1]
+		if !s.ok {
+			continue
+		}
+		// k! orderings of the patterns, plus a floor for each pattern
+		guesses := log10Sum(s.product+log10Factorial(k), float64(k-1)*math.Log10(minGuessesBeforeGrowing))
+		if guesses < total {
+			total, count = guesses, k
+		}
+	}
+
+	sequence := make([]match, count)
+	for k, j := count, n-1; k > 0; k-- {
+		m := best[k][j].m
+		sequence[k-1] = m
+		j = m.i - 1
+	}
+	return total, sequence
+}
+
+// feedback explains the weakness of the longest pattern in sequence.
+func feedback(sequence []match) (string, []string) {
+	var longest *match
+	for i := range sequence {
+		m := &sequence[i]
+		if m.pattern == patternBruteforce {
+			continue
+		}
+		if longest == nil || m.j-m.i > longest.j-longest.i {
+			longest = m
+		}
+	}
+
+	suggestions := []string{SuggestionWords}
+	if longest == nil {
+		return "", suggestions
+	}
+
+	var warning string
+	switch longest.pattern {
+	case patternDictionary:
+		switch {
+		case longest.personal:
+			warning = WarningPersonal
+			suggestions = append(suggestions, SuggestionPersonal)
+		case longest.reversed || longest.leet || longest.capitalized:
+			warning = WarningSimilar
+		default:
+			warning = WarningCommon
+		}
+		if longest.capitalized {
+			suggestions = append(suggestions, SuggestionCapitals)
+		}
+		if longest.reversed {
+			suggestions = append(suggestions, SuggestionReversed)
+		}
+		if longest.leet {
+			suggestions = append(suggestions, SuggestionSubstitutions)
+		}
+	case patternSequence:
+		warning = WarningSequence
+		suggestions = append(suggestions, SuggestionPatterns)
+	case patternRepeat:
+		warning = WarningRepeat
+		suggestions = append(suggestions, SuggestionPatterns)
+	case patternKeyboard:
+		warning = WarningKeyboard
+		suggestions = append(suggestions, SuggestionPatterns)
+	case patternDate:
+		warning = WarningDate
+		suggestions = append(suggestions, SuggestionDates)
+	}
+	return warning, suggestions
+}
+
+func log10Sum(a, b float64) float64 {
+	if a < b {
+		a, b = b, a
+	}
+	return a + math.Log10(1+math.Pow(10, b-a))
+}
+
+func log10Factorial(n int) float64 {
+	lgamma, _ := math.Lgamma(float64(n + 1))
+	return lgamma / math.Ln10
+}
+
+func binomial(n, k int) float64 {
+	result := 1.0
+	for i := 1; i <= k; i++ {
+		result = result * float64(n-k+i) / float64(i)
+	}
+	return result
+}
+
+func reverse(s string) string {
+	runes := []rune(s)
+	for i, j := 0, len(runes)-1; i < j; i, j = i+1, j-1 {
+		runes[i], runes[j] = runes[j], runes[i]
+	}
+	return string(runes)
+}
+
+func allDigits(runes []rune) bool {
+	for _, r := range runes {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func atoi(digits string) int {
+	n := 0
+	for _, r := range digits {
+		n = n*10 + int(r-'0')
+	}
+	return n
+}
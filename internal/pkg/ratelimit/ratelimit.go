@@ -0,0 +1,75 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// Limiter allows each key a number of events in any window of time, such as
+// three login emails per address every 15 minutes. It keeps the times of the
+// recent events in memory, so limits are per process.
+type Limiter struct {
+	mu     sync.Mutex
+	limit  int
+	window time.Duration
+	events map[string][]time.Time
+	swept  time.Time
+	now    func() time.Time
+}
+
+type Params struct {
+	// Limit is how many events a key may have in Window
+	Limit  int
+	Window time.Duration
+}
+
+func New(p Params) *Limiter {
+	return &Limiter{
+		limit:  p.Limit,
+		window: p.Window,
+		events: map[string][]time.Time{},
+		now:    time.Now,
+	}
+}
+
+// Allow records an event for key when it is under the limit. Otherwise it
+// returns false and how long until the oldest event leaves the window.
+func (l *Limiter) Allow(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.sweep(now)
+
+	recent := l.recent(key, now)
+	if len(recent) >= l.limit {
+		return false, recent[0].Add(l.window).Sub(now)
+	}
+
+	l.events[key] = append(recent, now)
+	return true, 0
+}
+
+// recent drops the events of key that have left the window.
+func (l *Limiter) recent(key string, now time.Time) []time.Time {
+	events := l.events[key]
+	i := 0
+	for i < len(events) && !events[i].After(now.Add(-l.window)) {
+		i++
+	}
+	return events[i:]
+}
+
+// sweep forgets keys without recent events, once per window.
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.swept) < l.window {
+		return
+	}
+	l.swept = now
+
+	for key := range l.events {
+		if len(l.recent(key, now)) == 0 {
+			delete(l.events, key)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+//go:build unit
+// +build unit
+
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLimiter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	l := New(Params{Limit: 2, Window: time.Minute})
+	l.now = func() time.Time { return now }
+
+	ok, _ := l.Allow("a")
+	assert.True(t, ok)
+
+	now = now.Add(20 * time.Second)
+	ok, _ = l.Allow("a")
+	assert.True(t, ok)
+
+	ok, retry := l.Allow("a")
+	assert.False(t, ok)
+	assert.Equal(t, 40*time.Second, retry)
+
+	// other keys have their own limit
+	ok, _ = l.Allow("b")
+	assert.True(t, ok)
+
+	// the first event has left the window
+	now = now.Add(40 * time.Second)
+	ok, _ = l.Allow("a")
+	assert.True(t, ok)
+
+	now = now.Add(2 * time.Minute)
+	l.Allow("c")
+	assert.NotContains(t, l.events, "a")
+	assert.NotContains(t, l.events, "b")
+}
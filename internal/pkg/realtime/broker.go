@@ -0,0 +1,240 @@
+package realtime
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// ErrClosed is returned by Subscribe once the broker has shut down.
+var ErrClosed = errors.New("realtime: broker closed")
+
+// Event is a single server-sent event. ID is unique and increasing across all
+// topics so one Last-Event-ID resumes every topic a client listens to.
+type Event struct {
+	ID    uint64
+	Topic string
+	Name  string
+	Data  string
+}
+
+// UserTopic is the private topic of a single user.
+func UserTopic(userID string) string {
+	return "user:" + userID
+}
+
+// Broker fans events out to subscribers by topic and keeps the most recent
+// events of each topic so reconnecting clients can resume.
+type Broker struct {
+	logger            *zap.Logger
+	historySize       int
+	clientBuffer      int
+	heartbeatInterval time.Duration
+
+	mu      sync.Mutex
+	lastID  uint64
+	history map[string][]Event
+	topics  map[string]map[*Subscription]struct{}
+	closed  bool
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+type BrokerParams struct {
+	Logger *zap.Logger
+	// HistorySize is how many events per topic are kept for resuming, defaults to 100
+	HistorySize int
+	// ClientBuffer is how many events may queue for a client before it is
+	// considered too slow and disconnected, defaults to 32
+	ClientBuffer int
+	// HeartbeatInterval defaults to 15 seconds
+	HeartbeatInterval time.Duration
+}
+
+func NewBroker(p BrokerParams) *Broker {
+	if p.HistorySize == 0 {
+		p.HistorySize = 100
+	}
+	if p.ClientBuffer == 0 {
+		p.ClientBuffer = 32
+	}
+	if p.HeartbeatInterval == 0 {
+		p.HeartbeatInterval = 15 * time.Second
+	}
+
+	return &Broker{
+		logger:            p.Logger,
+		historySize:       p.HistorySize,
+		clientBuffer:      p.ClientBuffer,
+		heartbeatInterval: p.HeartbeatInterval,
+		history:           map[string][]Event{},
+		topics:            map[string]map[*Subscription]struct{}{},
+		done:              make(chan struct{}),
+	}
+}
+
+// Subscription receives the events of its topics on Events. The channel is
+// closed when the subscriber falls behind or the broker shuts down.
+type Subscription struct {
+	Events <-chan Event
+
+	events chan Event
+	topics []string
+	// dropped is set when the channel was closed because the client was too slow
+	dropped bool
+}
+
+// Dropped reports whether the subscription ended because the client could not
+// keep up, as opposed to the broker shutting down.
+func (s *Subscription) Dropped() bool {
+	return s.dropped
+}
+
+// Publish sends an event to every subscriber of topic and records it in the
+// topic's history. Subscribers whose buffer is full are disconnected instead
+// of blocking the publisher; they resume from history when they reconnect.
+func (b *Broker) Publish(topic, name, data string) Event {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.lastID++
+	event := Event{ID: b.lastID, Topic: topic, Name: name, Data: data}
+
+	if b.closed {
+		return event
+	}
+
+	history := append(b.history[topic], event)
+	if len(history) > b.historySize {
+		history = history[len(history)-b.historySize:]
+	}
+	b.history[topic] = history
+
+	for sub := range b.topics[topic] {
+		select {
+		case sub.events <- event:
+		default:
+			b.logger.Warn("Dropping slow realtime subscriber", zap.String("topic", topic))
+			sub.dropped = true
+			b.removeLocked(sub)
+		}
+	}
+
+	return event
+}
+
+// PublishUser sends an event to every connection of a single user.
+func (b *Broker) PublishUser(userID, name, data string) Event {
+	return b.Publish(UserTopic(userID), name, data)
+}
+
+// Subscribe registers for topics and returns the events published after
+// lastEventID that are still in history, oldest first.
+func (b *Broker) Subscribe(topics []string, lastEventID uint64) (*Subscription, []Event, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return nil, nil, ErrClosed
+	}
+
+	events := make(chan Event, b.clientBuffer)
+	sub := &Subscription{Events: events, events: events, topics: topics}
+
+	var replay []Event
+	// an ID from before a restart can't be resumed, start fresh instead
+	if lastEventID > 0 && lastEventID <= b.lastID {
+		for _, topic := range topics {
+			for _, event := range b.history[topic] {
+				if event.ID > lastEventID {
+					replay = append(replay, event)
+				}
+			}
+		}
+		sort.Slice(replay, func(i, j int) bool { return replay[i].ID < replay[j].ID })
+	}
+
+	for _, topic := range topics {
+		if b.topics[topic] == nil {
+			b.topics[topic] = map[*Subscription]struct{}{}
+		}
+		b.topics[topic][sub] = struct{}{}
+	}
+
+	return sub, replay, nil
+}
+
+// Unsubscribe removes sub, it is safe to call more than once.
+func (b *Broker) Unsubscribe(sub *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.removeLocked(sub)
+}
+
+func (b *Broker) removeLocked(sub *Subscription) {
+	removed := false
+	for _, topic := range sub.topics {
+		if subs, ok := b.topics[topic]; ok {
+			if _, ok := subs[sub]; ok {
+				delete(subs, sub)
+				removed = true
+			}
+			if len(subs) == 0 {
+				delete(b.topics, topic)
+			}
+		}
+	}
+
+	if removed {
+		close(sub.events)
+	}
+}
+
+// track counts a streaming connection for Shutdown to wait on. It returns
+// false once the broker is closed.
+func (b *Broker) track() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return false
+	}
+	b.wg.Add(1)
+	return true
+}
+
+// Shutdown disconnects every subscriber and waits for their streams to end or
+// for ctx to expire. Call it before http.Server.Shutdown, which would otherwise
+// wait for the never ending event streams.
+func (b *Broker) Shutdown(ctx context.Context) error {
+	b.mu.Lock()
+	if !b.closed {
+		b.closed = true
+		close(b.done)
+		for _, subs := range b.topics {
+			for sub := range subs {
+				b.removeLocked(sub)
+			}
+		}
+	}
+	b.mu.Unlock()
+
+	finished := make(chan struct{})
+	go func() {
+		b.wg.Wait()
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
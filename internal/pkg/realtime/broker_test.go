@@ -0,0 +1,81 @@
+//go:build unit
+// +build unit
+
+package realtime
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func TestBrokerResume(t *testing.T) {
+
+	assert := assert.New(t)
+
+	b := NewBroker(BrokerParams{Logger: zap.NewNop(), HistorySize: 2})
+
+	first := b.Publish("news", "headline", "one")
+	b.Publish("news", "headline", "two")
+	b.Publish("other", "headline", "elsewhere")
+	b.Publish("news", "headline", "three")
+
+	_, replay, err := b.Subscribe([]string{"news"}, first.ID)
+	assert.NoError(err)
+
+	// history keeps the last two events of the topic
+	if assert.Len(replay, 2) {
+		assert.Equal("two", replay[0].Data)
+		assert.Equal("three", replay[1].Data)
+	}
+
+	_, replay, err = b.Subscribe([]string{"news"}, 1000)
+	assert.NoError(err)
+	assert.Empty(replay, "ids from before a restart are not resumed")
+}
+
+func TestBrokerDropsSlowSubscribers(t *testing.T) {
+
+	assert := assert.New(t)
+
+	b := NewBroker(BrokerParams{Logger: zap.NewNop(), ClientBuffer: 1})
+
+	slow, _, err := b.Subscribe([]string{"news"}, 0)
+	assert.NoError(err)
+
+	b.Publish("news", "headline", "one")
+	b.Publish("news", "headline", "two")
+
+	event, ok := <-slow.Events
+	assert.True(ok)
+	assert.Equal("one", event.Data)
+
+	_, ok = <-slow.Events
+	assert.False(ok, "the subscriber should be disconnected once its buffer is full")
+	assert.True(slow.Dropped())
+}
+
+func TestBrokerShutdown(t *testing.T) {
+
+	assert := assert.New(t)
+
+	b := NewBroker(BrokerParams{Logger: zap.NewNop()})
+
+	sub, _, err := b.Subscribe([]string{"news"}, 0)
+	assert.NoError(err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	assert.NoError(b.Shutdown(ctx))
+
+	_, ok := <-sub.Events
+	assert.False(ok)
+	assert.False(sub.Dropped())
+
+	_, _, err = b.Subscribe([]string{"news"}, 0)
+	assert.ErrorIs(err, ErrClosed)
+}
@@ -0,0 +1,114 @@
+package realtime
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tomdoestech/goth/internal/pkg/identity"
+)
+
+// userID returns the id of the user the identity middleware let in, "" for
+// anonymous requests.
+func userID(r *http.Request) string {
+	if p, ok := identity.FromContext(r.Context()); ok {
+		return p.UserID.String()
+	}
+	return ""
+}
+
+// ServeHTTP streams events as text/event-stream. Clients pick public topics
+// with ?topic=a&topic=b; logged in users are always subscribed to their own
+// user topic and can't subscribe to anyone else's. Reconnecting clients send
+// Last-Event-ID and receive what they missed from history.
+func (b *Broker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	var topics []string
+	for _, topic := range r.URL.Query()["topic"] {
+		if topic == "" || strings.HasPrefix(topic, "user:") {
+			continue
+		}
+		topics = append(topics, topic)
+	}
+
+	if id := userID(r); id != "" {
+		topics = append(topics, UserTopic(id))
+	}
+
+	if len(topics) == 0 {
+		http.Error(w, "No topics to subscribe to", http.StatusBadRequest)
+		return
+	}
+
+	if !b.track() {
+		http.Error(w, "Server is shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	defer b.wg.Done()
+
+	lastEventID, _ := strconv.ParseUint(r.Header.Get("Last-Event-ID"), 10, 64)
+
+	sub, replay, err := b.Subscribe(topics, lastEventID)
+	if err != nil {
+		http.Error(w, "Server is shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	defer b.Unsubscribe(sub)
+
+	h := w.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	h.Set("Connection", "keep-alive")
+	// stop nginx from buffering the stream
+	h.Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	fmt.Fprint(w, "retry: 3000\n\n")
+	for _, event := range replay {
+		writeEvent(w, event)
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(b.heartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-b.done:
+			return
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": heartbeat\n\n")
+			flusher.Flush()
+		case event, ok := <-sub.Events:
+			if !ok {
+				// dropped as too slow or shutting down, the client reconnects
+				// with Last-Event-ID and catches up from history
+				return
+			}
+			writeEvent(w, event)
+			flusher.Flush()
+		}
+	}
+}
+
+// writeEvent writes event in the text/event-stream format. Data can span
+// several lines, each needs its own data field.
+func writeEvent(w http.ResponseWriter, event Event) {
+	fmt.Fprintf(w, "id: %d\n", event.ID)
+	if event.Name != "" {
+		fmt.Fprintf(w, "event: %s\n", event.Name)
+	}
+	for _, line := range strings.Split(event.Data, "\n") {
+		fmt.Fprintf(w, "data: %s\n", line)
+	}
+	fmt.Fprint(w, "\n")
+}
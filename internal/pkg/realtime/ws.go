@@ -0,0 +1,472 @@
+package realtime
+
+import (
+	"context"
+	"encoding/json"
+	"html"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/jwtauth/v5"
+	"github.com/gorilla/websocket"
+	"github.com/tomdoestech/goth/internal/pkg/metrics"
+	"go.uber.org/zap"
+)
+
+// roomName keeps room names short and safe to use in element ids.
+var roomName = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// Message is what the client sends. The htmx ws extension sends the values of
+// the submitted form as JSON, so action and room are usually hidden inputs;
+// Raw holds the whole message for handlers that need the other fields.
+type Message struct {
+	Action  string            `json:"action"`
+	Room    string            `json:"room"`
+	Headers map[string]string `json:"HEADERS"`
+	Raw     json.RawMessage   `json:"-"`
+}
+
+// Hub manages authenticated WebSocket connections and the rooms they join.
+type Hub struct {
+	logger         *zap.Logger
+	upgrader       websocket.Upgrader
+	allowedOrigins map[string]bool
+	onMessage      func(c *Conn, msg Message)
+	sendBuffer     int
+	pingInterval   time.Duration
+	maxMessageSize int64
+
+	mu     sync.Mutex
+	conns  map[*Conn]struct{}
+	rooms  map[string]map[*Conn]struct{}
+	closed bool
+
+	wg sync.WaitGroup
+}
+
+type HubParams struct {
+	Logger *zap.Logger
+	// AllowedOrigins are accepted in addition to the origin the request was
+	// made to, e.g. https://app.example.com
+	AllowedOrigins []string
+	// OnMessage is called for every message that isn't a join or leave
+	OnMessage func(c *Conn, msg Message)
+	// SendBuffer is how many messages may queue for a connection before it is
+	// considered too slow and closed, defaults to 16
+	SendBuffer int
+	// PingInterval defaults to 30 seconds, connections that don't answer
+	// within twice the interval are closed
+	PingInterval time.Duration
+	// MaxMessageSize defaults to 4KB
+	MaxMessageSize int64
+}
+
+func NewHub(p HubParams) *Hub {
+	if p.SendBuffer == 0 {
+		p.SendBuffer = 16
+	}
+	if p.PingInterval == 0 {
+		p.PingInterval = 30 * time.Second
+	}
+	if p.MaxMessageSize == 0 {
+		p.MaxMessageSize = 4 << 10
+	}
+
+	h := &Hub{
+		logger:         p.Logger,
+		allowedOrigins: map[string]bool{},
+		onMessage:      p.OnMessage,
+		sendBuffer:     p.SendBuffer,
+		pingInterval:   p.PingInterval,
+		maxMessageSize: p.MaxMessageSize,
+		conns:          map[*Conn]struct{}{},
+		rooms:          map[string]map[*Conn]struct{}{},
+	}
+
+	for _, origin := range p.AllowedOrigins {
+		h.allowedOrigins[strings.ToLower(strings.TrimSuffix(origin, "/"))] = true
+	}
+
+	h.upgrader = websocket.Upgrader{
+		ReadBufferSize:  1024,
+		WriteBufferSize: 1024,
+		CheckOrigin:     h.checkOrigin,
+	}
+
+	return h
+}
+
+// checkOrigin only lets browsers connect from our own origin or an allowed
+// one. The token cookie is sent with cross site handshakes too, so without
+// this any site could open a connection as the user.
+func (h *Hub) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		// not a browser, there is no ambient cookie to abuse
+		return true
+	}
+
+	if h.allowedOrigins[strings.ToLower(origin)] {
+		return true
+	}
+
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u.Host, r.Host)
+}
+
+// Conn is a single authenticated WebSocket connection.
+type Conn struct {
+	UserID string
+
+	hub   *Hub
+	ws    *websocket.Conn
+	send  chan []byte
+	rooms map[string]struct{}
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// Send queues msg for the connection. A connection whose queue is full is
+// closed rather than blocking the sender.
+func (c *Conn) Send(msg []byte) bool {
+	select {
+	case <-c.done:
+		return false
+	default:
+	}
+
+	select {
+	case c.send <- msg:
+		return true
+	default:
+		c.hub.logger.Warn("Closing slow WebSocket connection", zap.String("user_id", c.UserID))
+		c.close()
+		return false
+	}
+}
+
+func (c *Conn) close() {
+	c.closeOnce.Do(func() { close(c.done) })
+}
+
+// Fragment wraps content in an element that htmx swaps into the element with
+// the given id. The htmx ws extension swaps every message out of band, so this
+// is how a message says where it goes. swap is any hx-swap style except
+// outerHTML, e.g. innerHTML or beforeend.
+func Fragment(id, swap, content string) []byte {
+	return []byte(`<div id="` + html.EscapeString(id) + `" hx-swap-oob="` + html.EscapeString(swap) + `">` + content + `</div>`)
+}
+
+// PresenceID is the id of the element the hub updates with the number of
+// connections in room.
+func PresenceID(room string) string {
+	return "presence-" + room
+}
+
+// ServeHTTP upgrades the request of a logged in user to a WebSocket. Rooms in
+// ?room= are joined straight away; clients join and leave others by sending
+// {"action": "join", "room": "..."} or {"action": "leave", ...}.
+func (h *Hub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	userID := userID(r)
+	if userID == "" {
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	rooms := r.URL.Query()["room"]
+	for _, room := range rooms {
+		if !roomName.MatchString(room) {
+			http.Error(w, "Invalid room", http.StatusBadRequest)
+			return
+		}
+	}
+
+	if !h.track() {
+		http.Error(w, "Server is shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	defer h.wg.Done()
+
+	ws, err := h.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// the upgrader has already written the error response
+		h.logger.Debug("WebSocket upgrade failed", zap.Error(err))
+		return
+	}
+
+	c := &Conn{
+		UserID: userID,
+		hub:    h,
+		ws:     ws,
+		send:   make(chan []byte, h.sendBuffer),
+		rooms:  map[string]struct{}{},
+		done:   make(chan struct{}),
+	}
+
+	if !h.register(c) {
+		ws.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, ""))
+		ws.Close()
+		return
+	}
+	defer h.unregister(c)
+
+	for _, room := range rooms {
+		h.Join(c, room)
+	}
+
+	// connections of a token end with it, server-side sessions have no
+	// expiry known up front
+	var expires time.Time
+	if token, _, err := jwtauth.FromContext(r.Context()); err == nil && token != nil {
+		expires = token.Expiration()
+	}
+
+	go c.writePump(expires)
+	c.readPump()
+}
+
+// readPump handles incoming messages until the connection fails or is closed.
+func (c *Conn) readPump() {
+	defer c.close()
+
+	pongWait := 2 * c.hub.pingInterval
+
+	c.ws.SetReadLimit(c.hub.maxMessageSize)
+	c.ws.SetReadDeadline(time.Now().Add(pongWait))
+	c.ws.SetPongHandler(func(string) error {
+		return c.ws.SetReadDeadline(time.Now().Add(pongWait))
+	})
+
+	for {
+		_, data, err := c.ws.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure, websocket.CloseNoStatusReceived) {
+				c.hub.logger.Debug("WebSocket read failed", zap.Error(err))
+			}
+			return
+		}
+
+		var msg Message
+		if err := json.Unmarshal(data, &msg); err != nil {
+			c.hub.logger.Debug("Ignoring malformed WebSocket message", zap.Error(err))
+			continue
+		}
+		msg.Raw = data
+
+		switch msg.Action {
+		case "join":
+			if roomName.MatchString(msg.Room) {
+				c.hub.Join(c, msg.Room)
+			}
+		case "leave":
+			c.hub.Leave(c, msg.Room)
+		default:
+			if c.hub.onMessage != nil {
+				c.hub.onMessage(c, msg)
+			}
+		}
+	}
+}
+
+// writePump owns all writes to the connection. It closes the connection when
+// the token it was opened with expires, the client has to log in again.
+func (c *Conn) writePump(expires time.Time) {
+	ping := time.NewTicker(c.hub.pingInterval)
+	defer ping.Stop()
+
+	var expired <-chan time.Time
+	if !expires.IsZero() {
+		timer := time.NewTimer(time.Until(expires))
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	defer c.ws.Close()
+
+	writeWait := 10 * time.Second
+
+	for {
+		select {
+		case msg := <-c.send:
+			c.ws.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := c.ws.WriteMessage(websocket.TextMessage, msg); err != nil {
+				c.close()
+				return
+			}
+		case <-ping.C:
+			c.ws.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := c.ws.WriteMessage(websocket.PingMessage, nil); err != nil {
+				c.close()
+				return
+			}
+		case <-expired:
+			c.close()
+			c.writeClose(websocket.ClosePolicyViolation, "session expired")
+			return
+		case <-c.done:
+			c.writeClose(websocket.CloseGoingAway, "")
+			return
+		}
+	}
+}
+
+func (c *Conn) writeClose(code int, text string) {
+	c.ws.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, text), time.Now().Add(time.Second))
+}
+
+func (h *Hub) track() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.closed {
+		return false
+	}
+	h.wg.Add(1)
+	return true
+}
+
+func (h *Hub) register(c *Conn) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.closed {
+		return false
+	}
+	h.conns[c] = struct{}{}
+	h.recordLocked()
+	return true
+}
+
+func (h *Hub) unregister(c *Conn) {
+	h.mu.Lock()
+	delete(h.conns, c)
+	var left []string
+	for room := range c.rooms {
+		h.leaveLocked(c, room)
+		left = append(left, room)
+	}
+	h.recordLocked()
+	h.mu.Unlock()
+
+	for _, room := range left {
+		h.broadcastPresence(room)
+	}
+}
+
+// Join adds c to room and tells the room how many are in it now.
+func (h *Hub) Join(c *Conn, room string) {
+	h.mu.Lock()
+	if _, ok := h.conns[c]; !ok {
+		h.mu.Unlock()
+		return
+	}
+	if h.rooms[room] == nil {
+		h.rooms[room] = map[*Conn]struct{}{}
+	}
+	h.rooms[room][c] = struct{}{}
+	c.rooms[room] = struct{}{}
+	h.recordLocked()
+	h.mu.Unlock()
+
+	h.broadcastPresence(room)
+}
+
+// Leave removes c from room.
+func (h *Hub) Leave(c *Conn, room string) {
+	h.mu.Lock()
+	if _, ok := c.rooms[room]; !ok {
+		h.mu.Unlock()
+		return
+	}
+	h.leaveLocked(c, room)
+	h.recordLocked()
+	h.mu.Unlock()
+
+	h.broadcastPresence(room)
+}
+
+func (h *Hub) leaveLocked(c *Conn, room string) {
+	delete(c.rooms, room)
+	if members, ok := h.rooms[room]; ok {
+		delete(members, c)
+		if len(members) == 0 {
+			delete(h.rooms, room)
+		}
+	}
+}
+
+func (h *Hub) recordLocked() {
+	metrics.SetWebSocketCounts(len(h.conns), len(h.rooms))
+}
+
+func (h *Hub) broadcastPresence(room string) {
+	h.mu.Lock()
+	count := len(h.rooms[room])
+	h.mu.Unlock()
+
+	h.Broadcast(room, Fragment(PresenceID(room), "innerHTML", strconv.Itoa(count)))
+}
+
+// Broadcast sends msg, usually a Fragment, to everyone in room.
+func (h *Hub) Broadcast(room string, msg []byte) {
+	h.mu.Lock()
+	members := make([]*Conn, 0, len(h.rooms[room]))
+	for c := range h.rooms[room] {
+		members = append(members, c)
+	}
+	h.mu.Unlock()
+
+	for _, c := range members {
+		c.Send(msg)
+	}
+}
+
+// SendUser sends msg to every connection of a single user.
+func (h *Hub) SendUser(userID string, msg []byte) {
+	h.mu.Lock()
+	var conns []*Conn
+	for c := range h.conns {
+		if c.UserID == userID {
+			conns = append(conns, c)
+		}
+	}
+	h.mu.Unlock()
+
+	for _, c := range conns {
+		c.Send(msg)
+	}
+}
+
+// Shutdown closes every connection with a going away status and waits for
+// them to finish or for ctx to expire. Hijacked connections aren't tracked by
+// http.Server.Shutdown, so call this alongside it.
+func (h *Hub) Shutdown(ctx context.Context) error {
+	h.mu.Lock()
+	h.closed = true
+	for c := range h.conns {
+		c.close()
+	}
+	h.mu.Unlock()
+
+	finished := make(chan struct{})
+	go func() {
+		h.wg.Wait()
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
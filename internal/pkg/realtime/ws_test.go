@@ -0,0 +1,131 @@
+//go:build unit
+// +build unit
+
+package realtime
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/tomdoestech/goth/internal/pkg/identity"
+	"go.uber.org/zap"
+)
+
+// newTestHubServer serves hub, with the id in the user cookie standing in for
+// the identity middleware.
+func newTestHubServer(t *testing.T, hub *Hub) *httptest.Server {
+	t.Helper()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if cookie, err := r.Cookie("user"); err == nil {
+			r = r.WithContext(identity.NewContext(r.Context(), &identity.Principal{UserID: uuid.MustParse(cookie.Value)}))
+		}
+		hub.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+func dial(t *testing.T, srv *httptest.Server, userID, origin, query string) (*websocket.Conn, *http.Response, error) {
+	t.Helper()
+
+	header := http.Header{}
+	if origin != "" {
+		header.Set("Origin", origin)
+	}
+	if userID != "" {
+		header.Set("Cookie", "user="+userID)
+	}
+
+	return websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+query, header)
+}
+
+func TestHubHandshake(t *testing.T) {
+
+	hub := NewHub(HubParams{Logger: zap.NewNop(), AllowedOrigins: []string{"https://app.example.com"}})
+	srv := newTestHubServer(t, hub)
+
+	user := uuid.NewString()
+
+	tests := []struct {
+		name   string
+		userID string
+		origin string
+		query  string
+		status int
+	}{
+		{name: "same origin", userID: user, origin: srv.URL, status: http.StatusSwitchingProtocols},
+		{name: "allowed origin", userID: user, origin: "https://app.example.com", status: http.StatusSwitchingProtocols},
+		{name: "no origin", userID: user, status: http.StatusSwitchingProtocols},
+		{name: "cross origin", userID: user, origin: "https://evil.example.com", status: http.StatusForbidden},
+		{name: "logged out", origin: srv.URL, status: http.StatusUnauthorized},
+		{name: "invalid room", userID: user, origin: srv.URL, query: "?room=a%20b", status: http.StatusBadRequest},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			conn, resp, _ := dial(t, srv, test.userID, test.origin, test.query)
+			if conn != nil {
+				conn.Close()
+			}
+			if assert.NotNil(t, resp) {
+				assert.Equal(t, test.status, resp.StatusCode)
+			}
+		})
+	}
+}
+
+func TestHubRooms(t *testing.T) {
+
+	assert := assert.New(t)
+
+	hub := NewHub(HubParams{Logger: zap.NewNop()})
+	srv := newTestHubServer(t, hub)
+
+	read := func(conn *websocket.Conn) string {
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		_, msg, err := conn.ReadMessage()
+		assert.NoError(err)
+		return string(msg)
+	}
+
+	alice, _, err := dial(t, srv, uuid.NewString(), "", "?room=lobby")
+	assert.NoError(err)
+	defer alice.Close()
+	assert.Equal(`<div id="presence-lobby" hx-swap-oob="innerHTML">1</div>`, read(alice))
+
+	bobID := uuid.NewString()
+	bob, _, err := dial(t, srv, bobID, "", "")
+	assert.NoError(err)
+	defer bob.Close()
+
+	assert.NoError(bob.WriteJSON(map[string]string{"action": "join", "room": "lobby"}))
+	assert.Equal(`<div id="presence-lobby" hx-swap-oob="innerHTML">2</div>`, read(alice))
+	assert.Equal(`<div id="presence-lobby" hx-swap-oob="innerHTML">2</div>`, read(bob))
+
+	hub.Broadcast("lobby", Fragment("chat", "beforeend", "<p>hi</p>"))
+	assert.Equal(`<div id="chat" hx-swap-oob="beforeend"><p>hi</p></div>`, read(alice))
+	assert.Equal(`<div id="chat" hx-swap-oob="beforeend"><p>hi</p></div>`, read(bob))
+
+	hub.SendUser(bobID, []byte("<p>just bob</p>"))
+	assert.Equal("<p>just bob</p>", read(bob))
+
+	assert.NoError(bob.WriteJSON(map[string]string{"action": "leave", "room": "lobby"}))
+	assert.Equal(`<div id="presence-lobby" hx-swap-oob="innerHTML">1</div>`, read(alice))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	assert.NoError(hub.Shutdown(ctx))
+
+	alice.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, _, err = alice.ReadMessage()
+	assert.True(websocket.IsCloseError(err, websocket.CloseGoingAway))
+}
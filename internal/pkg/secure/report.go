@@ -0,0 +1,58 @@
+package secure
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"go.uber.org/zap"
+)
+
+// maxReportSize bounds violation report bodies, real reports are well under 8KB.
+const maxReportSize = 64 << 10
+
+// ReportHandler logs CSP violation reports, accepting both the report-uri
+// format (application/csp-report) and the Reporting API format
+// (application/reports+json).
+func ReportHandler(logger *zap.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxReportSize))
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		var legacy struct {
+			Report map[string]interface{} `json:"csp-report"`
+		}
+		if err := json.Unmarshal(body, &legacy); err == nil && legacy.Report != nil {
+			logViolation(logger, r, legacy.Report)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		var reports []struct {
+			Type string                 `json:"type"`
+			Body map[string]interface{} `json:"body"`
+		}
+		if err := json.Unmarshal(body, &reports); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		for _, report := range reports {
+			if report.Type == "csp-violation" {
+				logViolation(logger, r, report.Body)
+			}
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func logViolation(logger *zap.Logger, r *http.Request, report map[string]interface{}) {
+	logger.Warn("CSP violation",
+		zap.Any("report", report),
+		zap.String("user_agent", r.UserAgent()),
+	)
+}
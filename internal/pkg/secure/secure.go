@@ -0,0 +1,200 @@
+package secure
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+const (
+	// ScriptNonce and StyleNonce are replaced with the request's nonces in CSP sources
+	ScriptNonce = "{script-nonce}"
+	StyleNonce  = "{style-nonce}"
+)
+
+// Directive is a single Content-Security-Policy directive, e.g. script-src.
+type Directive struct {
+	Name    string
+	Sources []string
+}
+
+type Config struct {
+	CSP []Directive
+	// ReportOnly sends the policy as Content-Security-Policy-Report-Only
+	ReportOnly bool
+	// ReportURI receives violation reports, it is added as report-uri
+	ReportURI string
+
+	// HSTSMaxAge in seconds, zero disables Strict-Transport-Security
+	HSTSMaxAge            int
+	HSTSIncludeSubdomains bool
+	HSTSPreload           bool
+
+	ReferrerPolicy            string
+	PermissionsPolicy         string
+	CrossOriginOpenerPolicy   string
+	CrossOriginEmbedderPolicy string
+}
+
+// DefaultConfig only allows same origin resources and scripts and styles that
+// carry the request's nonce. The style hash is for the inline style tag htmx adds.
+func DefaultConfig() Config {
+	return Config{
+		CSP: []Directive{
+			{"default-src", []string{"'self'"}},
+			{"script-src", []string{"'nonce-" + ScriptNonce + "'"}},
+			{"style-src", []string{"'nonce-" + StyleNonce + "'", "'sha256-d7rFBVhb3n/Drrf+EpNWYdITkos3kQRFpB0oSOycXg4='"}},
+			{"img-src", []string{"'self'", "data:"}},
+			{"object-src", []string{"'none'"}},
+			{"base-uri", []string{"'self'"}},
+			{"form-action", []string{"'self'"}},
+			{"frame-ancestors", []string{"'none'"}},
+		},
+		ReportURI:                 "/csp-report",
+		HSTSMaxAge:                63072000,
+		HSTSIncludeSubdomains:     true,
+		ReferrerPolicy:            "strict-origin-when-cross-origin",
+		PermissionsPolicy:         "camera=(), microphone=(), geolocation=(), payment=()",
+		CrossOriginOpenerPolicy:   "same-origin",
+		CrossOriginEmbedderPolicy: "require-corp",
+	}
+}
+
+// directiveName matches the names of CSP directives, such as img-src.
+var directiveName = regexp.MustCompile(`^[a-z][a-z-]*$`)
+
+// ParseDirectives parses directives written as in a policy, such as
+// "img-src 'self' data:; connect-src 'self'".
+func ParseDirectives(policy string) ([]Directive, error) {
+	var directives []Directive
+	for _, part := range strings.Split(policy, ";") {
+		fields := strings.Fields(part)
+		if len(fields) == 0 {
+			continue
+		}
+		if !directiveName.MatchString(fields[0]) {
+			return nil, fmt.Errorf("invalid CSP directive %q", fields[0])
+		}
+		directives = append(directives, Directive{Name: fields[0], Sources: fields[1:]})
+	}
+	return directives, nil
+}
+
+// Set replaces the directive of the same name, or adds d when there is none.
+func (c *Config) Set(d Directive) {
+	for i := range c.CSP {
+		if c.CSP[i].Name == d.Name {
+			c.CSP[i] = d
+			return
+		}
+	}
+	c.CSP = append(c.CSP, d)
+}
+
+type contextKey struct{}
+
+type nonces struct {
+	script string
+	style  string
+}
+
+// Nonces returns the script and style nonces generated for the request, they
+// are empty when the middleware did not run.
+func Nonces(ctx context.Context) (script string, style string) {
+	n, _ := ctx.Value(contextKey{}).(nonces)
+	return n.script, n.style
+}
+
+// Middleware generates fresh nonces for every request, stores them in the
+// request context for templates and sets the security headers.
+func Middleware(conf Config) func(next http.Handler) http.Handler {
+	policy := buildPolicy(conf)
+
+	cspHeader := "Content-Security-Policy"
+	if conf.ReportOnly {
+		cspHeader = "Content-Security-Policy-Report-Only"
+	}
+
+	hsts := ""
+	if conf.HSTSMaxAge > 0 {
+		hsts = fmt.Sprintf("max-age=%d", conf.HSTSMaxAge)
+		if conf.HSTSIncludeSubdomains {
+			hsts += "; includeSubDomains"
+		}
+		if conf.HSTSPreload {
+			hsts += "; preload"
+		}
+	}
+
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			script, err := generateNonce()
+			if err != nil {
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+
+			style, err := generateNonce()
+			if err != nil {
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+
+			h := w.Header()
+			h.Set(cspHeader, strings.NewReplacer(ScriptNonce, script, StyleNonce, style).Replace(policy))
+			h.Set("X-Content-Type-Options", "nosniff")
+
+			if hsts != "" && isHTTPS(r) {
+				h.Set("Strict-Transport-Security", hsts)
+			}
+			if conf.ReferrerPolicy != "" {
+				h.Set("Referrer-Policy", conf.ReferrerPolicy)
+			}
+			if conf.PermissionsPolicy != "" {
+				h.Set("Permissions-Policy", conf.PermissionsPolicy)
+			}
+			if conf.CrossOriginOpenerPolicy != "" {
+				h.Set("Cross-Origin-Opener-Policy", conf.CrossOriginOpenerPolicy)
+			}
+			if conf.CrossOriginEmbedderPolicy != "" {
+				h.Set("Cross-Origin-Embedder-Policy", conf.CrossOriginEmbedderPolicy)
+			}
+
+			ctx := context.WithValue(r.Context(), contextKey{}, nonces{script: script, style: style})
+			next.ServeHTTP(w, r.WithContext(ctx))
+		}
+		return http.HandlerFunc(fn)
+	}
+}
+
+func buildPolicy(conf Config) string {
+	directives := make([]string, 0, len(conf.CSP)+1)
+	for _, d := range conf.CSP {
+		directives = append(directives, strings.TrimSpace(d.Name+" "+strings.Join(d.Sources, " ")))
+	}
+
+	if conf.ReportURI != "" {
+		directives = append(directives, "report-uri "+conf.ReportURI)
+	}
+
+	return strings.Join(directives, "; ")
+}
+
+// generateNonce returns 128 random bits, the minimum the CSP spec recommends.
+func generateNonce() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(b), nil
+}
+
+// isHTTPS reports whether the client connected over TLS, directly or through a
+// proxy. Browsers ignore HSTS sent over plain http.
+func isHTTPS(r *http.Request) bool {
+	return r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https"
+}
@@ -0,0 +1,146 @@
+//go:build unit
+// +build unit
+
+package secure
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestNonces(t *testing.T) {
+	var script, style string
+	handler := Middleware(DefaultConfig())(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		script, style = Nonces(r.Context())
+	}))
+
+	serve := func() string {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+		return w.Header().Get("Content-Security-Policy")
+	}
+
+	policy := serve()
+	first := script
+	assert.Len(t, first, 24, "128 bits in base64")
+	assert.NotEqual(t, script, style)
+	assert.Contains(t, policy, "script-src 'nonce-"+script+"'")
+	assert.Contains(t, policy, "style-src 'nonce-"+style+"'")
+	assert.NotContains(t, policy, ScriptNonce)
+
+	serve()
+	assert.NotEqual(t, first, script, "a fresh nonce per request")
+
+	script, style = Nonces(httptest.NewRequest("GET", "/", nil).Context())
+	assert.Empty(t, script)
+	assert.Empty(t, style)
+}
+
+func TestHeaders(t *testing.T) {
+	serve := func(conf Config, req *http.Request) http.Header {
+		w := httptest.NewRecorder()
+		Middleware(conf)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(w, req)
+		return w.Header()
+	}
+
+	conf := DefaultConfig()
+
+	t.Run("HSTS only over https", func(t *testing.T) {
+		h := serve(conf, httptest.NewRequest("GET", "/", nil))
+		assert.Empty(t, h.Get("Strict-Transport-Security"))
+
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("X-Forwarded-Proto", "https")
+		h = serve(conf, req)
+		assert.Equal(t, "max-age=63072000; includeSubDomains", h.Get("Strict-Transport-Security"))
+
+		req = httptest.NewRequest("GET", "/", nil)
+		req.TLS = &tls.ConnectionState{}
+		preload := conf
+		preload.HSTSPreload = true
+		h = serve(preload, req)
+		assert.Equal(t, "max-age=63072000; includeSubDomains; preload", h.Get("Strict-Transport-Security"))
+
+		off := conf
+		off.HSTSMaxAge = 0
+		assert.Empty(t, serve(off, req).Get("Strict-Transport-Security"))
+	})
+
+	t.Run("report only", func(t *testing.T) {
+		h := serve(conf, httptest.NewRequest("GET", "/", nil))
+		assert.NotEmpty(t, h.Get("Content-Security-Policy"))
+		assert.Empty(t, h.Get("Content-Security-Policy-Report-Only"))
+		assert.True(t, strings.HasSuffix(h.Get("Content-Security-Policy"), "; report-uri /csp-report"))
+
+		reportOnly := conf
+		reportOnly.ReportOnly = true
+		h = serve(reportOnly, httptest.NewRequest("GET", "/", nil))
+		assert.Empty(t, h.Get("Content-Security-Policy"))
+		assert.NotEmpty(t, h.Get("Content-Security-Policy-Report-Only"))
+	})
+
+	t.Run("configured directives and headers", func(t *testing.T) {
+		directives, err := ParseDirectives("img-src 'self' https://cdn.example.com ; connect-src 'self' wss:;")
+		if assert.NoError(t, err) && assert.Len(t, directives, 2) {
+			assert.Equal(t, Directive{"img-src", []string{"'self'", "https://cdn.example.com"}}, directives[0])
+		}
+		_, err = ParseDirectives("img-src 'self'; 'self'")
+		assert.Error(t, err)
+
+		custom := DefaultConfig()
+		for _, d := range directives {
+			custom.Set(d)
+		}
+		custom.PermissionsPolicy = "camera=(self)"
+		custom.CrossOriginEmbedderPolicy = ""
+
+		h := serve(custom, httptest.NewRequest("GET", "/", nil))
+		policy := h.Get("Content-Security-Policy")
+		assert.Contains(t, policy, "img-src 'self' https://cdn.example.com;")
+		assert.NotContains(t, policy, "data:", "the directive is replaced")
+		assert.Contains(t, policy, "connect-src 'self' wss:")
+		assert.Equal(t, "camera=(self)", h.Get("Permissions-Policy"))
+		assert.Equal(t, "same-origin", h.Get("Cross-Origin-Opener-Policy"))
+		assert.Empty(t, h.Values("Cross-Origin-Embedder-Policy"))
+	})
+}
+
+func TestReportHandler(t *testing.T) {
+	core, logs := observer.New(zapcore.WarnLevel)
+	handler := ReportHandler(zap.New(core))
+
+	post := func(contentType, body string) int {
+		req := httptest.NewRequest("POST", "/csp-report", strings.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		req.Header.Set("User-Agent", "test-browser")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusNoContent, post("application/csp-report", `{"csp-report":{"violated-directive":"script-src"}}`))
+	assert.Equal(t, http.StatusNoContent, post("application/reports+json",
+		`[{"type":"csp-violation","body":{"effectiveDirective":"img-src"}},{"type":"deprecation","body":{}}]`))
+
+	entries := logs.FilterMessage("CSP violation").All()
+	if assert.Len(t, entries, 2, "other report types aren't logged") {
+		assert.Equal(t, map[string]interface{}{"violated-directive": "script-src"}, entries[0].ContextMap()["report"])
+		assert.Equal(t, "test-browser", entries[0].ContextMap()["user_agent"])
+		assert.Equal(t, map[string]interface{}{"effectiveDirective": "img-src"}, entries[1].ContextMap()["report"])
+	}
+
+	assert.Equal(t, http.StatusBadRequest, post("application/csp-report", "not json"))
+
+	// a valid report padded past the limit is cut off, so no longer valid
+	huge := `{"csp-report":{"violated-directive":"script-src","sample":"` + strings.Repeat("a", maxReportSize) + `"}}`
+	assert.Equal(t, http.StatusBadRequest, post("application/csp-report", huge))
+	assert.Equal(t, 2, logs.FilterMessage("CSP violation").Len())
+}
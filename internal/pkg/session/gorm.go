@@ -0,0 +1,74 @@
+package session
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type sessionModel struct {
+	ID        string `gorm:"primaryKey"`
+	Data      []byte
+	CreatedAt time.Time
+	ExpiresAt time.Time `gorm:"index"`
+}
+
+func (sessionModel) TableName() string {
+	return "sessions"
+}
+
+// GormStore keeps sessions in the sessions table, shared by every instance
+// using the database.
+type GormStore struct {
+	db *gorm.DB
+}
+
+func NewGormStore(db *gorm.DB) (*GormStore, error) {
+	if err := db.AutoMigrate(&sessionModel{}); err != nil {
+		return nil, err
+	}
+	return &GormStore{db: db}, nil
+}
+
+func (s *GormStore) Load(ctx context.Context, id string) (*Record, error) {
+	var m sessionModel
+	err := s.db.WithContext(ctx).Where("id = ?", id).First(&m).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Record{ID: m.ID, CreatedAt: m.CreatedAt, ExpiresAt: m.ExpiresAt}
+	if err := json.Unmarshal(m.Data, &r.Data); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (s *GormStore) Save(ctx context.Context, r *Record) error {
+	data, err := json.Marshal(r.Data)
+	if err != nil {
+		return err
+	}
+
+	return s.db.WithContext(ctx).Save(&sessionModel{
+		ID:        r.ID,
+		Data:      data,
+		CreatedAt: r.CreatedAt,
+		ExpiresAt: r.ExpiresAt,
+	}).Error
+}
+
+func (s *GormStore) Delete(ctx context.Context, id string) error {
+	return s.db.WithContext(ctx).Where("id = ?", id).Delete(&sessionModel{}).Error
+}
+
+func (s *GormStore) DeleteExpired(ctx context.Context, now time.Time) (int, error) {
+	result := s.db.WithContext(ctx).Where("expires_at < ?", now).Delete(&sessionModel{})
+	return int(result.RowsAffected), result.Error
+}
@@ -0,0 +1,393 @@
+// Package session keeps browser sessions on the server. The cookie holds only
+// a random id; the data lives in a Store, so it isn't visible to the browser
+// and deleting it ends the session.
+package session
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// touchInterval is how often the expiry of a session that is only read is
+// pushed back, rather than writing to the store on every request.
+const touchInterval = time.Minute
+
+type Params struct {
+	Store Store
+	// CookieName defaults to "session"
+	CookieName string
+	// IdleTimeout ends sessions that aren't used for this long, defaults to
+	// 24 hours. Every request pushes it back.
+	IdleTimeout time.Duration
+	// AbsoluteTimeout ends sessions this long after they started however
+	// much they are used, 0 never
+	AbsoluteTimeout time.Duration
+	// GCInterval is how often Run deletes expired sessions, defaults to 10
+	// minutes
+	GCInterval time.Duration
+	Logger     *zap.Logger
+}
+
+// Manager loads the session of each request and saves it once the handler
+// has written its response.
+type Manager struct {
+	store           Store
+	cookieName      string
+	idleTimeout     time.Duration
+	absoluteTimeout time.Duration
+	gcInterval      time.Duration
+	logger          *zap.Logger
+	now             func() time.Time
+}
+
+func New(p Params) *Manager {
+	if p.CookieName == "" {
+		p.CookieName = "session"
+	}
+	if p.IdleTimeout <= 0 {
+		p.IdleTimeout = 24 * time.Hour
+	}
+	if p.GCInterval <= 0 {
+		p.GCInterval = 10 * time.Minute
+	}
+	if p.Logger == nil {
+		p.Logger = zap.NewNop()
+	}
+
+	return &Manager{
+		store:           p.Store,
+		cookieName:      p.CookieName,
+		idleTimeout:     p.IdleTimeout,
+		absoluteTimeout: p.AbsoluteTimeout,
+		gcInterval:      p.GCInterval,
+		logger:          p.Logger,
+		now:             time.Now,
+	}
+}
+
+// Session is the data of one browser's session. Handlers get it with
+// FromContext. A session is only stored once something is set in it.
+//
+// Parallel requests of the same browser each load their own copy, and the
+// last one to finish wins.
+type Session struct {
+	mu sync.Mutex
+
+	id        string
+	data      map[string]json.RawMessage
+	createdAt time.Time
+	expiresAt time.Time
+
+	// stored is the id the session was loaded with, deleted when the id
+	// changes
+	stored    string
+	changed   bool
+	renewed   bool
+	destroyed bool
+}
+
+type contextKey struct{}
+
+// FromContext returns the session of the request, nil outside of the
+// middleware.
+func FromContext(ctx context.Context) *Session {
+	s, _ := ctx.Value(contextKey{}).(*Session)
+	return s
+}
+
+// NewContext returns a copy of ctx carrying s, for tests of handlers.
+func NewContext(ctx context.Context, s *Session) context.Context {
+	return context.WithValue(ctx, contextKey{}, s)
+}
+
+// Get decodes the value of key into v, reporting whether it was there.
+func (s *Session) Get(key string, v interface{}) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	raw, ok := s.data[key]
+	if !ok {
+		return false
+	}
+	return json.Unmarshal(raw, v) == nil
+}
+
+// GetString returns the string value of key, "" if there is none.
+func (s *Session) GetString(key string) string {
+	var v string
+	s.Get(key, &v)
+	return v
+}
+
+// Set stores v, which must encode to JSON, under key.
+func (s *Session) Set(key string, v interface{}) error {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.data == nil {
+		s.data = map[string]json.RawMessage{}
+	}
+	s.data[key] = raw
+	s.changed = true
+	s.destroyed = false
+	return nil
+}
+
+// Remove deletes key.
+func (s *Session) Remove(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.data[key]; ok {
+		delete(s.data, key)
+		s.changed = true
+	}
+}
+
+// RenewID gives the session a new id, keeping its data. Call it whenever
+// the user's privileges change, logging in above all, so an id planted in
+// the browser before can't be used to ride along.
+func (s *Session) RenewID() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.renewed = true
+	s.changed = true
+}
+
+// Destroy deletes the session and its cookie.
+func (s *Session) Destroy() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.data = nil
+	s.destroyed = true
+	s.changed = false
+}
+
+// storeID is the id a session is stored under, a hash of the cookie value.
+func storeID(id string) string {
+	sum := sha256.Sum256([]byte(id))
+	return hex.EncodeToString(sum[:])
+}
+
+func newID() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// load returns the session of the cookie value, or a new empty session.
+func (m *Manager) load(ctx context.Context, cookie string) *Session {
+	now := m.now()
+	s := &Session{createdAt: now}
+
+	if cookie == "" {
+		return s
+	}
+
+	r, err := m.store.Load(ctx, storeID(cookie))
+	if err != nil {
+		if !errors.Is(err, ErrNotFound) {
+			m.logger.Error("Error loading session", zap.Error(err))
+		}
+		return s
+	}
+	if !now.Before(r.ExpiresAt) {
+		return s
+	}
+
+	s.id = cookie
+	s.stored = cookie
+	s.data = r.Data
+	s.createdAt = r.CreatedAt
+	s.expiresAt = r.ExpiresAt
+	return s
+}
+
+// save writes the session to the store and sets or clears the cookie as
+// needed.
+func (m *Manager) save(w http.ResponseWriter, r *http.Request, s *Session) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ctx := r.Context()
+	now := m.now()
+
+	if s.destroyed {
+		if s.stored != "" {
+			if err := m.store.Delete(ctx, storeID(s.stored)); err != nil {
+				m.logger.Error("Error deleting session", zap.Error(err))
+			}
+		}
+		if _, err := r.Cookie(m.cookieName); err == nil {
+			http.SetCookie(w, &http.Cookie{Name: m.cookieName, Value: "", Path: "/", Expires: time.Unix(0, 0), MaxAge: -1})
+		}
+		return
+	}
+
+	// sliding expiry, without writing for every request
+	touch := s.id != "" && s.expiresAt.Sub(now) < m.idleTimeout-touchInterval
+	if !s.changed && !touch {
+		return
+	}
+	if s.id == "" && len(s.data) == 0 {
+		return
+	}
+
+	if s.id == "" || s.renewed {
+		id, err := newID()
+		if err != nil {
+			m.logger.Error("Error generating session id", zap.Error(err))
+			return
+		}
+		s.id = id
+	}
+
+	s.expiresAt = now.Add(m.idleTimeout)
+	if m.absoluteTimeout > 0 && s.createdAt.Add(m.absoluteTimeout).Before(s.expiresAt) {
+		s.expiresAt = s.createdAt.Add(m.absoluteTimeout)
+	}
+
+	err := m.store.Save(ctx, &Record{
+		ID:        storeID(s.id),
+		Data:      s.data,
+		CreatedAt: s.createdAt,
+		ExpiresAt: s.expiresAt,
+	})
+	if err != nil {
+		m.logger.Error("Error saving session", zap.Error(err))
+		return
+	}
+
+	if s.stored != "" && s.stored != s.id {
+		if err := m.store.Delete(ctx, storeID(s.stored)); err != nil {
+			m.logger.Error("Error deleting renewed session", zap.Error(err))
+		}
+	}
+
+	if s.stored != s.id {
+		// no Expires, closing the browser ends the session like the token cookie
+		http.SetCookie(w, &http.Cookie{
+			Name:     m.cookieName,
+			Value:    s.id,
+			Path:     "/",
+			HttpOnly: true,
+			Secure:   r.TLS != nil,
+			SameSite: http.SameSiteLaxMode,
+		})
+	}
+
+	s.stored = s.id
+	s.changed = false
+	s.renewed = false
+}
+
+// Middleware puts the request's session in its context and saves it just
+// before the response is written.
+func (m *Manager) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var value string
+		if cookie, err := r.Cookie(m.cookieName); err == nil {
+			value = cookie.Value
+		}
+
+		s := m.load(r.Context(), value)
+		sw := &responseWriter{ResponseWriter: w, save: func() { m.save(w, r, s) }}
+
+		next.ServeHTTP(sw, r.WithContext(NewContext(r.Context(), s)))
+
+		sw.commit()
+	})
+}
+
+// Run deletes expired sessions every GCInterval until ctx is done.
+func (m *Manager) Run(ctx context.Context) {
+	ticker := time.NewTicker(m.gcInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		n, err := m.store.DeleteExpired(ctx, m.now())
+		if err != nil {
+			m.logger.Error("Error deleting expired sessions", zap.Error(err))
+			continue
+		}
+		if n > 0 {
+			m.logger.Debug("Deleted expired sessions", zap.Int("count", n))
+		}
+	}
+}
+
+// responseWriter saves the session when the headers are about to be sent,
+// the last moment the cookie can still be set.
+type responseWriter struct {
+	http.ResponseWriter
+	save      func()
+	committed bool
+}
+
+func (sw *responseWriter) commit() {
+	if sw.committed {
+		return
+	}
+	sw.committed = true
+	sw.save()
+}
+
+func (sw *responseWriter) WriteHeader(status int) {
+	// informational responses go straight through
+	if status < 100 || status >= 200 {
+		sw.commit()
+	}
+	sw.ResponseWriter.WriteHeader(status)
+}
+
+func (sw *responseWriter) Write(b []byte) (int, error) {
+	sw.commit()
+	return sw.ResponseWriter.Write(b)
+}
+
+func (sw *responseWriter) Flush() {
+	sw.commit()
+	if f, ok := sw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (sw *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	sw.commit()
+	if hj, ok := sw.ResponseWriter.(http.Hijacker); ok {
+		return hj.Hijack()
+	}
+	return nil, nil, errors.New("session: underlying ResponseWriter does not implement http.Hijacker")
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (sw *responseWriter) Unwrap() http.ResponseWriter {
+	return sw.ResponseWriter
+}
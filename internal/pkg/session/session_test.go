@@ -0,0 +1,151 @@
+//go:build unit
+// +build unit
+
+package session
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func stores(t *testing.T) map[string]Store {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	gormStore, err := NewGormStore(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileStore, err := NewFileStore(filepath.Join(t.TempDir(), "sessions"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return map[string]Store{
+		"memory": NewMemoryStore(),
+		"gorm":   gormStore,
+		"file":   fileStore,
+	}
+}
+
+func TestStores(t *testing.T) {
+	for name, store := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+			ctx := context.Background()
+			now := time.Now().UTC().Truncate(time.Second)
+
+			_, err := store.Load(ctx, "abc123")
+			assert.ErrorIs(err, ErrNotFound)
+			_, err = store.Load(ctx, "../../etc/passwd")
+			assert.ErrorIs(err, ErrNotFound)
+
+			live := &Record{ID: "aaaa", Data: map[string]json.RawMessage{"user_id": []byte(`"42"`)}, CreatedAt: now, ExpiresAt: now.Add(time.Hour)}
+			expired := &Record{ID: "bbbb", Data: map[string]json.RawMessage{}, CreatedAt: now, ExpiresAt: now.Add(-time.Hour)}
+			assert.NoError(store.Save(ctx, live))
+			assert.NoError(store.Save(ctx, expired))
+
+			loaded, err := store.Load(ctx, "aaaa")
+			if assert.NoError(err) {
+				assert.JSONEq(`"42"`, string(loaded.Data["user_id"]))
+				assert.True(loaded.ExpiresAt.Equal(live.ExpiresAt))
+			}
+
+			n, err := store.DeleteExpired(ctx, now)
+			assert.NoError(err)
+			assert.Equal(1, n)
+
+			_, err = store.Load(ctx, "bbbb")
+			assert.ErrorIs(err, ErrNotFound)
+
+			assert.NoError(store.Delete(ctx, "aaaa"))
+			assert.NoError(store.Delete(ctx, "aaaa"), "deleting twice is fine")
+			_, err = store.Load(ctx, "aaaa")
+			assert.ErrorIs(err, ErrNotFound)
+		})
+	}
+}
+
+func TestManager(t *testing.T) {
+	assert := assert.New(t)
+
+	now := time.Now()
+	m := New(Params{Store: NewMemoryStore(), IdleTimeout: time.Hour, AbsoluteTimeout: 3 * time.Hour})
+	m.now = func() time.Time { return now }
+
+	handler := m.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s := FromContext(r.Context())
+		switch r.URL.Path {
+		case "/login":
+			s.RenewID()
+			s.Set("user_id", "42")
+		case "/logout":
+			s.Destroy()
+		}
+		w.Write([]byte(s.GetString("user_id")))
+	}))
+
+	do := func(path, cookie string) (string, string) {
+		req := httptest.NewRequest("GET", path, nil)
+		if cookie != "" {
+			req.AddCookie(&http.Cookie{Name: "session", Value: cookie})
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		for _, c := range w.Result().Cookies() {
+			if c.Name == "session" {
+				cookie = c.Value
+			}
+		}
+		return w.Body.String(), cookie
+	}
+
+	body, cookie := do("/", "")
+	assert.Empty(body)
+	assert.Empty(cookie, "nothing is stored until something is set")
+
+	planted := "planted-by-an-attacker"
+	body, cookie = do("/login", planted)
+	assert.Equal("42", body)
+	assert.NotEqual(planted, cookie, "logging in gets a new id")
+
+	first := cookie
+	body, cookie = do("/login", cookie)
+	assert.Equal("42", body)
+	assert.NotEqual(first, cookie)
+	body, _ = do("/", first)
+	assert.Empty(body, "the old id no longer works")
+
+	// every request pushes the idle timeout back, up to the absolute timeout
+	for i := 0; i < 4; i++ {
+		now = now.Add(50 * time.Minute)
+		body, _ = do("/", cookie)
+		if i < 3 {
+			assert.Equal("42", body)
+		} else {
+			assert.Empty(body, "sessions end after the absolute timeout")
+		}
+	}
+
+	_, cookie = do("/login", "")
+	now = now.Add(2 * time.Hour)
+	body, _ = do("/", cookie)
+	assert.Empty(body, "idle sessions expire")
+
+	_, cookie = do("/login", "")
+	_, cleared := do("/logout", cookie)
+	assert.Empty(cleared)
+	body, _ = do("/", cookie)
+	assert.Empty(body)
+}
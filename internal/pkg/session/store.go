@@ -0,0 +1,94 @@
+package session
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrNotFound is returned by stores for sessions they don't have.
+var ErrNotFound = errors.New("session not found")
+
+// Record is a session as it is stored. ID is a hash of the id in the cookie,
+// so whoever can read the store can't take over sessions.
+type Record struct {
+	ID        string                     `json:"id"`
+	Data      map[string]json.RawMessage `json:"data"`
+	CreatedAt time.Time                  `json:"created_at"`
+	ExpiresAt time.Time                  `json:"expires_at"`
+}
+
+func (r *Record) clone() *Record {
+	c := *r
+	c.Data = make(map[string]json.RawMessage, len(r.Data))
+	for k, v := range r.Data {
+		c.Data[k] = append(json.RawMessage(nil), v...)
+	}
+	return &c
+}
+
+// Store keeps sessions on the server. Load may return expired records, the
+// manager checks ExpiresAt itself.
+type Store interface {
+	Load(ctx context.Context, id string) (*Record, error)
+	// Save creates or replaces the record
+	Save(ctx context.Context, r *Record) error
+	// Delete removes the record, deleting one that doesn't exist is not an error
+	Delete(ctx context.Context, id string) error
+	// DeleteExpired removes records that expired before now, returning how many
+	DeleteExpired(ctx context.Context, now time.Time) (int, error)
+}
+
+// MemoryStore keeps sessions in memory. They are lost on restart and not
+// shared between instances, which suits development and tests.
+type MemoryStore struct {
+	mu      sync.Mutex
+	records map[string]*Record
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{records: map[string]*Record{}}
+}
+
+func (s *MemoryStore) Load(ctx context.Context, id string) (*Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, ok := s.records[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return r.clone(), nil
+}
+
+func (s *MemoryStore) Save(ctx context.Context, r *Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.records[r.ID] = r.clone()
+	return nil
+}
+
+func (s *MemoryStore) Delete(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.records, id)
+	return nil
+}
+
+func (s *MemoryStore) DeleteExpired(ctx context.Context, now time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := 0
+	for id, r := range s.records {
+		if r.ExpiresAt.Before(now) {
+			delete(s.records, id)
+			n++
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,101 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	_ "image/gif"
+	_ "image/jpeg"
+	"image/png"
+)
+
+// ImageTypes are the content types Thumbnail decodes.
+var ImageTypes = []string{"image/jpeg", "image/png", "image/gif"}
+
+// ErrInvalidImage is returned by Thumbnail for data it can't decode, or
+// images too large to decode safely.
+var ErrInvalidImage = errors.New("invalid image")
+
+// maxPixels keeps small files that decode to huge images from exhausting
+// memory.
+const maxPixels = 40_000_000
+
+// Thumbnail crops the middle square of an image and scales it to size by
+// size pixels, encoded as PNG.
+func Thumbnail(data []byte, size int) ([]byte, error) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidImage, err)
+	}
+	if config.Width*config.Height > maxPixels {
+		return nil, fmt.Errorf("%w: %dx%d pixels", ErrInvalidImage, config.Width, config.Height)
+	}
+
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidImage, err)
+	}
+
+	bounds := src.Bounds()
+	side := bounds.Dx()
+	if bounds.Dy() < side {
+		side = bounds.Dy()
+	}
+	if side == 0 {
+		return nil, ErrInvalidImage
+	}
+
+	// copy the square to RGBA first, draw has fast paths for the decoders'
+	// image types
+	origin := image.Pt(bounds.Min.X+(bounds.Dx()-side)/2, bounds.Min.Y+(bounds.Dy()-side)/2)
+	square := image.NewRGBA(image.Rect(0, 0, side, side))
+	draw.Draw(square, square.Bounds(), src, origin, draw.Src)
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, scale(square, size)); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// scale resizes a square image to size by size, averaging the source pixels
+// that fall in each destination pixel.
+func scale(src *image.RGBA, size int) *image.RGBA {
+	side := src.Bounds().Dx()
+	dst := image.NewRGBA(image.Rect(0, 0, size, size))
+
+	span := func(i int) (int, int) {
+		from, to := i*side/size, (i+1)*side/size
+		if to <= from {
+			to = from + 1
+		}
+		return from, to
+	}
+
+	for dy := 0; dy < size; dy++ {
+		y0, y1 := span(dy)
+		for dx := 0; dx < size; dx++ {
+			x0, x1 := span(dx)
+
+			var sum [4]int
+			for y := y0; y < y1; y++ {
+				row := src.Pix[y*src.Stride+x0*4 : y*src.Stride+x1*4]
+				for i := 0; i < len(row); i += 4 {
+					sum[0] += int(row[i])
+					sum[1] += int(row[i+1])
+					sum[2] += int(row[i+2])
+					sum[3] += int(row[i+3])
+				}
+			}
+
+			n := (x1 - x0) * (y1 - y0)
+			o := dy*dst.Stride + dx*4
+			for c := 0; c < 4; c++ {
+				dst.Pix[o+c] = uint8((sum[c] + n/2) / n)
+			}
+		}
+	}
+	return dst
+}
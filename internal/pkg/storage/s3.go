@@ -0,0 +1,185 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// maxPresign is the longest S3 accepts presigned links for.
+const maxPresign = 7 * 24 * time.Hour
+
+// S3Store keeps blobs in a bucket of S3 or a service compatible with it,
+// such as MinIO or R2. Links are presigned, so downloads go straight to the
+// bucket, unless streamed through the app, see S3Params.Links.
+type S3Store struct {
+	endpoint *url.URL
+	bucket   string
+	signer   sigV4
+	links    *Signer
+	client   *http.Client
+	now      func() time.Time
+}
+
+type S3Params struct {
+	// Endpoint is the URL of the API, such as
+	// https://s3.eu-west-1.amazonaws.com. Buckets are addressed in the path.
+	Endpoint        string
+	Region          string
+	Bucket          string
+	AccessKeyID     string
+	SecretAccessKey string
+	// Links, when set, signs the links URL returns instead of S3, for the
+	// app's Handler to stream the blobs from the bucket. Pages whose
+	// Cross-Origin-Embedder-Policy is require-corp can only show those, the
+	// bucket doesn't send Cross-Origin-Resource-Policy.
+	Links *Signer
+	// Client defaults to one with a 30 second timeout
+	Client *http.Client
+}
+
+func NewS3Store(p S3Params) (*S3Store, error) {
+	endpoint, err := url.Parse(strings.TrimSuffix(p.Endpoint, "/"))
+	if err != nil || endpoint.Host == "" || (endpoint.Scheme != "http" && endpoint.Scheme != "https") {
+		return nil, fmt.Errorf("invalid S3 endpoint %q", p.Endpoint)
+	}
+	if p.Bucket == "" {
+		return nil, fmt.Errorf("no S3 bucket")
+	}
+	if p.Region == "" {
+		p.Region = "us-east-1"
+	}
+	if p.Client == nil {
+		p.Client = &http.Client{Timeout: 30 * time.Second}
+	}
+
+	return &S3Store{
+		endpoint: endpoint,
+		bucket:   p.Bucket,
+		signer: sigV4{
+			accessKeyID:     p.AccessKeyID,
+			secretAccessKey: p.SecretAccessKey,
+			region:          p.Region,
+			service:         "s3",
+		},
+		links:  p.Links,
+		client: p.Client,
+		now:    time.Now,
+	}, nil
+}
+
+func (s *S3Store) url(key string) *url.URL {
+	u := *s.endpoint
+	u.Path = u.Path + "/" + s.bucket + "/" + key
+	return &u
+}
+
+// do signs and sends a request for key. The body is read into memory to
+// hash it, blobs are uploads the app already limits.
+func (s *S3Store) do(ctx context.Context, method, key string, body []byte, header http.Header) (*http.Response, error) {
+	if !ValidKey(key) {
+		return nil, ErrInvalidKey
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, s.url(key).String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+
+	hash := emptyPayloadHash
+	if len(body) > 0 {
+		sum := sha256.Sum256(body)
+		hash = hex.EncodeToString(sum[:])
+	}
+	s.signer.sign(req, hash, s.now())
+
+	return s.client.Do(req)
+}
+
+func (s *S3Store) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	body, err := io.ReadAll(io.LimitReader(r, size))
+	if err != nil {
+		return err
+	}
+
+	header := http.Header{}
+	header.Set("Content-Type", contentType)
+
+	res, err := s.do(ctx, http.MethodPut, key, body, header)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return s3Error(res, "putting", key)
+	}
+	return nil
+}
+
+func (s *S3Store) Get(ctx context.Context, key string) (*Blob, error) {
+	res, err := s.do(ctx, http.MethodGet, key, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode == http.StatusNotFound {
+		res.Body.Close()
+		return nil, ErrNotFound
+	}
+	if res.StatusCode != http.StatusOK {
+		defer res.Body.Close()
+		return nil, s3Error(res, "getting", key)
+	}
+
+	return &Blob{ReadCloser: res.Body, ContentType: res.Header.Get("Content-Type"), Size: res.ContentLength}, nil
+}
+
+func (s *S3Store) Delete(ctx context.Context, key string) error {
+	res, err := s.do(ctx, http.MethodDelete, key, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusOK, http.StatusNoContent, http.StatusNotFound:
+		return nil
+	}
+	return s3Error(res, "deleting", key)
+}
+
+func (s *S3Store) URL(ctx context.Context, key string, ttl time.Duration) (string, error) {
+	if !ValidKey(key) {
+		return "", ErrInvalidKey
+	}
+	if s.links != nil {
+		return s.links.URL(key, ttl), nil
+	}
+	if ttl > maxPresign/2 {
+		ttl = maxPresign / 2
+	}
+
+	// signed at the start of the window, so the link stays the same
+	now := s.now().Truncate(ttl)
+	expires := window(s.now(), ttl).Sub(now)
+
+	u := s.url(key)
+	s.signer.presign(http.MethodGet, u, now, expires)
+	return u.String(), nil
+}
+
+func s3Error(res *http.Response, action, key string) error {
+	body, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+	return fmt.Errorf("%s %s: %s: %s", action, key, res.Status, bytes.TrimSpace(body))
+}
@@ -0,0 +1,66 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrNotFound is returned by stores for blobs they don't have.
+	ErrNotFound = errors.New("blob not found")
+	// ErrInvalidKey is returned for keys ValidKey rejects.
+	ErrInvalidKey = errors.New("invalid blob key")
+)
+
+// Blob is a stored file being read. Close it when done.
+type Blob struct {
+	io.ReadCloser
+	ContentType string
+	// Size is -1 when the store doesn't know it
+	Size int64
+}
+
+// BlobStore keeps uploaded files under keys such as
+// "avatars/<user id>/<name>.png".
+type BlobStore interface {
+	// Put creates or replaces the blob with the size bytes read from r
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	Get(ctx context.Context, key string) (*Blob, error)
+	// Delete removes the blob, deleting one that doesn't exist is not an error
+	Delete(ctx context.Context, key string) error
+	// URL returns a link anyone can download the blob with for at least ttl
+	URL(ctx context.Context, key string, ttl time.Duration) (string, error)
+}
+
+// ValidKey reports whether key is a relative, slash separated path of
+// letters, digits, dots, dashes and underscores, without empty, . or ..
+// segments, so it can't escape a directory or need escaping in URLs.
+func ValidKey(key string) bool {
+	if key == "" || len(key) > 512 {
+		return false
+	}
+	for _, segment := range strings.Split(key, "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return false
+		}
+		for _, c := range segment {
+			switch {
+			case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+			case c == '.' || c == '-' || c == '_':
+			default:
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// window returns when links for ttl that are created at now expire. Links
+// handed out within the same window of ttl are the same, so browsers can
+// cache what they point at.
+func window(now time.Time, ttl time.Duration) time.Time {
+	return now.Truncate(ttl).Add(2 * ttl)
+}
@@ -0,0 +1,95 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+)
+
+var (
+	// ErrTooLarge is returned by ReadUpload for files over the limit.
+	ErrTooLarge = errors.New("upload too large")
+	// ErrUnsupportedType is returned by ReadUpload for files of other types
+	// than the ones allowed.
+	ErrUnsupportedType = errors.New("unsupported upload type")
+	// ErrNoFile is returned by ReadUpload when the request has no file in the
+	// field.
+	ErrNoFile = errors.New("no file uploaded")
+)
+
+// formOverhead is how much of a multipart body may be boundaries, headers and
+// other fields.
+const formOverhead = 64 << 10
+
+// Upload is a file read from a multipart form.
+type Upload struct {
+	Filename string
+	// ContentType is sniffed from the data, what the client claims isn't
+	// trusted
+	ContentType string
+	Data        []byte
+}
+
+// ReadUpload reads the file in field of a multipart/form-data request, of at
+// most limit bytes and one of types when any are given. The body is limited
+// too, so a larger request is cut off rather than read to the end.
+func ReadUpload(w http.ResponseWriter, r *http.Request, field string, limit int64, types ...string) (*Upload, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, limit+formOverhead)
+
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return nil, ErrNoFile
+	}
+
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			return nil, ErrNoFile
+		}
+		if err != nil {
+			return nil, uploadError(err)
+		}
+
+		if part.FormName() != field || part.FileName() == "" {
+			part.Close()
+			continue
+		}
+
+		data, err := io.ReadAll(io.LimitReader(part, limit+1))
+		part.Close()
+		if err != nil {
+			return nil, uploadError(err)
+		}
+		if int64(len(data)) > limit {
+			return nil, ErrTooLarge
+		}
+		if len(data) == 0 {
+			return nil, ErrNoFile
+		}
+
+		contentType, _, _ := strings.Cut(http.DetectContentType(data), ";")
+		if len(types) > 0 && !contains(types, contentType) {
+			return nil, ErrUnsupportedType
+		}
+
+		return &Upload{Filename: part.FileName(), ContentType: contentType, Data: data}, nil
+	}
+}
+
+func uploadError(err error) error {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return ErrTooLarge
+	}
+	return err
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
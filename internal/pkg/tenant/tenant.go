@@ -0,0 +1,85 @@
+package tenant
+
+import (
+	"context"
+	"errors"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrNoOrg is returned by queries scoped to the organization of a context
+// that has none.
+var ErrNoOrg = errors.New("no organization in the context")
+
+// Org is an organization the user belongs to, with their role in it.
+type Org struct {
+	ID   uuid.UUID
+	Name string
+	Slug string
+	Role string
+}
+
+type contextKey struct{}
+
+type contextValue struct {
+	current *Org
+	orgs    []Org
+}
+
+// NewContext sets the organization the request acts for, and every
+// organization the user can switch to.
+func NewContext(ctx context.Context, current *Org, orgs []Org) context.Context {
+	return context.WithValue(ctx, contextKey{}, contextValue{current: current, orgs: orgs})
+}
+
+// FromContext returns the organization the request acts for.
+func FromContext(ctx context.Context) (*Org, bool) {
+	v, _ := ctx.Value(contextKey{}).(contextValue)
+	return v.current, v.current != nil
+}
+
+// OrgsFromContext returns the organizations the user belongs to.
+func OrgsFromContext(ctx context.Context) []Org {
+	v, _ := ctx.Value(contextKey{}).(contextValue)
+	return v.orgs
+}
+
+// Model is embedded in the models of tenant data. Rows created with a
+// context, db.WithContext(ctx), get the context's organization.
+type Model struct {
+	OrgID uuid.UUID `gorm:"type:uuid;index;not null" json:"-"`
+}
+
+// BeforeCreate fills in OrgID from the statement's context, refusing to
+// create rows that would belong to no organization.
+func (m *Model) BeforeCreate(tx *gorm.DB) error {
+	if m.OrgID != uuid.Nil {
+		return nil
+	}
+	org, ok := FromContext(tx.Statement.Context)
+	if !ok {
+		return ErrNoOrg
+	}
+	m.OrgID = org.ID
+	return nil
+}
+
+// Scope filters a query to the rows of the context's organization, for
+// tables with an org_id column:
+//
+//	db.Scopes(tenant.Scope(ctx)).Find(&projects)
+//
+// Without an organization the query fails with ErrNoOrg rather than
+// returning every tenant's rows.
+func Scope(ctx context.Context) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		org, ok := FromContext(ctx)
+		if !ok {
+			db.AddError(ErrNoOrg)
+			return db
+		}
+		return db.Where(clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: "org_id"}, Value: org.ID})
+	}
+}
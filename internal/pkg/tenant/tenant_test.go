@@ -0,0 +1,64 @@
+//go:build unit
+// +build unit
+
+package tenant
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+type project struct {
+	ID uuid.UUID `gorm:"primaryKey;type:uuid"`
+	Model
+	Name string
+}
+
+func TestScope(t *testing.T) {
+	f, err := os.CreateTemp("", "tenant-*.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+
+	db, err := gorm.Open(sqlite.Open(f.Name()), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, db.AutoMigrate(&project{}))
+
+	acme := &Org{ID: uuid.New(), Name: "Acme"}
+	globex := &Org{ID: uuid.New(), Name: "Globex"}
+	acmeCtx := NewContext(context.Background(), acme, []Org{*acme, *globex})
+	globexCtx := NewContext(context.Background(), globex, []Org{*acme, *globex})
+
+	assert.NoError(t, db.WithContext(acmeCtx).Create(&project{ID: uuid.New(), Name: "Rocket"}).Error)
+	assert.NoError(t, db.WithContext(globexCtx).Create(&project{ID: uuid.New(), Name: "Doomsday"}).Error)
+	assert.ErrorIs(t, db.Create(&project{ID: uuid.New(), Name: "Orphan"}).Error, ErrNoOrg)
+
+	var projects []project
+	assert.NoError(t, db.Scopes(Scope(acmeCtx)).Find(&projects).Error)
+	if assert.Len(t, projects, 1) {
+		assert.Equal(t, "Rocket", projects[0].Name)
+		assert.Equal(t, acme.ID, projects[0].OrgID)
+	}
+
+	// updates and deletes are scoped too
+	result := db.Scopes(Scope(globexCtx)).Where("name = ?", "Rocket").Delete(&project{})
+	assert.NoError(t, result.Error)
+	assert.Zero(t, result.RowsAffected)
+
+	assert.ErrorIs(t, db.Scopes(Scope(context.Background())).Find(&projects).Error, ErrNoOrg)
+
+	org, ok := FromContext(acmeCtx)
+	assert.True(t, ok)
+	assert.Equal(t, "Acme", org.Name)
+	assert.Len(t, OrgsFromContext(acmeCtx), 2)
+}
@@ -0,0 +1,124 @@
+// Package useragent turns User-Agent headers into something a user can
+// recognise, like "Firefox 118 on Windows". It knows the common browsers and
+// operating systems and nothing more.
+package useragent
+
+import (
+	"strings"
+)
+
+const (
+	DeviceDesktop = "desktop"
+	DeviceMobile  = "mobile"
+	DeviceTablet  = "tablet"
+	DeviceBot     = "bot"
+)
+
+// UserAgent is a parsed User-Agent header. Unknown parts are empty.
+type UserAgent struct {
+	Browser string `json:"browser"`
+	// Version is the browser's major version
+	Version string `json:"version"`
+	OS      string `json:"os"`
+	Device  string `json:"device"`
+}
+
+// browsers are matched in order, browsers built on Chrome mention Chrome and
+// Safari too so they come first.
+var browsers = []struct {
+	token string
+	name  string
+}{
+	{"Edg/", "Edge"},
+	{"EdgA/", "Edge"},
+	{"OPR/", "Opera"},
+	{"SamsungBrowser/", "Samsung Internet"},
+	{"YaBrowser/", "Yandex Browser"},
+	{"Vivaldi/", "Vivaldi"},
+	{"Firefox/", "Firefox"},
+	{"FxiOS/", "Firefox"},
+	{"CriOS/", "Chrome"},
+	{"Chrome/", "Chrome"},
+	{"Version/", "Safari"},
+	{"curl/", "curl"},
+}
+
+var systems = []struct {
+	token string
+	name  string
+}{
+	{"Windows", "Windows"},
+	{"iPhone", "iOS"},
+	{"iPad", "iPadOS"},
+	{"Android", "Android"},
+	{"CrOS", "ChromeOS"},
+	{"Mac OS X", "macOS"},
+	{"Macintosh", "macOS"},
+	{"Linux", "Linux"},
+}
+
+// Parse parses a User-Agent header.
+func Parse(header string) UserAgent {
+	var ua UserAgent
+
+	for _, b := range browsers {
+		i := strings.Index(header, b.token)
+		if i < 0 {
+			continue
+		}
+		if b.name == "Safari" && !strings.Contains(header, "Safari/") {
+			continue
+		}
+		ua.Browser = b.name
+		ua.Version = majorVersion(header[i+len(b.token):])
+		break
+	}
+
+	for _, s := range systems {
+		if strings.Contains(header, s.token) {
+			ua.OS = s.name
+			break
+		}
+	}
+
+	lower := strings.ToLower(header)
+	switch {
+	case strings.Contains(lower, "bot") || strings.Contains(lower, "spider") || strings.Contains(lower, "crawl"):
+		ua.Device = DeviceBot
+	case strings.Contains(header, "iPad") || strings.Contains(header, "Tablet") ||
+		(ua.OS == "Android" && !strings.Contains(header, "Mobile")):
+		ua.Device = DeviceTablet
+	case strings.Contains(header, "Mobile") || strings.Contains(header, "iPhone"):
+		ua.Device = DeviceMobile
+	case ua.OS != "":
+		ua.Device = DeviceDesktop
+	}
+
+	return ua
+}
+
+// String describes the browser, e.g. "Firefox 118 on Windows", or returns
+// "" when nothing is known.
+func (ua UserAgent) String() string {
+	name := ua.Browser
+	if name != "" && ua.Version != "" {
+		name += " " + ua.Version
+	}
+	switch {
+	case name != "" && ua.OS != "":
+		return name + " on " + ua.OS
+	case name != "":
+		return name
+	default:
+		return ua.OS
+	}
+}
+
+// majorVersion returns the digits at the start of s.
+func majorVersion(s string) string {
+	end := 0
+	for end < len(s) && s[end] >= '0' && s[end] <= '9' {
+		end++
+	}
+	return s[:end]
+}
@@ -0,0 +1,71 @@
+//go:build unit
+// +build unit
+
+package useragent
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	testCases := []struct {
+		description string
+		header      string
+		expected    UserAgent
+		expectedStr string
+	}{
+		{
+			"firefox on windows",
+			"Mozilla/5.0 (Windows NT 10.0; Win64; x64; rv:118.0) Gecko/20100101 Firefox/118.0",
+			UserAgent{Browser: "Firefox", Version: "118", OS: "Windows", Device: DeviceDesktop},
+			"Firefox 118 on Windows",
+		},
+		{
+			"chrome on macOS",
+			"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/117.0.0.0 Safari/537.36",
+			UserAgent{Browser: "Chrome", Version: "117", OS: "macOS", Device: DeviceDesktop},
+			"Chrome 117 on macOS",
+		},
+		{
+			"edge is not chrome",
+			"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/117.0.0.0 Safari/537.36 Edg/117.0.2045.43",
+			UserAgent{Browser: "Edge", Version: "117", OS: "Windows", Device: DeviceDesktop},
+			"Edge 117 on Windows",
+		},
+		{
+			"safari on iPhone",
+			"Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.0 Mobile/15E148 Safari/604.1",
+			UserAgent{Browser: "Safari", Version: "17", OS: "iOS", Device: DeviceMobile},
+			"Safari 17 on iOS",
+		},
+		{
+			"android tablet",
+			"Mozilla/5.0 (Linux; Android 13; SM-X700) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/116.0.0.0 Safari/537.36",
+			UserAgent{Browser: "Chrome", Version: "116", OS: "Android", Device: DeviceTablet},
+			"Chrome 116 on Android",
+		},
+		{
+			"curl",
+			"curl/8.1.2",
+			UserAgent{Browser: "curl", Version: "8"},
+			"curl 8",
+		},
+		{
+			"bot",
+			"Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)",
+			UserAgent{Device: DeviceBot},
+			"",
+		},
+		{"empty", "", UserAgent{}, ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			ua := Parse(tc.header)
+			assert.Equal(t, tc.expected, ua)
+			assert.Equal(t, tc.expectedStr, ua.String())
+		})
+	}
+}
@@ -0,0 +1,279 @@
+package users
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html"
+	"net/http"
+	"strconv"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/tomdoestech/goth/internal/pkg/apperror"
+	"github.com/tomdoestech/goth/internal/pkg/i18n"
+	"github.com/tomdoestech/goth/internal/pkg/identity"
+	"github.com/tomdoestech/goth/internal/pkg/storage"
+	"github.com/tomdoestech/goth/internal/web"
+	"go.uber.org/zap"
+)
+
+// avatarLinkTTL is how long the links avatars are sent to work for at least.
+const avatarLinkTTL = time.Hour
+
+// placeholderColors are the backgrounds of the initials shown for users
+// without an avatar.
+var placeholderColors = []string{"#2563eb", "#7c3aed", "#db2777", "#ea580c", "#16a34a", "#0891b2"}
+
+// AvatarHandler lets users upload a picture of themselves, shown in the nav.
+type AvatarHandler struct {
+	userService *UserService
+	store       storage.BlobStore
+	maxSize     int64
+	i18n        *i18n.Bundle
+	logger      *zap.Logger
+}
+
+type AvatarHandlerParams struct {
+	UserService *UserService
+	Store       storage.BlobStore
+	// MaxSize is the largest picture in bytes that can be uploaded, defaults
+	// to 5 MB
+	MaxSize int64
+	I18n    *i18n.Bundle
+	Logger  *zap.Logger
+}
+
+type avatarData struct {
+	// Avatar is the picture, a JPEG, PNG or GIF
+	Avatar string `json:"avatar" validate:"required"`
+}
+
+// AvatarResponse links to a user's avatar, the links expire.
+type AvatarResponse struct {
+	URL          string `json:"url"`
+	ThumbnailURL string `json:"thumbnail_url"`
+}
+
+func NewAvatarHandler(p AvatarHandlerParams) *AvatarHandler {
+	if p.MaxSize == 0 {
+		p.MaxSize = 5 << 20
+	}
+
+	return &AvatarHandler{
+		userService: p.UserService,
+		store:       p.Store,
+		maxSize:     p.MaxSize,
+		i18n:        p.I18n,
+		logger:      p.Logger,
+	}
+}
+
+// principal returns the logged in user, who may change their profile.
+func (h *AvatarHandler) principal(r *http.Request) (*identity.Principal, error) {
+	loc := h.i18n.FromRequest(r)
+
+	p, ok := identity.FromContext(r.Context())
+	if !ok {
+		return nil, apperror.Unauthorized(loc.T("error.unauthorized.message"), nil)
+	}
+	if !p.Can(identity.ScopeProfileWrite) {
+		return nil, apperror.Forbidden(loc.T("error.scope", identity.ScopeProfileWrite))
+	}
+	return p, nil
+}
+
+func (h *AvatarHandler) Page(w http.ResponseWriter, r *http.Request) error {
+	p, err := h.principal(r)
+	if err != nil {
+		return err
+	}
+
+	user, err := h.userService.FindUserByID(p.UserID)
+	if err != nil {
+		return apperror.Unauthorized(h.i18n.FromRequest(r).T("error.unauthorized.message"), fmt.Errorf("finding user: %w", err))
+	}
+
+	web.RenderTemplate(w, "avatar.html", map[string]interface{}{
+		"Title":     "avatar.title",
+		"HasAvatar": user.Avatar != "",
+		"MaxSizeMB": h.maxSize >> 20,
+	}, r)
+
+	return nil
+}
+
+// Upload stores the picture in the avatar field, cropped square and in the
+// avatar sizes, and replaces the previous avatar.
+func (h *AvatarHandler) Upload(w http.ResponseWriter, r *http.Request) error {
+	p, err := h.principal(r)
+	if err != nil {
+		return err
+	}
+
+	loc := h.i18n.FromRequest(r)
+
+	upload, err := storage.ReadUpload(w, r, "avatar", h.maxSize, storage.ImageTypes...)
+	switch {
+	case errors.Is(err, storage.ErrNoFile):
+		return apperror.Validation(loc.T("avatar.missing"), nil)
+	case errors.Is(err, storage.ErrTooLarge):
+		return apperror.Validation(loc.T("avatar.too_large", h.maxSize>>20), nil)
+	case errors.Is(err, storage.ErrUnsupportedType):
+		return apperror.Validation(loc.T("avatar.unsupported"), nil)
+	case err != nil:
+		return apperror.New(apperror.KindValidation, "", fmt.Errorf("reading avatar: %w", err))
+	}
+
+	// a new key every time, so links to the old picture don't show the new one
+	suffix := make([]byte, 8)
+	if _, err := rand.Read(suffix); err != nil {
+		return apperror.Internal(err)
+	}
+	avatar := "avatars/" + p.UserID.String() + "/" + hex.EncodeToString(suffix)
+
+	for _, size := range []int{AvatarSize, AvatarThumbnailSize} {
+		thumb, err := storage.Thumbnail(upload.Data, size)
+		if errors.Is(err, storage.ErrInvalidImage) {
+			return apperror.New(apperror.KindValidation, loc.T("avatar.invalid"), err)
+		}
+		if err != nil {
+			return apperror.Internal(fmt.Errorf("resizing avatar: %w", err))
+		}
+
+		if err := h.store.Put(r.Context(), AvatarKey(avatar, size), bytes.NewReader(thumb), int64(len(thumb)), "image/png"); err != nil {
+			return apperror.Internal(fmt.Errorf("storing avatar: %w", err))
+		}
+	}
+
+	previous, err := h.userService.SetAvatar(p.UserID, avatar)
+	if err != nil {
+		h.remove(r, avatar)
+		return apperror.Internal(fmt.Errorf("setting avatar: %w", err))
+	}
+	h.remove(r, previous)
+
+	if web.WantsJSON(r) {
+		res, err := h.links(r, avatar)
+		if err != nil {
+			return apperror.Internal(err)
+		}
+		return web.WriteJSON(w, http.StatusOK, res)
+	}
+
+	w.Header().Set("HX-Refresh", "true")
+	w.WriteHeader(http.StatusOK)
+
+	return nil
+}
+
+// Delete removes the user's avatar, the initials are shown instead.
+func (h *AvatarHandler) Delete(w http.ResponseWriter, r *http.Request) error {
+	p, err := h.principal(r)
+	if err != nil {
+		return err
+	}
+
+	previous, err := h.userService.SetAvatar(p.UserID, "")
+	if err != nil {
+		return apperror.Internal(fmt.Errorf("removing avatar: %w", err))
+	}
+	h.remove(r, previous)
+
+	if web.WantsJSON(r) {
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+
+	w.Header().Set("HX-Refresh", "true")
+	w.WriteHeader(http.StatusOK)
+
+	return nil
+}
+
+// Show sends logged in users to a link to the user's avatar, or draws their
+// initial when they have none. The size query parameter picks the thumbnail
+// for 64 or less.
+func (h *AvatarHandler) Show(w http.ResponseWriter, r *http.Request) error {
+	loc := h.i18n.FromRequest(r)
+
+	if _, ok := identity.FromContext(r.Context()); !ok {
+		return apperror.Unauthorized(loc.T("error.unauthorized.message"), nil)
+	}
+
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		return apperror.NotFound(loc.T("error.not_found.message"))
+	}
+
+	user, err := h.userService.FindUserByID(id)
+	if err != nil {
+		return apperror.NotFound(loc.T("error.not_found.message"))
+	}
+
+	// the link changes with the avatar, so check every time
+	w.Header().Set("Cache-Control", "private, no-cache")
+
+	if user.Avatar == "" {
+		w.Header().Set("Content-Type", "image/svg+xml")
+		w.Write(placeholder(user))
+		return nil
+	}
+
+	size := AvatarSize
+	if n, err := strconv.Atoi(r.URL.Query().Get("size")); err == nil && n <= AvatarThumbnailSize {
+		size = AvatarThumbnailSize
+	}
+
+	link, err := h.store.URL(r.Context(), AvatarKey(user.Avatar, size), avatarLinkTTL)
+	if err != nil {
+		return apperror.Internal(fmt.Errorf("linking avatar: %w", err))
+	}
+
+	http.Redirect(w, r, link, http.StatusFound)
+
+	return nil
+}
+
+func (h *AvatarHandler) links(r *http.Request, avatar string) (AvatarResponse, error) {
+	var res AvatarResponse
+	var err error
+	if res.URL, err = h.store.URL(r.Context(), AvatarKey(avatar, AvatarSize), avatarLinkTTL); err != nil {
+		return res, err
+	}
+	res.ThumbnailURL, err = h.store.URL(r.Context(), AvatarKey(avatar, AvatarThumbnailSize), avatarLinkTTL)
+	return res, err
+}
+
+// remove deletes the blobs of an avatar that is no longer used. Failures are
+// only logged, the blobs are unreachable anyway.
+func (h *AvatarHandler) remove(r *http.Request, avatar string) {
+	if avatar == "" {
+		return
+	}
+	for _, size := range []int{AvatarSize, AvatarThumbnailSize} {
+		if err := h.store.Delete(r.Context(), AvatarKey(avatar, size)); err != nil {
+			h.logger.Warn("Error deleting avatar", zap.String("avatar", avatar), zap.Error(err))
+		}
+	}
+}
+
+// placeholder draws the first letter of the user's email on a color picked
+// by their id.
+func placeholder(user *UserModel) []byte {
+	initial, _ := utf8.DecodeRuneInString(user.Email)
+	if initial == utf8.RuneError {
+		initial = '?'
+	}
+	color := placeholderColors[int(user.ID[0])%len(placeholderColors)]
+
+	return []byte(fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 64 64">`+
+		`<rect width="64" height="64" fill="%s"/>`+
+		`<text x="32" y="32" dy=".35em" text-anchor="middle" font-family="sans-serif" font-size="32" fill="#fff">%s</text>`+
+		`</svg>`, color, html.EscapeString(string(unicode.ToUpper(initial)))))
+}
@@ -0,0 +1,74 @@
+package users
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/tomdoestech/goth/internal/pkg/identity"
+	"github.com/tomdoestech/goth/internal/pkg/openapi"
+	"github.com/tomdoestech/goth/internal/web"
+)
+
+type AvatarHTTPParams struct {
+	AvatarHandler *AvatarHandler
+	ErrorHandler  *web.ErrorHandler
+	API           *openapi.Document
+	Mux           *chi.Mux
+}
+
+func NewAvatarHTTP(p AvatarHTTPParams) {
+
+	r := openapi.NewRouter(p.Mux, p.API)
+	e := p.ErrorHandler
+
+	r.Get("/account/avatar", openapi.Operation{
+		Summary: "Avatar page",
+		Tags:    []string{"users"},
+		Responses: map[int]openapi.Response{
+			http.StatusOK: {Description: "A form to upload or remove the avatar", ContentType: "text/html"},
+		},
+		Errors: []int{http.StatusUnauthorized, http.StatusForbidden},
+		Auth:   true,
+	}, e.Handle(p.AvatarHandler.Page))
+
+	r.Post("/api/account/avatar", openapi.Operation{
+		Summary:      "Upload avatar",
+		Description:  "Replaces the avatar with the picture in the avatar field. The type is sniffed from the data, not the name. The middle square is kept, in 256 and 64 pixels.",
+		Tags:         []string{"users"},
+		Request:      avatarData{},
+		RequestTypes: []string{"multipart/form-data"},
+		Responses: map[int]openapi.Response{
+			http.StatusOK: {Description: "Links to the new avatar, htmx is told to refresh", Body: AvatarResponse{}},
+		},
+		Errors: []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden},
+		Auth:   true,
+		Scopes: []string{identity.ScopeProfileWrite},
+	}, e.Handle(p.AvatarHandler.Upload))
+
+	r.Delete("/api/account/avatar", openapi.Operation{
+		Summary: "Remove avatar",
+		Tags:    []string{"users"},
+		Responses: map[int]openapi.Response{
+			http.StatusOK:        {Description: "Removed, htmx is told to refresh"},
+			http.StatusNoContent: {Description: "Removed, for JSON clients"},
+		},
+		Errors: []int{http.StatusUnauthorized, http.StatusForbidden},
+		Auth:   true,
+		Scopes: []string{identity.ScopeProfileWrite},
+	}, e.Handle(p.AvatarHandler.Delete))
+
+	r.Get("/users/{id}/avatar", openapi.Operation{
+		Summary:     "User avatar",
+		Description: "Redirects to a signed link to the user's avatar, or draws their initial as SVG when they have none.",
+		Tags:        []string{"users"},
+		Query: []openapi.Parameter{
+			{Name: "size", Description: "64 or less for the thumbnail, 256 otherwise"},
+		},
+		Responses: map[int]openapi.Response{
+			http.StatusFound: {Description: "A link to the avatar, which expires"},
+			http.StatusOK:    {Description: "The user's initial", ContentType: "image/svg+xml"},
+		},
+		Errors: []int{http.StatusUnauthorized, http.StatusNotFound},
+		Auth:   true,
+	}, e.Handle(p.AvatarHandler.Show))
+}
@@ -0,0 +1,179 @@
+//go:build unit
+// +build unit
+
+package users
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-playground/validator/v10"
+	"github.com/stretchr/testify/assert"
+	"github.com/tomdoestech/goth/internal/pkg/i18n"
+	"github.com/tomdoestech/goth/internal/pkg/identity"
+	"github.com/tomdoestech/goth/internal/pkg/openapi"
+	"github.com/tomdoestech/goth/internal/pkg/storage"
+	"github.com/tomdoestech/goth/internal/web"
+	"go.uber.org/zap"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestAvatar(t *testing.T) {
+	f, err := os.CreateTemp("", "test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+
+	db, err := gorm.Open(sqlite.Open(f.Name()), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	validate := validator.New()
+	bundle, err := i18n.NewBundle(i18n.BundleParams{Validate: validate})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	usersService := NewUserService(UserServiceParams{Logger: zap.NewNop(), Validate: validate, DB: db})
+	alice, err := usersService.CreateUser("alice@example.com", "tangerine-otter-89-lamp")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signer := storage.NewSigner([]byte("secret"), "/files/")
+	store, err := storage.NewLocalStore(t.TempDir(), signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	errorHandler := web.NewErrorHandler(web.ErrorHandlerParams{I18n: bundle, Logger: zap.NewNop()})
+
+	mux := chi.NewMux()
+	NewAvatarHTTP(AvatarHTTPParams{
+		AvatarHandler: NewAvatarHandler(AvatarHandlerParams{
+			UserService: usersService,
+			Store:       store,
+			MaxSize:     1 << 20,
+			I18n:        bundle,
+			Logger:      zap.NewNop(),
+		}),
+		ErrorHandler: errorHandler,
+		API:          openapi.New(openapi.Params{Title: "goth", Version: "1.0.0"}),
+		Mux:          mux,
+	})
+	mux.Handle("/files/*", storage.NewHandler(storage.HandlerParams{
+		Store:  store,
+		Signer: signer,
+		Error:  errorHandler.Render,
+		Logger: zap.NewNop(),
+	}))
+	handler := bundle.Middleware(mux)
+
+	asAlice := &identity.Principal{UserID: alice.ID, Email: alice.Email, Method: identity.MethodCookie}
+
+	do := func(p *identity.Principal, req *http.Request) *httptest.ResponseRecorder {
+		req.Header.Set("Accept", "application/json")
+		if p != nil {
+			req = req.WithContext(identity.NewContext(req.Context(), p))
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	upload := func(data []byte) *http.Request {
+		var body bytes.Buffer
+		mw := multipart.NewWriter(&body)
+		fw, _ := mw.CreateFormFile("avatar", "me.png")
+		fw.Write(data)
+		mw.Close()
+
+		req := httptest.NewRequest("POST", "/api/account/avatar", &body)
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+		return req
+	}
+
+	picture := image.NewRGBA(image.Rect(0, 0, 400, 300))
+	for x := 0; x < 400; x++ {
+		for y := 0; y < 300; y++ {
+			picture.Set(x, y, color.RGBA{G: 255, A: 255})
+		}
+	}
+	var data bytes.Buffer
+	png.Encode(&data, picture)
+
+	t.Run("placeholder", func(t *testing.T) {
+		w := do(asAlice, httptest.NewRequest("GET", "/users/"+alice.ID.String()+"/avatar", nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "image/svg+xml", w.Header().Get("Content-Type"))
+		assert.Contains(t, w.Body.String(), ">A</text>")
+
+		w = do(nil, httptest.NewRequest("GET", "/users/"+alice.ID.String()+"/avatar", nil))
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+
+	t.Run("upload", func(t *testing.T) {
+		w := do(nil, upload(data.Bytes()))
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+		w = do(asAlice, upload([]byte("<html><script>alert(1)</script>")))
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+
+		w = do(asAlice, upload(data.Bytes()))
+		if !assert.Equal(t, http.StatusOK, w.Code, w.Body.String()) {
+			return
+		}
+		var res AvatarResponse
+		json.NewDecoder(w.Body).Decode(&res)
+		assert.True(t, strings.HasPrefix(res.ThumbnailURL, "/files/avatars/"+alice.ID.String()+"/"), res.ThumbnailURL)
+
+		w = do(nil, httptest.NewRequest("GET", res.ThumbnailURL, nil))
+		if assert.Equal(t, http.StatusOK, w.Code) {
+			img, err := png.Decode(w.Body)
+			if assert.NoError(t, err) {
+				assert.Equal(t, image.Rect(0, 0, AvatarThumbnailSize, AvatarThumbnailSize), img.Bounds())
+			}
+		}
+
+		w = do(asAlice, httptest.NewRequest("GET", "/users/"+alice.ID.String()+"/avatar?size=64", nil))
+		assert.Equal(t, http.StatusFound, w.Code)
+		assert.Equal(t, res.ThumbnailURL, w.Header().Get("Location"))
+	})
+
+	t.Run("replace and remove", func(t *testing.T) {
+		user, _ := usersService.FindUserByID(alice.ID)
+		first := user.Avatar
+
+		w := do(asAlice, upload(data.Bytes()))
+		assert.Equal(t, http.StatusOK, w.Code)
+		user, _ = usersService.FindUserByID(alice.ID)
+		assert.NotEqual(t, first, user.Avatar)
+
+		_, err := store.Get(context.Background(), AvatarKey(first, AvatarSize))
+		assert.ErrorIs(t, err, storage.ErrNotFound, "the previous avatar is removed")
+
+		w = do(asAlice, httptest.NewRequest("DELETE", "/api/account/avatar", nil))
+		assert.Equal(t, http.StatusNoContent, w.Code)
+
+		_, err = store.Get(context.Background(), AvatarKey(user.Avatar, AvatarThumbnailSize))
+		assert.ErrorIs(t, err, storage.ErrNotFound)
+
+		w = do(asAlice, httptest.NewRequest("GET", "/users/"+alice.ID.String()+"/avatar", nil))
+		assert.Equal(t, "image/svg+xml", w.Header().Get("Content-Type"))
+	})
+}
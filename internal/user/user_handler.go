@@ -0,0 +1,196 @@
+package users
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-playground/validator/v10"
+	"github.com/google/uuid"
+	"github.com/tomdoestech/goth/internal/pkg/apperror"
+	"github.com/tomdoestech/goth/internal/pkg/i18n"
+	"github.com/tomdoestech/goth/internal/pkg/identity"
+	"github.com/tomdoestech/goth/internal/web"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+type UserHandler struct {
+	userService *UserService
+	validate    *validator.Validate
+	i18n        *i18n.Bundle
+	logger      *zap.Logger
+}
+
+type UserHandlerParams struct {
+	UserService *UserService
+	Validate    *validator.Validate
+	I18n        *i18n.Bundle
+	Logger      *zap.Logger
+}
+
+type localeData struct {
+	Locale string `json:"locale" validate:"required"`
+}
+
+type statusData struct {
+	Status string `json:"status" validate:"required,oneof=pending_verification active suspended deletion_scheduled"`
+	// Reason is shown to suspended users
+	Reason string `json:"reason" validate:"max=500"`
+	// Until ends a suspension, RFC 3339
+	Until string `json:"until" validate:"omitempty,datetime=2006-01-02T15:04:05Z07:00"`
+	// GraceDays is how long until an account scheduled for deletion is
+	// deleted, defaults to DeletionGracePeriod
+	GraceDays int `json:"grace_days" validate:"min=0,max=365"`
+}
+
+func NewUserHandler(p UserHandlerParams) *UserHandler {
+	return &UserHandler{
+		userService: p.UserService,
+		validate:    p.Validate,
+		i18n:        p.I18n,
+		logger:      p.Logger,
+	}
+}
+
+// SetLocale stores the chosen locale in a cookie and, for logged in users, as
+// their preference so it follows them to other devices.
+func (u *UserHandler) SetLocale(w http.ResponseWriter, r *http.Request) error {
+
+	loc := u.i18n.FromRequest(r)
+
+	var data localeData
+	if err := web.Decode(r, &data); err != nil {
+		return err
+	}
+
+	locale := data.Locale
+
+	if !u.i18n.Supports(locale) {
+		return apperror.Validation(loc.T("locale.unsupported"), nil)
+	}
+
+	p, ok := identity.FromContext(r.Context())
+	if ok && !p.Can(identity.ScopeProfileWrite) {
+		return apperror.Forbidden(loc.T("error.scope", identity.ScopeProfileWrite))
+	}
+
+	i18n.SetCookie(w, locale)
+
+	if ok {
+		if err := u.userService.UpdateLocale(p.UserID, locale); err != nil {
+			return apperror.Internal(err)
+		}
+	}
+
+	if web.WantsJSON(r) {
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+
+	w.Header().Set("HX-Refresh", "true")
+	w.WriteHeader(http.StatusOK)
+
+	return nil
+}
+
+// Me returns the user the request is authenticated as.
+func (u *UserHandler) Me(w http.ResponseWriter, r *http.Request) error {
+
+	loc := u.i18n.FromRequest(r)
+
+	p, ok := identity.FromContext(r.Context())
+	if !ok {
+		return apperror.Unauthorized(loc.T("error.unauthorized.message"), nil)
+	}
+
+	if !p.Can(identity.ScopeProfileRead) {
+		return apperror.Forbidden(loc.T("error.scope", identity.ScopeProfileRead))
+	}
+
+	user, err := u.userService.FindUserByID(p.UserID)
+	if err != nil {
+		return apperror.NotFound(loc.T("error.not_found.message"))
+	}
+
+	return web.WriteJSON(w, http.StatusOK, NewUserResponse(user))
+}
+
+// SetStatus changes the status of another user's account, for admins logged
+// in with a session.
+func (u *UserHandler) SetStatus(w http.ResponseWriter, r *http.Request) error {
+
+	loc := u.i18n.FromRequest(r)
+
+	p, ok := identity.FromContext(r.Context())
+	if !ok {
+		return apperror.Unauthorized(loc.T("error.unauthorized.message"), nil)
+	}
+	if p.Method == identity.MethodAPIKey || p.Impersonated() {
+		return apperror.Forbidden(loc.T("account.status.admin_only"))
+	}
+
+	admin, err := u.userService.FindUserByID(p.UserID)
+	if err != nil {
+		return apperror.Unauthorized(loc.T("error.unauthorized.message"), fmt.Errorf("finding user: %w", err))
+	}
+	if !admin.HasRole(RoleAdmin) {
+		return apperror.Forbidden(loc.T("account.status.admin_only"))
+	}
+
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		return apperror.NotFound(loc.T("error.not_found.message"))
+	}
+	if id == admin.ID {
+		return apperror.Forbidden(loc.T("account.status.self"))
+	}
+
+	var data statusData
+	if err := web.Decode(r, &data); err != nil {
+		return err
+	}
+
+	if err := u.validate.Struct(&data); err != nil {
+		return web.ValidationError(loc, &data, err)
+	}
+
+	var until *time.Time
+	if data.Until != "" {
+		t, _ := time.Parse(time.RFC3339, data.Until)
+		until = &t
+	}
+
+	switch data.Status {
+	case StatusActive:
+		err = u.userService.Activate(id)
+	case StatusSuspended:
+		err = u.userService.Suspend(id, data.Reason, until)
+	case StatusDeletionScheduled:
+		grace := DeletionGracePeriod
+		if data.GraceDays > 0 {
+			grace = time.Duration(data.GraceDays) * 24 * time.Hour
+		}
+		err = u.userService.ScheduleDeletion(id, grace)
+	default:
+		err = u.userService.setStatus(id, map[string]interface{}{"status": data.Status})
+	}
+	if errors.Is(err, ErrStatus) {
+		return apperror.Validation(loc.T("account.status.invalid_until"), nil)
+	}
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return apperror.NotFound(loc.T("error.not_found.message"))
+	}
+	if err != nil {
+		return apperror.Internal(fmt.Errorf("setting status: %w", err))
+	}
+
+	user, err := u.userService.FindUserByID(id)
+	if err != nil {
+		return apperror.Internal(err)
+	}
+
+	return web.WriteJSON(w, http.StatusOK, NewUserResponse(user))
+}
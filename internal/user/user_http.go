@@ -0,0 +1,60 @@
+package users
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/tomdoestech/goth/internal/pkg/identity"
+	"github.com/tomdoestech/goth/internal/pkg/openapi"
+	"github.com/tomdoestech/goth/internal/web"
+)
+
+type UserHTTPParams struct {
+	UserHandler  *UserHandler
+	ErrorHandler *web.ErrorHandler
+	API          *openapi.Document
+	Mux          *chi.Mux
+}
+
+func NewUserHTTP(p UserHTTPParams) {
+
+	r := openapi.NewRouter(p.Mux, p.API)
+	e := p.ErrorHandler
+
+	r.Post("/api/locale", openapi.Operation{
+		Summary:      "Change language",
+		Description:  "Stores the locale in the lang cookie and, for logged in users, as their preference.",
+		Tags:         []string{"users"},
+		Request:      localeData{},
+		RequestTypes: []string{"application/json", "application/x-www-form-urlencoded"},
+		Responses: map[int]openapi.Response{
+			http.StatusOK:        {Description: "Locale changed, browsers are told to refresh"},
+			http.StatusNoContent: {Description: "Locale changed, for JSON clients"},
+		},
+		Errors: []int{http.StatusBadRequest, http.StatusForbidden},
+		Scopes: []string{identity.ScopeProfileWrite},
+	}, e.Handle(p.UserHandler.SetLocale))
+
+	r.Get("/api/me", openapi.Operation{
+		Summary: "Current user",
+		Tags:    []string{"users"},
+		Responses: map[int]openapi.Response{
+			http.StatusOK: {Description: "The user the request is authenticated as", Body: UserResponse{}},
+		},
+		Errors: []int{http.StatusUnauthorized, http.StatusForbidden},
+		Auth:   true,
+		Scopes: []string{identity.ScopeProfileRead},
+	}, e.Handle(p.UserHandler.Me))
+
+	r.Put("/api/users/{id}/status", openapi.Operation{
+		Summary:     "Change account status",
+		Description: "Activates, suspends or schedules the deletion of another user's account, for admins. Blocked users are logged out on their next request and told why when they log in. Accounts scheduled for deletion are deleted once grace_days, 30 by default, have passed.",
+		Tags:        []string{"users"},
+		Request:     statusData{},
+		Responses: map[int]openapi.Response{
+			http.StatusOK: {Description: "The user with their new status", Body: UserResponse{}},
+		},
+		Errors: []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound},
+		Auth:   true,
+	}, e.Handle(p.UserHandler.SetStatus))
+}
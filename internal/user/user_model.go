@@ -1,6 +1,9 @@
 package users
 
 import (
+	"errors"
+	"fmt"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -17,8 +20,135 @@ type UserModel struct {
 
 	Email    string `gorm:"uniqueIndex" json:"email" validate:"required,email"`
 	Password string `gorm:"not null" json:"-"`
+	Locale   string `json:"locale"`
+	// Roles is space separated
+	Roles string `json:"-"`
+
+	Status string `gorm:"not null;default:active;index" json:"status"`
+	// SuspendedReason is shown to suspended users when they log in
+	SuspendedReason string `json:"-"`
+	// SuspendedUntil ends the suspension, nil suspends until reactivated
+	SuspendedUntil *time.Time `json:"-"`
+	// DeleteAt is when an account scheduled for deletion is deleted
+	DeleteAt *time.Time `gorm:"index" json:"-"`
+
+	// Avatar is the key of the avatar's blobs without the size, see
+	// AvatarKey. Empty for users without one.
+	Avatar string `json:"-"`
 }
 
 func (UserModel) TableName() string {
 	return "users"
 }
+
+// RoleAdmin may manage other users, such as inviting them with roles.
+const RoleAdmin = "admin"
+
+// Roles are the roles users can be given.
+var Roles = []string{RoleAdmin}
+
+// ValidRole reports whether role is one of Roles.
+func ValidRole(role string) bool {
+	for _, r := range Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+func (u *UserModel) RoleList() []string {
+	return strings.Fields(u.Roles)
+}
+
+func (u *UserModel) HasRole(role string) bool {
+	for _, r := range u.RoleList() {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// Account statuses. Only active users can log in; the others are told why.
+const (
+	// StatusPending accounts haven't verified their email yet
+	StatusPending = "pending_verification"
+	StatusActive  = "active"
+	// StatusSuspended accounts are blocked by an admin, until SuspendedUntil
+	// if set
+	StatusSuspended = "suspended"
+	// StatusDeletionScheduled accounts are deleted at DeleteAt, they can be
+	// reactivated until then
+	StatusDeletionScheduled = "deletion_scheduled"
+)
+
+// Statuses are the statuses admins can set.
+var Statuses = []string{StatusPending, StatusActive, StatusSuspended, StatusDeletionScheduled}
+
+var (
+	ErrAccountPending           = errors.New("account pending verification")
+	ErrAccountSuspended         = errors.New("account suspended")
+	ErrAccountDeletionScheduled = errors.New("account scheduled for deletion")
+)
+
+// CheckStatus returns why the user can't use their account at now, nil when
+// they can. Suspensions past SuspendedUntil are over.
+func (u *UserModel) CheckStatus(now time.Time) error {
+	switch u.Status {
+	case StatusPending:
+		return ErrAccountPending
+	case StatusSuspended:
+		if u.SuspendedUntil != nil && !now.Before(*u.SuspendedUntil) {
+			return nil
+		}
+		return ErrAccountSuspended
+	case StatusDeletionScheduled:
+		return ErrAccountDeletionScheduled
+	}
+	return nil
+}
+
+// Sizes avatars are stored in, in pixels. The nav shows the thumbnail.
+const (
+	AvatarSize          = 256
+	AvatarThumbnailSize = 64
+)
+
+// AvatarKey returns the key of the avatar blob of size.
+func AvatarKey(avatar string, size int) string {
+	return fmt.Sprintf("%s-%d.png", avatar, size)
+}
+
+// PasswordHistoryModel is a password a user had, so it can't be chosen again.
+type PasswordHistoryModel struct {
+	ID        uuid.UUID `gorm:"primaryKey;type:uuid"`
+	CreatedAt time.Time
+	UserID    uuid.UUID `gorm:"type:uuid;index"`
+	Hash      string    `gorm:"not null"`
+}
+
+func (PasswordHistoryModel) TableName() string {
+	return "password_history"
+}
+
+// UserResponse is the representation of a user returned to API clients.
+type UserResponse struct {
+	ID        uuid.UUID `json:"id"`
+	Email     string    `json:"email"`
+	Locale    string    `json:"locale,omitempty"`
+	Roles     []string  `json:"roles,omitempty"`
+	Status    string    `json:"status"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func NewUserResponse(u *UserModel) UserResponse {
+	return UserResponse{
+		ID:        u.ID,
+		Email:     u.Email,
+		Locale:    u.Locale,
+		Roles:     u.RoleList(),
+		Status:    u.Status,
+		CreatedAt: u.CreatedAt,
+	}
+}
@@ -1,37 +1,119 @@
 package users
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/go-playground/validator/v10"
 	"github.com/google/uuid"
+	"github.com/tomdoestech/goth/internal/pkg/jobs"
+	"github.com/tomdoestech/goth/internal/pkg/password"
+	"github.com/tomdoestech/goth/internal/pkg/storage"
 	"go.uber.org/zap"
-	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
+// PurgeJobKind is the kind of the job deleting the accounts whose deletion
+// is due.
+const PurgeJobKind = "users.purge"
+
+// ErrEmailTaken is returned by CreateUser when a user with the email exists.
+var ErrEmailTaken = errors.New("email already registered")
+
+// Dependent is a table whose rows belong to a user, by the id in Column.
+type Dependent struct {
+	Table string
+	// Column defaults to user_id
+	Column string
+}
+
 type UserService struct {
-	SecretKey []byte
-	db        *gorm.DB
-	logger    *zap.Logger
-	validate  *validator.Validate
+	SecretKey  []byte
+	db         *gorm.DB
+	logger     *zap.Logger
+	validate   *validator.Validate
+	hasher     password.Hasher
+	history    int
+	admins     []string
+	verify     bool
+	store      storage.BlobStore
+	dependents []Dependent
+	onDelete   []func(tx *gorm.DB, userIDs []uuid.UUID) error
+	now        func() time.Time
 }
 
 type UserServiceParams struct {
 	Logger   *zap.Logger
 	Validate *validator.Validate
 	DB       *gorm.DB
+	// Hasher hashes passwords, defaults to password.Default()
+	Hasher password.Hasher
+	// PasswordHistory is how many of their latest passwords users can't
+	// choose again, 0 keeps none
+	PasswordHistory int
+	// AdminEmails are given the admin role, when they register or straight
+	// away when they already have
+	AdminEmails []string
+	// VerifyEmail creates users pending verification, they can't log in
+	// until they do
+	VerifyEmail bool
+	// Queue runs the job deleting the accounts whose deletion is due every
+	// PurgeInterval, an hour by default. Optional, it must run after the
+	// service is created.
+	Queue         *jobs.Queue
+	PurgeInterval time.Duration
+	// Store keeps the avatars, deleted with their accounts. Optional.
+	Store storage.BlobStore
+	// Dependents are the tables of other packages whose rows are deleted
+	// with the accounts, such as sessions and API keys
+	Dependents []Dependent
+	// OnDelete run in the transaction deleting the accounts, before the
+	// Dependents rows are, for what takes more than deleting rows
+	OnDelete []func(tx *gorm.DB, userIDs []uuid.UUID) error
 }
 
 func NewUserService(p UserServiceParams) *UserService {
 
-	p.DB.AutoMigrate(&UserModel{})
+	p.DB.AutoMigrate(&UserModel{}, &PasswordHistoryModel{})
+
+	if p.Hasher == nil {
+		p.Hasher = password.Default()
+	}
+	if p.PurgeInterval == 0 {
+		p.PurgeInterval = time.Hour
+	}
+
+	s := &UserService{
+		logger:     p.Logger,
+		validate:   p.Validate,
+		db:         p.DB,
+		hasher:     p.Hasher,
+		history:    p.PasswordHistory,
+		admins:     p.AdminEmails,
+		verify:     p.VerifyEmail,
+		store:      p.Store,
+		dependents: p.Dependents,
+		onDelete:   p.OnDelete,
+		now:        time.Now,
+	}
+
+	if p.Queue != nil {
+		jobs.Every(p.Queue, PurgeJobKind, p.PurgeInterval, s.purgeDue)
+	}
 
-	return &UserService{
-		logger:   p.Logger,
-		validate: p.Validate,
-		db:       p.DB,
+	for _, email := range p.AdminEmails {
+		if user, err := s.FindUserByEmail(email); err == nil && !user.HasRole(RoleAdmin) {
+			if err := s.SetRoles(user.ID, append(user.RoleList(), RoleAdmin)); err != nil {
+				p.Logger.Error("Error making user an admin", zap.String("email", email), zap.Error(err))
+			}
+		}
 	}
+
+	return s
 }
 
 func (u *UserService) FindUserByEmail(email string) (*UserModel, error) {
@@ -47,17 +129,31 @@ func (u *UserService) FindUserByEmail(email string) (*UserModel, error) {
 	return &user, nil
 }
 
-func hashPassword(password string) (string, error) {
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
-	if err != nil {
-		return "", err
+func (u *UserService) FindUserByID(id uuid.UUID) (*UserModel, error) {
+	var user UserModel
+	result := u.db.Where("id = ?", id).First(&user)
+
+	if result.Error != nil {
+		if result.Error == gorm.ErrRecordNotFound {
+			return nil, fmt.Errorf("user not found")
+		}
+		return nil, result.Error
 	}
-	return string(hashedPassword), nil
+	return &user, nil
 }
 
 func (u *UserService) CreateUser(email string, password string) (*UserModel, error) {
 
-	hash, err := hashPassword(password)
+	var count int64
+	if err := u.db.Unscoped().Model(&UserModel{}).Where("email = ?", email).Count(&count).Error; err != nil {
+		return nil, err
+	}
+
+	if count > 0 {
+		return nil, ErrEmailTaken
+	}
+
+	hash, err := u.hasher.Hash(password)
 
 	if err != nil {
 		return nil, err
@@ -66,12 +162,300 @@ func (u *UserService) CreateUser(email string, password string) (*UserModel, err
 	user := &UserModel{
 		ID:       uuid.New(),
 		Email:    email,
-		Password: string(hash),
+		Password: hash,
+		Status:   StatusActive,
+	}
+
+	if u.verify {
+		user.Status = StatusPending
+	}
+
+	for _, admin := range u.admins {
+		if strings.EqualFold(admin, email) {
+			user.Roles = RoleAdmin
+		}
 	}
 
-	if err := u.db.Create(&user).Error; err != nil {
+	err = u.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&user).Error; err != nil {
+			return err
+		}
+		return u.remember(tx, user.ID, hash)
+	})
+	if err != nil {
 		return nil, err
 	}
 
 	return user, nil
 }
+
+// ChangePassword sets a new password chosen by the user, adding it to their
+// password history.
+func (u *UserService) ChangePassword(id uuid.UUID, password string) error {
+	hash, err := u.hasher.Hash(password)
+	if err != nil {
+		return err
+	}
+
+	return u.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&UserModel{}).Where("id = ?", id).Update("password", hash).Error; err != nil {
+			return err
+		}
+		return u.remember(tx, id, hash)
+	})
+}
+
+// PasswordReused reports whether password is the user's current password
+// or one of the previous ones in their history.
+func (u *UserService) PasswordReused(id uuid.UUID, password string) (bool, error) {
+	if u.history <= 0 {
+		return false, nil
+	}
+
+	user, err := u.FindUserByID(id)
+	if err != nil {
+		return false, err
+	}
+
+	var history []PasswordHistoryModel
+	if err := u.db.Where("user_id = ?", id).Order("created_at desc").Limit(u.history).Find(&history).Error; err != nil {
+		return false, err
+	}
+
+	hashes := []string{user.Password}
+	for _, h := range history {
+		hashes = append(hashes, h.Hash)
+	}
+
+	for _, hash := range hashes {
+		if u.hasher.Verify(password, hash) == nil {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// remember adds hash to the user's password history, keeping only the
+// latest ones.
+func (u *UserService) remember(tx *gorm.DB, userID uuid.UUID, hash string) error {
+	if u.history <= 0 {
+		return nil
+	}
+
+	err := tx.Create(&PasswordHistoryModel{ID: uuid.New(), UserID: userID, Hash: hash}).Error
+	if err != nil {
+		return err
+	}
+
+	var keep []uuid.UUID
+	err = tx.Model(&PasswordHistoryModel{}).Where("user_id = ?", userID).Order("created_at desc").Limit(u.history).Pluck("id", &keep).Error
+	if err != nil {
+		return err
+	}
+	return tx.Where("user_id = ? AND id NOT IN ?", userID, keep).Delete(&PasswordHistoryModel{}).Error
+}
+
+// UpdatePassword hashes password and stores it as the user's password, for
+// upgrading the hash of the same password. New passwords go through
+// ChangePassword.
+func (u *UserService) UpdatePassword(id uuid.UUID, password string) error {
+	hash, err := u.hasher.Hash(password)
+	if err != nil {
+		return err
+	}
+	return u.db.Model(&UserModel{}).Where("id = ?", id).Update("password", hash).Error
+}
+
+// SetRoles replaces the user's roles.
+func (u *UserService) SetRoles(id uuid.UUID, roles []string) error {
+	seen := map[string]bool{}
+	var unique []string
+	for _, role := range roles {
+		if !seen[role] {
+			seen[role] = true
+			unique = append(unique, role)
+		}
+	}
+	return u.db.Model(&UserModel{}).Where("id = ?", id).Update("roles", strings.Join(unique, " ")).Error
+}
+
+// SetAvatar stores the key of the user's new avatar, empty for none,
+// returning the previous one so its blobs can be deleted.
+func (u *UserService) SetAvatar(id uuid.UUID, avatar string) (string, error) {
+	var previous string
+	err := u.db.Transaction(func(tx *gorm.DB) error {
+		var user UserModel
+		if err := tx.Select("id", "avatar").Where("id = ?", id).First(&user).Error; err != nil {
+			return err
+		}
+		previous = user.Avatar
+		return tx.Model(&UserModel{}).Where("id = ?", id).Update("avatar", avatar).Error
+	})
+	return previous, err
+}
+
+func (u *UserService) UpdateLocale(id uuid.UUID, locale string) error {
+	return u.db.Model(&UserModel{}).Where("id = ?", id).Update("locale", locale).Error
+}
+
+// DeletionGracePeriod is how long accounts scheduled for deletion can still
+// be reactivated, unless the admin chooses another.
+const DeletionGracePeriod = 30 * 24 * time.Hour
+
+// ErrStatus is returned when an account can't change to the status asked for.
+var ErrStatus = errors.New("invalid status change")
+
+// Activate makes the account active, whatever its status: a pending user
+// verified their email, or an admin lifted a suspension or deletion.
+func (u *UserService) Activate(id uuid.UUID) error {
+	return u.setStatus(id, map[string]interface{}{
+		"status":           StatusActive,
+		"suspended_reason": "",
+		"suspended_until":  nil,
+		"delete_at":        nil,
+	})
+}
+
+// Suspend blocks the account until it is reactivated, or until until when
+// it isn't nil. The reason is shown to the user when they try to log in.
+func (u *UserService) Suspend(id uuid.UUID, reason string, until *time.Time) error {
+	if until != nil && !until.After(u.now()) {
+		return ErrStatus
+	}
+	return u.setStatus(id, map[string]interface{}{
+		"status":           StatusSuspended,
+		"suspended_reason": reason,
+		"suspended_until":  until,
+		"delete_at":        nil,
+	})
+}
+
+// ScheduleDeletion blocks the account and deletes it once grace has passed,
+// unless it is activated again before then.
+func (u *UserService) ScheduleDeletion(id uuid.UUID, grace time.Duration) error {
+	if grace < 0 {
+		return ErrStatus
+	}
+	at := u.now().Add(grace)
+	return u.setStatus(id, map[string]interface{}{
+		"status":           StatusDeletionScheduled,
+		"suspended_reason": "",
+		"suspended_until":  nil,
+		"delete_at":        &at,
+	})
+}
+
+func (u *UserService) setStatus(id uuid.UUID, fields map[string]interface{}) error {
+	result := u.db.Model(&UserModel{}).Where("id = ?", id).Updates(fields)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// StatusError returns why the user can't use their account, nil when they
+// can. Users that don't exist any more can't either.
+func (u *UserService) StatusError(ctx context.Context, id uuid.UUID) error {
+	var user UserModel
+	err := u.db.WithContext(ctx).Select("id", "status", "suspended_until").Where("id = ?", id).First(&user).Error
+	if err != nil {
+		return fmt.Errorf("finding user: %w", err)
+	}
+	return user.CheckStatus(u.now())
+}
+
+// ErrNotAdmin is returned by ImpersonatorError for users who are no longer
+// admins.
+var ErrNotAdmin = errors.New("not an admin")
+
+// ImpersonatorError returns why the admin can no longer act as another user,
+// nil when they still can: their account is blocked or they aren't an admin
+// any more.
+func (u *UserService) ImpersonatorError(ctx context.Context, id uuid.UUID) error {
+	var user UserModel
+	err := u.db.WithContext(ctx).Select("id", "status", "suspended_until", "roles").Where("id = ?", id).First(&user).Error
+	if err != nil {
+		return fmt.Errorf("finding user: %w", err)
+	}
+	if err := user.CheckStatus(u.now()); err != nil {
+		return err
+	}
+	if !user.HasRole(RoleAdmin) {
+		return ErrNotAdmin
+	}
+	return nil
+}
+
+// DeleteDue deletes the accounts scheduled for deletion whose grace period
+// is over for good, with their rows in the Dependents tables and their
+// avatars, after the OnDelete functions ran, returning how many were.
+func (u *UserService) DeleteDue(ctx context.Context) (int64, error) {
+	var due []UserModel
+	err := u.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Select("id", "avatar").
+			Where("status = ? AND delete_at <= ?", StatusDeletionScheduled, u.now()).
+			Find(&due).Error
+		if err != nil || len(due) == 0 {
+			return err
+		}
+
+		ids := make([]uuid.UUID, len(due))
+		for i, user := range due {
+			ids[i] = user.ID
+		}
+
+		for _, fn := range u.onDelete {
+			if err := fn(tx, ids); err != nil {
+				return err
+			}
+		}
+
+		dependents := append([]Dependent{{Table: PasswordHistoryModel{}.TableName()}}, u.dependents...)
+		for _, d := range dependents {
+			column := d.Column
+			if column == "" {
+				column = "user_id"
+			}
+			err := tx.Exec("DELETE FROM ? WHERE ? IN ?", clause.Table{Name: d.Table}, clause.Column{Name: column}, ids).Error
+			if err != nil {
+				return fmt.Errorf("deleting from %s: %w", d.Table, err)
+			}
+		}
+
+		return tx.Unscoped().Where("id IN ?", ids).Delete(&UserModel{}).Error
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	// the blobs can't be put back if the transaction fails, so they go after
+	if u.store != nil {
+		for _, user := range due {
+			if user.Avatar == "" {
+				continue
+			}
+			for _, size := range []int{AvatarSize, AvatarThumbnailSize} {
+				if err := u.store.Delete(ctx, AvatarKey(user.Avatar, size)); err != nil {
+					u.logger.Error("Error deleting avatar", zap.Stringer("user", user.ID), zap.Error(err))
+				}
+			}
+		}
+	}
+
+	return int64(len(due)), nil
+}
+
+// purgeDue is the job deleting the accounts whose deletion is due.
+func (u *UserService) purgeDue(ctx context.Context) error {
+	n, err := u.DeleteDue(ctx)
+	if err != nil {
+		return fmt.Errorf("deleting accounts: %w", err)
+	}
+	if n > 0 {
+		u.logger.Info("Deleted accounts scheduled for deletion", zap.Int64("count", n))
+	}
+	return nil
+}
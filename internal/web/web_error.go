@@ -0,0 +1,145 @@
+package web
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/tomdoestech/goth/internal/pkg/apperror"
+	"github.com/tomdoestech/goth/internal/pkg/i18n"
+	"go.uber.org/zap"
+)
+
+// HandlerFunc is an http handler that reports failures by returning an error
+// instead of writing the response itself.
+type HandlerFunc func(w http.ResponseWriter, r *http.Request) error
+
+type ErrorHandler struct {
+	i18n   *i18n.Bundle
+	logger *zap.Logger
+}
+
+type ErrorHandlerParams struct {
+	I18n   *i18n.Bundle
+	Logger *zap.Logger
+}
+
+func NewErrorHandler(p ErrorHandlerParams) *ErrorHandler {
+	return &ErrorHandler{
+		i18n:   p.I18n,
+		logger: p.Logger,
+	}
+}
+
+// Problem is an RFC 9457 problem details document.
+type Problem struct {
+	Type   string   `json:"type"`
+	Title  string   `json:"title"`
+	Status int      `json:"status"`
+	Detail string   `json:"detail,omitempty"`
+	Errors []string `json:"errors,omitempty"`
+	// Fields maps request fields to their validation message
+	Fields map[string]string `json:"fields,omitempty"`
+}
+
+// errorPages maps each kind to its template and default title and message keys.
+var errorPages = map[apperror.Kind]struct {
+	template string
+	key      string
+}{
+	apperror.KindNotFound:         {"404.html", "error.not_found"},
+	apperror.KindUnauthorized:     {"403.html", "error.unauthorized"},
+	apperror.KindForbidden:        {"403.html", "error.forbidden"},
+	apperror.KindValidation:       {"400.html", "error.validation"},
+	apperror.KindMethodNotAllowed: {"404.html", "error.method_not_allowed"},
+	apperror.KindConflict:         {"400.html", "error.conflict"},
+	apperror.KindTooManyRequests:  {"400.html", "error.too_many_requests"},
+	apperror.KindInternal:         {"500.html", "error.internal"},
+}
+
+// Handle adapts fn to an http.HandlerFunc, rendering any returned error.
+func (e *ErrorHandler) Handle(fn HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := fn(w, r); err != nil {
+			e.Render(w, r, err)
+		}
+	}
+}
+
+// Render reports err as problem JSON for API clients, as the error page's
+// content block for htmx requests and as a full error page otherwise.
+func (e *ErrorHandler) Render(w http.ResponseWriter, r *http.Request, err error) {
+	appErr := apperror.From(err)
+	status := appErr.Status()
+
+	if appErr.Kind == apperror.KindInternal {
+		e.logger.Error("Internal error", zap.Error(appErr), zap.String("path", r.URL.Path))
+	} else if appErr.Err != nil {
+		e.logger.Info("Request failed", zap.Error(appErr), zap.Int("status", status), zap.String("path", r.URL.Path))
+	}
+
+	page, ok := errorPages[appErr.Kind]
+	if !ok {
+		page = errorPages[apperror.KindInternal]
+	}
+
+	loc := e.i18n.FromRequest(r)
+
+	message := appErr.Message
+	if message == "" || appErr.Kind == apperror.KindInternal {
+		message = loc.T(page.key + ".message")
+	}
+
+	if WantsJSON(r) {
+		w.Header().Set("Content-Type", "application/problem+json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(Problem{
+			Type:   "about:blank",
+			Title:  http.StatusText(status),
+			Status: status,
+			Detail: message,
+			Errors: appErr.Details,
+			Fields: appErr.Fields,
+		})
+		return
+	}
+
+	data := map[string]interface{}{
+		"Title":   page.key + ".title",
+		"Status":  status,
+		"Message": message,
+		"Details": appErr.Details,
+	}
+
+	entry := "base"
+	if IsHTMX(r) {
+		entry = "content"
+	}
+
+	renderTemplate(w, r, loc, page.template, entry, status, data)
+}
+
+// NotFound replaces chi's default 404 response.
+func (e *ErrorHandler) NotFound(w http.ResponseWriter, r *http.Request) {
+	e.Render(w, r, apperror.NotFound(""))
+}
+
+// MethodNotAllowed replaces chi's default 405 response.
+func (e *ErrorHandler) MethodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	e.Render(w, r, apperror.New(apperror.KindMethodNotAllowed, "", nil))
+}
+
+// IsHTMX reports whether the request was made by htmx.
+func IsHTMX(r *http.Request) bool {
+	return r.Header.Get("HX-Request") == "true"
+}
+
+// WantsJSON reports whether the client asked for a JSON response, either with
+// the Accept header or by sending JSON.
+func WantsJSON(r *http.Request) bool {
+	accept := r.Header.Get("Accept")
+	if strings.Contains(accept, "application/json") || strings.Contains(accept, "application/problem+json") {
+		return true
+	}
+	return SendsJSON(r) && !strings.Contains(accept, "text/html")
+}
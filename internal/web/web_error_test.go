@@ -0,0 +1,128 @@
+//go:build unit
+// +build unit
+
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"html"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tomdoestech/goth/internal/pkg/apperror"
+	"github.com/tomdoestech/goth/internal/pkg/i18n"
+	"github.com/tomdoestech/goth/internal/pkg/secure"
+	"go.uber.org/zap"
+)
+
+func TestErrorHandlerRender(t *testing.T) {
+
+	bundle, err := i18n.NewBundle(i18n.BundleParams{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	errorHandler := NewErrorHandler(ErrorHandlerParams{
+		I18n:   bundle,
+		Logger: zap.NewNop(),
+	})
+
+	testCases := []struct {
+		description         string
+		err                 error
+		headers             map[string]string
+		expectedStatusCode  int
+		expectedContentType string
+		expectedBody        string
+		unexpectedBody      string
+	}{
+		{
+			description:         "not found - full page",
+			err:                 apperror.NotFound(""),
+			expectedStatusCode:  404,
+			expectedContentType: "text/html; charset=utf-8",
+			expectedBody:        "Page not found",
+		},
+		{
+			description:         "forbidden - htmx fragment",
+			err:                 apperror.Forbidden(""),
+			headers:             map[string]string{"HX-Request": "true"},
+			expectedStatusCode:  403,
+			expectedContentType: "text/html; charset=utf-8",
+			expectedBody:        "Access denied",
+			unexpectedBody:      "<html",
+		},
+		{
+			description:         "internal - cause is not shown",
+			err:                 errors.New("database is on fire"),
+			expectedStatusCode:  500,
+			expectedContentType: "text/html; charset=utf-8",
+			expectedBody:        "Something went wrong",
+			unexpectedBody:      "database is on fire",
+		},
+		{
+			description:         "validation - problem json",
+			err:                 apperror.Validation("Validation error", []string{"Email is required"}),
+			headers:             map[string]string{"Accept": "application/json"},
+			expectedStatusCode:  400,
+			expectedContentType: "application/problem+json",
+			expectedBody:        "Email is required",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+
+			assert := assert.New(t)
+
+			req := httptest.NewRequest("GET", "/", nil)
+			for key, value := range tc.headers {
+				req.Header.Set(key, value)
+			}
+			w := httptest.NewRecorder()
+
+			errorHandler.Render(w, req, tc.err)
+
+			assert.Equal(tc.expectedStatusCode, w.Code)
+			assert.Equal(tc.expectedContentType, w.Header().Get("Content-Type"))
+			assert.Contains(w.Body.String(), tc.expectedBody)
+
+			if tc.unexpectedBody != "" {
+				assert.NotContains(w.Body.String(), tc.unexpectedBody)
+			}
+
+			if tc.expectedContentType == "application/problem+json" {
+				var body Problem
+				assert.NoError(json.Unmarshal(w.Body.Bytes(), &body))
+				assert.Equal(tc.expectedStatusCode, body.Status)
+			}
+		})
+	}
+}
+
+func TestErrorPageNonces(t *testing.T) {
+	bundle, err := i18n.NewBundle(i18n.BundleParams{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	errorHandler := NewErrorHandler(ErrorHandlerParams{I18n: bundle, Logger: zap.NewNop()})
+
+	var script, style string
+	handler := secure.Middleware(secure.DefaultConfig())(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		script, style = secure.Nonces(r.Context())
+		errorHandler.NotFound(w, r)
+	}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/missing", nil))
+
+	// the templates escape the nonces' + as &#43;, which browsers decode
+	body := html.UnescapeString(w.Body.String())
+	assert.NotEmpty(t, script)
+	assert.Contains(t, body, `nonce="`+script+`"`, "the page's scripts carry the request's nonce")
+	assert.Contains(t, body, `nonce="`+style+`"`)
+}
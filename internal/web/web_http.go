@@ -1,40 +1,98 @@
 package web
 
 import (
-	"crypto/rand"
-	"encoding/hex"
+	"bytes"
 	"fmt"
 	"html/template"
+	"net"
 	"net/http"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/jwtauth/v5"
+	"github.com/tomdoestech/goth/internal/pkg/assets"
+	"github.com/tomdoestech/goth/internal/pkg/i18n"
+	"github.com/tomdoestech/goth/internal/pkg/identity"
+	"github.com/tomdoestech/goth/internal/pkg/openapi"
+	"github.com/tomdoestech/goth/internal/pkg/secure"
+	"github.com/tomdoestech/goth/internal/pkg/tenant"
+	"github.com/tomdoestech/goth/templates"
 )
 
 type WebHTTPParams struct {
 	WebHandler *WebHandler
+	Assets     *assets.Assets
+	API        *openapi.Document
 	Mux        *chi.Mux
+	// MagicLinks offers emailed login links on the login page
+	MagicLinks bool
 }
 
-func generateRandomString(length int) string {
-	bytes := make([]byte, length)
-	_, err := rand.Read(bytes)
+// assetURL backs the asset template function, NewWebHTTP points it at the
+// fingerprinted asset URLs.
+var assetURL = func(name string) string {
+	return "/static/" + name
+}
+
+func RenderTemplate(w http.ResponseWriter, tmplName string, data interface{}, r *http.Request) {
+	loc, _ := i18n.FromContext(r.Context())
+
+	renderTemplate(w, r, loc, tmplName, "base", http.StatusOK, data)
+}
+
+// RenderBlock renders a single block of a template, such as a list that htmx
+// swaps in after a change.
+func RenderBlock(w http.ResponseWriter, r *http.Request, tmplName string, block string, status int, data interface{}) {
+	loc, _ := i18n.FromContext(r.Context())
+
+	renderTemplate(w, r, loc, tmplName, block, status, data)
+}
+
+// ClientIP returns the IP address of the client, from RemoteAddr so put
+// middleware.RealIP in front when the app runs behind a proxy.
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
 	if err != nil {
-		return ""
+		return r.RemoteAddr
 	}
-	return hex.EncodeToString(bytes)
+	return host
 }
 
-func RenderTemplate(w http.ResponseWriter, tmplName string, data interface{}, r *http.Request) {
+// renderTemplate executes entry, either the full "base" layout or a single block
+// such as "content" for htmx fragments, and writes it with status. The output is
+// buffered so a failing template never leaves a half written page. Without a
+// Localizer the t function returns message keys untranslated.
+func renderTemplate(w http.ResponseWriter, r *http.Request, loc *i18n.Localizer, tmplName string, entry string, status int, data interface{}) {
 
+	// templates use .User.email, the same as the token claims
 	_, user, _ := jwtauth.FromContext(r.Context())
+	impersonating := false
+	if p, ok := identity.FromContext(r.Context()); ok {
+		user = map[string]interface{}{
+			"id":     p.UserID.String(),
+			"email":  p.Email,
+			"locale": p.Locale,
+		}
+		impersonating = p.Impersonated()
+	}
+
+	translate := func(key string, args ...interface{}) string { return key }
+	lang, locales := "en", []string{"en"}
+	if loc != nil {
+		translate = loc.T
+		lang, locales = loc.Locale(), loc.Locales()
+	}
 
-	tmpl, err := template.ParseFiles(
-		"templates/"+tmplName,
-		"templates/partial/header.html",
-		"templates/partial/nav.html",
-		"templates/partial/footer.html",
-		"templates/partial/base.html",
+	tmpl, err := template.New(tmplName).Funcs(template.FuncMap{
+		"t":     translate,
+		"asset": assetURL,
+	}).ParseFS(
+		templates.FS,
+		tmplName,
+		"partial/header.html",
+		"partial/nav.html",
+		"partial/footer.html",
+		"partial/base.html",
+		"partial/password_strength.html",
 	)
 
 	if err != nil {
@@ -43,65 +101,123 @@ func RenderTemplate(w http.ResponseWriter, tmplName string, data interface{}, r
 		return
 	}
 
-	data.(map[string]interface{})["User"] = user
+	// the layout adds to the handler's map, other data is kept under .Data
+	vars, ok := data.(map[string]interface{})
+	if !ok || vars == nil {
+		vars = map[string]interface{}{"Data": data}
+	}
+
+	vars["User"] = user
+	// the base layout shows a banner to stop impersonating
+	vars["Impersonating"] = impersonating
+	vars["Lang"] = lang
+	vars["Locales"] = locales
+
+	// the nav lets users switch organization
+	if org, ok := tenant.FromContext(r.Context()); ok {
+		vars["Org"] = org
+	}
+	vars["Orgs"] = tenant.OrgsFromContext(r.Context())
 
-	scriptNonce := "htmx_" + generateRandomString(8)
-	styleNonce := "tw_" + generateRandomString(8)
+	scriptNonce, styleNonce := secure.Nonces(r.Context())
 
-	data.(map[string]interface{})["scriptNonce"] = scriptNonce
-	data.(map[string]interface{})["styleNonce"] = styleNonce
+	vars["scriptNonce"] = scriptNonce
+	vars["styleNonce"] = styleNonce
 
-	err = tmpl.ExecuteTemplate(w, "base", data)
+	var buf bytes.Buffer
+	err = tmpl.ExecuteTemplate(&buf, entry, vars)
 	if err != nil {
 		fmt.Println("Error executing template:", err)
 		http.Error(w, "Error rendering template", http.StatusInternalServerError)
 		return
 	}
-}
 
-func NewWebHTTP(p WebHTTPParams) {
-	r := p.Mux
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	buf.WriteTo(w)
+}
 
-	fileServer := http.FileServer(http.Dir("./static"))
-	r.Handle("/static/*", http.StripPrefix("/static/", fileServer))
+// page documents a server rendered HTML page.
+func page(summary string) openapi.Operation {
+	return openapi.Operation{
+		Summary: summary,
+		Tags:    []string{"pages"},
+		Responses: map[int]openapi.Response{
+			http.StatusOK: {Description: "The page", ContentType: "text/html"},
+		},
+	}
+}
 
-	r.Get("/healthcheck", func(w http.ResponseWriter, r *http.Request) {
+func NewWebHTTP(p WebHTTPParams) {
+	r := openapi.NewRouter(p.Mux, p.API)
+
+	assetURL = p.Assets.URL
+	r.Get("/static/*", openapi.Operation{
+		Summary:     "Static assets",
+		Description: "Fingerprinted URLs from the asset template function are cached for a year, plain URLs are revalidated with their ETag.",
+		Tags:        []string{"pages"},
+		Responses: map[int]openapi.Response{
+			http.StatusOK:          {Description: "The asset", ContentType: "application/octet-stream"},
+			http.StatusNotModified: {Description: "The cached asset is up to date"},
+		},
+		Errors: []int{http.StatusNotFound},
+	}, http.StripPrefix("/static/", p.Assets))
+
+	r.Get("/healthcheck", openapi.Operation{
+		Summary: "Health check",
+		Tags:    []string{"operations"},
+		Responses: map[int]openapi.Response{
+			http.StatusOK: {Description: "The server is up", ContentType: "text/plain"},
+		},
+	}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte("OK"))
-	})
+	}))
 
-	r.Get("/login", func(w http.ResponseWriter, r *http.Request) {
+	r.Get("/openapi.json", openapi.Operation{
+		Summary: "OpenAPI document",
+		Tags:    []string{"operations"},
+		Responses: map[int]openapi.Response{
+			http.StatusOK: {Description: "This document", ContentType: "application/json"},
+		},
+	}, p.API)
+
+	r.Get("/docs", page("API documentation"), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		data := map[string]interface{}{
-			"Title": "Login",
+			"Title":   "docs.title",
+			"Entries": p.API.Entries(),
+			"Schemas": p.API.Schemas(),
 		}
 
-		RenderTemplate(w, "login.html", data, r)
-	})
+		RenderTemplate(w, "api_docs.html", data, r)
+	}))
 
-	r.Get("/register", func(w http.ResponseWriter, r *http.Request) {
+	r.Get("/login", page("Login page"), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		data := map[string]interface{}{
-			"Title": "Register",
+			"Title":      "login.title",
+			"Next":       r.URL.Query().Get("next"),
+			"MagicLinks": p.MagicLinks,
 		}
 
-		RenderTemplate(w, "register.html", data, r)
-	})
+		RenderTemplate(w, "login.html", data, r)
+	}))
 
-	r.Get("/", func(w http.ResponseWriter, r *http.Request) {
+	r.Get("/", page("Home page"), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 
 		data := map[string]interface{}{
-			"Title": "My Website",
+			"Title": "home.title",
 		}
 
 		// Render the home.html template and inject data
 		RenderTemplate(w, "home.html", data, r)
-	})
+	}))
 
-	r.Get("/about", func(w http.ResponseWriter, r *http.Request) {
+	r.Get("/about", page("About page"), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		data := map[string]interface{}{
-			"Title": "About",
+			"Title": "about.title",
 		}
 
 		// Render the home.html template and inject data
 		RenderTemplate(w, "about.html", data, r)
-	})
+	}))
 
 }
@@ -0,0 +1,140 @@
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/tomdoestech/goth/internal/pkg/apperror"
+	"github.com/tomdoestech/goth/internal/pkg/i18n"
+)
+
+// maxJSONBody bounds JSON request bodies, nothing we accept comes close.
+const maxJSONBody = 1 << 20
+
+// SendsJSON reports whether the request body is JSON.
+func SendsJSON(r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && (mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"))
+}
+
+// WriteJSON writes v as the JSON response body with status.
+func WriteJSON(w http.ResponseWriter, status int, v interface{}) error {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	return json.NewEncoder(w).Encode(v)
+}
+
+// Decode fills dst, a pointer to a struct, from a JSON body or from form
+// values. Form values are matched by the form tag, then the json tag, and
+// only string, bool, int and []string fields are filled from them.
+func Decode(r *http.Request, dst interface{}) error {
+	if SendsJSON(r) {
+		dec := json.NewDecoder(http.MaxBytesReader(nil, r.Body, maxJSONBody))
+		if err := dec.Decode(dst); err != nil {
+			return apperror.New(apperror.KindValidation, "", fmt.Errorf("decoding JSON body: %w", err))
+		}
+		return nil
+	}
+
+	if err := r.ParseForm(); err != nil {
+		return apperror.New(apperror.KindValidation, "", fmt.Errorf("parsing form: %w", err))
+	}
+
+	v := reflect.ValueOf(dst).Elem()
+	t := v.Type()
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := fieldName(field)
+		if !field.IsExported() || name == "" {
+			continue
+		}
+
+		values, ok := r.Form[name]
+		if !ok || len(values) == 0 {
+			continue
+		}
+
+		switch field.Type.Kind() {
+		case reflect.String:
+			v.Field(i).SetString(values[0])
+		case reflect.Bool:
+			// checkboxes send "on" unless they have a value
+			v.Field(i).SetBool(values[0] == "on" || values[0] == "true" || values[0] == "1")
+		case reflect.Int, reflect.Int64:
+			if values[0] == "" {
+				continue
+			}
+			n, err := strconv.ParseInt(values[0], 10, 64)
+			if err != nil {
+				return apperror.New(apperror.KindValidation, "", fmt.Errorf("parsing %s: %w", name, err))
+			}
+			v.Field(i).SetInt(n)
+		case reflect.Slice:
+			if field.Type.Elem().Kind() == reflect.String {
+				v.Field(i).Set(reflect.ValueOf(append([]string(nil), values...)))
+			}
+		}
+	}
+
+	return nil
+}
+
+// ValidationError converts the validator errors for v to a validation error
+// with one translated message per invalid field, also keyed by the name the
+// field has in requests.
+func ValidationError(loc *i18n.Localizer, v interface{}, err error) error {
+	var validationErrors validator.ValidationErrors
+	if !errors.As(err, &validationErrors) {
+		return apperror.Internal(err)
+	}
+
+	t := reflect.TypeOf(v)
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	details := make([]string, 0, len(validationErrors))
+	fields := make(map[string]string, len(validationErrors))
+
+	for _, fieldErr := range validationErrors {
+		message := fieldErr.Translate(loc.Translator())
+		details = append(details, message)
+
+		name := fieldErr.Field()
+		if field, ok := t.FieldByName(fieldErr.StructField()); ok {
+			if n := fieldName(field); n != "" {
+				name = n
+			}
+		}
+		fields[name] = message
+	}
+
+	appErr := apperror.Validation(loc.T("error.validation.title"), details)
+	appErr.Fields = fields
+
+	return appErr
+}
+
+// fieldName is the name of a struct field in requests, empty for ignored fields.
+func fieldName(field reflect.StructField) string {
+	for _, key := range []string{"form", "json"} {
+		if tag, ok := field.Tag.Lookup(key); ok {
+			name := strings.Split(tag, ",")[0]
+			if name == "-" {
+				return ""
+			}
+			if name != "" {
+				return name
+			}
+		}
+	}
+	return field.Name
+}
@@ -0,0 +1,133 @@
+package web
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/jwtauth/v5"
+	"github.com/tomdoestech/goth/internal/pkg/apperror"
+	"github.com/tomdoestech/goth/internal/pkg/errreport"
+	"github.com/tomdoestech/goth/internal/pkg/identity"
+	"github.com/tomdoestech/goth/internal/pkg/metrics"
+	"go.uber.org/zap"
+)
+
+type RecovererParams struct {
+	ErrorHandler *ErrorHandler
+	// Reporter is optional, events are only logged without it
+	Reporter errreport.Reporter
+	Logger   *zap.Logger
+}
+
+type recoverKey struct{}
+
+// recoverState holds the request as the middleware after the recoverer left
+// it, with the user and locale in its context.
+type recoverState struct {
+	r *http.Request
+}
+
+// CaptureRequest lets the recoverer see the request as the middleware in
+// between left it, so panics are reported with the user and rendered in their
+// locale. Register it after the last middleware adding to the context.
+func CaptureRequest(next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		if s, ok := r.Context().Value(recoverKey{}).(*recoverState); ok {
+			s.r = r
+		}
+		next.ServeHTTP(w, r)
+	}
+	return http.HandlerFunc(fn)
+}
+
+// NewRecoverer returns middleware that recovers from panics in later middleware
+// and handlers, logs the stack with the request ID, counts the panic, forwards
+// it to the reporter and renders the 500 page if nothing has been written yet.
+// Register it early, with CaptureRequest last.
+func NewRecoverer(p RecovererParams) func(next http.Handler) http.Handler {
+	reporter := p.Reporter
+	if reporter == nil {
+		reporter = errreport.Nop{}
+	}
+
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+
+			state := &recoverState{}
+			r = r.WithContext(context.WithValue(r.Context(), recoverKey{}, state))
+
+			defer func() {
+				rvr := recover()
+				if rvr == nil {
+					return
+				}
+
+				// the server uses this to abort a response, let it through
+				if rvr == http.ErrAbortHandler {
+					panic(rvr)
+				}
+
+				stack := string(debug.Stack())
+
+				// the panic may come from the middleware in between, before
+				// the request was captured
+				r := r
+				if state.r != nil {
+					r = state.r
+				}
+				requestID := middleware.GetReqID(r.Context())
+
+				routePattern := r.URL.Path
+				if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+					routePattern = rctx.RoutePattern()
+				}
+
+				p.Logger.Error("Recovered from panic",
+					zap.Any("panic", rvr),
+					zap.String("request_id", requestID),
+					zap.String("method", r.Method),
+					zap.String("path", r.URL.Path),
+					zap.String("stack", stack),
+				)
+
+				metrics.RecordPanic(routePattern)
+
+				event := errreport.Event{
+					Message:   fmt.Sprint(rvr),
+					Stack:     stack,
+					RequestID: requestID,
+					Method:    r.Method,
+					URL:       r.URL.String(),
+					Time:      time.Now(),
+				}
+				if p, ok := identity.FromContext(r.Context()); ok {
+					event.UserID = p.UserID.String()
+				} else if _, claims, err := jwtauth.FromContext(r.Context()); err == nil {
+					event.UserID, _ = claims["id"].(string)
+				}
+
+				go func() {
+					ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+					defer cancel()
+
+					if err := reporter.Report(ctx, event); err != nil {
+						p.Logger.Error("Error reporting panic", zap.Error(err), zap.String("request_id", requestID))
+					}
+				}()
+
+				if ww.Status() == 0 {
+					p.ErrorHandler.Render(w, r, apperror.Internal(fmt.Errorf("panic: %v", rvr)))
+				}
+			}()
+
+			next.ServeHTTP(ww, r)
+		}
+		return http.HandlerFunc(fn)
+	}
+}
@@ -0,0 +1,140 @@
+//go:build unit
+// +build unit
+
+package web
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/tomdoestech/goth/internal/pkg/errreport"
+	"github.com/tomdoestech/goth/internal/pkg/i18n"
+	"github.com/tomdoestech/goth/internal/pkg/identity"
+	"go.uber.org/zap"
+)
+
+type stubReporter struct {
+	events chan errreport.Event
+}
+
+func (s *stubReporter) Report(ctx context.Context, event errreport.Event) error {
+	s.events <- event
+	return nil
+}
+
+func TestRecoverer(t *testing.T) {
+
+	assert := assert.New(t)
+
+	bundle, err := i18n.NewBundle(i18n.BundleParams{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reporter := &stubReporter{events: make(chan errreport.Event, 1)}
+
+	r := chi.NewRouter()
+	r.Use(middleware.RequestID)
+	r.Use(NewRecoverer(RecovererParams{
+		ErrorHandler: NewErrorHandler(ErrorHandlerParams{I18n: bundle, Logger: zap.NewNop()}),
+		Reporter:     reporter,
+		Logger:       zap.NewNop(),
+	}))
+	r.Get("/panic", func(w http.ResponseWriter, r *http.Request) {
+		var data interface{} = "not a map"
+		_ = data.(map[string]interface{})
+	})
+
+	req := httptest.NewRequest("GET", "/panic", nil)
+	w := httptest.NewRecorder()
+
+	r.ServeHTTP(w, req)
+
+	assert.Equal(http.StatusInternalServerError, w.Code)
+	assert.Contains(w.Body.String(), "Something went wrong")
+
+	select {
+	case event := <-reporter.events:
+		assert.Contains(event.Message, "interface conversion")
+		assert.NotEmpty(event.RequestID)
+		assert.Contains(event.Stack, "web_recover_test.go")
+	case <-time.After(time.Second):
+		t.Fatal("expected the panic to be reported")
+	}
+}
+
+func TestRecovererMiddleware(t *testing.T) {
+	bundle, err := i18n.NewBundle(i18n.BundleParams{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reporter := &stubReporter{events: make(chan errreport.Event, 1)}
+	userID := uuid.New()
+
+	r := chi.NewRouter()
+	r.Use(middleware.RequestID)
+	r.Use(NewRecoverer(RecovererParams{
+		ErrorHandler: NewErrorHandler(ErrorHandlerParams{I18n: bundle, Logger: zap.NewNop()}),
+		Reporter:     reporter,
+		Logger:       zap.NewNop(),
+	}))
+	// stands in for the session middleware, which hits the database
+	r.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Query().Has("broken") {
+				panic("database is gone")
+			}
+			next.ServeHTTP(w, r.WithContext(identity.NewContext(r.Context(), &identity.Principal{UserID: userID, Locale: "fr"})))
+		})
+	})
+	r.Use(bundle.Middleware)
+	r.Use(CaptureRequest)
+	r.Get("/", func(w http.ResponseWriter, r *http.Request) {
+		RenderTemplate(w, "404.html", nil, r)
+		panic("after rendering")
+	})
+	r.Get("/panic", func(w http.ResponseWriter, r *http.Request) {
+		panic("in the handler")
+	})
+
+	t.Run("in middleware", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/panic?broken", nil)
+		req.Header.Set("Accept-Language", "fr")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusInternalServerError, w.Code)
+		assert.Contains(t, w.Body.String(), "Une erreur inattendue", "the locale is negotiated without the middleware")
+
+		event := <-reporter.events
+		assert.Equal(t, "database is gone", event.Message)
+		assert.Empty(t, event.UserID)
+	})
+
+	t.Run("in the handler", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", "/panic", nil))
+
+		assert.Equal(t, http.StatusInternalServerError, w.Code)
+		assert.Contains(t, w.Body.String(), "Une erreur inattendue", "in the user's locale")
+
+		event := <-reporter.events
+		assert.Equal(t, userID.String(), event.UserID)
+	})
+
+	t.Run("pages without data", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+
+		event := <-reporter.events
+		assert.Equal(t, "after rendering", event.Message, "rendering nil data doesn't panic")
+	})
+}
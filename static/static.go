@@ -0,0 +1,8 @@
+package static
+
+import "embed"
+
+// FS holds the assets served under /static, input.css is only the tailwind source.
+//
+//go:embed css/style.css img htmx.min.js
+var FS embed.FS
@@ -0,0 +1,9 @@
+package templates
+
+import "embed"
+
+// FS holds every page and partial template so rendering doesn't depend on the
+// working directory the binary is started from.
+//
+//go:embed *.html partial/*.html
+var FS embed.FS